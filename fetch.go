@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"Mod/src/backtest"
+	"Mod/src/config"
+	"Mod/src/stream"
+)
+
+var (
+	marketOnce sync.Once
+	marketCfg  config.MarketConfig
+)
+
+// newMarketClient builds a market-data client on the endpoints from the trader
+// config (default search path + TRADER_* env), falling back to the built-in
+// defaults when no valid config is available.
+func newMarketClient() *stream.HybridClient {
+	marketOnce.Do(func() {
+		marketCfg = config.Default().Market
+		if tc, err := config.Load(); err != nil {
+			log.Printf("trader config unavailable (%v); using default market endpoints", err)
+		} else {
+			marketCfg = tc.Market
+		}
+	})
+	return stream.NewMarketClient(stream.OptionsFromConfig(marketCfg))
+}
+
+// ==================== fetch command ====================
+
+// runFetch downloads [start, end] candles for many instruments in parallel and
+// appends them to <out>/<instId>.csv, resuming from whatever is already on disk.
+//
+//	go run . fetch -inst BTC-USDT-SWAP,ETH-USDT-SWAP -tf 15m -start 2024-01-01 [-end 2024-06-30] [-out ./data/candles] [-parallel 4]
+//
+// With -config, instruments/timeframe/dates/data_path default to the backtest config.
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	cfgPath := fs.String("config", "", "backtest config to take defaults from")
+	insts := fs.String("inst", "", "comma-separated instrument IDs")
+	tf := fs.String("tf", "", "timeframe (1m/5m/15m/30m/1h/4h/1d)")
+	start := fs.String("start", "", "range start (2006-01-02 or RFC3339, UTC)")
+	end := fs.String("end", "", "range end (default: now)")
+	out := fs.String("out", "", "CSV directory")
+	parallel := fs.Int("parallel", 4, "concurrent instruments")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cfg BacktestConfig
+	if *cfgPath != "" {
+		c, err := loadConfig(*cfgPath)
+		if err != nil {
+			return err
+		}
+		cfg = c
+	}
+	ids := cfg.Instruments
+	if *insts != "" {
+		ids = strings.Split(*insts, ",")
+	}
+	timeframe := firstNonEmpty(*tf, cfg.Timeframe, "15m")
+	dir := firstNonEmpty(*out, cfg.DataPath, "./data/candles")
+	from, err := parseFetchTime(firstNonEmpty(*start, cfg.StartDate))
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	var to time.Time
+	if s := firstNonEmpty(*end, cfg.EndDate); s != "" {
+		if to, err = parseFetchTime(s); err != nil {
+			return fmt.Errorf("end: %w", err)
+		}
+	}
+	if len(ids) == 0 || from.IsZero() {
+		fs.Usage()
+		return fmt.Errorf("fetch needs -inst and -start (or -config)")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := newMarketClient()
+	defer client.Close()
+	dl := stream.NewRangeDownloader(client, dir)
+	dl.Parallel = *parallel
+
+	jobs := make([]stream.DownloadJob, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			jobs = append(jobs, stream.DownloadJob{InstID: id, Timeframe: timeframe, Start: from, End: to})
+		}
+	}
+	results := dl.DownloadAll(ctx, jobs)
+
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if r.Err != "" {
+			status, failed = "FAILED: "+r.Err, failed+1
+		}
+		log.Printf("%-20s %s bars=%d added=%d gaps=%d requests=%d %s", r.InstID, r.Timeframe, r.Bars, r.Added, len(r.Gaps), r.Requests, status)
+	}
+	if err := saveJSON(filepath.Join(dir, "fetch_report.json"), results); err != nil {
+		log.Printf("write fetch report failed: %v", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed (rerun to resume)", failed, len(results))
+	}
+	return nil
+}
+
+// downloadRecent tops up csvPath with the latest `limit` bars (incremental, resumable)
+// and returns them from the file.
+func downloadRecent(instID, timeframe, csvPath string, limit int) ([]backtest.Candle, error) {
+	step := time.Duration(timeframeStepMS(timeframe)) * time.Millisecond
+	client := newMarketClient()
+	defer client.Close()
+	dl := stream.NewRangeDownloader(client, filepath.Dir(csvPath))
+	dl.PathFor = func(string, string) string { return csvPath }
+	job := stream.DownloadJob{InstID: instID, Timeframe: timeframe, Start: time.Now().Add(-time.Duration(limit) * step)}
+	if _, err := dl.Download(context.Background(), job); err != nil {
+		return nil, err
+	}
+	candles, err := loadFromCSV(csvPath, instID)
+	if err != nil {
+		return nil, err
+	}
+	candles = ensureAscUnique(candles, int64(step/time.Millisecond))
+	if len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles, nil
+}
+
+func parseFetchTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
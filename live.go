@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"Mod/src/config"
+	"Mod/src/execution"
+	"Mod/src/instrument"
+	"Mod/src/strategy"
+	"Mod/src/stream"
+)
+
+// ==================== live command ====================
+
+// runLive streams closed candles plus funding/basis into the strategy and logs
+// the signals it produces. Orders are sent only when the trader config has
+// execution.enable set (paper venue while execution.dryRun is true).
+//
+//	go run . live [-config backtest_config.json] [-trader ./configs/trader.yaml]
+//
+// Strategy parameters and instruments come from the backtest config; market
+// endpoints and execution settings come from the trader config (default search
+// path + TRADER_* env). Instrument specs come from the catalog, which refreshes
+// hourly and re-registers changed specs with the executor.
+func runLive(args []string) error {
+	fs := flag.NewFlagSet("live", flag.ContinueOnError)
+	cfgPath := fs.String("config", "backtest_config.json", "backtest config with strategy parameters and instruments")
+	traderPath := fs.String("trader", "", "trader YAML with market endpoints (default: search path)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Instruments) == 0 {
+		return fmt.Errorf("live needs instruments in %s", *cfgPath)
+	}
+	var paths []string
+	if *traderPath != "" {
+		paths = append(paths, *traderPath)
+	}
+	tc, err := config.Load(paths...)
+	if err != nil {
+		return fmt.Errorf("trader config: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	md := stream.NewMarketClient(stream.OptionsFromConfig(tc.Market))
+	cat := instrument.NewCatalog(instrument.Config{DataDir: cfg.InstrumentsDir}, md)
+	if err := cat.Start(); err != nil {
+		log.Printf("instrument catalog unavailable (%v); using built-in lot/tick", err)
+	}
+	defer cat.Close()
+	specs := make(map[string]instrument.Spec, len(cfg.Instruments))
+	for _, inst := range cfg.Instruments {
+		if s, ok := cat.Get(inst); ok {
+			specs[inst] = s
+		}
+	}
+
+	tfMin := normalizeTimeframe(cfg.Timeframe)
+	qm := buildStrategyEngine(cfg, tfMin, specs)
+
+	var lt *liveTrader
+	if tc.Execution.Enable {
+		if lt, err = newLiveTrader(cfg, tc, md, cat); err != nil {
+			return err
+		}
+	}
+
+	// Funding and basis pushes feed the strategy's carry inputs directly.
+	qm.BindCarry(md)
+	md.OnCandle(func(cs []stream.Candle) {
+		for _, c := range cs {
+			sigs := qm.OnCandle(strategy.Candle{InstID: c.InstID, T: c.Timestamp, O: c.Open, H: c.High, L: c.Low, C: c.Close, V: c.Volume})
+			for _, s := range sigs {
+				log.Printf("signal %s %s size=%.4f px=%.4f tag=%s", s.InstID, s.Side, s.Size, s.Price, s.Tag)
+			}
+			if lt != nil {
+				lt.onCandle(c, sigs)
+			}
+		}
+	})
+
+	if err := md.Start(ctx); err != nil {
+		return err
+	}
+	if err := md.SubscribeCandlesWS(cfg.Instruments, firstNonEmpty(cfg.Timeframe, "15m")); err != nil {
+		return err
+	}
+	if err := md.SubscribeCarry(cfg.Instruments); err != nil {
+		return err
+	}
+	log.Printf("live: %d instruments on %s, Ctrl-C to stop", len(cfg.Instruments), tc.Market.WSURL)
+	<-ctx.Done()
+	return nil
+}
+
+// liveTrader turns the strategy's relative targets into executor plans and
+// sends them through the pre-trade gate.
+type liveTrader struct {
+	ex     *execution.Executor
+	venue  execution.ExchangeAdapter
+	gate   *execution.PreTradeGate
+	target map[string]float64 // last relative target per instrument
+}
+
+// newLiveTrader builds the venue (paper or OKX), an executor whose specs come
+// from the catalog (including later refreshes) and the pre-trade gate.
+func newLiveTrader(cfg BacktestConfig, tc *config.Config, md *stream.HybridClient, cat *instrument.Catalog) (*liveTrader, error) {
+	ecfg := execution.Config{
+		AccountEquity:  cfg.InitialCash,
+		LeverageCap:    nonZeroOrFloat(tc.Risk.MaxLeverage, 3.0),
+		MaxAbsPosition: nonZeroOrFloat(cfg.Risk.MaxAbsPosition, 2.0) * nonZeroOrFloat(cfg.Risk.MaxLeverage, 3.0),
+		PreferPassive:  true,
+		Retry:          tc.Execution.Retry,
+		DryRun:         tc.Execution.DryRun,
+	}
+	venue, events, err := execution.NewVenue(ecfg, tc.Exchange, md)
+	if err != nil {
+		return nil, fmt.Errorf("execution venue: %w", err)
+	}
+	ex := execution.NewExecutor(ecfg)
+	cat.FeedExecutor(ex, cfg.Instruments...)
+	if r, ok := venue.(instrument.Registrar); ok {
+		cat.FeedExecutor(r, cfg.Instruments...) // paper venue needs the same specs to match orders
+	}
+	ex.Bind(events)
+	log.Printf("live: execution enabled (dryRun=%v)", tc.Execution.DryRun)
+	return &liveTrader{ex: ex, venue: venue, gate: execution.NewPreTradeGate(tc.Risk, ex, nil), target: make(map[string]float64)}, nil
+}
+
+// onCandle updates the target from this bar's signals, then steps the executor
+// (which also reprices or cancels resting orders when there is no new signal).
+func (t *liveTrader) onCandle(c stream.Candle, sigs []strategy.Signal) {
+	for _, s := range sigs {
+		if s.Side == "close" {
+			t.target[s.InstID] = 0
+		} else if v, ok := s.Meta["target"].(float64); ok {
+			t.target[s.InstID] = v
+		}
+	}
+	target, ok := t.target[c.InstID]
+	if !ok {
+		return
+	}
+	plan := t.ex.Step(c.InstID, target, c.Close, c.Volume)
+	if _, err := t.gate.Send(t.venue, plan); err != nil {
+		log.Printf("live: send %s: %v", c.InstID, err)
+	}
+}
@@ -16,10 +16,11 @@ import (
 	"time"
 
 	"Mod/src/backtest"
+	"Mod/src/instrument"
 	_ "Mod/src/netboot"
 	"Mod/src/portfolio"
 	"Mod/src/strategy"
-	"Mod/src/stream"
+	"Mod/src/tca"
 )
 
 // ==================== Backtest Config ====================
@@ -36,6 +37,8 @@ type BacktestConfig struct {
 	UseRisk            bool     `json:"use_risk"`
 	UsePortfolio       bool     `json:"use_portfolio"`
 	BarsLimit          int      `json:"bars_limit"`
+	InstrumentsDir     string   `json:"instruments_dir"`  // 合约规格目录（instruments.json），默认 ./data
+	CostCalibration    string   `json:"cost_calibration"` // TCA 校准文件（tca.Calibration JSON），为空则用默认费率/滑点
 
 	// Legacy flat strategy fields (kept for backward compatibility)
 	StrategyRiskTarget     float64 `json:"strategy_risk_target,omitempty"`
@@ -299,6 +302,8 @@ type BacktestRunner struct {
 	barMinutes   int
 	stratAdapter *StrategyAdapter
 	riskAdapter  *RiskAdapter
+	specs        map[string]instrument.Spec
+	costs        *tca.Calibration
 }
 
 type RunAnalytics struct {
@@ -331,9 +336,11 @@ func NewBacktestRunner(configPath string) (*BacktestRunner, error) {
 	log.Printf("Config => timeframe=%s(%dmin) source=%s data_path=%s portfolio=%v autofetch=%v",
 		strings.TrimSpace(cfg.Timeframe), tfMin, strings.ToLower(cfg.DataSource), cfg.DataPath, cfg.UsePortfolio, cfg.AutoFetchIfMissing)
 
-	strategyEngine := buildStrategyEngine(cfg, tfMin)
+	specs := loadInstrumentSpecs(cfg)
+	costs := loadCostCalibration(cfg)
+	strategyEngine := buildStrategyEngine(cfg, tfMin, specs)
 	portfolioEngine := buildPortfolioEngine(cfg, tfMin)
-	bt := buildBacktestEngine(cfg, tfMin)
+	bt := buildBacktestEngine(cfg, tfMin, specs, costs)
 
 	return &BacktestRunner{
 		config:     cfg,
@@ -341,6 +348,8 @@ func NewBacktestRunner(configPath string) (*BacktestRunner, error) {
 		portfolio:  portfolioEngine,
 		backtest:   bt,
 		barMinutes: tfMin,
+		specs:      specs,
+		costs:      costs,
 	}, nil
 }
 
@@ -506,7 +515,7 @@ func (br *BacktestRunner) runGridSearch(series backtest.Series, baseline backtes
 		cfg.Strategy.Regime.TrendAdxTh = br.config.Strategy.Regime.TrendAdxTh * params.RegimeMul
 		cfg.Strategy.Regime.RangeBwTh = br.config.Strategy.Regime.RangeBwTh * params.RegimeMul
 		cfg.normalize()
-		engine := buildBacktestEngine(cfg, br.barMinutes)
+		engine := buildBacktestEngine(cfg, br.barMinutes, br.specs, br.costs)
 		sa := NewStrategyAdapter(cfg.Strategy, cfg.Risk, br.barMinutes)
 		engine.SetStrategy(sa)
 		if cfg.UseRisk {
@@ -645,9 +654,35 @@ func normalizeTimeframe(tf string) int {
 	return tfMin
 }
 
+// loadInstrumentSpecs reads the shared instrument catalog; fetches it once when missing and auto-fetch is on.
+func loadInstrumentSpecs(cfg BacktestConfig) map[string]instrument.Spec {
+	ccfg := instrument.Config{DataDir: cfg.InstrumentsDir, RefreshEvery: -1}
+	cat := instrument.NewCatalog(ccfg, nil)
+	if err := cat.Load(); err != nil {
+		if !cfg.AutoFetchIfMissing {
+			log.Printf("instrument catalog unavailable (%v); using built-in lot/tick", err)
+			return nil
+		}
+		cat = instrument.NewCatalog(ccfg, newMarketClient())
+		if _, err := cat.Refresh(); err != nil {
+			log.Printf("instrument catalog fetch failed (%v); using built-in lot/tick", err)
+			return nil
+		}
+	}
+	specs := make(map[string]instrument.Spec, len(cfg.Instruments))
+	for _, inst := range cfg.Instruments {
+		if s, ok := cat.Get(inst); ok {
+			specs[inst] = s
+		} else {
+			log.Printf("instrument %s not in catalog; using built-in lot/tick", inst)
+		}
+	}
+	return specs
+}
+
 // buildStrategyEngine creates the QuantMasterElite strategy with parameters.
-func buildStrategyEngine(cfg BacktestConfig, tfMin int) *strategy.QuantMasterElite {
-	return strategy.NewQuantMasterElite(strategy.EliteParams{
+func buildStrategyEngine(cfg BacktestConfig, tfMin int, specs map[string]instrument.Spec) *strategy.QuantMasterElite {
+	params := strategy.EliteParams{
 		TimeframeMinutes: tfMin,
 		TrendWindows:     []int{6, 12, 24, 48},
 		TrendGain:        nonZeroOrFloat(cfg.Strategy.TrendGain, 3.0),
@@ -684,7 +719,14 @@ func buildStrategyEngine(cfg BacktestConfig, tfMin int) *strategy.QuantMasterEli
 
 		PerformanceWindow: 1000,
 		Seed:              time.Now().UnixNano(),
-	})
+	}
+	// Each instrument keeps its own lot/tick/multiplier; unknown ones use the defaults above.
+	for _, inst := range cfg.Instruments {
+		if s, ok := specs[inst]; ok {
+			instrument.ApplyElite(s, &params)
+		}
+	}
+	return strategy.NewQuantMasterElite(params)
 }
 
 // buildPortfolioEngine creates the portfolio engine if enabled.
@@ -710,9 +752,24 @@ func buildPortfolioEngine(cfg BacktestConfig, tfMin int) *portfolio.Engine {
 	})
 }
 
+// loadCostCalibration reads measured execution costs (TCA) when configured.
+func loadCostCalibration(cfg BacktestConfig) *tca.Calibration {
+	if cfg.CostCalibration == "" {
+		return nil
+	}
+	c, err := tca.LoadCalibration(cfg.CostCalibration)
+	if err != nil {
+		log.Printf("cost calibration unavailable (%v); using default fee/slippage", err)
+		return nil
+	}
+	log.Printf("Cost calibration => fee=%.2fbps slippage=%.2fbps maker=%.0f%% (%d orders, %d instruments)",
+		c.All.FeeBps, c.All.SlippageBps, c.All.MakerRatio*100, c.All.Orders, len(c.PerInst))
+	return &c
+}
+
 // buildBacktestEngine configures the backtest engine.
-func buildBacktestEngine(cfg BacktestConfig, tfMin int) *backtest.Engine {
-	return backtest.New(backtest.Config{
+func buildBacktestEngine(cfg BacktestConfig, tfMin int, specs map[string]instrument.Spec, costs *tca.Calibration) *backtest.Engine {
+	bc := backtest.Config{
 		InitialEquity:    cfg.InitialCash,
 		BarMinutes:       tfMin,
 		TradeOnNextBar:   true,
@@ -722,11 +779,22 @@ func buildBacktestEngine(cfg BacktestConfig, tfMin int) *backtest.Engine {
 		SlippageBps:      0.0,
 		MinRebalanceStep: 0.0,
 		MaxAbsPosition:   nonZeroOrFloat(cfg.Risk.MaxAbsPosition, 1.0),
+		BeforeFill: func(inst, side string, delta float64, ref float64) (float64, float64) {
+			if s, ok := specs[inst]; ok {
+				return s.RoundPx(ref, side), 0
+			}
+			return 0, 0
+		},
 		AfterFill: func(inst, side string, delta float64, ref float64) (float64, float64) {
 			log.Printf("FILL %-4s %-16s turnover=%.4f @ref=%.2f", strings.ToUpper(side), inst, delta, ref)
 			return 0, 0
 		},
-	})
+	}
+	if costs != nil {
+		costs.Apply(&bc)
+		bc.BeforeFill = costs.Hook(bc.BeforeFill)
+	}
+	return backtest.New(bc)
 }
 
 // ==================== Data Loading ====================
@@ -789,53 +857,15 @@ func (br *BacktestRunner) loadHistoricalData() (backtest.Series, error) {
 }
 
 func (br *BacktestRunner) fetchAndCache(instID, csvPath string, limit int) ([]backtest.Candle, error) {
-	candles, err := fetchFromAPI(instID, br.config.Timeframe, limit)
-	if err != nil {
-		return nil, err
-	}
 	if csvPath != "" {
-		if err := persistCandlesToCSV(csvPath, candles); err != nil {
-			log.Printf("閳跨媴绗?write %s failed: %v", csvPath, err)
-		}
+		// Append only the missing bars to the existing CSV instead of rewriting it.
+		return downloadRecent(instID, br.config.Timeframe, csvPath, limit)
 	}
-	return candles, nil
-}
-
-func persistCandlesToCSV(path string, candles []backtest.Candle) error {
-	if len(candles) == 0 {
-		return nil
-	}
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return err
-	}
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	w := csv.NewWriter(f)
-	defer w.Flush()
-	if err := w.Write([]string{"timestamp", "open", "high", "low", "close", "volume"}); err != nil {
-		return err
-	}
-	for _, c := range candles {
-		rec := []string{
-			fmt.Sprintf("%d", c.T),
-			fmt.Sprintf("%.8f", c.O),
-			fmt.Sprintf("%.8f", c.H),
-			fmt.Sprintf("%.8f", c.L),
-			fmt.Sprintf("%.8f", c.C),
-			fmt.Sprintf("%.8f", c.V),
-		}
-		if err := w.Write(rec); err != nil {
-			return err
-		}
-	}
-	return nil
+	return fetchFromAPI(instID, br.config.Timeframe, limit)
 }
 
 func fetchFromAPI(instID, timeframe string, limit int) ([]backtest.Candle, error) {
-	client := stream.NewHybridClient()
+	client := newMarketClient()
 	defer client.Close()
 
 	apiCandles, err := client.GetCandles(instID, timeframe, limit)
@@ -1503,6 +1533,18 @@ func annualizeVol(perBarStd float64, barMinutes int) float64 {
 // ==================== main ====================
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fetch" {
+		if err := runFetch(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "live" {
+		if err := runLive(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	runner, err := NewBacktestRunner("backtest_config.json")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,485 @@
+package execution
+
+// 母单执行算法 —— TWAP / VWAP / POV / Iceberg
+// =============================================================================
+// 1) 母单 = 目标净增量（张，带符号）+ 时间窗口；算法只回答“截至此刻应累计完成多少”；
+// 2) 统一驱动（StepAlgos）按 SliceInterval 节拍把“应完成 - 已成交 - 在途”拆成子单，
+//    子单走与 Step 相同的定价/取整/在途记账；窗口到期后剩余部分改为主动价收尾；
+// 3) UseIceberg=true 时所有母单同一时刻最多一个在途子单（隐藏真实规模）；
+// 4) 进度与相对到达价（ArrivalPx）的滑点随成交实时更新，AlgoProgress 查询。
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"Mod/src/storage"
+	"Mod/src/stream"
+)
+
+// Algo —— 执行算法：给出截至 now 应累计完成的数量（张，绝对值，0..Total）
+type Algo interface {
+	Name() string
+	Due(now time.Time, p *ParentOrder) float64
+}
+
+// ParentOrder —— 母单
+type ParentOrder struct {
+	ID         string
+	InstID     string
+	Delta      float64       // 目标净增量（张，带符号）
+	Horizon    time.Duration // 执行窗口
+	ArrivalPx  float64       // 到达价（滑点基准）
+	LimitPx    float64       // 可选：价格保护（买不高于/卖不低于）
+	ReduceOnly bool
+	Start      time.Time
+}
+
+func (p *ParentOrder) Side() Side     { return sideOf(p.Delta) }
+func (p *ParentOrder) Total() float64 { return math.Abs(p.Delta) }
+
+// elapsedFrac —— 已过时间占窗口比例（0..1）
+func (p *ParentOrder) elapsedFrac(now time.Time) float64 {
+	if p.Horizon <= 0 {
+		return 1
+	}
+	return clamp(float64(now.Sub(p.Start))/float64(p.Horizon), 0, 1)
+}
+
+// AlgoProgress —— 母单进度
+type AlgoProgress struct {
+	ID          string
+	Algo        string
+	InstID      string
+	Side        Side
+	Target      float64
+	Filled      float64
+	Working     float64
+	AvgPx       float64
+	ArrivalPx   float64
+	SlippageBps float64 // 正数为成本：买入均价高于到达价 / 卖出均价低于到达价
+	Elapsed     time.Duration
+	Done        bool
+}
+
+// ===================== 算法实现 =====================
+
+// TWAP —— 按 Slice（缺省取 Config.SliceInterval）把窗口等分，逐片线性推进
+type TWAP struct{ Slice time.Duration }
+
+func (a TWAP) Name() string { return "TWAP" }
+
+func (a TWAP) Due(now time.Time, p *ParentOrder) float64 {
+	if p.Horizon <= 0 || a.Slice <= 0 || a.Slice >= p.Horizon {
+		return p.Total() * p.elapsedFrac(now)
+	}
+	n := math.Ceil(float64(p.Horizon) / float64(a.Slice))
+	done := math.Floor(float64(now.Sub(p.Start))/float64(a.Slice)) + 1 // 开始即发第一片
+	return p.Total() * clamp(done/n, 0, 1)
+}
+
+// VolumeProfile —— 日内成交量分布（按 UTC 时段分桶，权重和为 1）
+type VolumeProfile struct {
+	Bucket  time.Duration
+	Weights []float64
+}
+
+// VolumeProfileFromCandles —— 由历史 K 线统计日内成交量分布；无数据时退化为均匀分布
+func VolumeProfileFromCandles(cs []storage.Candle, bucket time.Duration) VolumeProfile {
+	if bucket <= 0 || bucket > 24*time.Hour {
+		bucket = time.Hour
+	}
+	n := int((24 * time.Hour) / bucket)
+	w := make([]float64, n)
+	sum := 0.0
+	for _, c := range cs {
+		if c.V <= 0 {
+			continue
+		}
+		t := time.UnixMilli(c.T).UTC()
+		off := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+		w[int(off/bucket)%n] += c.V
+		sum += c.V
+	}
+	for i := range w {
+		if sum > 0 {
+			w[i] /= sum
+		} else {
+			w[i] = 1 / float64(n)
+		}
+	}
+	return VolumeProfile{Bucket: bucket, Weights: w}
+}
+
+// cum —— [0, t) 日内累计权重（跨日按整日累加）
+func (vp VolumeProfile) cum(t time.Time) float64 {
+	n := len(vp.Weights)
+	if n == 0 || vp.Bucket <= 0 {
+		return 0
+	}
+	t = t.UTC()
+	day := t.Truncate(24 * time.Hour)
+	off := t.Sub(day)
+	i := int(off / vp.Bucket)
+	acc := 0.0
+	for k := 0; k < i && k < n; k++ {
+		acc += vp.Weights[k]
+	}
+	if i < n {
+		acc += vp.Weights[i] * float64(off-time.Duration(i)*vp.Bucket) / float64(vp.Bucket)
+	}
+	return float64(day.Unix()/86400) + acc
+}
+
+// VWAP —— 按历史日内成交量分布分配窗口内的进度
+type VWAP struct{ Profile VolumeProfile }
+
+func (a VWAP) Name() string { return "VWAP" }
+
+func (a VWAP) Due(now time.Time, p *ParentOrder) float64 {
+	end := p.Start.Add(p.Horizon)
+	c0, c1 := a.Profile.cum(p.Start), a.Profile.cum(end)
+	if c1-c0 <= 1e-12 {
+		return p.Total() * p.elapsedFrac(now)
+	}
+	if now.After(end) {
+		now = end
+	}
+	return p.Total() * clamp((a.Profile.cum(now)-c0)/(c1-c0), 0, 1)
+}
+
+// POV —— 跟随实时成交量：应完成 = 参与率 × 母单开始后市场成交量
+// 需把行情成交推给 OnTrades（例如 md.OnTrade(pov.OnTrades)）
+type POV struct {
+	Participation float64
+
+	mu     sync.Mutex
+	inst   string
+	since  time.Time
+	volume float64
+}
+
+func NewPOV(instID string, participation float64) *POV {
+	if participation <= 0 || participation > 1 {
+		participation = 0.05
+	}
+	return &POV{Participation: participation, inst: instID}
+}
+
+func (a *POV) Name() string { return "POV" }
+
+func (a *POV) begin(t time.Time) {
+	a.mu.Lock()
+	a.since = t
+	a.mu.Unlock()
+}
+
+func (a *POV) Due(now time.Time, p *ParentOrder) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.since.IsZero() {
+		a.since = p.Start
+	}
+	return math.Min(p.Total(), a.volume*a.Participation)
+}
+
+// OnTrades —— 累计母单开始后的市场成交量（张）；本方成交也计入市场量，与交易所口径一致
+func (a *POV) OnTrades(arr []stream.TradeData) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range arr {
+		if t.InstID != a.inst {
+			continue
+		}
+		if a.since.IsZero() {
+			continue // 母单尚未开始
+		}
+		if ms, err := strconv.ParseInt(t.Ts, 10, 64); err == nil && time.UnixMilli(ms).Before(a.since) {
+			continue
+		}
+		a.volume += pf(t.Sz)
+	}
+}
+
+// Iceberg —— 全量立即可做，但每次只露出 Display 张
+type Iceberg struct{ Display float64 }
+
+func (a Iceberg) Name() string { return "Iceberg" }
+
+func (a Iceberg) Due(now time.Time, p *ParentOrder) float64 { return p.Total() }
+
+// ===================== 驱动 =====================
+
+type parentRun struct {
+	p        ParentOrder
+	algo     Algo
+	filled   float64
+	notional float64 // Σ qty*px（均价用）
+	children map[string]struct{}
+	lastSent time.Time
+	canceled bool
+	done     bool
+	dec      Decision // 挂母单时的决策上下文
+}
+
+func (r *parentRun) avgPx() float64 {
+	if r.filled <= lotEps {
+		return 0
+	}
+	return r.notional / r.filled
+}
+
+func (r *parentRun) slippageBps() float64 {
+	avg := r.avgPx()
+	if avg <= 0 || r.p.ArrivalPx <= 0 {
+		return 0
+	}
+	bps := (avg - r.p.ArrivalPx) / r.p.ArrivalPx * 10000
+	if r.p.Side() == SideSell {
+		bps = -bps
+	}
+	return bps
+}
+
+// StartParent —— 挂一张母单；Start/ID 为空时自动填充
+func (ex *Executor) StartParent(p ParentOrder, algo Algo) (string, error) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if algo == nil {
+		return "", fmt.Errorf("execution: nil algo")
+	}
+	if ex.halt != nil {
+		return "", fmt.Errorf("execution: halted (%s)", ex.halt.reason)
+	}
+	if _, ok := ex.specs[p.InstID]; !ok {
+		return "", fmt.Errorf("execution: instrument %s not registered", p.InstID)
+	}
+	if nearlyZero(p.Delta) {
+		return "", fmt.Errorf("execution: zero parent delta")
+	}
+	if p.Start.IsZero() {
+		p.Start = time.Now()
+	}
+	dec := ex.ensure(p.InstID).decision
+	if dec.Tag == "" {
+		dec.Tag = algo.Name()
+	}
+	if p.ID == "" {
+		p.ID = "p" + ex.ids.Next(dec.Tag)
+	}
+	if ex.parents == nil {
+		ex.parents = make(map[string]*parentRun)
+		ex.childOf = make(map[string]string)
+	}
+	if _, dup := ex.parents[p.ID]; dup {
+		return "", fmt.Errorf("execution: duplicate parent id %s", p.ID)
+	}
+	if tw, ok := algo.(TWAP); ok && tw.Slice <= 0 {
+		algo = TWAP{Slice: time.Duration(ex.cfg.SliceInterval) * time.Millisecond}
+	}
+	if b, ok := algo.(interface{ begin(time.Time) }); ok {
+		b.begin(p.Start)
+	}
+	ex.parents[p.ID] = &parentRun{p: p, algo: algo, children: make(map[string]struct{}), dec: dec}
+	ex.remember(Origin{ClientID: p.ID, InstID: p.InstID, Tag: dec.Tag, Signal: dec.Signal, Mark: p.ArrivalPx, Parent: p.ID, Algo: algo.Name(), Ts: p.Start})
+	return p.ID, nil
+}
+
+// CancelParent —— 停止母单，并撤掉其在途子单
+func (ex *Executor) CancelParent(id string) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	r, ok := ex.parents[id]
+	if !ok {
+		return Plan{}
+	}
+	r.canceled = true
+	var cancels []CancelRequest
+	st := ex.ensure(r.p.InstID)
+	for cid := range r.children {
+		if o, ok := st.open[cid]; ok && !o.State.Terminal() {
+			cancels = append(cancels, CancelRequest{InstID: r.p.InstID, ClientID: cid})
+		}
+	}
+	return Plan{Cancels: cancels}
+}
+
+// AlgoProgress —— 查询母单进度
+func (ex *Executor) AlgoProgress(id string) (AlgoProgress, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	r, ok := ex.parents[id]
+	if !ok {
+		return AlgoProgress{}, false
+	}
+	return ex.progress(r, time.Now()), true
+}
+
+// AlgoProgressAll —— 全部母单进度（按 ID 排序）
+func (ex *Executor) AlgoProgressAll() []AlgoProgress {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	now := time.Now()
+	out := make([]AlgoProgress, 0, len(ex.parents))
+	for _, r := range ex.parents {
+		out = append(out, ex.progress(r, now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (ex *Executor) progress(r *parentRun, now time.Time) AlgoProgress {
+	return AlgoProgress{
+		ID: r.p.ID, Algo: r.algo.Name(), InstID: r.p.InstID, Side: r.p.Side(),
+		Target: r.p.Total(), Filled: r.filled, Working: ex.working(r),
+		AvgPx: r.avgPx(), ArrivalPx: r.p.ArrivalPx, SlippageBps: r.slippageBps(),
+		Elapsed: now.Sub(r.p.Start), Done: r.done,
+	}
+}
+
+// working —— 子单在途（未成交）数量
+func (ex *Executor) working(r *parentRun) float64 {
+	st := ex.ensure(r.p.InstID)
+	w := 0.0
+	for cid := range r.children {
+		if o, ok := st.open[cid]; ok && !o.State.Terminal() {
+			w += math.Max(0, o.Qty-o.Filled)
+		}
+	}
+	return w
+}
+
+// StepAlgos —— 推进该品种全部母单，返回子单与超时撤单
+func (ex *Executor) StepAlgos(inst string, markPrice float64, now time.Time) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	sp, ok := ex.specs[inst]
+	if !ok || markPrice <= 0 {
+		return Plan{}
+	}
+	st := ex.ensure(inst)
+	st.book.setMark(markPrice, now)
+	if ex.halt != nil {
+		return Plan{}
+	}
+	var plan Plan
+	slice := time.Duration(ex.cfg.SliceInterval) * time.Millisecond
+
+	ids := make([]string, 0, len(ex.parents))
+	for id, r := range ex.parents {
+		if r.p.InstID == inst && !r.done {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		r := ex.parents[id]
+		working := 0.0
+		kids := make(map[string]*openOrder, len(r.children))
+		for cid := range r.children {
+			o, ok := st.open[cid]
+			if !ok {
+				delete(r.children, cid) // 已结束且成交入账
+				delete(ex.childOf, cid)
+				continue
+			}
+			if o.State.Terminal() {
+				continue
+			}
+			working += math.Max(0, o.Qty-o.Filled)
+			kids[cid] = o
+		}
+		cancels, amends := ex.manageResting(inst, st, sp, markPrice, kids, now)
+		plan.Cancels = append(plan.Cancels, cancels...)
+		plan.Amends = append(plan.Amends, amends...)
+
+		remaining := r.p.Total() - r.filled
+		if r.canceled || remaining <= lotEps {
+			if working <= lotEps {
+				r.done = true
+			}
+			continue
+		}
+		if now.Sub(r.lastSent) < slice {
+			continue
+		}
+		ice, isIce := r.algo.(Iceberg)
+		if (isIce || ex.cfg.UseIceberg) && working > lotEps {
+			continue // 冰山：上一片没做完不露新片
+		}
+
+		late := r.p.Horizon > 0 && !now.Before(r.p.Start.Add(r.p.Horizon))
+		due := r.p.Total()
+		if !late {
+			due = math.Min(r.algo.Due(now, &r.p), r.p.Total())
+		}
+		need := due - r.filled - working
+		if need <= lotEps {
+			continue
+		}
+		if !late && need < ex.cfg.ChildMinQty && due < r.p.Total()-lotEps {
+			continue // 不足一片的零头攒到下一拍
+		}
+		q := math.Min(need, ex.cfg.ChildMaxQty)
+		if isIce && ice.Display > 0 {
+			q = math.Min(q, ice.Display)
+		}
+		q = roundDownToLot(q, sp.LotSize)
+		if q <= 0 || q < sp.MinQty {
+			q = roundDownToLot(need, sp.LotSize)
+			if q <= 0 || q < sp.MinQty {
+				continue
+			}
+		}
+
+		sd := r.p.Side()
+		var ord OrderRequest
+		if !late && (ex.cfg.PreferPassive || isIce) {
+			px := ex.limitGuard(ex.pricePassive(markPrice, sd, sp), sd, r.p.LimitPx)
+			ord = ex.makeOrderLimit(inst, r.dec.Tag, sd, q, px, !isIce, r.p.ReduceOnly)
+		} else {
+			px := ex.limitGuard(ex.priceAggressive(markPrice, sd, sp), sd, r.p.LimitPx)
+			ord = ex.makeOrderIOC(inst, r.dec.Tag, sd, q, px, r.p.ReduceOnly)
+		}
+		ord.Meta = map[string]any{"parent": r.p.ID, "algo": r.algo.Name()}
+		arrival := r.p.ArrivalPx
+		if arrival <= 0 {
+			arrival = markPrice
+		}
+		ex.track(st, &ord, markPrice, arrival)
+		r.children[ord.ClientID] = struct{}{}
+		ex.childOf[ord.ClientID] = r.p.ID
+		r.lastSent = now
+		plan.Orders = append(plan.Orders, ord)
+	}
+	return plan
+}
+
+// limitGuard —— 母单限价保护
+func (ex *Executor) limitGuard(px float64, sd Side, limit float64) float64 {
+	if limit <= 0 {
+		return px
+	}
+	if sd == SideBuy {
+		return math.Min(px, limit)
+	}
+	return math.Max(px, limit)
+}
+
+// onChildFill —— 子单成交归集到母单（调用方持有 ex.mu）
+func (ex *Executor) onChildFill(f Fill) {
+	pid, ok := ex.childOf[f.ClientID]
+	if !ok {
+		return
+	}
+	r, ok := ex.parents[pid]
+	if !ok {
+		return
+	}
+	r.filled += f.Qty
+	r.notional += f.Qty * f.Price
+}
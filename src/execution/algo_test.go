@@ -0,0 +1,96 @@
+package execution
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"Mod/src/storage"
+	"Mod/src/stream"
+)
+
+func newAlgoExecutor(cfg Config) *Executor {
+	ex := NewExecutor(cfg)
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, ContractValue: 1, MinQty: 1})
+	return ex
+}
+
+func TestTWAPSlicesAndReportsSlippage(t *testing.T) {
+	ex := newAlgoExecutor(Config{SliceInterval: 1000, ChildMinQty: 1, CancelStaleAfterMs: 60000})
+	t0 := time.Unix(1700000000, 0)
+	id, err := ex.StartParent(ParentOrder{InstID: "X", Delta: 10, Horizon: 10 * time.Second, ArrivalPx: 100, Start: t0}, TWAP{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := ex.StepAlgos("X", 100, t0)
+	if len(p.Orders) != 1 || p.Orders[0].Qty != 1 || p.Orders[0].Side != SideBuy {
+		t.Fatalf("unexpected first slice: %+v", p.Orders)
+	}
+	// 同一节拍内不重复发单
+	if p2 := ex.StepAlgos("X", 100, t0.Add(500*time.Millisecond)); len(p2.Orders) != 0 {
+		t.Fatalf("slice interval not respected: %+v", p2.Orders)
+	}
+	ex.OnFill(Fill{InstID: "X", ClientID: p.Orders[0].ClientID, Side: SideBuy, Qty: 1, Price: 101, TradeID: "1"})
+
+	// 4.5s 后应完成 5 片
+	p = ex.StepAlgos("X", 100, t0.Add(4500*time.Millisecond))
+	if len(p.Orders) != 1 || p.Orders[0].Qty != 4 {
+		t.Fatalf("unexpected catch-up slice: %+v", p.Orders)
+	}
+	pr, ok := ex.AlgoProgress(id)
+	if !ok || pr.Filled != 1 || pr.Working != 4 || math.Abs(pr.SlippageBps-100) > 1e-9 {
+		t.Fatalf("unexpected progress: %+v", pr)
+	}
+
+	// 窗口到期：剩余全部主动收尾
+	p = ex.StepAlgos("X", 100, t0.Add(11*time.Second))
+	if len(p.Orders) != 1 || p.Orders[0].Qty != 5 || p.Orders[0].TimeInForce != IOC {
+		t.Fatalf("unexpected final sweep: %+v", p.Orders)
+	}
+}
+
+func TestIcebergShowsOneSliceAtATime(t *testing.T) {
+	ex := newAlgoExecutor(Config{SliceInterval: 1, ChildMinQty: 1})
+	t0 := time.Now()
+	if _, err := ex.StartParent(ParentOrder{InstID: "X", Delta: -7, Horizon: time.Hour, ArrivalPx: 100, Start: t0}, Iceberg{Display: 3}); err != nil {
+		t.Fatal(err)
+	}
+	p := ex.StepAlgos("X", 100, t0)
+	if len(p.Orders) != 1 || p.Orders[0].Qty != 3 || p.Orders[0].Side != SideSell || p.Orders[0].TimeInForce != GTC {
+		t.Fatalf("unexpected iceberg slice: %+v", p.Orders)
+	}
+	if p2 := ex.StepAlgos("X", 100, t0.Add(time.Second)); len(p2.Orders) != 0 {
+		t.Fatalf("iceberg exposed a second slice: %+v", p2.Orders)
+	}
+}
+
+func TestPOVFollowsMarketVolume(t *testing.T) {
+	ex := newAlgoExecutor(Config{SliceInterval: 1, ChildMinQty: 1})
+	t0 := time.Now()
+	pov := NewPOV("X", 0.1)
+	if _, err := ex.StartParent(ParentOrder{InstID: "X", Delta: 100, Horizon: time.Hour, Start: t0}, pov); err != nil {
+		t.Fatal(err)
+	}
+	if p := ex.StepAlgos("X", 100, t0); len(p.Orders) != 0 {
+		t.Fatalf("pov traded without market volume: %+v", p.Orders)
+	}
+	pov.OnTrades([]stream.TradeData{{InstID: "X", Sz: "30"}, {InstID: "Y", Sz: "1000"}})
+	if p := ex.StepAlgos("X", 100, t0.Add(time.Second)); len(p.Orders) != 1 || p.Orders[0].Qty != 3 {
+		t.Fatalf("unexpected pov child: %+v", p.Orders)
+	}
+}
+
+func TestVolumeProfileWeightsVWAP(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cs := []storage.Candle{
+		{T: day.Add(1 * time.Hour).UnixMilli(), V: 300},
+		{T: day.Add(2 * time.Hour).UnixMilli(), V: 100},
+	}
+	vp := VolumeProfileFromCandles(cs, time.Hour)
+	a := VWAP{Profile: vp}
+	po := &ParentOrder{Delta: 40, Horizon: 2 * time.Hour, Start: day.Add(time.Hour)}
+	if got := a.Due(day.Add(2*time.Hour), po); math.Abs(got-30) > 1e-9 {
+		t.Fatalf("vwap due after first hour = %.4f, want 30", got)
+	}
+}
@@ -0,0 +1,143 @@
+package execution
+
+// clOrdId 生成 —— OKX 合规、进程内单调、模拟可复现、携带策略/决策标签
+// =============================================================================
+// 1) 格式：<tag ≤8><session 6><seq 8>，仅字母数字（OKX clOrdId 规则：1-32 位字母数字）；
+//    最长 22 位，为随单止盈止损的 "a" 后缀等派生 ID 留出余量；
+// 2) seq 为定宽 base36 递增计数，同一会话内严格单调，不依赖时钟，快速切片也不会撞号；
+// 3) session 默认取启动时刻（秒，base36），不同进程互不冲突；Config.ClientIDSession 固定后同一输入序列生成同一串 ID；
+// 4) 每个 ID 登记来源（信号 / 风控批准仓位 / 母单），Origin 查询，容量 maxOrigins 先进先出。
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	idTagMax     = 8
+	idSessionLen = 6
+	idSeqLen     = 8
+	maxOrigins   = 4096
+)
+
+// IDGen —— clOrdId 生成器（并发安全）
+type IDGen struct {
+	mu      sync.Mutex
+	session string
+	seq     uint64
+}
+
+// NewIDGen —— session 为空时按当前时间生成；否则清洗为字母数字并定长到 6 位
+func NewIDGen(session string) *IDGen {
+	if session == "" {
+		session = strconv.FormatInt(time.Now().Unix(), 36)
+	}
+	return &IDGen{session: fixWidth(alnum(session), idSessionLen)}
+}
+
+// Session —— 会话前缀
+func (g *IDGen) Session() string { return g.session }
+
+// Next —— 生成下一个 ID；tag 只保留字母数字，超长截断，为空记为 x
+func (g *IDGen) Next(tag string) string {
+	g.mu.Lock()
+	g.seq++
+	n := g.seq
+	g.mu.Unlock()
+	return cleanTag(tag) + g.session + fixWidth(strconv.FormatUint(n, 36), idSeqLen)
+}
+
+// ParseClientID —— 拆出 tag / session / seq（非本生成器格式返回 ok=false）
+func ParseClientID(id string) (tag, session string, seq uint64, ok bool) {
+	n := len(id)
+	if n <= idSessionLen+idSeqLen || n > idTagMax+idSessionLen+idSeqLen || alnum(id) != id {
+		return "", "", 0, false
+	}
+	seq, err := strconv.ParseUint(id[n-idSeqLen:], 36, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return id[:n-idSessionLen-idSeqLen], id[n-idSessionLen-idSeqLen : n-idSeqLen], seq, true
+}
+
+func cleanTag(tag string) string {
+	tag = alnum(tag)
+	if tag == "" {
+		return "x"
+	}
+	if len(tag) > idTagMax {
+		tag = tag[:idTagMax]
+	}
+	return tag
+}
+
+func alnum(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// fixWidth —— 左补 0 / 保留低位到定长
+func fixWidth(s string, n int) string {
+	if len(s) >= n {
+		return s[len(s)-n:]
+	}
+	return strings.Repeat("0", n-len(s)) + s
+}
+
+// ===================== 决策标签与来源登记 =====================
+
+// Decision —— 当前决策上下文（由策略/组合层在 Step 前设置）
+type Decision struct {
+	Tag    string // 策略/决策标签，写入 clOrdId（≤8 位字母数字）
+	Signal string // 信号标识（信号 ID、理由等，仅登记不下发）
+}
+
+// Origin —— clOrdId 的来源
+type Origin struct {
+	ClientID string
+	InstID   string
+	Tag      string
+	Signal   string
+	Approved float64 // 风控批准后的相对仓位（Step 入参；母单子单为 0）
+	Mark     float64 // 决策 mark
+	Parent   string  // 母单 ID（algo 子单）
+	Algo     string
+	Ts       time.Time
+}
+
+// SetDecision —— 设置品种的决策上下文；之后 Step/StartParent 产生的订单都带上它
+func (ex *Executor) SetDecision(inst string, d Decision) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.ensure(inst).decision = d
+}
+
+// Origin —— 按 clOrdId 查来源（含母单 ID、常驻止损）
+func (ex *Executor) Origin(clientID string) (Origin, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	o, ok := ex.origins[clientID]
+	return o, ok
+}
+
+// remember —— 登记来源（调用方持有 ex.mu）
+func (ex *Executor) remember(o Origin) {
+	if ex.origins == nil {
+		ex.origins = make(map[string]Origin)
+	}
+	if _, ok := ex.origins[o.ClientID]; !ok {
+		ex.originQ = append(ex.originQ, o.ClientID)
+	}
+	ex.origins[o.ClientID] = o
+	for len(ex.originQ) > maxOrigins {
+		delete(ex.origins, ex.originQ[0])
+		ex.originQ = ex.originQ[1:]
+	}
+}
@@ -0,0 +1,78 @@
+package execution
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+var okxClOrdID = regexp.MustCompile(`^[a-zA-Z0-9]{1,32}$`)
+
+func TestIDGenCompliantMonotonicAndReproducible(t *testing.T) {
+	g := NewIDGen("sim-01")
+	prev := ""
+	for i := 0; i < 2000; i++ {
+		id := g.Next("QuantMaster-Elite")
+		if !okxClOrdID.MatchString(id) || len(id)+1 > 32 {
+			t.Fatalf("non-compliant id %q", id)
+		}
+		if id <= prev {
+			t.Fatalf("not monotonic: %q after %q", id, prev)
+		}
+		prev = id
+	}
+	tag, session, seq, ok := ParseClientID(prev)
+	if !ok || tag != "QuantMas" || session != "0sim01" || seq != 2000 {
+		t.Fatalf("parse %q: %q %q %d %v", prev, tag, session, seq, ok)
+	}
+	if _, _, _, ok := ParseClientID("BTC-USDT-SWAP-1712345678901234567"); ok {
+		t.Fatal("legacy id should not parse")
+	}
+
+	// 固定会话：同一输入序列生成同一串 ID
+	run := func() []string {
+		ex := NewExecutor(Config{AccountEquity: 1000, LeverageCap: 1, ChildMinQty: 1, ChildMaxQty: 1, ClientIDSession: "bt"})
+		ex.RegisterInstrument(InstrumentSpec{InstID: "BTC-USDT-SWAP", TickSize: 1, LotSize: 1, ContractValue: 1})
+		ex.SetDecision("BTC-USDT-SWAP", Decision{Tag: "trend", Signal: "sig-42"})
+		var ids []string
+		for i := 0; i < 3; i++ {
+			for _, o := range ex.Step("BTC-USDT-SWAP", 0.01, 100, 1000).Orders {
+				ids = append(ids, o.ClientID)
+			}
+		}
+		return ids
+	}
+	a, b := run(), run()
+	if len(a) != 3 || len(b) != 3 || a[0] != b[0] || a[2] != b[2] || a[0] != "trend0000bt00000001" {
+		t.Fatalf("not reproducible: %v vs %v", a, b)
+	}
+}
+
+func TestOriginLookup(t *testing.T) {
+	ex := NewExecutor(Config{AccountEquity: 1000, LeverageCap: 1, ChildMinQty: 1, ChildMaxQty: 5, SliceInterval: 1})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 1, LotSize: 1, ContractValue: 1})
+	ex.SetDecision("X", Decision{Tag: "mr", Signal: "zscore<-2"})
+
+	o := ex.Step("X", 0.002, 100, 1000).Orders[0]
+	org, ok := ex.Origin(o.ClientID)
+	if !ok || org.Tag != "mr" || org.Signal != "zscore<-2" || org.Approved != 0.002 || org.Mark != 100 || o.Meta["signal"] != "zscore<-2" {
+		t.Fatalf("direct origin: %+v %v", org, o.Meta)
+	}
+
+	now := time.Now()
+	pid, err := ex.StartParent(ParentOrder{InstID: "X", Delta: 4, Horizon: time.Minute, ArrivalPx: 100, Start: now}, TWAP{Slice: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kids := ex.StepAlgos("X", 101, now.Add(30*time.Second)).Orders
+	if len(kids) != 1 {
+		t.Fatalf("children: %+v", kids)
+	}
+	org, ok = ex.Origin(kids[0].ClientID)
+	if !ok || org.Parent != pid || org.Algo != "TWAP" || org.Tag != "mr" || org.Approved != 0 || org.Mark != 101 {
+		t.Fatalf("child origin: %+v", org)
+	}
+	if p, ok := ex.Origin(pid); !ok || p.Signal != "zscore<-2" {
+		t.Fatalf("parent origin: %+v", p)
+	}
+}
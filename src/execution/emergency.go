@@ -0,0 +1,443 @@
+package execution
+
+// 紧急平仓 —— 撤掉全部挂单/策略单，reduce-only 市价单一次性平掉全部持仓
+// =============================================================================
+// 1) Halt 之后 Step / StepAlgos / SyncStops / StartParent 不再产生新单，直到人工 Resume；
+// 2) FlattenPlan 不走参与率/子单上限：每个品种一笔 reduce-only 市价 IOC，数量 = 全部持仓；
+// 3) Flattener 负责收发与确认：以交易所持仓推送（或快照）归零为准，未归零则按剩余持仓重发，
+//    最多 FlattenMaxRounds 轮；
+// 4) 场所能列出未触发策略单（AlgoOrderLister）时按交易所编号全部撤掉，含本地不认识的；
+// 5) 触发入口：风控 halt 动作（OnRiskActions）、信号文件（WatchFile）、HTTP（Handler）。
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Mod/src/risk"
+	"Mod/src/storage"
+)
+
+// ===================== 执行器侧：停机与平仓计划 =====================
+
+// haltState —— 停机状态；pushAt 为停机后最近一次持仓推送时刻
+type haltState struct {
+	reason string
+	since  time.Time
+	pushAt time.Time
+}
+
+// Halt —— 进入停机：此后不再产生新开仓/调仓单（重复调用只更新原因）
+func (ex *Executor) Halt(reason string) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.halt != nil {
+		ex.halt.reason = reason
+		return
+	}
+	ex.halt = &haltState{reason: reason, since: time.Now()}
+	for _, r := range ex.parents {
+		r.canceled = true
+	}
+}
+
+// Halted —— 是否停机及原因
+func (ex *Executor) Halted() (bool, string) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.halt == nil {
+		return false, ""
+	}
+	return true, ex.halt.reason
+}
+
+// Resume —— 解除停机（人工确认后调用）
+func (ex *Executor) Resume() {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.halt = nil
+}
+
+// FlattenPlan —— 生成“撤全部 + 平全部”计划；snap 非空时以交易所挂单/持仓为准（补上本地未知的挂单）
+func (ex *Executor) FlattenPlan(snap *ExchangeSnapshot) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	var plan Plan
+	seen := make(map[string]bool)
+	for inst, st := range ex.ins {
+		for id, o := range st.open {
+			if !o.State.Terminal() {
+				plan.Cancels = append(plan.Cancels, CancelRequest{InstID: inst, ClientID: id})
+				seen[id] = true
+			}
+		}
+		for _, s := range []*liveStop{st.stop, st.stopPending} {
+			if s != nil {
+				plan.AlgoCancels = append(plan.AlgoCancels, AlgoCancelRequest{InstID: inst, ClientID: s.ClientID})
+			}
+		}
+		st.stop, st.stopPending = nil, nil
+	}
+	for _, r := range ex.parents {
+		r.canceled = true
+	}
+
+	pos := make(map[string]float64)
+	if snap != nil {
+		for _, o := range snap.OpenOrders {
+			if o.ClientID != "" && !seen[o.ClientID] {
+				plan.Cancels = append(plan.Cancels, CancelRequest{InstID: o.InstID, ClientID: o.ClientID})
+				seen[o.ClientID] = true
+			}
+		}
+		for _, p := range snap.Positions {
+			pos[p.InstID] += p.Qty
+		}
+	} else {
+		for inst, st := range ex.ins {
+			pos[inst] = st.position
+		}
+	}
+
+	for inst, q := range pos {
+		qty := roundDownToLot(math.Abs(q), ex.specs[inst].LotSize)
+		if qty <= lotEps {
+			continue
+		}
+		st := ex.ensure(inst)
+		ord := OrderRequest{
+			InstID: inst, Side: sideOpposite(q), Type: OrdMarket, Qty: qty,
+			TimeInForce: IOC, ReduceOnly: true, ClientID: ex.ids.Next("flat"),
+		}
+		ex.track(st, &ord, st.book.mark, st.book.mark)
+		plan.Orders = append(plan.Orders, ord)
+	}
+	return plan
+}
+
+// flatSince —— 停机后、since 之后收到过持仓推送，且本地净仓位全部归零
+func (ex *Executor) flatSince(since time.Time) bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.halt == nil || !ex.halt.pushAt.After(since) {
+		return false
+	}
+	for _, st := range ex.ins {
+		if !nearlyZero(st.position) {
+			return false
+		}
+	}
+	return true
+}
+
+// residual —— 未平的净仓位（张）
+func (ex *Executor) residual() map[string]float64 {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	out := make(map[string]float64)
+	for inst, st := range ex.ins {
+		if !nearlyZero(st.position) {
+			out[inst] = st.position
+		}
+	}
+	return out
+}
+
+// ===================== Flattener：收发与确认 =====================
+
+// PendingAlgo —— 交易所侧未触发的策略单；AlgoID 为交易所编号
+// （手工下的、重启前下的策略单可能没有 algoClOrdId 或不在适配器的映射里，只能按它撤）
+type PendingAlgo struct {
+	AlgoOrderRequest
+	AlgoID string
+}
+
+// AlgoOrderLister —— 可列出交易所侧未触发策略单的场所（可选能力）
+type AlgoOrderLister interface {
+	PendingAlgos() ([]PendingAlgo, error)
+}
+
+// FlattenReport —— 一次紧急平仓的结果
+type FlattenReport struct {
+	Reason      string             `json:"reason"`
+	Started     time.Time          `json:"started"`
+	Finished    time.Time          `json:"finished"`
+	Rounds      int                `json:"rounds"`
+	Cancels     int                `json:"cancels"`
+	AlgoCancels int                `json:"algoCancels"`
+	Orders      int                `json:"orders"`
+	Flat        bool               `json:"flat"`
+	Residual    map[string]float64 `json:"residual,omitempty"`
+	Errors      []string           `json:"errors,omitempty"`
+}
+
+// Flattener —— 紧急平仓执行者（并发安全；同一时刻只跑一次）
+type Flattener struct {
+	ex    *Executor
+	venue ExchangeAdapter
+	lg    *storage.TradeLogger
+
+	mu      sync.Mutex
+	running bool
+	last    *FlattenReport
+
+	poll time.Duration
+}
+
+// NewFlattener —— venue 为实际收发的适配器（可为 ResilientAdapter）；lg 可为 nil
+func NewFlattener(ex *Executor, venue ExchangeAdapter, lg *storage.TradeLogger) *Flattener {
+	return &Flattener{ex: ex, venue: venue, lg: lg, poll: 50 * time.Millisecond}
+}
+
+// Flatten —— 同步执行：停机 → 逐轮撤单/平仓 → 等持仓推送确认归零
+func (f *Flattener) Flatten(ctx context.Context, reason string) (FlattenReport, error) {
+	if !f.begin() {
+		return FlattenReport{}, errors.New("紧急平仓已在进行中")
+	}
+	defer f.end()
+	return f.run(ctx, reason)
+}
+
+// Trigger —— 异步触发（已在进行中则忽略）；返回是否新启动
+func (f *Flattener) Trigger(reason string) bool {
+	if !f.begin() {
+		return false
+	}
+	go func() {
+		defer f.end()
+		_, _ = f.run(context.Background(), reason)
+	}()
+	return true
+}
+
+func (f *Flattener) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running {
+		return false
+	}
+	f.running = true
+	return true
+}
+
+func (f *Flattener) end() {
+	f.mu.Lock()
+	f.running = false
+	f.mu.Unlock()
+}
+
+func (f *Flattener) run(ctx context.Context, reason string) (FlattenReport, error) {
+	ex := f.ex
+	ex.Halt(reason)
+	rep := FlattenReport{Reason: reason, Started: time.Now()}
+	log.Printf("🛑 紧急平仓: %s", reason)
+	f.action("", "halt", reason, 0, nil)
+
+	wait := time.Duration(ex.cfg.FlattenConfirmMs) * time.Millisecond
+	for rep.Rounds < ex.cfg.FlattenMaxRounds && !rep.Flat && ctx.Err() == nil {
+		rep.Rounds++
+		sent := time.Now()
+
+		var snap *ExchangeSnapshot
+		if sp, ok := f.venue.(SnapshotProvider); ok {
+			s, err := sp.Snapshot()
+			if err != nil {
+				rep.Errors = append(rep.Errors, err.Error())
+			} else {
+				snap = &s
+			}
+		}
+		plan := ex.FlattenPlan(snap)
+		if snap != nil && len(plan.Orders) == 0 && len(snap.OpenOrders) == 0 {
+			rep.Flat = true // 交易所快照已无持仓
+		}
+		if al, ok := f.venue.(AlgoOrderLister); ok {
+			pending, err := al.PendingAlgos()
+			if err != nil && !errors.Is(err, ErrUnsupported) {
+				rep.Errors = append(rep.Errors, err.Error())
+			}
+			known := make(map[string]int, len(plan.AlgoCancels))
+			for i, c := range plan.AlgoCancels {
+				known[c.ClientID] = i
+			}
+			for _, a := range pending {
+				if i, ok := known[a.ClientID]; ok && a.ClientID != "" {
+					plan.AlgoCancels[i].AlgoID = a.AlgoID
+					continue
+				}
+				plan.AlgoCancels = append(plan.AlgoCancels, AlgoCancelRequest{InstID: a.InstID, ClientID: a.ClientID, AlgoID: a.AlgoID})
+			}
+		}
+		f.send(plan, &rep)
+		if rep.Flat {
+			break
+		}
+
+		deadline := sent.Add(wait)
+		for time.Now().Before(deadline) && ctx.Err() == nil {
+			if ex.flatSince(sent) {
+				rep.Flat = true
+				break
+			}
+			time.Sleep(f.poll)
+		}
+	}
+
+	rep.Finished = time.Now()
+	if !rep.Flat {
+		rep.Residual = ex.residual()
+	}
+	f.mu.Lock()
+	f.last = &rep
+	f.mu.Unlock()
+
+	meta := map[string]any{"rounds": rep.Rounds, "orders": rep.Orders, "cancels": rep.Cancels, "algoCancels": rep.AlgoCancels, "flat": rep.Flat}
+	if rep.Flat {
+		log.Printf("✅ 紧急平仓完成: %d 轮, 撤单 %d, 撤策略单 %d, 平仓单 %d", rep.Rounds, rep.Cancels, rep.AlgoCancels, rep.Orders)
+		f.action("", "flatten", reason, 0, meta)
+		return rep, nil
+	}
+	meta["residual"] = rep.Residual
+	log.Printf("⚠️ 紧急平仓未确认归零: %d 轮后剩余 %v", rep.Rounds, rep.Residual)
+	f.action("", "flatten", reason, 0, meta)
+	if err := ctx.Err(); err != nil {
+		return rep, err
+	}
+	return rep, fmt.Errorf("紧急平仓 %d 轮后仍有持仓: %v", rep.Rounds, rep.Residual)
+}
+
+// send —— 先撤单、撤策略单，再发平仓单（reduce-only 在撤单未完成时也不会反向开仓）；
+// 不经过 PreTradeGate：平仓单只减不增，且无 mark 时也必须能发出
+func (f *Flattener) send(plan Plan, rep *FlattenReport) {
+	note := func(err error) {
+		if err != nil {
+			rep.Errors = append(rep.Errors, err.Error())
+			log.Printf("⚠️ 紧急平仓: %v", err)
+		}
+	}
+	if len(plan.Cancels) > 0 {
+		note(f.venue.CancelOrders(plan.Cancels))
+		rep.Cancels += len(plan.Cancels)
+	}
+	if len(plan.AlgoCancels) > 0 {
+		if at, ok := f.venue.(AlgoTrader); ok {
+			note(at.CancelAlgoOrders(plan.AlgoCancels))
+			rep.AlgoCancels += len(plan.AlgoCancels)
+		}
+	}
+	if len(plan.Orders) > 0 {
+		note(f.venue.SendOrders(plan.Orders))
+		rep.Orders += len(plan.Orders)
+	}
+}
+
+// Last —— 最近一次紧急平仓结果
+func (f *Flattener) Last() (FlattenReport, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last == nil {
+		return FlattenReport{}, false
+	}
+	return *f.last, true
+}
+
+func (f *Flattener) action(inst, typ, reason string, size float64, meta map[string]any) {
+	if f.lg == nil {
+		return
+	}
+	_ = f.lg.Action(storage.LogAction{InstID: inst, Type: typ, Reason: reason, Size: size, Meta: meta})
+}
+
+// ===================== 触发入口 =====================
+
+// OnRiskActions —— 风控动作中含 halt（如 KillSwitch）即触发
+func (f *Flattener) OnRiskActions(acts []risk.Action) bool {
+	for _, a := range acts {
+		if a.Type == "halt" {
+			return f.Trigger("risk:" + a.Reason)
+		}
+	}
+	return false
+}
+
+// WatchFile —— 轮询信号文件：出现即触发（文件内容作为原因），触发后删除文件
+func (f *Flattener) WatchFile(ctx context.Context, path string, every time.Duration) {
+	if every <= 0 {
+		every = time.Second
+	}
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				b, err := os.ReadFile(path)
+				if err != nil {
+					continue
+				}
+				if err := os.Remove(path); err != nil {
+					log.Printf("⚠️ 删除平仓信号文件失败: %v", err)
+				}
+				reason := strings.TrimSpace(string(b))
+				if reason == "" {
+					reason = "signal_file"
+				}
+				f.Trigger("file:" + reason)
+			}
+		}
+	}()
+}
+
+// Handler —— HTTP 入口：GET 查询状态；POST 触发平仓（?reason=...），POST ?action=resume 解除停机。
+// token 非空时要求请求头 X-Flatten-Token 一致
+func (f *Flattener) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("X-Flatten-Token") != token {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if r.URL.Query().Get("action") == "resume" {
+				f.mu.Lock()
+				busy := f.running
+				f.mu.Unlock()
+				if busy {
+					http.Error(w, "flatten in progress", http.StatusConflict)
+					return
+				}
+				f.ex.Resume()
+				log.Printf("✅ 已解除停机")
+				break
+			}
+			reason := r.URL.Query().Get("reason")
+			if reason == "" {
+				reason = "manual"
+			}
+			if !f.Trigger("http:" + reason) {
+				http.Error(w, "flatten in progress", http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		halted, why := f.ex.Halted()
+		f.mu.Lock()
+		body := map[string]any{"halted": halted, "reason": why, "running": f.running, "last": f.last}
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,140 @@
+package execution
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/risk"
+	"Mod/src/stream"
+)
+
+func TestFlattenerCancelsAllAndClosesInOneShot(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1e6})
+	ex := NewExecutor(Config{AccountEquity: 1e6, LeverageCap: 1, ChildMinQty: 1, ChildMaxQty: 5, FlattenConfirmMs: 200})
+	for _, sp := range []InstrumentSpec{{InstID: "X", TickSize: 1, LotSize: 1, CtVal: 1}, {InstID: "Y", TickSize: 0.1, LotSize: 1, CtVal: 1}} {
+		ex.RegisterInstrument(sp)
+		p.RegisterInstrument(sp)
+	}
+	ex.Bind(p)
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99", AskPx: "101", Last: "100"}, {InstID: "Y", BidPx: "9", AskPx: "11", Last: "10"}})
+
+	// 建仓（多 X 30 张、空 Y 7 张）+ 本地未知的挂单 + 常驻止损 + 本地未知的策略单
+	if err := p.SendOrders([]OrderRequest{
+		{InstID: "X", Side: SideBuy, Type: OrdLimit, Qty: 30, Price: 102, TimeInForce: IOC, ClientID: "a"},
+		{InstID: "Y", Side: SideSell, Type: OrdLimit, Qty: 7, Price: 8, TimeInForce: IOC, ClientID: "b"},
+		{InstID: "X", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 90, TimeInForce: GTC, ClientID: "rest"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendAlgoOrders(append(ex.SyncStops("X", 80).Algos, AlgoOrderRequest{InstID: "Y", ClientID: "tp1", Type: AlgoTrigger, Side: SideBuy, Qty: 7, TriggerPx: 5})); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlattener(ex, p, nil)
+	rep, err := f.Flatten(context.Background(), "test")
+	if err != nil || !rep.Flat || rep.Orders != 2 || rep.Cancels != 1 || rep.AlgoCancels != 2 {
+		t.Fatalf("report: %+v err=%v", rep, err)
+	}
+	snap, _ := p.Snapshot()
+	if len(snap.Positions) != 0 || len(snap.OpenOrders) != 0 || len(p.AlgoOrders()) != 0 {
+		t.Fatalf("venue not flat: %+v algos=%v", snap, p.AlgoOrders())
+	}
+
+	// 停机：不再产生新单
+	if plan := ex.Step("X", 0.5, 100, 1e6); len(plan.Orders) != 0 {
+		t.Fatalf("halted executor traded: %+v", plan)
+	}
+	if _, err := ex.StartParent(ParentOrder{InstID: "X", Delta: 3}, TWAP{}); err == nil {
+		t.Fatal("parent accepted while halted")
+	}
+
+	// 风控 halt 动作：已平仓时首轮快照即确认
+	if f.OnRiskActions([]risk.Action{{InstID: "X", Type: "reduce"}}) {
+		t.Fatal("non-halt action triggered")
+	}
+	if !f.OnRiskActions([]risk.Action{{InstID: "X", Type: "halt", Reason: "kill_switch"}}) {
+		t.Fatal("halt action ignored")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if last, ok := f.Last(); ok && last.Reason == "risk:kill_switch" && last.Flat && last.Rounds == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			last, _ := f.Last()
+			t.Fatalf("risk trigger did not finish: %+v", last)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// HTTP：鉴权、查询、解除停机
+	srv := httptest.NewServer(f.Handler("secret"))
+	defer srv.Close()
+	if resp, _ := http.Get(srv.URL); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("no token: %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"?action=resume", nil)
+	req.Header.Set("X-Flatten-Token", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct{ Halted bool }
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || body.Halted {
+		t.Fatalf("resume: %d %+v", resp.StatusCode, body)
+	}
+	if halted, _ := ex.Halted(); halted {
+		t.Fatal("still halted")
+	}
+}
+
+func TestFlattenerCancelsExchangeAlgosByAlgoID(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data any = []any{}
+		switch r.URL.Path {
+		case "/api/v5/trade/orders-algo-pending":
+			switch r.URL.Query().Get("ordType") {
+			case "conditional": // 重启前下的常驻止损，适配器映射里没有
+				data = []map[string]string{{"algoId": "A1", "algoClOrdId": "sl1", "instId": "X", "ordType": "conditional", "side": "sell", "sz": "3", "slTriggerPx": "90"}}
+			case "trigger": // 手工下的计划委托，没有 algoClOrdId
+				data = []map[string]string{{"algoId": "A2", "instId": "X", "ordType": "trigger", "side": "buy", "sz": "1", "triggerPx": "120"}}
+			}
+		case "/api/v5/trade/cancel-algos":
+			var args []map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&args)
+			items := make([]map[string]string, 0, len(args))
+			mu.Lock()
+			for _, a := range args {
+				cancelled = append(cancelled, a["algoId"])
+				items = append(items, map[string]string{"algoId": a["algoId"], "sCode": "0"})
+			}
+			mu.Unlock()
+			data = items
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": data})
+	}))
+	defer srv.Close()
+
+	okx := NewOKXAdapter(config.ExchangeConfig{APIKey: "key", SecretKey: "s", Passphrase: "pass", BaseURL: srv.URL})
+	venue := NewResilientAdapter(okx, RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond})
+	ex := NewExecutor(Config{FlattenConfirmMs: 50})
+	rep, err := NewFlattener(ex, venue, nil).Flatten(context.Background(), "test")
+	if err != nil || !rep.Flat || rep.AlgoCancels != 2 {
+		t.Fatalf("report: %+v err=%v", rep, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(cancelled) != 2 || cancelled[0] != "A1" || cancelled[1] != "A2" {
+		t.Fatalf("cancelled algoIds: %v", cancelled)
+	}
+}
@@ -1,511 +1,874 @@
-package execution
-
-import (
-	"fmt"
-	"math"
-	"time"
-)
-
-type Side string
-
-const (
-	SideBuy  Side = "buy"
-	SideSell Side = "sell"
-)
-
-type OrderType string
-
-const (
-	OrdLimit  OrderType = "limit"
-	OrdMarket OrderType = "market"
-)
-
-type TIF string
-
-const (
-	GTC TIF = "GTC"
-	IOC TIF = "IOC"
-	FOK TIF = "FOK"
-)
-
-type OrderRequest struct {
-	InstID      string
-	Side        Side
-	Type        OrderType
-	Qty         float64
-	Price       float64
-	TimeInForce TIF
-	PostOnly    bool
-	ReduceOnly  bool
-	ClientID    string
-	Meta        map[string]any
-}
-
-type CancelRequest struct {
-	InstID   string
-	ClientID string
-}
-
-type Plan struct {
-	Orders  []OrderRequest
-	Cancels []CancelRequest
-}
-
-type OrderUpdate struct {
-	InstID    string
-	ClientID  string
-	FilledQty float64
-	Status    string // new/partially_filled/filled/canceled/rejected
-	AvgPrice  float64
-}
-
-type Fill struct {
-	InstID   string
-	ClientID string
-	Side     Side
-	Qty      float64
-	Price    float64
-	Fee      float64
-}
-
-// ===================== 规格/配置 =====================
-
-type InstrumentSpec struct {
-	InstID        string
-	TickSize      float64
-	LotSize       float64
-	ContractValue float64 // 每张名义（计价币），如 1 USDT/张
-	MinNotional   float64 // 最小名义（计价币）
-	MinQty        float64 // 最小下单张数
-}
-
-type Config struct {
-	AccountEquity float64
-	LeverageCap   float64
-
-	MaxChildSlices   int
-	SliceInterval    int
-	MaxParticipation float64
-	ChildMinQty      float64
-	ChildMaxQty      float64
-
-	PassivePriceOffsetTicks int
-	AggressiveSlippageBps   float64
-
-	PreferPassive bool
-	UseIceberg    bool
-
-	CancelStaleAfterMs int
-	MaxRetries         int
-}
-
-func (c *Config) withDefaults() Config {
-	q := *c
-	if q.AccountEquity <= 0 {
-		q.AccountEquity = 10000
-	}
-	if q.LeverageCap <= 0 {
-		q.LeverageCap = 3
-	}
-	if q.MaxChildSlices <= 0 {
-		q.MaxChildSlices = 4
-	}
-	if q.SliceInterval <= 0 {
-		q.SliceInterval = 500
-	}
-	if q.MaxParticipation <= 0 {
-		q.MaxParticipation = 0.05
-	}
-	if q.ChildMinQty <= 0 {
-		q.ChildMinQty = 1
-	}
-	if q.ChildMaxQty <= 0 || q.ChildMaxQty < q.ChildMinQty {
-		q.ChildMaxQty = 100
-	}
-	if q.PassivePriceOffsetTicks <= 0 {
-		q.PassivePriceOffsetTicks = 1
-	}
-	if q.AggressiveSlippageBps <= 0 {
-		q.AggressiveSlippageBps = 10
-	}
-	if q.CancelStaleAfterMs <= 0 {
-		q.CancelStaleAfterMs = 3000
-	}
-	if q.MaxRetries <= 0 {
-		q.MaxRetries = 2
-	}
-	return q
-}
-
-// ===================== 执行器主体 =====================
-
-type Executor struct {
-	cfg   Config
-	specs map[string]InstrumentSpec
-	ins   map[string]*state
-}
-
-func NewExecutor(cfg Config) *Executor {
-	c := cfg.withDefaults()
-	return &Executor{
-		cfg:   c,
-		specs: make(map[string]InstrumentSpec),
-		ins:   make(map[string]*state),
-	}
-}
-
-func (ex *Executor) RegisterInstrument(spec InstrumentSpec) { ex.specs[spec.InstID] = spec }
-
-// Step —— 给定“批准后的相对仓位目标”，生成本时刻的下/撤建议
-// approvedPosRel：-1..+1（或上层定义的 MaxAbs）
-// markPrice：标记价（计价币）
-// adv：近似可成交“张数”的日内/区间均值（用于参与率）
-func (ex *Executor) Step(inst string, approvedPosRel float64, markPrice float64, adv float64) Plan {
-	sp, ok := ex.specs[inst]
-	if !ok || markPrice <= 0 || sp.ContractValue <= 0 {
-		return Plan{}
-	}
-	st := ex.ensure(inst)
-
-	// 先处理超时挂单的撤单（重定价交给下一拍）
-	cancels := ex.findStaleCancels(inst, st)
-
-	// 目标张数（向下取整到 lot；不足最小名义/最小张数则返回 0）
-	target := ex.targetContracts(sp, approvedPosRel, markPrice)
-
-	// 当前净仓位 + 净在途（同向）≈“有效仓位”
-	outstanding := st.netOutstanding() // 同向为正、反向为负
-	// 需要的“净增量”
-	rawDelta := target - (st.position + outstanding)
-
-	// 极小变化忽略（避免抖动）
-	if nearlyZero(rawDelta) {
-		return Plan{Cancels: cancels}
-	}
-
-	orders := make([]OrderRequest, 0, 2)
-
-	// —— 若方向发生切换：先 ReduceOnly 将仓位减到 0 —— //
-	if st.position*rawDelta < 0 && !nearlyZero(st.position) {
-		needReduce := math.Min(math.Abs(st.position), math.Abs(rawDelta))
-		child := ex.childQty(sp, needReduce, adv)
-		if child > 0 {
-			px := ex.priceAggressive(markPrice, sideOpposite(st.position), sp) // 受控滑点
-			ord := ex.makeOrderIOC(inst, sideOpposite(st.position), child, px, true /*RO*/)
-			orders = append(orders, ord)
-			st.addOpen(ord.ClientID, ord.Side, ord.Qty, ord.Price)
-			// 先把减仓发出去，下一拍再继续（避免一次做两件事）
-			return Plan{Orders: orders, Cancels: cancels}
-		}
-	}
-
-	// —— 同向调仓或已完成减仓 —— //
-	if !nearlyZero(rawDelta) {
-		child := ex.childQty(sp, math.Abs(rawDelta), adv)
-		if child > 0 {
-			sd := sideOf(rawDelta)
-			if ex.cfg.PreferPassive {
-				px := ex.pricePassive(markPrice, sd, sp)
-				ord := ex.makeOrderLimit(inst, sd, child, px, true /*post-only*/, false /*RO*/)
-				orders = append(orders, ord)
-				st.addOpen(ord.ClientID, ord.Side, ord.Qty, ord.Price)
-			} else {
-				px := ex.priceAggressive(markPrice, sd, sp)
-				ord := ex.makeOrderIOC(inst, sd, child, px, false /*RO*/)
-				orders = append(orders, ord)
-				st.addOpen(ord.ClientID, ord.Side, ord.Qty, ord.Price)
-			}
-		}
-	}
-
-	return Plan{Orders: orders, Cancels: cancels}
-}
-
-// —— 订单/成交回写 —— //
-
-func (ex *Executor) OnOrderUpdate(u OrderUpdate) {
-	st := ex.ensure(u.InstID)
-	if _, ok := st.open[u.ClientID]; !ok {
-		return
-	}
-	switch u.Status {
-	case "canceled", "rejected":
-		delete(st.open, u.ClientID)
-	case "filled":
-		delete(st.open, u.ClientID)
-	}
-}
-
-func (ex *Executor) OnFill(f Fill) {
-	st := ex.ensure(f.InstID)
-	dir := 1.0
-	if f.Side == SideSell {
-		dir = -1
-	}
-	st.position += dir * f.Qty
-
-	// 扣减 open 剩余
-	if o, ok := st.open[f.ClientID]; ok {
-		o.Remaining -= f.Qty
-		if o.Remaining <= lotEps {
-			delete(st.open, f.ClientID)
-		} else {
-			st.open[f.ClientID] = o
-		}
-	}
-}
-
-// ===================== 内部状态与工具 =====================
-
-type state struct {
-	position float64              // 实际持仓（张）
-	open     map[string]openOrder // 还在路上的单（用于在途净Δ）
-}
-
-type openOrder struct {
-	Side      Side
-	Qty       float64
-	Price     float64
-	Remaining float64
-	Ts        time.Time
-}
-
-func (s *state) addOpen(id string, side Side, qty, price float64) {
-	if s.open == nil {
-		s.open = make(map[string]openOrder)
-	}
-	s.open[id] = openOrder{
-		Side:      side,
-		Qty:       qty,
-		Price:     price,
-		Remaining: qty,
-		Ts:        time.Now(),
-	}
-}
-
-// 净在途Δ：买单剩余为 +Remaining，卖单为 -Remaining
-func (s *state) netOutstanding() float64 {
-	sum := 0.0
-	for _, o := range s.open {
-		if o.Side == SideBuy {
-			sum += o.Remaining
-		} else {
-			sum -= o.Remaining
-		}
-	}
-	return sum
-}
-
-func (ex *Executor) ensure(inst string) *state {
-	if s, ok := ex.ins[inst]; ok {
-		return s
-	}
-	s := &state{open: make(map[string]openOrder)}
-	ex.ins[inst] = s
-	return s
-}
-
-// 目标张数换算（稳健版）
-func (ex *Executor) targetContracts(sp InstrumentSpec, approvedPosRel float64, mark float64) float64 {
-	r := clamp(approvedPosRel, -1, 1)
-	if mark <= 0 || sp.ContractValue <= 0 {
-		return 0
-	}
-	// 目标名义（计价币）
-	notional := r * ex.cfg.AccountEquity * ex.cfg.LeverageCap
-	if nearlyZero(notional) {
-		return 0
-	}
-	// 原始张数
-	raw := notional / sp.ContractValue
-	// 先满足最小名义/最小张数
-	minByNotional := 0.0
-	if sp.MinNotional > 0 {
-		minByNotional = sp.MinNotional / sp.ContractValue
-	}
-	minReq := math.Max(sp.MinQty, minByNotional)
-	absRaw := math.Abs(raw)
-	if absRaw < minReq {
-		// 小目标直接忽略，避免被 lot 四舍五入成假单
-		return 0
-	}
-	// 向“有利于不超”的方向取整到 lot
-	q := roundDownToLot(absRaw, sp.LotSize)
-	if q <= 0 {
-		return 0
-	}
-	return signed(q, sign(raw))
-}
-
-// 子单 sizing（参与率优先→再夹断到[min,max]→向下取整到 lot）
-func (ex *Executor) childQty(sp InstrumentSpec, need float64, adv float64) float64 {
-	if need <= 0 {
-		return 0
-	}
-	// 参与率上限对应的张数
-	capAdv := math.Max(0, adv) * ex.cfg.MaxParticipation
-	// 先按参与率截断
-	q := math.Min(need, capAdv)
-	// 再夹断到 [ChildMinQty, ChildMaxQty]
-	q = clamp(q, ex.cfg.ChildMinQty, ex.cfg.ChildMaxQty)
-	// 向下取整到 lot（绝不超过 need）
-	q = roundDownToLot(q, sp.LotSize)
-	if q <= 0 {
-		return 0
-	}
-	// 最后再确保不超过 need
-	if q > need {
-		q = roundDownToLot(need, sp.LotSize)
-	}
-	if q <= 0 {
-		return 0
-	}
-	return q
-}
-
-// 被动价：买 = mark - ticks，卖 = mark + ticks；严格对齐 tick，不越界
-func (ex *Executor) pricePassive(mark float64, side Side, sp InstrumentSpec) float64 {
-	ticks := float64(ex.cfg.PassivePriceOffsetTicks)
-	offs := ticks * sp.TickSize
-	if side == SideBuy {
-		return roundToTick(maxFloat(mark-offs, sp.TickSize), sp.TickSize)
-	}
-	return roundToTick(mark+offs, sp.TickSize)
-}
-
-// 主动价：受控滑点 bps + tick 对齐
-func (ex *Executor) priceAggressive(mark float64, side Side, sp InstrumentSpec) float64 {
-	lim := math.Abs(ex.cfg.AggressiveSlippageBps) / 10000.0
-	if lim < 0 {
-		lim = 0
-	}
-	var px float64
-	if side == SideBuy {
-		px = mark * (1 + lim)
-	} else {
-		px = mark * (1 - lim)
-	}
-	px = roundToTick(px, sp.TickSize)
-	if px <= 0 {
-		px = sp.TickSize
-	}
-	return px
-}
-
-// 造单（Limit GTC / PostOnly 可选）
-func (ex *Executor) makeOrderLimit(inst string, side Side, qty float64, price float64, postOnly bool, reduceOnly bool) OrderRequest {
-	id := ex.cid(inst)
-	return OrderRequest{
-		InstID:      inst,
-		Side:        side,
-		Type:        OrdLimit,
-		Qty:         qty,
-		Price:       price,
-		TimeInForce: GTC,
-		PostOnly:    postOnly,
-		ReduceOnly:  reduceOnly,
-		ClientID:    id,
-	}
-}
-
-// 造单（IOC + 可选 ReduceOnly）——用于减仓或强制推进
-func (ex *Executor) makeOrderIOC(inst string, side Side, qty float64, price float64, reduceOnly bool) OrderRequest {
-	id := ex.cid(inst)
-	return OrderRequest{
-		InstID:      inst,
-		Side:        side,
-		Type:        OrdLimit,
-		Qty:         qty,
-		Price:       price,
-		TimeInForce: IOC,
-		PostOnly:    false,
-		ReduceOnly:  reduceOnly,
-		ClientID:    id,
-	}
-}
-
-// 撤单（超时即撤，重定价交回下一拍）
-func (ex *Executor) findStaleCancels(inst string, st *state) []CancelRequest {
-	if len(st.open) == 0 || ex.cfg.CancelStaleAfterMs <= 0 {
-		return nil
-	}
-	now := time.Now()
-	var out []CancelRequest
-	exp := time.Duration(ex.cfg.CancelStaleAfterMs) * time.Millisecond
-	for cid, o := range st.open {
-		if now.Sub(o.Ts) >= exp {
-			out = append(out, CancelRequest{InstID: inst, ClientID: cid})
-		}
-	}
-	return out
-}
-
-// ===================== 工具 =====================
-
-const lotEps = 1e-9
-
-func roundToTick(px, tick float64) float64 {
-	if tick <= 0 {
-		return px
-	}
-	steps := math.Round(px / tick)
-	return steps * tick
-}
-
-// “向下”取整到 lot：确保不会因为四舍五入超出 need
-func roundDownToLot(qty, lot float64) float64 {
-	if lot <= 0 {
-		return qty
-	}
-	steps := math.Floor(qty/lot + 1e-12)
-	return steps * lot
-}
-
-func clamp(x, lo, hi float64) float64 {
-	if x < lo {
-		return lo
-	}
-	if x > hi {
-		return hi
-	}
-	return x
-}
-func sign(x float64) float64 {
-	if x < 0 {
-		return -1
-	}
-	if x > 0 {
-		return 1
-	}
-	return 0
-}
-func signed(x, s float64) float64 { return x * s }
-func nearlyZero(x float64) bool   { return math.Abs(x) < 1e-9 }
-
-func sideOf(delta float64) Side {
-	if delta >= 0 {
-		return SideBuy
-	}
-	return SideSell
-}
-func sideOpposite(pos float64) Side {
-	if pos > 0 {
-		return SideSell
-	}
-	return SideBuy
-}
-
-func maxFloat(a, b float64) float64 {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-func (ex *Executor) cid(inst string) string { return fmt.Sprintf("%s-%d", inst, time.Now().UnixNano()) }
-
-// 适配器接口（与你原版一致）
-type ExchangeAdapter interface {
-	SendOrders([]OrderRequest) error
-	CancelOrders([]CancelRequest) error
-}
+package execution
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/storage"
+)
+
+type Side string
+
+const (
+	SideBuy  Side = "buy"
+	SideSell Side = "sell"
+)
+
+type OrderType string
+
+const (
+	OrdLimit  OrderType = "limit"
+	OrdMarket OrderType = "market"
+)
+
+type TIF string
+
+const (
+	GTC TIF = "GTC"
+	IOC TIF = "IOC"
+	FOK TIF = "FOK"
+)
+
+type OrderRequest struct {
+	InstID      string
+	Side        Side
+	Type        OrderType
+	Qty         float64
+	Price       float64
+	TimeInForce TIF
+	PostOnly    bool
+	ReduceOnly  bool
+	ClientID    string
+	Attach      *TPSL // 可选：随单附带止盈止损（成交后由交易所挂出）
+	Meta        map[string]any
+}
+
+type CancelRequest struct {
+	InstID   string
+	ClientID string
+}
+
+// AmendRequest —— 改单（保留队列位置）；NewPrice/NewQty 为 0 表示不改
+type AmendRequest struct {
+	InstID   string
+	ClientID string
+	NewPrice float64
+	NewQty   float64
+}
+
+type Plan struct {
+	Orders      []OrderRequest
+	Cancels     []CancelRequest
+	Amends      []AmendRequest
+	Algos       []AlgoOrderRequest  // 交易所常驻策略单（止损/止盈/触发/移动止损）
+	AlgoCancels []AlgoCancelRequest // 需撤销的策略单
+}
+
+type OrderUpdate struct {
+	InstID    string
+	ClientID  string
+	OrderID   string // 交易所订单号（可为空）
+	FilledQty float64
+	Status    string // new/partially_filled/filled/canceled/rejected
+	AvgPrice  float64
+	Ts        time.Time
+}
+
+type Fill struct {
+	InstID    string
+	ClientID  string
+	Side      Side
+	Qty       float64
+	Price     float64
+	Fee       float64 // 正数为支出，负数为返佣
+	TradeID   string
+	Liquidity string // M=maker / T=taker（可为空）
+	Ts        time.Time
+}
+
+// Position —— 交易所侧持仓（张）；PosSide=net 时 Qty 带符号，long/short 模式下空头记为负
+type Position struct {
+	InstID  string
+	PosSide string // net/long/short
+	Qty     float64
+	AvgPx   float64
+	UPL     float64
+	MarkPx  float64
+	LiqPx   float64
+	Lever   float64
+	MgnMode string // cross/isolated/cash
+	Ts      time.Time
+}
+
+// Balance —— 单币种余额
+type Balance struct {
+	Ccy    string
+	Equity float64
+	Cash   float64
+	Avail  float64
+	Frozen float64
+	UPL    float64
+	Ts     time.Time
+}
+
+// AccountSnapshot —— 账户总览（总权益按美元折算）
+type AccountSnapshot struct {
+	TotalEq float64
+	Details []Balance
+	Ts      time.Time
+}
+
+// ===================== 规格/配置 =====================
+
+type InstrumentSpec struct {
+	InstID        string
+	TickSize      float64
+	LotSize       float64
+	ContractValue float64 // 每张名义（计价币），如 1 USDT/张
+	CtVal         float64 // 每张标的数量（币），线性合约/现货；>0 时每张名义 = CtVal × 价格，优先于 ContractValue
+	Inverse       bool    // 币本位合约：ContractValue 为每张面值，盈亏以标的币结算；否则 ContractValue 按计价币线性结算
+	MinNotional   float64 // 最小名义（计价币）
+	MinQty        float64 // 最小下单张数
+}
+
+type Config struct {
+	AccountEquity float64 // 未收到账户推送前的换算权益（计价币）
+	LeverageCap   float64
+
+	MaxAbsPosition float64            // 相对目标夹断范围 ±MaxAbsPosition（未 SetPositionLimits 接风控层时的兜底，默认 1）
+	MarginCcy      string             // 保证金币种（如 USDT）；为空取账户总权益（美元折算）
+	MarginFX       float64            // 保证金币种 → 合约计价币汇率（默认 1；可由 SetMarginFX 实时更新）
+	Leverage       map[string]float64 // 单品种交易所杠杆（PushLeverage 下发；同时限制该品种名义 ≤ 权益×杠杆）
+
+	MaxChildSlices   int
+	SliceInterval    int
+	MaxParticipation float64
+	ChildMinQty      float64
+	ChildMaxQty      float64
+
+	PassivePriceOffsetTicks int
+	AggressiveSlippageBps   float64
+
+	PreferPassive bool
+	UseIceberg    bool
+
+	CancelStaleAfterMs int
+	MaxRetries         int                // 交易所调用失败重试次数（Retry.MaxAttempts 未配置时使用）
+	Retry              config.RetryConfig // 重试次数/退避基数（resilient.go）
+	ReconcileGraceMs   int                // 对账宽限：本地订单最近变更不足该时长时，不因交易所缺席判定差异
+	StopMoveTicks      int                // 常驻止损触发价变化不足该 tick 数时不重挂（防抖）
+
+	// post-only 挂单改价：偏离目标被动价 ≥ AmendMinTicks 才改；> AmendMaxTicks 视为行情跑远，直接撤
+	AmendMinTicks      int
+	AmendMaxTicks      int
+	AmendMinIntervalMs int // 同一订单两次改价的最小间隔
+	MaxAmendsPerOrder  int // 单笔订单改价次数上限，用尽后回到“超时即撤”
+
+	DryRun bool // 纸面交易：NewVenue 返回本地 PaperExchange，而不是 OKX
+
+	ClientIDSession string // clOrdId 会话前缀（回放/模拟固定后可复现）；为空按启动时间生成
+
+	FlattenConfirmMs int // 紧急平仓每轮等待持仓推送确认归零的时长
+	FlattenMaxRounds int // 紧急平仓最多重发轮数
+}
+
+func (c *Config) withDefaults() Config {
+	q := *c
+	if q.AccountEquity <= 0 {
+		q.AccountEquity = 10000
+	}
+	if q.LeverageCap <= 0 {
+		q.LeverageCap = 3
+	}
+	if q.MaxAbsPosition <= 0 {
+		q.MaxAbsPosition = 1
+	}
+	if q.MarginFX <= 0 {
+		q.MarginFX = 1
+	}
+	if q.MaxChildSlices <= 0 {
+		q.MaxChildSlices = 4
+	}
+	if q.SliceInterval <= 0 {
+		q.SliceInterval = 500
+	}
+	if q.MaxParticipation <= 0 {
+		q.MaxParticipation = 0.05
+	}
+	if q.ChildMinQty <= 0 {
+		q.ChildMinQty = 1
+	}
+	if q.ChildMaxQty <= 0 || q.ChildMaxQty < q.ChildMinQty {
+		q.ChildMaxQty = 100
+	}
+	if q.PassivePriceOffsetTicks <= 0 {
+		q.PassivePriceOffsetTicks = 1
+	}
+	if q.AggressiveSlippageBps <= 0 {
+		q.AggressiveSlippageBps = 10
+	}
+	if q.CancelStaleAfterMs <= 0 {
+		q.CancelStaleAfterMs = 3000
+	}
+	if q.MaxRetries <= 0 {
+		q.MaxRetries = 2
+	}
+	if q.ReconcileGraceMs <= 0 {
+		q.ReconcileGraceMs = 2000
+	}
+	if q.StopMoveTicks <= 0 {
+		q.StopMoveTicks = 2
+	}
+	if q.AmendMinTicks <= 0 {
+		q.AmendMinTicks = 1
+	}
+	if q.AmendMaxTicks <= 0 || q.AmendMaxTicks < q.AmendMinTicks {
+		q.AmendMaxTicks = 20
+	}
+	if q.AmendMinIntervalMs <= 0 {
+		q.AmendMinIntervalMs = 1000
+	}
+	if q.MaxAmendsPerOrder <= 0 {
+		q.MaxAmendsPerOrder = 5
+	}
+	if q.FlattenConfirmMs <= 0 {
+		q.FlattenConfirmMs = 3000
+	}
+	if q.FlattenMaxRounds <= 0 {
+		q.FlattenMaxRounds = 5
+	}
+	return q
+}
+
+// ===================== 执行器主体 =====================
+
+type Executor struct {
+	mu    sync.Mutex
+	cfg   Config
+	specs map[string]InstrumentSpec
+	ins   map[string]*state
+
+	parents map[string]*parentRun // 母单（algo.go）
+	childOf map[string]string     // 子单 ClientID -> 母单 ID
+
+	journal *storage.TradeLogger // 执行日志（journal.go，可选）
+
+	ids     *IDGen            // clOrdId 生成（clordid.go）
+	origins map[string]Origin // clOrdId -> 来源
+	originQ []string
+
+	halt *haltState // 紧急停机（emergency.go）；非空时不再产生新单
+
+	live   liveEquity         // 账户推送的实时权益（sizing.go）
+	maxAbs map[string]float64 // 单品种相对目标上限
+	limits PositionLimits     // 风控层的单品种上限（SetPositionLimits）
+}
+
+func NewExecutor(cfg Config) *Executor {
+	c := cfg.withDefaults()
+	return &Executor{
+		cfg:   c,
+		specs: make(map[string]InstrumentSpec),
+		ins:   make(map[string]*state),
+		ids:   NewIDGen(c.ClientIDSession),
+	}
+}
+
+func (ex *Executor) RegisterInstrument(spec InstrumentSpec) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.specs[spec.InstID] = spec
+}
+
+// Step —— 给定“批准后的相对仓位目标”，生成本时刻的下/撤建议
+// approvedPosRel：-1..+1（或上层定义的 MaxAbs）
+// markPrice：标记价（计价币）
+// adv：近似可成交“张数”的日内/区间均值（用于参与率）
+func (ex *Executor) Step(inst string, approvedPosRel float64, markPrice float64, adv float64) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	sp, ok := ex.specs[inst]
+	if !ok || markPrice <= 0 || notionalPerContract(sp, markPrice) <= 0 {
+		return Plan{}
+	}
+	st := ex.ensure(inst)
+	st.book.setMark(markPrice, time.Now())
+	st.approved = approvedPosRel
+	if ex.halt != nil {
+		return Plan{}
+	}
+	tag := st.decision.Tag
+
+	// 先处理在途挂单：post-only 向 mark 改价，其余超时即撤（重定价交给下一拍）
+	cancels, amends := ex.manageResting(inst, st, sp, markPrice, st.open, time.Now())
+
+	// 目标张数（向下取整到 lot；不足最小名义/最小张数则返回 0）
+	target := ex.targetContracts(sp, approvedPosRel, markPrice)
+
+	// 当前净仓位 + 净在途（同向）≈“有效仓位”
+	outstanding := st.netOutstanding() // 同向为正、反向为负
+	// 需要的“净增量”
+	rawDelta := target - (st.position + outstanding)
+
+	// 极小变化忽略（避免抖动）
+	if nearlyZero(rawDelta) {
+		return Plan{Cancels: cancels, Amends: amends}
+	}
+
+	orders := make([]OrderRequest, 0, 2)
+
+	// —— 若方向发生切换：先 ReduceOnly 将仓位减到 0 —— //
+	if st.position*rawDelta < 0 && !nearlyZero(st.position) {
+		needReduce := math.Min(math.Abs(st.position), math.Abs(rawDelta))
+		child := ex.childQty(sp, needReduce, adv)
+		if child > 0 {
+			px := ex.priceAggressive(markPrice, sideOpposite(st.position), sp) // 受控滑点
+			ord := ex.makeOrderIOC(inst, tag, sideOpposite(st.position), child, px, true /*RO*/)
+			ex.track(st, &ord, markPrice, markPrice)
+			orders = append(orders, ord)
+			// 先把减仓发出去，下一拍再继续（避免一次做两件事）
+			return Plan{Orders: orders, Cancels: cancels, Amends: amends}
+		}
+	}
+
+	// —— 同向调仓或已完成减仓 —— //
+	if !nearlyZero(rawDelta) {
+		child := ex.childQty(sp, math.Abs(rawDelta), adv)
+		if child > 0 {
+			sd := sideOf(rawDelta)
+			if ex.cfg.PreferPassive {
+				px := ex.pricePassive(markPrice, sd, sp)
+				ord := ex.makeOrderLimit(inst, tag, sd, child, px, true /*post-only*/, false /*RO*/)
+				ex.track(st, &ord, markPrice, markPrice)
+				orders = append(orders, ord)
+			} else {
+				px := ex.priceAggressive(markPrice, sd, sp)
+				ord := ex.makeOrderIOC(inst, tag, sd, child, px, false /*RO*/)
+				ex.track(st, &ord, markPrice, markPrice)
+				orders = append(orders, ord)
+			}
+		}
+	}
+
+	return Plan{Orders: orders, Cancels: cancels, Amends: amends}
+}
+
+// —— 订单/成交回写 —— //
+
+// OnOrderUpdate —— 经状态机推进；乱序/重复/未知回报不会改变本地状态
+func (ex *Executor) OnOrderUpdate(u OrderUpdate) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st := ex.ensure(u.InstID)
+	o, ok := st.open[u.ClientID]
+	if !ok {
+		if _, done := st.done[u.ClientID]; !done {
+			log.Printf("⚠️ 未知订单回报: %s %s status=%s", u.InstID, u.ClientID, u.Status)
+		}
+		return
+	}
+	next := parseOrderState(u.Status)
+	if u.OrderID != "" {
+		o.OrderID = u.OrderID
+	}
+	if u.FilledQty > o.ExchFilled {
+		o.ExchFilled = u.FilledQty
+	}
+	if !o.State.canMoveTo(next) {
+		return
+	}
+	o.State = next
+	o.Updated = time.Now()
+	if next == OrdRejected {
+		log.Printf("⚠️ 订单被拒: %s %s %s %.4f@%.4f", u.InstID, u.ClientID, o.Side, o.Qty, o.Price)
+	}
+	st.settle(u.ClientID, o)
+}
+
+// OnFill —— 按 TradeID 去重；未知/已结束订单的成交只记录不入账（由持仓推送/对账校正）
+func (ex *Executor) OnFill(f Fill) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st := ex.ensure(f.InstID)
+	if f.TradeID != "" {
+		key := f.ClientID + "/" + f.TradeID
+		if _, dup := st.seenFills[key]; dup {
+			return
+		}
+		st.rememberFill(key)
+	}
+
+	o, ok := st.open[f.ClientID]
+	if !ok {
+		if _, done := st.done[f.ClientID]; done {
+			log.Printf("⚠️ 已结束订单的迟到成交（未入账）: %s %s %.4f@%.4f", f.InstID, f.ClientID, f.Qty, f.Price)
+		} else {
+			if len(st.orphans) < maxOrphanFills {
+				st.orphans = append(st.orphans, f)
+			}
+			log.Printf("⚠️ 未知订单成交（未入账）: %s %s %s %.4f@%.4f", f.InstID, f.ClientID, f.Side, f.Qty, f.Price)
+		}
+		return
+	}
+	dir := 1.0
+	if f.Side == SideSell {
+		dir = -1
+	}
+	st.position += dir * f.Qty
+	ts := f.Ts
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	st.book.applyFill(ex.specs[f.InstID], dir, f.Qty, f.Price, f.Fee, ts)
+	ex.journalFill(f)
+	ex.onChildFill(f)
+
+	o.Filled += f.Qty
+	if o.Filled > o.ExchFilled {
+		o.ExchFilled = o.Filled
+	}
+	if !o.State.Terminal() {
+		if o.Qty-o.Filled <= lotEps {
+			o.State = OrdFilled
+		} else if o.State.canMoveTo(OrdPartiallyFilled) {
+			o.State = OrdPartiallyFilled
+		}
+	}
+	o.Updated = time.Now()
+	st.settle(f.ClientID, o)
+}
+
+// OnPositions —— 无在途单时以交易所持仓推送为准覆盖本地净仓位；推送按 (instId, posSide) 合并，
+// 双向持仓只推一侧时另一侧保留上次的值，净额为各侧之和。
+// 有在途单（含成交未到齐的终态单）时净仓位交给 OnFill：positions 与 orders 频道之间无序，
+// 推送可能已含某笔成交而其回报未到，覆盖后再记成交会重复计入（与 syncBooks 的 force=false 同一规则）
+func (ex *Executor) OnPositions(ps []Position) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	touched := make(map[string]*state, len(ps))
+	for _, p := range ps {
+		st := ex.ensure(p.InstID)
+		st.setLeg(p)
+		touched[p.InstID] = st
+	}
+	merged := make([]Position, 0, len(ps))
+	for _, st := range touched {
+		net := 0.0
+		for _, l := range st.legs {
+			net += l.Qty
+			merged = append(merged, l)
+		}
+		if len(st.open) == 0 {
+			st.position = net
+		}
+	}
+	if ex.halt != nil {
+		ex.halt.pushAt = time.Now()
+	}
+	ex.syncBooks(merged, false)
+}
+
+// EventSource —— 订单/成交/持仓推送源（私有 WS、模拟撮合等）
+type EventSource interface {
+	OnOrder(func(OrderUpdate))
+	OnFill(func(Fill))
+	OnPosition(func([]Position))
+}
+
+// Bind —— 把推送源接到执行器（支持账户推送的源同时接入实时权益）
+func (ex *Executor) Bind(src EventSource) {
+	src.OnOrder(ex.OnOrderUpdate)
+	src.OnFill(ex.OnFill)
+	src.OnPosition(ex.OnPositions)
+	if as, ok := src.(AccountSource); ok {
+		as.OnAccount(ex.OnAccount)
+		as.OnBalance(ex.OnBalances)
+	}
+}
+
+// ===================== 内部状态与工具 =====================
+
+type state struct {
+	position float64               // 实际持仓（张）
+	open     map[string]*openOrder // 还在路上的单（用于在途净Δ）；终态但成交未到齐的也暂留
+
+	done      map[string]OrderState // 已结束订单（用于识别迟到/重复回报）
+	doneQ     []string
+	seenFills map[string]struct{} // 已入账成交（ClientID/TradeID）
+	fillQ     []string
+	orphans   []Fill // 未知 ClientID 的成交
+
+	legs map[string]Position // 交易所持仓，按 posSide（net / long / short）
+
+	stop        *liveStop // 交易所常驻止损（stops.go）
+	stopPending *liveStop // 已下发、待确认的新止损
+	book        book      // 持仓核算（pnl.go）
+
+	decision Decision // 当前决策上下文（clordid.go）
+	approved float64  // 最近一次 Step 的批准仓位
+}
+
+type openOrder struct {
+	Side       Side
+	Qty        float64
+	Price      float64
+	Remaining  float64 // 计入在途的数量
+	Ts         time.Time
+	State      OrderState
+	OrderID    string
+	Filled     float64 // 已入账成交（来自 Fill）
+	ExchFilled float64 // 交易所累计成交（来自 OrderUpdate，可能先于 Fill 到达）
+	Updated    time.Time
+	PostOnly   bool
+	Amends     int       // 已发出的改价次数
+	LastAmend  time.Time // 最近一次改价（超时按此重新计时）
+	AmendPx    float64   // 已发出、待确认的改价（0 表示无；确认后才写入 Price）
+}
+
+func (s *state) addOpen(id string, side Side, qty, price float64) {
+	if s.open == nil {
+		s.open = make(map[string]*openOrder)
+	}
+	now := time.Now()
+	s.open[id] = &openOrder{
+		Side:      side,
+		Qty:       qty,
+		Price:     price,
+		Remaining: qty,
+		Ts:        now,
+		State:     OrdPendingNew,
+		Updated:   now,
+	}
+}
+
+// setLeg —— 覆盖一侧持仓（posSide 缺省按 net）
+func (s *state) setLeg(p Position) {
+	if s.legs == nil {
+		s.legs = make(map[string]Position)
+	}
+	side := p.PosSide
+	if side == "" {
+		side = "net"
+	}
+	s.legs[side] = p
+}
+
+// addOrder —— 按下单请求登记在途
+func (s *state) addOrder(o OrderRequest) {
+	s.addOpen(o.ClientID, o.Side, o.Qty, o.Price)
+	s.open[o.ClientID].PostOnly = o.PostOnly
+}
+
+// 净在途Δ：买单剩余为 +Remaining，卖单为 -Remaining
+func (s *state) netOutstanding() float64 {
+	sum := 0.0
+	for _, o := range s.open {
+		if o.Side == SideBuy {
+			sum += o.Remaining
+		} else {
+			sum -= o.Remaining
+		}
+	}
+	return sum
+}
+
+func (ex *Executor) ensure(inst string) *state {
+	if s, ok := ex.ins[inst]; ok {
+		return s
+	}
+	s := &state{
+		open:      make(map[string]*openOrder),
+		done:      make(map[string]OrderState),
+		seenFills: make(map[string]struct{}),
+	}
+	ex.ins[inst] = s
+	return s
+}
+
+// 目标张数换算（稳健版；权益与夹断范围见 sizing.go）
+func (ex *Executor) targetContracts(sp InstrumentSpec, approvedPosRel float64, mark float64) float64 {
+	lim := ex.posLimit(sp.InstID)
+	r := clamp(approvedPosRel, -lim, lim)
+	cv := notionalPerContract(sp, mark)
+	if mark <= 0 || cv <= 0 {
+		return 0
+	}
+	// 目标名义（计价币）
+	eq := ex.sizingEquity()
+	notional := r * eq * ex.cfg.LeverageCap
+	if l := ex.exchangeLever(sp.InstID); l > 0 && math.Abs(notional) > eq*l {
+		notional = signed(eq*l, sign(notional))
+	}
+	if nearlyZero(notional) {
+		return 0
+	}
+	// 原始张数
+	raw := notional / cv
+	// 先满足最小名义/最小张数
+	minByNotional := 0.0
+	if sp.MinNotional > 0 {
+		minByNotional = sp.MinNotional / cv
+	}
+	minReq := math.Max(sp.MinQty, minByNotional)
+	absRaw := math.Abs(raw)
+	if absRaw < minReq {
+		// 小目标直接忽略，避免被 lot 四舍五入成假单
+		return 0
+	}
+	// 向“有利于不超”的方向取整到 lot
+	q := roundDownToLot(absRaw, sp.LotSize)
+	if q <= 0 {
+		return 0
+	}
+	return signed(q, sign(raw))
+}
+
+// 子单 sizing（参与率优先→再夹断到[min,max]→向下取整到 lot）
+func (ex *Executor) childQty(sp InstrumentSpec, need float64, adv float64) float64 {
+	if need <= 0 {
+		return 0
+	}
+	// 参与率上限对应的张数
+	capAdv := math.Max(0, adv) * ex.cfg.MaxParticipation
+	// 先按参与率截断
+	q := math.Min(need, capAdv)
+	// 再夹断到 [ChildMinQty, ChildMaxQty]
+	q = clamp(q, ex.cfg.ChildMinQty, ex.cfg.ChildMaxQty)
+	// 向下取整到 lot（绝不超过 need）
+	q = roundDownToLot(q, sp.LotSize)
+	if q <= 0 {
+		return 0
+	}
+	// 最后再确保不超过 need
+	if q > need {
+		q = roundDownToLot(need, sp.LotSize)
+	}
+	if q <= 0 {
+		return 0
+	}
+	return q
+}
+
+// 被动价：买 = mark - ticks，卖 = mark + ticks；严格对齐 tick，不越界
+func (ex *Executor) pricePassive(mark float64, side Side, sp InstrumentSpec) float64 {
+	ticks := float64(ex.cfg.PassivePriceOffsetTicks)
+	offs := ticks * sp.TickSize
+	if side == SideBuy {
+		return roundToTick(maxFloat(mark-offs, sp.TickSize), sp.TickSize)
+	}
+	return roundToTick(mark+offs, sp.TickSize)
+}
+
+// 主动价：受控滑点 bps + tick 对齐
+func (ex *Executor) priceAggressive(mark float64, side Side, sp InstrumentSpec) float64 {
+	lim := math.Abs(ex.cfg.AggressiveSlippageBps) / 10000.0
+	if lim < 0 {
+		lim = 0
+	}
+	var px float64
+	if side == SideBuy {
+		px = mark * (1 + lim)
+	} else {
+		px = mark * (1 - lim)
+	}
+	px = roundToTick(px, sp.TickSize)
+	if px <= 0 {
+		px = sp.TickSize
+	}
+	return px
+}
+
+// 造单（Limit GTC / PostOnly 可选）
+func (ex *Executor) makeOrderLimit(inst, tag string, side Side, qty float64, price float64, postOnly bool, reduceOnly bool) OrderRequest {
+	id := ex.ids.Next(tag)
+	return OrderRequest{
+		InstID:      inst,
+		Side:        side,
+		Type:        OrdLimit,
+		Qty:         qty,
+		Price:       price,
+		TimeInForce: GTC,
+		PostOnly:    postOnly,
+		ReduceOnly:  reduceOnly,
+		ClientID:    id,
+	}
+}
+
+// 造单（IOC + 可选 ReduceOnly）——用于减仓或强制推进
+func (ex *Executor) makeOrderIOC(inst, tag string, side Side, qty float64, price float64, reduceOnly bool) OrderRequest {
+	id := ex.ids.Next(tag)
+	return OrderRequest{
+		InstID:      inst,
+		Side:        side,
+		Type:        OrdLimit,
+		Qty:         qty,
+		Price:       price,
+		TimeInForce: IOC,
+		PostOnly:    false,
+		ReduceOnly:  reduceOnly,
+		ClientID:    id,
+	}
+}
+
+// 在途挂单管理：
+// post-only 单偏离目标被动价 [AmendMinTicks, AmendMaxTicks] 个 tick 时改价（受频率/次数上限约束）；
+// 偏离过大、改价额度用尽或非 post-only 的单，超时即撤（重定价交回下一拍）
+func (ex *Executor) manageResting(inst string, st *state, sp InstrumentSpec, mark float64, orders map[string]*openOrder, now time.Time) ([]CancelRequest, []AmendRequest) {
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	var cancels []CancelRequest
+	var amends []AmendRequest
+	exp := time.Duration(ex.cfg.CancelStaleAfterMs) * time.Millisecond
+	gap := time.Duration(ex.cfg.AmendMinIntervalMs) * time.Millisecond
+	for cid, o := range orders {
+		if o.State.Terminal() {
+			continue
+		}
+		if o.AmendPx > 0 {
+			continue // 改价在途：等 ConfirmAmends
+		}
+		// 未确认的单不能改，只走超时撤
+		if o.PostOnly && o.State != OrdPendingNew && sp.TickSize > 0 && mark > 0 {
+			target := ex.pricePassive(mark, o.Side, sp)
+			drift := math.Round(math.Abs(target-o.Price) / sp.TickSize)
+			switch {
+			case drift < float64(ex.cfg.AmendMinTicks):
+				continue // 仍在目标附近：保留队列位置，不因超时撤单
+			case drift <= float64(ex.cfg.AmendMaxTicks) && o.Amends < ex.cfg.MaxAmendsPerOrder:
+				if now.Sub(o.LastAmend) < gap {
+					continue
+				}
+				amends = append(amends, AmendRequest{InstID: inst, ClientID: cid, NewPrice: target})
+				o.AmendPx, o.Amends, o.LastAmend = target, o.Amends+1, now
+				continue
+			}
+		}
+		since := o.Ts
+		if o.LastAmend.After(since) {
+			since = o.LastAmend
+		}
+		if exp > 0 && now.Sub(since) >= exp {
+			cancels = append(cancels, CancelRequest{InstID: inst, ClientID: cid})
+		}
+	}
+	return cancels, amends
+}
+
+// ConfirmAmends —— 改价下发结果（err 为 AmendOrders 的返回）：交易所确认的改价才写入本地挂单价，
+// 被拒的保持原价，下一拍按原价重新评估
+func (ex *Executor) ConfirmAmends(amends []AmendRequest, err error) {
+	items := make(map[string]error)
+	whole := splitErrs(err, items)
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	for _, m := range amends {
+		st, ok := ex.ins[m.InstID]
+		if !ok {
+			continue
+		}
+		o, ok := st.open[m.ClientID]
+		if !ok || o.AmendPx == 0 {
+			continue
+		}
+		if e := errors.Join(whole, items[m.ClientID]); e != nil {
+			log.Printf("⚠️ 改价被拒 %s %s %.4f -> %.4f: %v", m.InstID, m.ClientID, o.Price, o.AmendPx, e)
+		} else {
+			o.Price = o.AmendPx
+		}
+		o.AmendPx = 0
+	}
+}
+
+// ===================== 工具 =====================
+
+const lotEps = 1e-9
+
+func roundToTick(px, tick float64) float64 {
+	if tick <= 0 {
+		return px
+	}
+	steps := math.Round(px / tick)
+	return steps * tick
+}
+
+// “向下”取整到 lot：确保不会因为四舍五入超出 need
+func roundDownToLot(qty, lot float64) float64 {
+	if lot <= 0 {
+		return qty
+	}
+	steps := math.Floor(qty/lot + 1e-12)
+	return steps * lot
+}
+
+func clamp(x, lo, hi float64) float64 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
+func sign(x float64) float64 {
+	if x < 0 {
+		return -1
+	}
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+func signed(x, s float64) float64 { return x * s }
+func nearlyZero(x float64) bool   { return math.Abs(x) < 1e-9 }
+
+func sideOf(delta float64) Side {
+	if delta >= 0 {
+		return SideBuy
+	}
+	return SideSell
+}
+func sideOpposite(pos float64) Side {
+	if pos > 0 {
+		return SideSell
+	}
+	return SideBuy
+}
+
+// 每张名义（计价币）
+func notionalPerContract(sp InstrumentSpec, px float64) float64 {
+	if sp.CtVal > 0 {
+		return sp.CtVal * px
+	}
+	return sp.ContractValue
+}
+
+// 张数 -> 标的数量（币）：CtVal 直接换算；否则 ContractValue 为每张计价币名义，按 px 折算
+// （成交按成交价；持仓按均价，张数归零时币数随之归零）
+func baseQty(sp InstrumentSpec, qty, px float64) float64 {
+	if sp.CtVal > 0 {
+		return qty * sp.CtVal
+	}
+	if sp.ContractValue > 0 && px > 0 {
+		return qty * sp.ContractValue / px
+	}
+	return qty
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 适配器接口
+type ExchangeAdapter interface {
+	SendOrders([]OrderRequest) error
+	CancelOrders([]CancelRequest) error
+	AmendOrders([]AmendRequest) error
+}
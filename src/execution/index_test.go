@@ -0,0 +1,83 @@
+package execution
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStepAmendsRestingPostOnlyInsteadOfCancel(t *testing.T) {
+	ex := NewExecutor(Config{
+		AccountEquity: 1000, LeverageCap: 1, PreferPassive: true, ChildMinQty: 1, ChildMaxQty: 1,
+		CancelStaleAfterMs: 1, AmendMinTicks: 2, AmendMaxTicks: 10, AmendMinIntervalMs: 1, MaxAmendsPerOrder: 2,
+	})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 1, LotSize: 1, ContractValue: 1})
+
+	p := ex.Step("X", 0.001, 100, 1000) // 目标 1 张，被动价 99
+	if len(p.Orders) != 1 || !p.Orders[0].PostOnly || p.Orders[0].Price != 99 {
+		t.Fatalf("unexpected first order: %+v", p.Orders)
+	}
+	id := p.Orders[0].ClientID
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: id, Status: "live"})
+	time.Sleep(2 * time.Millisecond)
+
+	// 偏离 1 tick：保留队列，不撤也不改
+	if p = ex.Step("X", 0.001, 101, 1000); len(p.Cancels)+len(p.Amends) != 0 {
+		t.Fatalf("expected no action within band: %+v", p)
+	}
+	// 偏离 4 tick：改价到 103
+	p = ex.Step("X", 0.001, 104, 1000)
+	if len(p.Cancels) != 0 || len(p.Amends) != 1 || p.Amends[0].ClientID != id || p.Amends[0].NewPrice != 103 {
+		t.Fatalf("expected amend: %+v", p)
+	}
+	// 确认前不改本地价；被拒则保持原价
+	time.Sleep(2 * time.Millisecond)
+	if q := ex.Step("X", 0.001, 104, 1000); len(q.Amends)+len(q.Cancels) != 0 || ex.ensure("X").open[id].Price != 99 {
+		t.Fatalf("amend applied before ack: %+v", q)
+	}
+	ex.ConfirmAmends(p.Amends, &OKXError{Op: "amend-batch-orders", Code: "51000", ClientID: id})
+	if o := ex.ensure("X").open[id]; o.Price != 99 || o.AmendPx != 0 {
+		t.Fatalf("rejected amend changed local price: %+v", o)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if p = ex.Step("X", 0.001, 108, 1000); len(p.Amends) != 1 || p.Amends[0].NewPrice != 107 {
+		t.Fatalf("expected second amend: %+v", p)
+	}
+	ex.ConfirmAmends(p.Amends, nil)
+	if o := ex.ensure("X").open[id]; o.Price != 107 {
+		t.Fatalf("acked amend not applied: %+v", o)
+	}
+	time.Sleep(2 * time.Millisecond)
+	// 改价额度用尽：回到超时即撤
+	if p = ex.Step("X", 0.001, 112, 1000); len(p.Amends) != 0 || len(p.Cancels) != 1 {
+		t.Fatalf("expected cancel after amend budget: %+v", p)
+	}
+	// 偏离过大：直接撤
+	ex2 := NewExecutor(Config{AccountEquity: 1000, LeverageCap: 1, PreferPassive: true, ChildMinQty: 1, ChildMaxQty: 1, CancelStaleAfterMs: 1, AmendMaxTicks: 3})
+	ex2.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 1, LotSize: 1, ContractValue: 1})
+	p = ex2.Step("X", 0.001, 100, 1000)
+	ex2.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: p.Orders[0].ClientID, Status: "live"})
+	time.Sleep(2 * time.Millisecond)
+	if p = ex2.Step("X", 0.001, 120, 1000); len(p.Amends) != 0 || len(p.Cancels) != 1 {
+		t.Fatalf("expected cancel when far outside band: %+v", p)
+	}
+}
+
+func TestPositionsPushDoesNotDoubleCountInFlightFills(t *testing.T) {
+	ex := NewExecutor(Config{})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 1, LotSize: 1, CtVal: 1})
+	ex.ensure("X").addOrder(OrderRequest{InstID: "X", ClientID: "o", Side: SideBuy, Type: OrdLimit, Qty: 2, Price: 100})
+
+	// 持仓推送先到（已含 1 张成交），成交回报后到：只计一次
+	ex.OnPositions([]Position{{InstID: "X", PosSide: "net", Qty: 1, AvgPx: 100}})
+	ex.OnFill(Fill{InstID: "X", ClientID: "o", Side: SideBuy, Qty: 1, Price: 100})
+	if q := ex.ensure("X").position; q != 1 {
+		t.Fatalf("position with order in flight = %.2f, want 1", q)
+	}
+	// 无在途单后以推送为准
+	ex.OnFill(Fill{InstID: "X", ClientID: "o", Side: SideBuy, Qty: 1, Price: 100})
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: "o", Status: "filled", FilledQty: 2})
+	ex.OnPositions([]Position{{InstID: "X", PosSide: "net", Qty: 3, AvgPx: 100}})
+	if q := ex.ensure("X").position; q != 3 {
+		t.Fatalf("position after settle = %.2f, want 3", q)
+	}
+}
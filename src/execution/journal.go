@@ -0,0 +1,84 @@
+package execution
+
+// 执行日志 —— 把“决策时 mark + 子单 + 成交”按 ClientID 写入 TradeLogger，供 TCA 离线归因
+// =============================================================================
+// 1) 下单：LogOrder.Meta 记 mark（本拍决策价）、arrival（母单到达价；直连单同 mark）、algo/parent、tag/signal；
+// 2) 成交：LogFill.Meta 记 liq（M/T）、tradeId、notional（计价币名义）、fillTs（交易所成交时间，毫秒）；
+// 3) 未设置日志时全部跳过；写失败只告警，不影响执行。
+
+import (
+	"log"
+	"time"
+
+	"Mod/src/storage"
+)
+
+// SetJournal —— 设置执行日志（nil 关闭）
+func (ex *Executor) SetJournal(lg *storage.TradeLogger) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.journal = lg
+}
+
+// track —— 补齐决策信息、登记来源与在途并写下单日志（调用方持有 ex.mu）
+func (ex *Executor) track(st *state, ord *OrderRequest, mark, arrival float64) {
+	if ord.Meta == nil {
+		ord.Meta = make(map[string]any, 2)
+	}
+	org := Origin{
+		ClientID: ord.ClientID, InstID: ord.InstID, Tag: st.decision.Tag, Signal: st.decision.Signal,
+		Approved: st.approved, Mark: mark, Ts: time.Now(),
+	}
+	if pid, _ := ord.Meta["parent"].(string); pid != "" {
+		if r, ok := ex.parents[pid]; ok {
+			org.Parent, org.Algo, org.Approved = pid, r.algo.Name(), 0
+			org.Tag, org.Signal = r.dec.Tag, r.dec.Signal
+		}
+	}
+	ex.remember(org)
+	ord.Meta["mark"] = mark
+	ord.Meta["arrival"] = arrival
+	if org.Tag != "" {
+		ord.Meta["tag"] = org.Tag
+	}
+	if org.Signal != "" {
+		ord.Meta["signal"] = org.Signal
+	}
+	st.addOrder(*ord)
+	if ex.journal == nil {
+		return
+	}
+	err := ex.journal.Order(storage.LogOrder{
+		InstID: ord.InstID, ClientID: ord.ClientID, Side: string(ord.Side), OrdType: string(ord.Type),
+		Qty: ord.Qty, Price: ord.Price, TIF: string(ord.TimeInForce),
+		PostOnly: ord.PostOnly, ReduceOnly: ord.ReduceOnly, Meta: ord.Meta,
+	})
+	if err != nil {
+		log.Printf("⚠️ 执行日志写入失败(order %s): %v", ord.ClientID, err)
+	}
+}
+
+// journalFill —— 写成交日志（调用方持有 ex.mu）
+func (ex *Executor) journalFill(f Fill) {
+	if ex.journal == nil {
+		return
+	}
+	meta := map[string]any{
+		"liq":      f.Liquidity,
+		"tradeId":  f.TradeID,
+		"notional": f.Qty * notionalPerContract(ex.specs[f.InstID], f.Price),
+	}
+	if !f.Ts.IsZero() {
+		meta["fillTs"] = f.Ts.UnixMilli()
+	}
+	if pid, ok := ex.childOf[f.ClientID]; ok {
+		meta["parent"] = pid
+	}
+	err := ex.journal.Fill(storage.LogFill{
+		InstID: f.InstID, ClientID: f.ClientID, Side: string(f.Side),
+		Qty: f.Qty, Price: f.Price, Fee: f.Fee, Meta: meta,
+	})
+	if err != nil {
+		log.Printf("⚠️ 执行日志写入失败(fill %s): %v", f.ClientID, err)
+	}
+}
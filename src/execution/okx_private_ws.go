@@ -0,0 +1,600 @@
+package execution
+
+// OKX 私有 WebSocket 客户端（订单 / 持仓 / 账户推送）
+// =============================================================================
+// 1) 登录：sign = Base64(HMAC-SHA256(secretKey, ts + "GET" + "/users/self/verify"))，ts 为 Unix 秒；
+// 2) 订阅：orders / positions / account / balance_and_position；
+// 3) 断线后自动重连（指数退避，封顶 30s），重连后重新登录并恢复订阅；
+// 4) 推送解码为 OrderUpdate / Fill / Position / Balance / AccountSnapshot，
+//    通过 OnOrder/OnFill/OnPosition 等回调下发（实现 EventSource，可直接 Executor.Bind）。
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"Mod/src/config"
+)
+
+const (
+	okxPrivateWSURL     = "wss://ws.okx.com:8443/ws/v5/private"
+	okxPrivateWSDemoURL = "wss://wspap.okx.com:8443/ws/v5/private?brokerId=9999"
+)
+
+type PrivateWSClient struct {
+	url        string
+	apiKey     string
+	secretKey  string
+	passphrase string
+
+	mu       sync.RWMutex
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	running  bool
+	loggedIn bool
+	done     chan struct{}
+
+	channels []map[string]string // 订阅参数（重连后原样恢复）
+
+	// ---------- 回调 ----------
+	orderHandlers    []func(OrderUpdate)
+	fillHandlers     []func(Fill)
+	positionHandlers []func([]Position)
+	balanceHandlers  []func([]Balance)
+	accountHandlers  []func(AccountSnapshot)
+
+	loginTimeout time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	now          func() time.Time
+}
+
+// NewPrivateWSClient —— 模拟盘且使用默认实盘地址时，自动切到 wspap 地址
+func NewPrivateWSClient(cfg config.ExchangeConfig) *PrivateWSClient {
+	url := cfg.WSURL
+	if url == "" {
+		url = okxPrivateWSURL
+	}
+	if cfg.Simulated && url == okxPrivateWSURL {
+		url = okxPrivateWSDemoURL
+	}
+	return &PrivateWSClient{
+		url:        url,
+		apiKey:     cfg.APIKey,
+		secretKey:  cfg.SecretKey,
+		passphrase: cfg.Passphrase,
+		channels: []map[string]string{
+			{"channel": "orders", "instType": "ANY"},
+			{"channel": "positions", "instType": "ANY"},
+			{"channel": "account"},
+			{"channel": "balance_and_position"},
+		},
+		loginTimeout: 10 * time.Second,
+		minBackoff:   time.Second,
+		maxBackoff:   30 * time.Second,
+		now:          time.Now,
+	}
+}
+
+// ===================== 回调注册（EventSource） =====================
+
+func (c *PrivateWSClient) OnOrder(h func(OrderUpdate)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.orderHandlers = append(c.orderHandlers, h)
+}
+func (c *PrivateWSClient) OnFill(h func(Fill)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fillHandlers = append(c.fillHandlers, h)
+}
+func (c *PrivateWSClient) OnPosition(h func([]Position)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positionHandlers = append(c.positionHandlers, h)
+}
+func (c *PrivateWSClient) OnBalance(h func([]Balance)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.balanceHandlers = append(c.balanceHandlers, h)
+}
+func (c *PrivateWSClient) OnAccount(h func(AccountSnapshot)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accountHandlers = append(c.accountHandlers, h)
+}
+
+// ===================== 连接管理 =====================
+
+// Start —— 首次连接+登录+订阅（同步返回错误），之后在后台保持连接
+func (c *PrivateWSClient) Start() error {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return nil
+	}
+	c.running = true
+	c.done = make(chan struct{})
+	c.mu.Unlock()
+
+	conn, err := c.connect()
+	if err != nil {
+		c.mu.Lock()
+		c.running = false
+		c.mu.Unlock()
+		return err
+	}
+	go c.serve(conn)
+	return nil
+}
+
+// IsLoggedIn —— 当前连接是否已登录
+func (c *PrivateWSClient) IsLoggedIn() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loggedIn
+}
+
+// Close —— 停止重连并关闭连接
+func (c *PrivateWSClient) Close() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = false
+	close(c.done)
+	conn := c.conn
+	c.conn = nil
+	c.loggedIn = false
+	c.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+	log.Println("👋 私有WS已关闭")
+}
+
+// connect —— 拨号 → 登录 → 订阅；拨号/登录期间 Close 了（或已换了一轮 Start）则关掉新连接返回错误
+func (c *PrivateWSClient) connect() (*websocket.Conn, error) {
+	c.mu.RLock()
+	done := c.done
+	c.mu.RUnlock()
+	dialer := *websocket.DefaultDialer // 复用全局默认（可能含代理/自定义解析）
+	log.Printf("📡 连接私有 WebSocket: %s", c.url)
+	conn, _, err := dialer.Dial(c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("私有WS连接失败: %v", err)
+	}
+	if err := c.login(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	if !c.running || c.done != done {
+		c.mu.Unlock()
+		_ = conn.Close()
+		return nil, errors.New("私有WS已关闭")
+	}
+	c.conn = conn
+	c.loggedIn = true
+	args := append([]map[string]string(nil), c.channels...)
+	c.mu.Unlock()
+
+	if err := c.write(conn, map[string]any{"op": "subscribe", "args": args}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("私有WS订阅失败: %v", err)
+	}
+	log.Println("✅ 私有WS登录成功")
+	return conn, nil
+}
+
+// login —— 发送签名登录包并等待 event=login 应答
+func (c *PrivateWSClient) login(conn *websocket.Conn) error {
+	ts := strconv.FormatInt(c.now().Unix(), 10)
+	arg := map[string]string{
+		"apiKey":     c.apiKey,
+		"passphrase": c.passphrase,
+		"timestamp":  ts,
+		"sign":       signOKX(c.secretKey, ts, "GET", "/users/self/verify", ""),
+	}
+	if err := c.write(conn, map[string]any{"op": "login", "args": []any{arg}}); err != nil {
+		return fmt.Errorf("私有WS登录发送失败: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(c.loginTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("私有WS登录应答失败: %v", err)
+		}
+		var ev privEvent
+		if json.Unmarshal(msg, &ev) != nil {
+			continue
+		}
+		switch ev.Event {
+		case "login":
+			if ev.Code == "0" || ev.Code == "" {
+				return nil
+			}
+			return &OKXError{Op: "ws-login", Code: ev.Code, Msg: ev.Msg}
+		case "error":
+			return &OKXError{Op: "ws-login", Code: ev.Code, Msg: ev.Msg}
+		}
+	}
+}
+
+// serve —— 读循环；断线后带退避重连
+func (c *PrivateWSClient) serve(conn *websocket.Conn) {
+	attempt := 0
+	for {
+		if conn != nil {
+			attempt = 0
+			c.readLoop(conn)
+		}
+		c.mu.Lock()
+		c.loggedIn = false
+		if c.conn == conn {
+			c.conn = nil
+		}
+		running := c.running
+		done := c.done
+		c.mu.Unlock()
+		if !running {
+			return
+		}
+
+		attempt++
+		delay := c.minBackoff << uint(minInt(attempt-1, 5))
+		if delay > c.maxBackoff {
+			delay = c.maxBackoff
+		}
+		log.Printf("🔄 %v后重连私有WS (第%d次)...", delay, attempt)
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return
+		}
+		var err error
+		conn, err = c.connect()
+		if err != nil {
+			log.Printf("❌ 私有WS重连失败: %v", err)
+			conn = nil
+		}
+	}
+}
+
+func (c *PrivateWSClient) readLoop(conn *websocket.Conn) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.keepAlive(conn, stop)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		if string(msg) == "pong" {
+			continue
+		}
+		c.handleMessage(msg)
+	}
+}
+
+// keepAlive —— OKX 要求 30s 内有数据，否则断开；这里每 20s 发文本 ping
+func (c *PrivateWSClient) keepAlive(conn *websocket.Conn, stop <-chan struct{}) {
+	t := time.NewTicker(20 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.writeMu.Lock()
+			err := conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+			c.writeMu.Unlock()
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *PrivateWSClient) write(conn *websocket.Conn, v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if conn == nil {
+		return errors.New("ws nil")
+	}
+	return conn.WriteJSON(v)
+}
+
+// ===================== 推送解码 =====================
+
+type privEvent struct {
+	Event string `json:"event"`
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+	Arg   struct {
+		Channel string `json:"channel"`
+	} `json:"arg"`
+	Data json.RawMessage `json:"data"`
+}
+
+type okxOrderPush struct {
+	InstID    string `json:"instId"`
+	OrdID     string `json:"ordId"`
+	ClOrdID   string `json:"clOrdId"`
+	Side      string `json:"side"`
+	State     string `json:"state"`
+	Sz        string `json:"sz"`
+	Px        string `json:"px"`
+	AccFillSz string `json:"accFillSz"`
+	AvgPx     string `json:"avgPx"`
+	FillSz    string `json:"fillSz"`
+	FillPx    string `json:"fillPx"`
+	FillFee   string `json:"fillFee"`
+	FillTime  string `json:"fillTime"`
+	TradeID   string `json:"tradeId"`
+	ExecType  string `json:"execType"`
+	UTime     string `json:"uTime"`
+}
+
+type okxPositionPush struct {
+	InstID  string `json:"instId"`
+	PosSide string `json:"posSide"`
+	Pos     string `json:"pos"`
+	AvgPx   string `json:"avgPx"`
+	Upl     string `json:"upl"`
+	MarkPx  string `json:"markPx"`
+	LiqPx   string `json:"liqPx"`
+	Lever   string `json:"lever"`
+	MgnMode string `json:"mgnMode"`
+	UTime   string `json:"uTime"`
+}
+
+type okxBalancePush struct {
+	Ccy       string `json:"ccy"`
+	Eq        string `json:"eq"`
+	CashBal   string `json:"cashBal"`
+	AvailBal  string `json:"availBal"`
+	FrozenBal string `json:"frozenBal"`
+	Upl       string `json:"upl"`
+	UTime     string `json:"uTime"`
+}
+
+type okxAccountPush struct {
+	TotalEq string           `json:"totalEq"`
+	UTime   string           `json:"uTime"`
+	Details []okxBalancePush `json:"details"`
+}
+
+type okxBalAndPosPush struct {
+	PTime   string            `json:"pTime"`
+	BalData []okxBalancePush  `json:"balData"`
+	PosData []okxPositionPush `json:"posData"`
+}
+
+func (c *PrivateWSClient) handleMessage(msg []byte) {
+	var ev privEvent
+	if err := json.Unmarshal(msg, &ev); err != nil {
+		return
+	}
+	switch ev.Event {
+	case "subscribe":
+		log.Printf("✅ 私有WS订阅成功: %s", ev.Arg.Channel)
+		return
+	case "error":
+		log.Printf("❌ 私有WS错误: code=%s msg=%s", ev.Code, ev.Msg)
+		return
+	case "":
+	default:
+		return
+	}
+	if len(ev.Data) == 0 {
+		return
+	}
+
+	switch ev.Arg.Channel {
+	case "orders":
+		var arr []okxOrderPush
+		if err := json.Unmarshal(ev.Data, &arr); err != nil {
+			return
+		}
+		for _, o := range arr {
+			// 先成交后状态：保证 Executor 收到 filled 时仓位已更新
+			if f, ok := decodeOrderFill(o); ok {
+				c.dispatchFill(f)
+			}
+			c.dispatchOrder(decodeOrderUpdate(o))
+		}
+
+	case "positions":
+		var arr []okxPositionPush
+		if err := json.Unmarshal(ev.Data, &arr); err != nil {
+			return
+		}
+		c.dispatchPositions(decodePositions(arr))
+
+	case "account":
+		var arr []okxAccountPush
+		if err := json.Unmarshal(ev.Data, &arr); err != nil {
+			return
+		}
+		for _, a := range arr {
+			snap := AccountSnapshot{TotalEq: pf(a.TotalEq), Ts: pms(a.UTime)}
+			for _, d := range a.Details {
+				snap.Details = append(snap.Details, decodeBalance(d))
+			}
+			c.dispatchAccount(snap)
+		}
+
+	case "balance_and_position":
+		var arr []okxBalAndPosPush
+		if err := json.Unmarshal(ev.Data, &arr); err != nil {
+			return
+		}
+		for _, bp := range arr {
+			if len(bp.BalData) > 0 {
+				bals := make([]Balance, 0, len(bp.BalData))
+				for _, b := range bp.BalData {
+					bals = append(bals, decodeBalance(b))
+				}
+				c.dispatchBalances(bals)
+			}
+			if len(bp.PosData) > 0 {
+				c.dispatchPositions(decodePositions(bp.PosData))
+			}
+		}
+	}
+}
+
+func decodeOrderUpdate(o okxOrderPush) OrderUpdate {
+	return OrderUpdate{
+		InstID:    o.InstID,
+		ClientID:  o.ClOrdID,
+		OrderID:   o.OrdID,
+		FilledQty: pf(o.AccFillSz),
+		Status:    okxStateToStatus(o.State),
+		AvgPrice:  pf(o.AvgPx),
+		Ts:        pms(o.UTime),
+	}
+}
+
+func decodeOrderFill(o okxOrderPush) (Fill, bool) {
+	qty := pf(o.FillSz)
+	if qty <= 0 {
+		return Fill{}, false
+	}
+	return Fill{
+		InstID:    o.InstID,
+		ClientID:  o.ClOrdID,
+		Side:      Side(o.Side),
+		Qty:       qty,
+		Price:     pf(o.FillPx),
+		Fee:       -pf(o.FillFee), // OKX：负数为扣费
+		TradeID:   o.TradeID,
+		Liquidity: o.ExecType,
+		Ts:        pms(o.FillTime),
+	}, true
+}
+
+func decodePositions(arr []okxPositionPush) []Position {
+	out := make([]Position, 0, len(arr))
+	for _, p := range arr {
+		q := pf(p.Pos)
+		if p.PosSide == "short" && q > 0 {
+			q = -q
+		}
+		out = append(out, Position{
+			InstID:  p.InstID,
+			PosSide: p.PosSide,
+			Qty:     q,
+			AvgPx:   pf(p.AvgPx),
+			UPL:     pf(p.Upl),
+			MarkPx:  pf(p.MarkPx),
+			LiqPx:   pf(p.LiqPx),
+			Lever:   pf(p.Lever),
+			MgnMode: p.MgnMode,
+			Ts:      pms(p.UTime),
+		})
+	}
+	return out
+}
+
+func decodeBalance(b okxBalancePush) Balance {
+	return Balance{
+		Ccy:    b.Ccy,
+		Equity: pf(b.Eq),
+		Cash:   pf(b.CashBal),
+		Avail:  pf(b.AvailBal),
+		Frozen: pf(b.FrozenBal),
+		UPL:    pf(b.Upl),
+		Ts:     pms(b.UTime),
+	}
+}
+
+// OKX 订单状态 -> 执行层状态
+func okxStateToStatus(s string) string {
+	switch s {
+	case "live":
+		return "new"
+	case "mmp_canceled":
+		return "canceled"
+	}
+	return s
+}
+
+// ===================== 分发 =====================
+
+func (c *PrivateWSClient) dispatchOrder(u OrderUpdate) {
+	c.mu.RLock()
+	hs := append([]func(OrderUpdate){}, c.orderHandlers...)
+	c.mu.RUnlock()
+	for _, h := range hs {
+		h(u)
+	}
+}
+func (c *PrivateWSClient) dispatchFill(f Fill) {
+	c.mu.RLock()
+	hs := append([]func(Fill){}, c.fillHandlers...)
+	c.mu.RUnlock()
+	for _, h := range hs {
+		h(f)
+	}
+}
+func (c *PrivateWSClient) dispatchPositions(ps []Position) {
+	c.mu.RLock()
+	hs := append([]func([]Position){}, c.positionHandlers...)
+	c.mu.RUnlock()
+	for _, h := range hs {
+		h(ps)
+	}
+}
+func (c *PrivateWSClient) dispatchBalances(bs []Balance) {
+	c.mu.RLock()
+	hs := append([]func([]Balance){}, c.balanceHandlers...)
+	c.mu.RUnlock()
+	for _, h := range hs {
+		h(bs)
+	}
+}
+func (c *PrivateWSClient) dispatchAccount(a AccountSnapshot) {
+	c.mu.RLock()
+	hs := append([]func(AccountSnapshot){}, c.accountHandlers...)
+	c.mu.RUnlock()
+	for _, h := range hs {
+		h(a)
+	}
+}
+
+// ===================== 工具 =====================
+
+func pf(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v
+}
+
+func pms(s string) time.Time {
+	ms, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
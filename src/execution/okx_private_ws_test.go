@@ -0,0 +1,225 @@
+package execution
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"Mod/src/config"
+)
+
+// 本地私有 WS 替身：校验登录签名并回应答，记录订阅参数；登录+订阅完成后把连接交给测试推送或断开
+type fakePrivateWS struct {
+	t      *testing.T
+	secret string
+	ts     string
+	mu     sync.Mutex
+	logins int
+	subs   [][]map[string]string
+	ready  chan *websocket.Conn
+}
+
+func (f *fakePrivateWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			Op   string              `json:"op"`
+			Args []map[string]string `json:"args"`
+		}
+		if json.Unmarshal(msg, &req) != nil {
+			continue
+		}
+		switch req.Op {
+		case "login":
+			a := req.Args[0]
+			want := signOKX(f.secret, f.ts, "GET", "/users/self/verify", "")
+			if a["apiKey"] != "key" || a["passphrase"] != "pass" || a["timestamp"] != f.ts || a["sign"] != want {
+				f.t.Errorf("bad login: %+v", a)
+				_ = conn.WriteJSON(map[string]string{"event": "login", "code": "60009", "msg": "Login failed."})
+				return
+			}
+			f.mu.Lock()
+			f.logins++
+			f.mu.Unlock()
+			_ = conn.WriteJSON(map[string]string{"event": "login", "code": "0"})
+		case "subscribe":
+			f.mu.Lock()
+			f.subs = append(f.subs, req.Args)
+			f.mu.Unlock()
+			f.ready <- conn
+		}
+	}
+}
+
+func TestPrivateWSLoginDecodeAndRelogin(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := &fakePrivateWS{t: t, secret: "s3cret", ts: "1704164645", ready: make(chan *websocket.Conn, 2)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := NewPrivateWSClient(config.ExchangeConfig{
+		APIKey: "key", SecretKey: "s3cret", Passphrase: "pass", WSURL: "ws" + strings.TrimPrefix(srv.URL, "http"),
+	})
+	c.now = func() time.Time { return now }
+	c.minBackoff = 10 * time.Millisecond
+
+	events := make(chan string, 8)
+	var fill Fill
+	var upd OrderUpdate
+	var pos []Position
+	c.OnFill(func(x Fill) { fill = x; events <- "fill" })
+	c.OnOrder(func(x OrderUpdate) { upd = x; events <- "order" })
+	c.OnPosition(func(x []Position) { pos = x; events <- "position" })
+
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := waitConn(t, f.ready)
+	if !c.IsLoggedIn() {
+		t.Fatal("not logged in")
+	}
+
+	push := func(conn *websocket.Conn, msg string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-events:
+				if got != w {
+					t.Fatalf("event %s, want %s", got, w)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("no %s event", w)
+			}
+		}
+	}
+
+	// 成交先于订单状态下发；OKX 负手续费为扣费
+	push(conn, `{"arg":{"channel":"orders","instType":"SWAP"},"data":[{"instId":"X","ordId":"1","clOrdId":"c1","side":"buy",
+		"state":"partially_filled","sz":"2","accFillSz":"1","avgPx":"100","fillSz":"1","fillPx":"100","fillFee":"-0.05",
+		"fillTime":"1700000000000","tradeId":"t1","execType":"M","uTime":"1700000000001"}]}`)
+	expect("fill", "order")
+	if fill.ClientID != "c1" || fill.Side != SideBuy || fill.Qty != 1 || fill.Price != 100 || !approx(fill.Fee, 0.05) ||
+		fill.TradeID != "t1" || fill.Liquidity != "M" || !fill.Ts.Equal(time.UnixMilli(1700000000000)) {
+		t.Fatalf("fill: %+v", fill)
+	}
+	if upd.OrderID != "1" || upd.Status != "partially_filled" || upd.FilledQty != 1 || upd.AvgPrice != 100 {
+		t.Fatalf("order: %+v", upd)
+	}
+
+	// 双向持仓：空头为负
+	push(conn, `{"arg":{"channel":"positions","instType":"SWAP"},"data":[{"instId":"X","posSide":"short","pos":"3","avgPx":"101","markPx":"99","uTime":"1700000000002"}]}`)
+	expect("position")
+	if len(pos) != 1 || pos[0].PosSide != "short" || pos[0].Qty != -3 || pos[0].AvgPx != 101 || pos[0].MarkPx != 99 {
+		t.Fatalf("positions: %+v", pos)
+	}
+
+	// 断线：重新登录并恢复全部订阅，推送照常
+	conn.Close()
+	conn = waitConn(t, f.ready)
+	f.mu.Lock()
+	logins, subs := f.logins, f.subs
+	f.mu.Unlock()
+	if logins != 2 || len(subs) != 2 || len(subs[1]) != 4 || subs[1][0]["channel"] != "orders" {
+		t.Fatalf("logins=%d subs=%+v", logins, subs)
+	}
+	push(conn, `{"arg":{"channel":"balance_and_position"},"data":[{"posData":[{"instId":"X","posSide":"long","pos":"1"}]}]}`)
+	expect("position")
+	if len(pos) != 1 || pos[0].Qty != 1 {
+		t.Fatalf("positions after relogin: %+v", pos)
+	}
+}
+
+func waitConn(t *testing.T, ch <-chan *websocket.Conn) *websocket.Conn {
+	t.Helper()
+	select {
+	case c := <-ch:
+		return c
+	case <-time.After(3 * time.Second):
+		t.Fatal("no login+subscribe")
+	}
+	return nil
+}
+
+func TestOnPositionsMergesLegs(t *testing.T) {
+	ex := NewExecutor(Config{AccountEquity: 1000})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	ex.OnPositions([]Position{
+		{InstID: "X", PosSide: "long", Qty: 5, AvgPx: 100},
+		{InstID: "X", PosSide: "short", Qty: -2, AvgPx: 110},
+	})
+	if q := ex.ensure("X").position; q != 3 {
+		t.Fatalf("net=%v", q)
+	}
+	// 只推了空头一侧：多头保留
+	ex.OnPositions([]Position{{InstID: "X", PosSide: "short", Qty: -1, AvgPx: 110}})
+	if q := ex.ensure("X").position; q != 4 {
+		t.Fatalf("net after one-leg push=%v", q)
+	}
+	if p, _ := ex.PositionOf("X"); p.Qty != 4 {
+		t.Fatalf("book: %+v", p)
+	}
+}
+
+func TestPrivateWSCloseDuringLoginDropsConnection(t *testing.T) {
+	loginSeen, release, gone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		close(loginSeen)
+		<-release
+		_ = conn.WriteJSON(map[string]string{"event": "login", "code": "0"})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(gone)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c := NewPrivateWSClient(config.ExchangeConfig{APIKey: "key", SecretKey: "s", Passphrase: "pass", WSURL: "ws" + strings.TrimPrefix(srv.URL, "http")})
+	started := make(chan error, 1)
+	go func() { started <- c.Start() }()
+	<-loginSeen
+	c.Close()
+	close(release)
+
+	if err := <-started; err == nil {
+		t.Fatal("Start succeeded after Close")
+	}
+	select {
+	case <-gone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection kept after Close")
+	}
+	if c.IsLoggedIn() {
+		t.Fatal("logged in after Close")
+	}
+}
@@ -0,0 +1,604 @@
+package execution
+
+// OKX v5 REST 交易适配器（实现 ExchangeAdapter）
+// =============================================================================
+// 1) 签名：Base64(HMAC-SHA256(secretKey, timestamp + METHOD + requestPath + body))；
+// 2) 模拟盘：ExchangeConfig.Simulated=true 时附带 x-simulated-trading: 1；
+// 3) 批量：batch-orders / cancel-batch-orders / amend-batch-orders，单批最多 20 笔，超出自动分批；
+// 4) 错误：HTTP 状态、顶层 code 与逐笔 sCode 统一映射为 *OKXError，
+//    可用 errors.Is(err, ErrRateLimited) 等哨兵错误判断类别；
+// 5) 策略单：order-algo（逐笔）/ cancel-algos（单批最多 10 笔），algoClOrdId -> algoId 本地留存。
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Mod/src/config"
+)
+
+// ===================== 错误类型 =====================
+
+var (
+	ErrAuth               = errors.New("okx: 鉴权失败")
+	ErrRateLimited        = errors.New("okx: 触发限频")
+	ErrServiceUnavailable = errors.New("okx: 服务繁忙/超时")
+	ErrInsufficientFunds  = errors.New("okx: 余额/保证金不足")
+	ErrOrderNotFound      = errors.New("okx: 订单不存在")
+	ErrOrderCompleted     = errors.New("okx: 订单已完结（已成交/已撤销）")
+	ErrDuplicateClientID  = errors.New("okx: clOrdId 重复")
+	ErrInvalidParam       = errors.New("okx: 参数错误")
+	ErrPriceOutOfRange    = errors.New("okx: 价格超出限价范围")
+)
+
+// OKXError —— 交易所返回的错误（顶层 code 或逐笔 sCode）
+type OKXError struct {
+	Op         string // 接口：batch-orders / cancel-batch-orders ...
+	HTTPStatus int
+	Code       string
+	Msg        string
+	InstID     string
+	ClientID   string
+}
+
+func (e *OKXError) Error() string {
+	s := fmt.Sprintf("okx %s: code=%s msg=%s", e.Op, e.Code, e.Msg)
+	if e.ClientID != "" {
+		s += " clOrdId=" + e.ClientID
+	}
+	if e.HTTPStatus != 0 && e.HTTPStatus != http.StatusOK {
+		s += fmt.Sprintf(" http=%d", e.HTTPStatus)
+	}
+	return s
+}
+
+// Unwrap —— 把错误码归类到哨兵错误（未知错误码返回 nil）
+func (e *OKXError) Unwrap() error {
+	if e.HTTPStatus == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+	return okxErrKind(e.Code)
+}
+
+// 错误码 -> 类别（只收录执行层关心的部分）
+func okxErrKind(code string) error {
+	switch code {
+	case "50011", "50061":
+		return ErrRateLimited
+	case "50001", "50004", "50013", "50026":
+		return ErrServiceUnavailable
+	case "50100", "50101", "50102", "50103", "50104", "50105", "50111", "50112", "50113":
+		return ErrAuth
+	case "51008", "51127", "51131":
+		return ErrInsufficientFunds
+	case "51603":
+		return ErrOrderNotFound
+	case "51400", "51401", "51402", "51503":
+		return ErrOrderCompleted
+	case "51016":
+		return ErrDuplicateClientID
+	case "51006":
+		return ErrPriceOutOfRange
+	case "51000", "51001", "51020":
+		return ErrInvalidParam
+	}
+	return nil
+}
+
+// ===================== 适配器 =====================
+
+const (
+	okxMaxBatch     = 20
+	okxMaxAlgoBatch = 10
+)
+
+type OKXAdapter struct {
+	apiKey     string
+	secretKey  string
+	passphrase string
+	baseURL    string
+	simulated  bool
+	tdMode     string // 为空时按品种推断：现货 cash，其余 cross
+
+	httpClient  *http.Client
+	httpTimeout time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	algoIDs map[string]string // algoClOrdId -> algoId
+}
+
+// NewOKXAdapter —— 复用全局默认传输栈（兼容 netboot.Init）
+func NewOKXAdapter(cfg config.ExchangeConfig) *OKXAdapter {
+	var hc *http.Client
+	if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+		hc = &http.Client{Timeout: 10 * time.Second, Transport: dt.Clone()}
+	} else {
+		hc = http.DefaultClient
+	}
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	if base == "" {
+		base = "https://www.okx.com"
+	}
+	return &OKXAdapter{
+		apiKey:      cfg.APIKey,
+		secretKey:   cfg.SecretKey,
+		passphrase:  cfg.Passphrase,
+		baseURL:     base,
+		simulated:   cfg.Simulated,
+		httpClient:  hc,
+		httpTimeout: 10 * time.Second,
+		now:         time.Now,
+		algoIDs:     make(map[string]string),
+	}
+}
+
+// SetTradeMode —— 固定 tdMode（cross/isolated/cash）
+func (a *OKXAdapter) SetTradeMode(mode string) { a.tdMode = mode }
+
+// SetHTTPClient —— 替换 HTTP 客户端（测试/自定义代理）
+func (a *OKXAdapter) SetHTTPClient(hc *http.Client) {
+	if hc != nil {
+		a.httpClient = hc
+	}
+}
+
+// SendOrders —— POST /api/v5/trade/batch-orders（自动分批）
+func (a *OKXAdapter) SendOrders(orders []OrderRequest) error {
+	var errs []error
+	for _, chunk := range chunkOrders(orders, okxMaxBatch) {
+		args := make([]map[string]any, 0, len(chunk))
+		for _, o := range chunk {
+			args = append(args, a.orderArg(o))
+		}
+		errs = append(errs, a.doBatch("batch-orders", "/api/v5/trade/batch-orders", args)...)
+	}
+	return errors.Join(errs...)
+}
+
+// CancelOrders —— POST /api/v5/trade/cancel-batch-orders（自动分批）
+func (a *OKXAdapter) CancelOrders(cancels []CancelRequest) error {
+	var errs []error
+	for i := 0; i < len(cancels); i += okxMaxBatch {
+		j := i + okxMaxBatch
+		if j > len(cancels) {
+			j = len(cancels)
+		}
+		args := make([]map[string]any, 0, j-i)
+		for _, c := range cancels[i:j] {
+			args = append(args, map[string]any{"instId": c.InstID, "clOrdId": c.ClientID})
+		}
+		errs = append(errs, a.doBatch("cancel-batch-orders", "/api/v5/trade/cancel-batch-orders", args)...)
+	}
+	return errors.Join(errs...)
+}
+
+// AmendOrders —— POST /api/v5/trade/amend-batch-orders（自动分批；改价失败不撤单）
+func (a *OKXAdapter) AmendOrders(amends []AmendRequest) error {
+	var errs []error
+	for i := 0; i < len(amends); i += okxMaxBatch {
+		j := i + okxMaxBatch
+		if j > len(amends) {
+			j = len(amends)
+		}
+		args := make([]map[string]any, 0, j-i)
+		for _, m := range amends[i:j] {
+			arg := map[string]any{"instId": m.InstID, "clOrdId": m.ClientID, "cxlOnFail": false}
+			if m.NewPrice > 0 {
+				arg["newPx"] = fmtNum(m.NewPrice)
+			}
+			if m.NewQty > 0 {
+				arg["newSz"] = fmtNum(m.NewQty)
+			}
+			args = append(args, arg)
+		}
+		errs = append(errs, a.doBatch("amend-batch-orders", "/api/v5/trade/amend-batch-orders", args)...)
+	}
+	return errors.Join(errs...)
+}
+
+// 下单参数映射
+func (a *OKXAdapter) orderArg(o OrderRequest) map[string]any {
+	m := map[string]any{
+		"instId":  o.InstID,
+		"tdMode":  a.tradeModeFor(o.InstID),
+		"side":    string(o.Side),
+		"ordType": okxOrdType(o),
+		"sz":      fmtNum(o.Qty),
+	}
+	if o.ClientID != "" {
+		m["clOrdId"] = o.ClientID
+	}
+	if o.Type != OrdMarket && o.Price > 0 {
+		m["px"] = fmtNum(o.Price)
+	}
+	if o.ReduceOnly {
+		m["reduceOnly"] = true
+	}
+	if o.Attach != nil {
+		att := map[string]any{}
+		putTPSL(att, *o.Attach)
+		if o.ClientID != "" {
+			att["attachAlgoClOrdId"] = o.ClientID + "a"
+		}
+		m["attachAlgoOrds"] = []map[string]any{att}
+	}
+	return m
+}
+
+// putTPSL —— 止盈止损字段（委托价 0 -> "-1" 市价）
+func putTPSL(m map[string]any, t TPSL) {
+	px := func(v float64) string {
+		if v <= 0 {
+			return "-1"
+		}
+		return fmtNum(v)
+	}
+	typ := t.TriggerPxType
+	if typ == "" {
+		typ = "last"
+	}
+	if t.TPTriggerPx > 0 {
+		m["tpTriggerPx"], m["tpOrdPx"], m["tpTriggerPxType"] = fmtNum(t.TPTriggerPx), px(t.TPOrdPx), typ
+	}
+	if t.SLTriggerPx > 0 {
+		m["slTriggerPx"], m["slOrdPx"], m["slTriggerPxType"] = fmtNum(t.SLTriggerPx), px(t.SLOrdPx), typ
+	}
+}
+
+// ===================== 策略单 =====================
+
+// SendAlgoOrders —— POST /api/v5/trade/order-algo（OKX 仅支持逐笔）
+func (a *OKXAdapter) SendAlgoOrders(orders []AlgoOrderRequest) error {
+	var errs []error
+	for _, o := range orders {
+		env, err := a.do("order-algo", http.MethodPost, "/api/v5/trade/order-algo", nil, a.algoArg(o))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var items []okxAlgoItem
+		_ = json.Unmarshal(env.Data, &items)
+		if env.Code != "0" && len(items) == 0 {
+			errs = append(errs, &OKXError{Op: "order-algo", HTTPStatus: http.StatusOK, Code: env.Code, Msg: env.Msg, InstID: o.InstID, ClientID: o.ClientID})
+			continue
+		}
+		for _, it := range items {
+			if it.SCode != "" && it.SCode != "0" {
+				errs = append(errs, &OKXError{Op: "order-algo", HTTPStatus: http.StatusOK, Code: it.SCode, Msg: it.SMsg, InstID: o.InstID, ClientID: o.ClientID})
+				continue
+			}
+			if o.ClientID != "" && it.AlgoID != "" {
+				a.mu.Lock()
+				a.algoIDs[o.ClientID] = it.AlgoID
+				a.mu.Unlock()
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CancelAlgoOrders —— POST /api/v5/trade/cancel-algos（按 algoId，自动分批）；
+// algoClOrdId -> algoId 的映射只在该条撤单被确认（sCode=0）后删除，失败可按 ClientID 重试
+func (a *OKXAdapter) CancelAlgoOrders(cancels []AlgoCancelRequest) error {
+	var errs []error
+	args := make([]map[string]any, 0, len(cancels))
+	byAlgo := make(map[string]AlgoCancelRequest, len(cancels)) // algoId -> 请求
+	a.mu.Lock()
+	for _, c := range cancels {
+		id := c.AlgoID
+		if id == "" {
+			id = a.algoIDs[c.ClientID]
+		}
+		if id == "" {
+			errs = append(errs, &OKXError{Op: "cancel-algos", Code: "51603", Msg: "unknown algoClOrdId", InstID: c.InstID, ClientID: c.ClientID})
+			continue
+		}
+		byAlgo[id] = c
+		args = append(args, map[string]any{"instId": c.InstID, "algoId": id})
+	}
+	a.mu.Unlock()
+	for i := 0; i < len(args); i += okxMaxAlgoBatch {
+		j := i + okxMaxAlgoBatch
+		if j > len(args) {
+			j = len(args)
+		}
+		env, err := a.do("cancel-algos", http.MethodPost, "/api/v5/trade/cancel-algos", nil, args[i:j])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var items []okxAlgoItem
+		_ = json.Unmarshal(env.Data, &items)
+		if env.Code != "0" && len(items) == 0 {
+			errs = append(errs, &OKXError{Op: "cancel-algos", HTTPStatus: http.StatusOK, Code: env.Code, Msg: env.Msg})
+		}
+		for _, it := range items {
+			c := byAlgo[it.AlgoID]
+			if it.SCode != "" && it.SCode != "0" {
+				errs = append(errs, &OKXError{Op: "cancel-algos", HTTPStatus: http.StatusOK, Code: it.SCode, Msg: it.SMsg, InstID: c.InstID, ClientID: c.ClientID})
+				continue
+			}
+			if c.ClientID != "" {
+				a.mu.Lock()
+				delete(a.algoIDs, c.ClientID)
+				a.mu.Unlock()
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PendingAlgos —— GET /api/v5/trade/orders-algo-pending（按策略单类型逐类分页）
+func (a *OKXAdapter) PendingAlgos() ([]PendingAlgo, error) {
+	var out []PendingAlgo
+	for _, typ := range []AlgoOrdType{AlgoConditional, AlgoOCO, AlgoTrigger, AlgoTrailing} {
+		after := ""
+		for {
+			q := url.Values{"ordType": {string(typ)}, "limit": {"100"}}
+			if after != "" {
+				q.Set("after", after)
+			}
+			var rows []okxPendingAlgo
+			if err := a.get("orders-algo-pending", "/api/v5/trade/orders-algo-pending", q, &rows); err != nil {
+				return out, err
+			}
+			for _, r := range rows {
+				req := AlgoOrderRequest{
+					InstID: r.InstID, ClientID: r.AlgoClOrdID, Type: AlgoOrdType(r.OrdType), Side: Side(r.Side),
+					Qty: pf(r.Sz), ReduceOnly: r.ReduceOnly == "true", TriggerPx: pf(r.TriggerPx), OrdPx: pf(r.OrdPx),
+					CallbackRatio: pf(r.CallbackRatio), CallbackSpread: pf(r.CallbackSpread), ActivePx: pf(r.ActivePx),
+				}
+				req.TPSL = TPSL{TPTriggerPx: pf(r.TPTriggerPx), TPOrdPx: pf(r.TPOrdPx), SLTriggerPx: pf(r.SLTriggerPx), SLOrdPx: pf(r.SLOrdPx)}
+				out = append(out, PendingAlgo{AlgoOrderRequest: req, AlgoID: r.AlgoID})
+			}
+			if len(rows) < 100 {
+				break
+			}
+			after = rows[len(rows)-1].AlgoID
+		}
+	}
+	a.mu.Lock()
+	for _, p := range out {
+		if p.ClientID != "" {
+			a.algoIDs[p.ClientID] = p.AlgoID // 重启后也能按 algoClOrdId 撤
+		}
+	}
+	a.mu.Unlock()
+	return out, nil
+}
+
+type okxPendingAlgo struct {
+	AlgoID         string `json:"algoId"`
+	AlgoClOrdID    string `json:"algoClOrdId"`
+	InstID         string `json:"instId"`
+	OrdType        string `json:"ordType"`
+	Side           string `json:"side"`
+	Sz             string `json:"sz"`
+	ReduceOnly     string `json:"reduceOnly"`
+	TPTriggerPx    string `json:"tpTriggerPx"`
+	TPOrdPx        string `json:"tpOrdPx"`
+	SLTriggerPx    string `json:"slTriggerPx"`
+	SLOrdPx        string `json:"slOrdPx"`
+	TriggerPx      string `json:"triggerPx"`
+	OrdPx          string `json:"ordPx"`
+	CallbackRatio  string `json:"callbackRatio"`
+	CallbackSpread string `json:"callbackSpread"`
+	ActivePx       string `json:"activePx"`
+}
+
+type okxAlgoItem struct {
+	AlgoID      string `json:"algoId"`
+	AlgoClOrdID string `json:"algoClOrdId"`
+	SCode       string `json:"sCode"`
+	SMsg        string `json:"sMsg"`
+}
+
+// 策略单参数映射
+func (a *OKXAdapter) algoArg(o AlgoOrderRequest) map[string]any {
+	m := map[string]any{
+		"instId":  o.InstID,
+		"tdMode":  a.tradeModeFor(o.InstID),
+		"side":    string(o.Side),
+		"ordType": string(o.Type),
+		"sz":      fmtNum(o.Qty),
+	}
+	if o.ClientID != "" {
+		m["algoClOrdId"] = o.ClientID
+	}
+	if o.ReduceOnly {
+		m["reduceOnly"] = true
+	}
+	switch o.Type {
+	case AlgoConditional, AlgoOCO:
+		putTPSL(m, o.TPSL)
+	case AlgoTrigger:
+		m["triggerPx"] = fmtNum(o.TriggerPx)
+		if o.OrdPx > 0 {
+			m["orderPx"] = fmtNum(o.OrdPx)
+		} else {
+			m["orderPx"] = "-1"
+		}
+		if o.TriggerPxType != "" {
+			m["triggerPxType"] = o.TriggerPxType
+		}
+	case AlgoTrailing:
+		if o.CallbackRatio > 0 {
+			m["callbackRatio"] = fmtNum(o.CallbackRatio)
+		} else {
+			m["callbackSpread"] = fmtNum(o.CallbackSpread)
+		}
+		if o.ActivePx > 0 {
+			m["activePx"] = fmtNum(o.ActivePx)
+		}
+	}
+	return m
+}
+
+func okxOrdType(o OrderRequest) string {
+	if o.Type == OrdMarket {
+		return "market"
+	}
+	if o.PostOnly {
+		return "post_only"
+	}
+	switch o.TimeInForce {
+	case IOC:
+		return "ioc"
+	case FOK:
+		return "fok"
+	}
+	return "limit"
+}
+
+func (a *OKXAdapter) tradeModeFor(inst string) string {
+	if a.tdMode != "" {
+		return a.tdMode
+	}
+	// 现货形如 BTC-USDT；合约带 -SWAP / 交割日期后缀
+	if strings.Count(inst, "-") == 1 {
+		return "cash"
+	}
+	return "cross"
+}
+
+// ===================== 请求/签名 =====================
+
+type okxEnvelope struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+type okxBatchItem struct {
+	ClOrdID string `json:"clOrdId"`
+	OrdID   string `json:"ordId"`
+	SCode   string `json:"sCode"`
+	SMsg    string `json:"sMsg"`
+}
+
+// doBatch —— 批量接口：逐笔 sCode 非 0 的都转成 *OKXError
+func (a *OKXAdapter) doBatch(op, path string, args []map[string]any) []error {
+	if len(args) == 0 {
+		return nil
+	}
+	env, err := a.do(op, http.MethodPost, path, nil, args)
+	if err != nil {
+		return []error{err}
+	}
+	var items []okxBatchItem
+	_ = json.Unmarshal(env.Data, &items)
+	if env.Code != "0" && len(items) == 0 {
+		return []error{&OKXError{Op: op, HTTPStatus: http.StatusOK, Code: env.Code, Msg: env.Msg}}
+	}
+	// 回填 instId（响应里没有）
+	inst := make(map[string]string, len(args))
+	for _, arg := range args {
+		id, _ := arg["clOrdId"].(string)
+		in, _ := arg["instId"].(string)
+		inst[id] = in
+	}
+	var errs []error
+	for _, it := range items {
+		if it.SCode != "" && it.SCode != "0" {
+			errs = append(errs, &OKXError{Op: op, HTTPStatus: http.StatusOK, Code: it.SCode, Msg: it.SMsg, InstID: inst[it.ClOrdID], ClientID: it.ClOrdID})
+		}
+	}
+	return errs
+}
+
+// do —— 发送签名请求；返回顶层 envelope（code 由调用方解释）
+func (a *OKXAdapter) do(op, method, path string, query url.Values, body any) (*okxEnvelope, error) {
+	requestPath := path
+	if len(query) > 0 {
+		requestPath += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = b
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.httpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+requestPath, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	ts := a.now().UTC().Format("2006-01-02T15:04:05.000Z")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("OK-ACCESS-KEY", a.apiKey)
+	req.Header.Set("OK-ACCESS-SIGN", signOKX(a.secretKey, ts, method, requestPath, string(payload)))
+	req.Header.Set("OK-ACCESS-TIMESTAMP", ts)
+	req.Header.Set("OK-ACCESS-PASSPHRASE", a.passphrase)
+	if a.simulated {
+		req.Header.Set("x-simulated-trading", "1")
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("okx %s 请求失败: %w", op, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var env okxEnvelope
+	jerr := json.Unmarshal(b, &env)
+	if resp.StatusCode != http.StatusOK {
+		e := &OKXError{Op: op, HTTPStatus: resp.StatusCode, Code: env.Code, Msg: env.Msg}
+		if jerr != nil || e.Code == "" {
+			e.Code = strconv.Itoa(resp.StatusCode)
+			e.Msg = truncate(string(b), 256)
+		}
+		return nil, e
+	}
+	if jerr != nil {
+		return nil, fmt.Errorf("okx %s 解析JSON失败: %v", op, jerr)
+	}
+	return &env, nil
+}
+
+// signOKX —— OKX v5 签名
+func signOKX(secret, ts, method, requestPath, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + strings.ToUpper(method) + requestPath + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ===================== 工具 =====================
+
+func chunkOrders(in []OrderRequest, n int) [][]OrderRequest {
+	var out [][]OrderRequest
+	for i := 0; i < len(in); i += n {
+		j := i + n
+		if j > len(in) {
+			j = len(in)
+		}
+		out = append(out, in[i:j])
+	}
+	return out
+}
+
+func fmtNum(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
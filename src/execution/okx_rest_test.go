@@ -0,0 +1,189 @@
+package execution
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"Mod/src/config"
+)
+
+// 本地 OKX 替身：校验签名/模拟盘头，并按 clOrdId 返回预设的 sCode
+type fakeOKX struct {
+	t       *testing.T
+	secret  string
+	mu      sync.Mutex
+	batches [][]map[string]any
+	paths   []string
+	sCode   map[string]string
+	status  int
+}
+
+func (f *fakeOKX) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	ts := r.Header.Get("OK-ACCESS-TIMESTAMP")
+	want := signOKX(f.secret, ts, r.Method, r.URL.RequestURI(), string(body))
+	if r.Header.Get("OK-ACCESS-SIGN") != want {
+		f.t.Errorf("bad signature for %s", r.URL.Path)
+	}
+	if r.Header.Get("OK-ACCESS-KEY") != "key" || r.Header.Get("OK-ACCESS-PASSPHRASE") != "pass" {
+		f.t.Errorf("missing auth headers")
+	}
+	if r.Header.Get("x-simulated-trading") != "1" {
+		f.t.Errorf("expected x-simulated-trading header")
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"code":"50011","msg":"Too Many Requests"}`))
+		return
+	}
+
+	var args []map[string]any
+	_ = json.Unmarshal(body, &args)
+	f.mu.Lock()
+	f.batches = append(f.batches, args)
+	f.paths = append(f.paths, r.URL.Path)
+	f.mu.Unlock()
+
+	code := "0"
+	var data []okxBatchItem
+	for _, a := range args {
+		id, _ := a["clOrdId"].(string)
+		sc := "0"
+		if c, ok := f.sCode[id]; ok {
+			sc, code = c, "2"
+		}
+		data = append(data, okxBatchItem{ClOrdID: id, OrdID: "1", SCode: sc})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": "", "data": data})
+}
+
+func newTestAdapter(t *testing.T, f *fakeOKX) (*OKXAdapter, func()) {
+	srv := httptest.NewServer(f)
+	a := NewOKXAdapter(config.ExchangeConfig{
+		APIKey: "key", SecretKey: f.secret, Passphrase: "pass", BaseURL: srv.URL, Simulated: true,
+	})
+	a.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC) }
+	return a, srv.Close
+}
+
+func TestOKXAdapterSendOrdersSignedAndBatched(t *testing.T) {
+	f := &fakeOKX{t: t, secret: "s3cret"}
+	a, done := newTestAdapter(t, f)
+	defer done()
+
+	orders := make([]OrderRequest, 0, 25)
+	for i := 0; i < 25; i++ {
+		orders = append(orders, OrderRequest{
+			InstID: "BTC-USDT-SWAP", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 42000.5,
+			TimeInForce: GTC, PostOnly: i == 0, ClientID: "c" + string(rune('a'+i)),
+		})
+	}
+	if err := a.SendOrders(orders); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.batches) != 2 || len(f.batches[0]) != 20 || len(f.batches[1]) != 5 {
+		t.Fatalf("expected 20+5 batches, got %d", len(f.batches))
+	}
+	first := f.batches[0][0]
+	if first["ordType"] != "post_only" || first["tdMode"] != "cross" || first["px"] != "42000.5" {
+		t.Fatalf("unexpected order arg: %+v", first)
+	}
+}
+
+func TestOKXAdapterMapsErrors(t *testing.T) {
+	f := &fakeOKX{t: t, secret: "s3cret", sCode: map[string]string{"x2": "51008"}}
+	a, done := newTestAdapter(t, f)
+	defer done()
+
+	err := a.SendOrders([]OrderRequest{
+		{InstID: "BTC-USDT-SWAP", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 1, ClientID: "x1"},
+		{InstID: "BTC-USDT-SWAP", Side: SideSell, Type: OrdMarket, Qty: 1, ClientID: "x2"},
+	})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	var oe *OKXError
+	if !errors.As(err, &oe) || oe.ClientID != "x2" || oe.InstID != "BTC-USDT-SWAP" {
+		t.Fatalf("expected typed error for x2, got %+v", oe)
+	}
+
+	if err := a.CancelOrders([]CancelRequest{{InstID: "BTC-USDT-SWAP", ClientID: "x1"}}); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if f.paths[len(f.paths)-1] != "/api/v5/trade/cancel-batch-orders" {
+		t.Fatalf("unexpected cancel path %s", f.paths[len(f.paths)-1])
+	}
+
+	f.status = http.StatusTooManyRequests
+	if err := a.CancelOrders([]CancelRequest{{InstID: "BTC-USDT-SWAP", ClientID: "x1"}}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestOKXAdapterAmendOrders(t *testing.T) {
+	f := &fakeOKX{t: t, secret: "s3cret", sCode: map[string]string{"gone": "51503"}}
+	a, done := newTestAdapter(t, f)
+	defer done()
+
+	err := a.AmendOrders([]AmendRequest{
+		{InstID: "BTC-USDT-SWAP", ClientID: "a1", NewPrice: 42001.5},
+		{InstID: "BTC-USDT-SWAP", ClientID: "gone", NewQty: 2},
+	})
+	if !errors.Is(err, ErrOrderCompleted) {
+		t.Fatalf("expected ErrOrderCompleted, got %v", err)
+	}
+	if f.paths[0] != "/api/v5/trade/amend-batch-orders" {
+		t.Fatalf("unexpected amend path %s", f.paths[0])
+	}
+	first, second := f.batches[0][0], f.batches[0][1]
+	if first["newPx"] != "42001.5" || first["newSz"] != nil || second["newSz"] != "2" || first["cxlOnFail"] != false {
+		t.Fatalf("unexpected amend args: %+v %+v", first, second)
+	}
+}
+
+func TestOKXAdapterCancelAlgosKeepsUnackedMapping(t *testing.T) {
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var args []map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&args)
+		var data []okxAlgoItem
+		for _, a := range args {
+			id, _ := a["algoId"].(string)
+			sc := "0"
+			if id == "A2" {
+				sc = "51000"
+			}
+			data = append(data, okxAlgoItem{AlgoID: id, SCode: sc})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": data})
+	}))
+	defer srv.Close()
+	a := NewOKXAdapter(config.ExchangeConfig{APIKey: "key", SecretKey: "s", Passphrase: "pass", BaseURL: srv.URL})
+	a.algoIDs["s1"], a.algoIDs["s2"] = "A1", "A2"
+	cancels := []AlgoCancelRequest{{InstID: "X", ClientID: "s1"}, {InstID: "X", ClientID: "s2"}}
+
+	// 请求失败：映射保留，可重试
+	if err := a.CancelAlgoOrders(cancels); err == nil || len(a.algoIDs) != 2 {
+		t.Fatalf("transport failure: err=%v ids=%v", err, a.algoIDs)
+	}
+	// 只删除确认撤掉的；失败项带 ClientID
+	fail = false
+	err := a.CancelAlgoOrders(cancels)
+	var oe *OKXError
+	if !errors.As(err, &oe) || oe.ClientID != "s2" || oe.Code != "51000" {
+		t.Fatalf("item error: %v", err)
+	}
+	if _, ok := a.algoIDs["s1"]; ok || a.algoIDs["s2"] != "A2" {
+		t.Fatalf("mapping: %v", a.algoIDs)
+	}
+}
@@ -0,0 +1,391 @@
+package execution
+
+// 订单生命周期状态机 + 与交易所视图的周期对账
+// =============================================================================
+// 状态：pending_new → live → partially_filled → filled / canceled / rejected / expired
+// 1) 只允许“向前”迁移：乱序到达的旧状态、重复回报被忽略（幂等）；
+// 2) 终态但成交未到齐（OrderUpdate 先于 Fill）时，订单暂留 open，剩余待入账数量仍计入在途；
+// 3) Reconcile 用交易所的挂单/持仓快照校正本地视图，并逐条记录差异。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type OrderState string
+
+const (
+	OrdPendingNew      OrderState = "pending_new"
+	OrdLive            OrderState = "live"
+	OrdPartiallyFilled OrderState = "partially_filled"
+	OrdFilled          OrderState = "filled"
+	OrdCanceled        OrderState = "canceled"
+	OrdRejected        OrderState = "rejected"
+	OrdExpired         OrderState = "expired"
+)
+
+func (s OrderState) Terminal() bool {
+	switch s {
+	case OrdFilled, OrdCanceled, OrdRejected, OrdExpired:
+		return true
+	}
+	return false
+}
+
+func (s OrderState) rank() int {
+	switch s {
+	case OrdPendingNew:
+		return 0
+	case OrdLive:
+		return 1
+	case OrdPartiallyFilled:
+		return 2
+	}
+	return 3
+}
+
+// canMoveTo —— 终态吸收；其余只能前进（partially_filled 可重复，用于累计成交推进）
+func (s OrderState) canMoveTo(next OrderState) bool {
+	if s.Terminal() || next == "" {
+		return false
+	}
+	if next == OrdPartiallyFilled && s == OrdPartiallyFilled {
+		return true
+	}
+	return next.rank() > s.rank()
+}
+
+// 回报状态字符串 -> 状态机状态（兼容 OKX 原始值与执行层约定值）
+func parseOrderState(status string) OrderState {
+	switch status {
+	case "new", "live", "accepted":
+		return OrdLive
+	case "pending_new":
+		return OrdPendingNew
+	case "partially_filled":
+		return OrdPartiallyFilled
+	case "filled":
+		return OrdFilled
+	case "canceled", "cancelled", "mmp_canceled":
+		return OrdCanceled
+	case "rejected":
+		return OrdRejected
+	case "expired":
+		return OrdExpired
+	}
+	return ""
+}
+
+// ===================== state 辅助 =====================
+
+const (
+	maxDoneOrders  = 4096
+	maxSeenFills   = 8192
+	maxOrphanFills = 256
+)
+
+// settle —— 重算在途数量；终态且成交到齐则移出 open
+func (s *state) settle(id string, o *openOrder) {
+	if o.State.Terminal() {
+		o.Remaining = math.Max(0, o.ExchFilled-o.Filled)
+	} else {
+		o.Remaining = math.Max(0, o.Qty-o.Filled)
+	}
+	if o.State.Terminal() && o.Remaining <= lotEps {
+		delete(s.open, id)
+		s.markDone(id, o.State)
+	}
+}
+
+func (s *state) markDone(id string, st OrderState) {
+	if _, ok := s.done[id]; !ok {
+		s.doneQ = append(s.doneQ, id)
+	}
+	s.done[id] = st
+	for len(s.doneQ) > maxDoneOrders {
+		delete(s.done, s.doneQ[0])
+		s.doneQ = s.doneQ[1:]
+	}
+}
+
+func (s *state) rememberFill(key string) {
+	s.seenFills[key] = struct{}{}
+	s.fillQ = append(s.fillQ, key)
+	for len(s.fillQ) > maxSeenFills {
+		delete(s.seenFills, s.fillQ[0])
+		s.fillQ = s.fillQ[1:]
+	}
+}
+
+// OrderInfo —— 本地订单视图（只读快照）
+type OrderInfo struct {
+	InstID     string
+	ClientID   string
+	OrderID    string
+	Side       Side
+	Qty        float64
+	Price      float64
+	Filled     float64
+	ExchFilled float64
+	State      OrderState
+	Created    time.Time
+	Updated    time.Time
+}
+
+// OpenOrders —— 当前未结束（或成交未到齐）的订单
+func (ex *Executor) OpenOrders(inst string) []OrderInfo {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st, ok := ex.ins[inst]
+	if !ok {
+		return nil
+	}
+	out := make([]OrderInfo, 0, len(st.open))
+	for id, o := range st.open {
+		out = append(out, OrderInfo{
+			InstID: inst, ClientID: id, OrderID: o.OrderID, Side: o.Side, Qty: o.Qty, Price: o.Price,
+			Filled: o.Filled, ExchFilled: o.ExchFilled, State: o.State, Created: o.Ts, Updated: o.Updated,
+		})
+	}
+	return out
+}
+
+// ===================== 对账 =====================
+
+// RemoteOrder —— 交易所侧挂单
+type RemoteOrder struct {
+	InstID    string
+	ClientID  string
+	OrderID   string
+	Side      Side
+	Qty       float64
+	Price     float64
+	FilledQty float64
+	Status    string
+}
+
+// ExchangeSnapshot —— 交易所视图（全部挂单 + 全部非零持仓）
+type ExchangeSnapshot struct {
+	OpenOrders []RemoteOrder
+	Positions  []Position
+	Ts         time.Time
+}
+
+// SnapshotProvider —— 适配器可选实现：提供对账快照
+type SnapshotProvider interface {
+	Snapshot() (ExchangeSnapshot, error)
+}
+
+// Discrepancy —— 对账差异
+// Kind: missing_on_exchange / unknown_on_exchange / fill_mismatch / position_mismatch / stuck_terminal
+type Discrepancy struct {
+	InstID   string
+	ClientID string
+	Kind     string
+	Local    float64
+	Remote   float64
+	Detail   string
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("%s %s %s local=%.6f remote=%.6f %s", d.Kind, d.InstID, d.ClientID, d.Local, d.Remote, d.Detail)
+}
+
+// Reconcile —— 以交易所快照为准校正本地视图，返回发现的差异
+func (ex *Executor) Reconcile(snap ExchangeSnapshot) []Discrepancy {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	now := time.Now()
+	grace := time.Duration(ex.cfg.ReconcileGraceMs) * time.Millisecond
+	var out []Discrepancy
+
+	remote := make(map[string]RemoteOrder, len(snap.OpenOrders))
+	for _, r := range snap.OpenOrders {
+		remote[r.InstID+"|"+r.ClientID] = r
+	}
+
+	// 1) 本地在途 vs 交易所挂单
+	for inst, st := range ex.ins {
+		for id, o := range st.open {
+			r, ok := remote[inst+"|"+id]
+			if ok {
+				delete(remote, inst+"|"+id)
+				if r.FilledQty > o.ExchFilled+lotEps {
+					out = append(out, Discrepancy{InstID: inst, ClientID: id, Kind: "fill_mismatch", Local: o.ExchFilled, Remote: r.FilledQty})
+					o.ExchFilled = r.FilledQty
+				}
+				if next := parseOrderState(r.Status); o.State.canMoveTo(next) {
+					o.State = next
+				}
+				continue
+			}
+			if now.Sub(o.Updated) < grace {
+				continue // 刚下/刚变更的单，交易所视图可能尚未包含
+			}
+			if o.State.Terminal() {
+				// 终态但成交迟迟未到：以持仓对账为准，直接结束
+				out = append(out, Discrepancy{InstID: inst, ClientID: id, Kind: "stuck_terminal", Local: o.Filled, Remote: o.ExchFilled, Detail: string(o.State)})
+				o.ExchFilled = o.Filled
+			} else {
+				out = append(out, Discrepancy{InstID: inst, ClientID: id, Kind: "missing_on_exchange", Local: o.Qty - o.Filled, Detail: string(o.State)})
+				if o.State == OrdPendingNew {
+					o.State = OrdExpired
+				} else {
+					o.State = OrdCanceled
+				}
+				o.ExchFilled = o.Filled
+			}
+			o.Updated = now
+			st.settle(id, o)
+		}
+	}
+
+	// 2) 交易所有而本地没有：收编进 open，保证在途计算不漏
+	for _, r := range remote {
+		st := ex.ensure(r.InstID)
+		if _, done := st.done[r.ClientID]; done {
+			continue
+		}
+		out = append(out, Discrepancy{InstID: r.InstID, ClientID: r.ClientID, Kind: "unknown_on_exchange", Remote: r.Qty - r.FilledQty, Detail: string(r.Side)})
+		st.addOpen(r.ClientID, r.Side, r.Qty, r.Price)
+		o := st.open[r.ClientID]
+		o.OrderID = r.OrderID
+		o.Filled, o.ExchFilled = r.FilledQty, r.FilledQty // 历史成交已体现在持仓里
+		if next := parseOrderState(r.Status); o.State.canMoveTo(next) {
+			o.State = next
+		}
+		st.settle(r.ClientID, o)
+	}
+
+	// 3) 持仓：交易所为准（快照只含非零持仓，缺席即 0；各侧持仓整体替换）
+	for _, st := range ex.ins {
+		st.legs = nil
+	}
+	for _, p := range snap.Positions {
+		ex.ensure(p.InstID).setLeg(p)
+	}
+	net := make(map[string]float64, len(snap.Positions))
+	for _, p := range snap.Positions {
+		net[p.InstID] += p.Qty
+	}
+	for inst := range ex.ins {
+		if _, ok := net[inst]; !ok {
+			net[inst] = 0
+		}
+	}
+	ps := append([]Position(nil), snap.Positions...)
+	for inst, q := range net {
+		st := ex.ensure(inst)
+		if math.Abs(st.position-q) > lotEps {
+			out = append(out, Discrepancy{InstID: inst, Kind: "position_mismatch", Local: st.position, Remote: q})
+			st.position = q
+		}
+		if q == 0 {
+			ps = append(ps, Position{InstID: inst}) // 让已平仓品种的账本归零
+		}
+	}
+	ex.syncBooks(ps, true)
+
+	for _, d := range out {
+		log.Printf("🧾 对账差异: %s", d)
+	}
+	return out
+}
+
+// StartReconciler —— 周期对账，直到 ctx 结束
+func (ex *Executor) StartReconciler(ctx context.Context, p SnapshotProvider, every time.Duration) {
+	if every <= 0 {
+		every = 30 * time.Second
+	}
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				snap, err := p.Snapshot()
+				if err != nil {
+					log.Printf("⚠️ 对账快照失败: %v", err)
+					continue
+				}
+				ex.Reconcile(snap)
+			}
+		}
+	}()
+}
+
+// ===================== 适配器快照实现 =====================
+
+// Snapshot —— GET orders-pending（分页）+ account/positions
+func (a *OKXAdapter) Snapshot() (ExchangeSnapshot, error) {
+	snap := ExchangeSnapshot{Ts: a.now()}
+	after := ""
+	for {
+		q := url.Values{"limit": {"100"}}
+		if after != "" {
+			q.Set("after", after)
+		}
+		var rows []okxOrderPush
+		if err := a.get("orders-pending", "/api/v5/trade/orders-pending", q, &rows); err != nil {
+			return snap, err
+		}
+		for _, r := range rows {
+			snap.OpenOrders = append(snap.OpenOrders, RemoteOrder{
+				InstID: r.InstID, ClientID: r.ClOrdID, OrderID: r.OrdID, Side: Side(r.Side),
+				Qty: pf(r.Sz), Price: pf(r.Px), FilledQty: pf(r.AccFillSz), Status: r.State,
+			})
+		}
+		if len(rows) < 100 {
+			break
+		}
+		after = rows[len(rows)-1].OrdID
+	}
+	var pos []okxPositionPush
+	if err := a.get("positions", "/api/v5/account/positions", nil, &pos); err != nil {
+		return snap, err
+	}
+	snap.Positions = decodePositions(pos)
+	return snap, nil
+}
+
+// get —— 签名 GET，顶层 code 非 0 即错误
+func (a *OKXAdapter) get(op, path string, q url.Values, out any) error {
+	env, err := a.do(op, http.MethodGet, path, q, nil)
+	if err != nil {
+		return err
+	}
+	if env.Code != "0" {
+		return &OKXError{Op: op, HTTPStatus: http.StatusOK, Code: env.Code, Msg: env.Msg}
+	}
+	if out == nil || len(env.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(env.Data, out)
+}
+
+// Snapshot —— 模拟盘视图
+func (p *PaperExchange) Snapshot() (ExchangeSnapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := ExchangeSnapshot{Ts: p.now()}
+	for _, po := range p.orders {
+		snap.OpenOrders = append(snap.OpenOrders, RemoteOrder{
+			InstID: po.req.InstID, ClientID: po.req.ClientID, OrderID: "paper-" + po.req.ClientID, Side: po.req.Side,
+			Qty: po.req.Qty, Price: po.req.Price, FilledQty: po.filled, Status: "live",
+		})
+	}
+	for inst, ps := range p.pos {
+		if !nearlyZero(ps.qty) {
+			snap.Positions = append(snap.Positions, p.position(inst, ps))
+		}
+	}
+	return snap, nil
+}
@@ -0,0 +1,77 @@
+package execution
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderStateMachineIgnoresStaleAndDuplicateEvents(t *testing.T) {
+	ex := NewExecutor(Config{})
+	st := ex.ensure("X")
+	st.addOpen("c1", SideBuy, 4, 100)
+
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: "c1", Status: "live"})
+	// 成交回报先于 Fill 到达：终态但仍计入在途
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: "c1", Status: "filled", FilledQty: 4})
+	// 乱序的旧状态不得回退
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: "c1", Status: "partially_filled", FilledQty: 1})
+	if o := st.open["c1"]; o == nil || o.State != OrdFilled || o.Remaining != 4 {
+		t.Fatalf("unexpected order after updates: %+v", o)
+	}
+
+	ex.OnFill(Fill{InstID: "X", ClientID: "c1", Side: SideBuy, Qty: 3, Price: 100, TradeID: "t1"})
+	ex.OnFill(Fill{InstID: "X", ClientID: "c1", Side: SideBuy, Qty: 3, Price: 100, TradeID: "t1"}) // 重复推送
+	if st.position != 3 {
+		t.Fatalf("duplicate fill booked: position=%.2f", st.position)
+	}
+	ex.OnFill(Fill{InstID: "X", ClientID: "c1", Side: SideBuy, Qty: 1, Price: 100, TradeID: "t2"})
+	if _, ok := st.open["c1"]; ok || st.done["c1"] != OrdFilled || st.position != 4 {
+		t.Fatalf("order not settled: open=%v done=%v pos=%.2f", st.open["c1"], st.done["c1"], st.position)
+	}
+
+	// 已结束订单的迟到回报/成交被忽略
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: "c1", Status: "canceled"})
+	ex.OnFill(Fill{InstID: "X", ClientID: "c1", Side: SideBuy, Qty: 1, Price: 100, TradeID: "t3"})
+	if st.position != 4 || len(st.open) != 0 {
+		t.Fatalf("late events changed state: pos=%.2f open=%d", st.position, len(st.open))
+	}
+}
+
+func TestReconcileCorrectsLocalView(t *testing.T) {
+	ex := NewExecutor(Config{ReconcileGraceMs: 1})
+	st := ex.ensure("X")
+	st.position = 2
+	st.addOpen("gone", SideBuy, 1, 100)
+	st.addOpen("part", SideSell, 5, 101)
+	st.open["gone"].Updated = time.Now().Add(-time.Second)
+
+	ds := ex.Reconcile(ExchangeSnapshot{
+		OpenOrders: []RemoteOrder{
+			{InstID: "X", ClientID: "part", Side: SideSell, Qty: 5, Price: 101, FilledQty: 2, Status: "partially_filled"},
+			{InstID: "X", ClientID: "ext", Side: SideBuy, Qty: 1, Price: 99, Status: "live"},
+		},
+		Positions: []Position{{InstID: "X", PosSide: "net", Qty: 0}},
+	})
+
+	kinds := map[string]string{}
+	for _, d := range ds {
+		kinds[d.Kind] = d.ClientID
+	}
+	for _, k := range []string{"missing_on_exchange", "fill_mismatch", "unknown_on_exchange", "position_mismatch"} {
+		if _, ok := kinds[k]; !ok {
+			t.Fatalf("missing discrepancy %q in %v", k, ds)
+		}
+	}
+	if _, ok := st.open["gone"]; ok {
+		t.Fatalf("order missing on exchange must be closed")
+	}
+	if o := st.open["part"]; o == nil || o.State != OrdPartiallyFilled || o.ExchFilled != 2 {
+		t.Fatalf("unexpected partial order: %+v", o)
+	}
+	if o := st.open["ext"]; o == nil || o.Remaining != 1 {
+		t.Fatalf("unknown order not adopted: %+v", o)
+	}
+	if st.position != 0 {
+		t.Fatalf("position not adopted: %.2f", st.position)
+	}
+}
@@ -0,0 +1,762 @@
+package execution
+
+// PaperExchange —— 进程内模拟交易所（实现 ExchangeAdapter + EventSource）
+// =============================================================================
+// 1) 行情：挂到 stream.HybridClient（实时或回放）的 ticker / trades / books 回调；
+// 2) 撮合：limit / post-only / IOC / FOK / reduce-only，主动成交吃对手一档，
+//    挂单在对手价穿越或成交价穿越挂单价时按挂单价成交（保守：等价不成交）；
+// 3) 费用：maker / taker bps，按名义扣除计价币余额；
+// 4) 回报：OrderUpdate / Fill / Position 与私有 WS 同形，Executor.Bind 后即为纸面交易。
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/stream"
+)
+
+type PaperConfig struct {
+	InitialCash float64 // 初始计价币余额
+	QuoteCcy    string  // 计价币，默认 USDT
+	MakerFeeBps float64
+	TakerFeeBps float64
+}
+
+func (c *PaperConfig) withDefaults() PaperConfig {
+	q := *c
+	if q.InitialCash <= 0 {
+		q.InitialCash = 10000
+	}
+	if q.QuoteCcy == "" {
+		q.QuoteCcy = "USDT"
+	}
+	if q.MakerFeeBps == 0 {
+		q.MakerFeeBps = 2
+	}
+	if q.TakerFeeBps == 0 {
+		q.TakerFeeBps = 5
+	}
+	return q
+}
+
+type PaperExchange struct {
+	mu     sync.Mutex
+	cfg    PaperConfig
+	specs  map[string]InstrumentSpec
+	quotes map[string]paperQuote
+	orders map[string]*paperOrder // key: ClientID（仅存活单）
+	algos  map[string]*paperAlgo  // key: algoClOrdId（未触发的策略单）
+	pos    map[string]*paperPos
+	cash   float64
+	seq    int64
+
+	orderHandlers    []func(OrderUpdate)
+	fillHandlers     []func(Fill)
+	positionHandlers []func([]Position)
+
+	now func() time.Time
+}
+
+type paperQuote struct {
+	bid, ask     float64
+	bidSz, askSz float64 // 0 表示未知（不限量）
+	last         float64
+}
+
+type paperOrder struct {
+	req    OrderRequest
+	filled float64
+	cost   float64 // Σ px*qty（算均价）
+	queued int64   // 排队序号：挂单 / 改价时取号（时间优先）
+}
+
+type paperPos struct {
+	qty   float64 // 张（带符号）
+	avgPx float64 // 与核算账本同口径（blendAvg），折合币数始终为 baseQty(qty, avgPx)
+	upl   float64
+	mark  float64
+}
+
+func NewPaperExchange(cfg PaperConfig) *PaperExchange {
+	c := cfg.withDefaults()
+	return &PaperExchange{
+		cfg:    c,
+		specs:  make(map[string]InstrumentSpec),
+		quotes: make(map[string]paperQuote),
+		orders: make(map[string]*paperOrder),
+		algos:  make(map[string]*paperAlgo),
+		pos:    make(map[string]*paperPos),
+		cash:   c.InitialCash,
+		now:    time.Now,
+	}
+}
+
+func (p *PaperExchange) RegisterInstrument(spec InstrumentSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.specs[spec.InstID] = spec
+}
+
+// Attach —— 订阅行情回调（实时 / 回放 / 模拟均可）
+func (p *PaperExchange) Attach(md stream.MarketData) {
+	md.OnTicker(p.OnTickers)
+	md.OnTrade(p.OnTrades)
+	bt, hasBook := md.(stream.BookTop)
+	md.OnBook(func(m map[string][]stream.BookData) {
+		// 增量推送的首个价位不是盘口：有本地簿时改用维护好的最优价
+		top := make(map[string][]stream.BookData, len(m))
+		for inst, arr := range m {
+			if !hasBook {
+				top[inst] = arr
+				continue
+			}
+			if bid, ask, ok := bt.BestBidAsk(inst); ok {
+				arr = []stream.BookData{{InstID: inst, Bids: [][]string{{bid.PxStr, bid.SzStr}}, Asks: [][]string{{ask.PxStr, ask.SzStr}}}}
+			}
+			top[inst] = arr
+		}
+		p.OnBooks(top)
+	})
+}
+
+// ===================== EventSource =====================
+
+func (p *PaperExchange) OnOrder(h func(OrderUpdate)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.orderHandlers = append(p.orderHandlers, h)
+}
+func (p *PaperExchange) OnFill(h func(Fill)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fillHandlers = append(p.fillHandlers, h)
+}
+func (p *PaperExchange) OnPosition(h func([]Position)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.positionHandlers = append(p.positionHandlers, h)
+}
+
+// ===================== ExchangeAdapter =====================
+
+func (p *PaperExchange) SendOrders(orders []OrderRequest) error {
+	var evs paperEvents
+	var errs []error
+	p.mu.Lock()
+	for _, o := range orders {
+		if err := p.place(o, &evs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+	return errors.Join(errs...)
+}
+
+func (p *PaperExchange) CancelOrders(cancels []CancelRequest) error {
+	var evs paperEvents
+	var errs []error
+	p.mu.Lock()
+	for _, c := range cancels {
+		po, ok := p.orders[c.ClientID]
+		if !ok {
+			errs = append(errs, &OKXError{Op: "paper-cancel", Code: "51603", Msg: "order not exist", InstID: c.InstID, ClientID: c.ClientID})
+			continue
+		}
+		delete(p.orders, c.ClientID)
+		evs.orders = append(evs.orders, p.update(po, "canceled"))
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+	return errors.Join(errs...)
+}
+
+// AmendOrders —— 改价/改量：post-only 改到可成交价被拒（订单不变）；改量不得低于已成交
+func (p *PaperExchange) AmendOrders(amends []AmendRequest) error {
+	var evs paperEvents
+	var errs []error
+	p.mu.Lock()
+	for _, m := range amends {
+		po, ok := p.orders[m.ClientID]
+		if !ok {
+			errs = append(errs, &OKXError{Op: "paper-amend", Code: "51603", Msg: "order not exist", InstID: m.InstID, ClientID: m.ClientID})
+			continue
+		}
+		px, qty := po.req.Price, po.req.Qty
+		if m.NewPrice > 0 {
+			px = m.NewPrice
+		}
+		if m.NewQty > 0 {
+			qty = m.NewQty
+		}
+		if qty < po.filled-lotEps {
+			errs = append(errs, &OKXError{Op: "paper-amend", Code: "51000", Msg: "new size below filled", InstID: m.InstID, ClientID: m.ClientID})
+			continue
+		}
+		q := p.quotes[po.req.InstID]
+		cross := (po.req.Side == SideBuy && q.ask > 0 && px >= q.ask) || (po.req.Side == SideSell && q.bid > 0 && px <= q.bid)
+		if po.req.PostOnly && cross {
+			errs = append(errs, &OKXError{Op: "paper-amend", Code: "51000", Msg: "post-only amend would take liquidity", InstID: m.InstID, ClientID: m.ClientID})
+			continue
+		}
+		if px != po.req.Price {
+			po.queued = p.nextSeq() // 改价重新排队
+		}
+		po.req.Price, po.req.Qty = px, qty
+		if qty-po.filled <= lotEps {
+			delete(p.orders, m.ClientID)
+			evs.orders = append(evs.orders, p.update(po, "filled"))
+			continue
+		}
+		if cross {
+			p.matchResting(po.req.InstID, q, &evs)
+		}
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+	return errors.Join(errs...)
+}
+
+// ===================== 行情驱动 =====================
+
+func (p *PaperExchange) OnTickers(arr []stream.TickerData) {
+	var evs paperEvents
+	p.mu.Lock()
+	for _, t := range arr {
+		q := p.quotes[t.InstID]
+		if v := pf(t.BidPx); v > 0 {
+			q.bid = v
+		}
+		if v := pf(t.AskPx); v > 0 {
+			q.ask = v
+		}
+		if v := pf(t.Last); v > 0 {
+			q.last = v
+		}
+		p.quotes[t.InstID] = q
+		p.matchResting(t.InstID, q, &evs)
+		p.checkAlgos(t.InstID, q.last, &evs)
+		p.markToMarket(t.InstID)
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+}
+
+func (p *PaperExchange) OnTrades(arr []stream.TradeData) {
+	var evs paperEvents
+	p.mu.Lock()
+	for _, t := range arr {
+		px, sz := pf(t.Px), pf(t.Sz)
+		if px <= 0 {
+			continue
+		}
+		q := p.quotes[t.InstID]
+		q.last = px
+		p.quotes[t.InstID] = q
+		// 成交价穿越挂单价：按价格-时间优先依次按挂单价成交，合计不超过该笔成交量（缺失/无法解析时不限量）
+		left := sz
+		for _, po := range p.resting(t.InstID) {
+			if sz > 0 && left <= lotEps {
+				break
+			}
+			through := (po.req.Side == SideBuy && px < po.req.Price) || (po.req.Side == SideSell && px > po.req.Price)
+			if !through {
+				continue
+			}
+			qty := po.req.Qty - po.filled
+			if sz > 0 {
+				qty = math.Min(qty, left)
+				left -= qty
+			}
+			p.fill(po, qty, po.req.Price, "M", &evs)
+			if po.req.Qty-po.filled <= lotEps {
+				delete(p.orders, po.req.ClientID)
+			}
+		}
+		p.checkAlgos(t.InstID, px, &evs)
+		p.markToMarket(t.InstID)
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+}
+
+// OnBooks —— 只取一档；key 为 instId（"default" 无法定位品种，忽略）
+func (p *PaperExchange) OnBooks(m map[string][]stream.BookData) {
+	var evs paperEvents
+	p.mu.Lock()
+	for inst, arr := range m {
+		if inst == "default" || len(arr) == 0 {
+			continue
+		}
+		b := arr[len(arr)-1]
+		q := p.quotes[inst]
+		if len(b.Bids) > 0 && len(b.Bids[0]) >= 2 {
+			q.bid, q.bidSz = pf(b.Bids[0][0]), pf(b.Bids[0][1])
+		}
+		if len(b.Asks) > 0 && len(b.Asks[0]) >= 2 {
+			q.ask, q.askSz = pf(b.Asks[0][0]), pf(b.Asks[0][1])
+		}
+		p.quotes[inst] = q
+		p.matchResting(inst, q, &evs)
+		p.markToMarket(inst)
+	}
+	p.mu.Unlock()
+	p.flush(evs)
+}
+
+// ===================== 查询 =====================
+
+// Positions —— 当前持仓快照
+func (p *PaperExchange) Positions() []Position {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]Position, 0, len(p.pos))
+	for inst, ps := range p.pos {
+		out = append(out, p.position(inst, ps))
+	}
+	return out
+}
+
+// Balance —— 计价币余额（Equity 含未实现盈亏）
+func (p *PaperExchange) Balance() Balance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	upl := 0.0
+	for _, ps := range p.pos {
+		upl += ps.upl
+	}
+	return Balance{Ccy: p.cfg.QuoteCcy, Equity: p.cash + upl, Cash: p.cash, Avail: p.cash, UPL: upl, Ts: p.now()}
+}
+
+// ===================== 撮合内核（调用方持锁） =====================
+
+type paperEvents struct {
+	orders    []OrderUpdate
+	fills     []Fill
+	positions []Position
+}
+
+func (p *PaperExchange) place(o OrderRequest, evs *paperEvents) error {
+	if o.ClientID == "" {
+		o.ClientID = fmt.Sprintf("paper%d", p.nextSeq())
+	}
+	if _, dup := p.orders[o.ClientID]; dup {
+		return &OKXError{Op: "paper-order", Code: "51016", Msg: "duplicated clOrdId", InstID: o.InstID, ClientID: o.ClientID}
+	}
+	po := &paperOrder{req: o}
+	reject := func(msg string) error {
+		evs.orders = append(evs.orders, p.update(po, "rejected"))
+		return &OKXError{Op: "paper-order", Code: "51000", Msg: msg, InstID: o.InstID, ClientID: o.ClientID}
+	}
+	if o.Qty <= 0 {
+		return reject("qty must be positive")
+	}
+
+	// reduce-only：只能减仓，数量截到当前仓位
+	if o.ReduceOnly {
+		cur := 0.0
+		if ps := p.pos[o.InstID]; ps != nil {
+			cur = ps.qty
+		}
+		if nearlyZero(cur) || (o.Side == SideBuy) == (cur > 0) {
+			return reject("reduce-only would increase position")
+		}
+		po.req.Qty = math.Min(o.Qty, math.Abs(cur))
+	}
+
+	q := p.quotes[o.InstID]
+	opp, oppSz := q.ask, q.askSz
+	if o.Side == SideSell {
+		opp, oppSz = q.bid, q.bidSz
+	}
+	marketable := opp > 0 && (o.Type == OrdMarket ||
+		(o.Side == SideBuy && o.Price >= opp) || (o.Side == SideSell && o.Price <= opp))
+
+	switch {
+	case o.Type == OrdMarket:
+		if opp <= 0 {
+			opp = q.last // 只有成交流时按最新价
+		}
+		if opp <= 0 {
+			return reject("no market data")
+		}
+		p.fill(po, po.req.Qty, opp, "T", evs)
+		return nil
+	case o.PostOnly && marketable:
+		return reject("post-only order would take liquidity")
+	case o.TimeInForce == FOK:
+		if !marketable || (oppSz > 0 && oppSz < po.req.Qty) {
+			evs.orders = append(evs.orders, p.update(po, "canceled"))
+			return nil
+		}
+		p.fill(po, po.req.Qty, opp, "T", evs)
+		return nil
+	}
+
+	if marketable {
+		qty := po.req.Qty
+		if oppSz > 0 {
+			qty = math.Min(qty, oppSz)
+		}
+		p.fill(po, qty, opp, "T", evs)
+	}
+	rem := po.req.Qty - po.filled
+	if rem <= lotEps {
+		return nil
+	}
+	if o.TimeInForce == IOC {
+		evs.orders = append(evs.orders, p.update(po, "canceled"))
+		return nil
+	}
+	po.queued = p.nextSeq()
+	p.orders[o.ClientID] = po
+	if po.filled == 0 {
+		evs.orders = append(evs.orders, p.update(po, "new"))
+	}
+	return nil
+}
+
+// 对手价穿越挂单价：按价格-时间优先依次按挂单价成交，同侧合计不超过对手一档数量（未知时不限量）
+func (p *PaperExchange) matchResting(inst string, q paperQuote, evs *paperEvents) {
+	left := map[Side]float64{SideBuy: q.askSz, SideSell: q.bidSz}
+	for _, po := range p.resting(inst) {
+		side := po.req.Side
+		cross := (side == SideBuy && q.ask > 0 && q.ask <= po.req.Price) || (side == SideSell && q.bid > 0 && q.bid >= po.req.Price)
+		capped := (side == SideBuy && q.askSz > 0) || (side == SideSell && q.bidSz > 0)
+		if !cross || (capped && left[side] <= lotEps) {
+			continue
+		}
+		qty := po.req.Qty - po.filled
+		if capped {
+			qty = math.Min(qty, left[side])
+			left[side] -= qty
+		}
+		p.fill(po, qty, po.req.Price, "M", evs)
+		if po.req.Qty-po.filled <= lotEps {
+			delete(p.orders, po.req.ClientID)
+		}
+	}
+}
+
+// resting —— 该品种的存活挂单：买单在前，各自按价格优先（买高 / 卖低）、再按排队序号，回放结果确定
+func (p *PaperExchange) resting(inst string) []*paperOrder {
+	var out []*paperOrder
+	for _, po := range p.orders {
+		if po.req.InstID == inst {
+			out = append(out, po)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.req.Side != b.req.Side {
+			return a.req.Side == SideBuy
+		}
+		if a.req.Price != b.req.Price {
+			return (a.req.Price > b.req.Price) == (a.req.Side == SideBuy)
+		}
+		return a.queued < b.queued
+	})
+	return out
+}
+
+// fill —— 记一笔成交：更新订单、仓位、余额并生成回报
+func (p *PaperExchange) fill(po *paperOrder, qty, px float64, liq string, evs *paperEvents) {
+	if qty <= lotEps || px <= 0 {
+		return
+	}
+	o := po.req
+	sp := p.specs[o.InstID]
+	base := baseQty(sp, qty, px)
+	feeBps := p.cfg.TakerFeeBps
+	if liq == "M" {
+		feeBps = p.cfg.MakerFeeBps
+	}
+	fee := base * px * feeBps / 10000
+
+	dir := 1.0
+	if o.Side == SideSell {
+		dir = -1
+	}
+	ps := p.pos[o.InstID]
+	if ps == nil {
+		ps = &paperPos{}
+		p.pos[o.InstID] = ps
+	}
+	p.cash += ps.apply(sp, dir*qty, px) - fee
+
+	po.filled += qty
+	po.cost += qty * px
+	status := "partially_filled"
+	if po.req.Qty-po.filled <= lotEps {
+		status = "filled"
+	}
+	evs.fills = append(evs.fills, Fill{
+		InstID: o.InstID, ClientID: o.ClientID, Side: o.Side, Qty: qty, Price: px, Fee: fee,
+		TradeID: strconv.FormatInt(p.nextSeq(), 10), Liquidity: liq, Ts: p.now(),
+	})
+	evs.orders = append(evs.orders, p.update(po, status))
+	if o.Attach != nil {
+		p.attachTPSL(po, qty)
+	}
+	ps.mark = px
+	ps.upl = ps.unrealized(sp)
+	evs.positions = append(evs.positions, p.position(o.InstID, ps))
+}
+
+// base —— 持仓折合标的数量（币，带符号），由张数与均价导出，张数归零即为零
+func (ps *paperPos) base(sp InstrumentSpec) float64 {
+	return baseQty(sp, ps.qty, ps.avgPx)
+}
+
+// toQuote —— 结算币金额折成计价币（模拟盘现金以计价币记账；币本位按 px 折算）
+func toQuote(sp InstrumentSpec, amount, px float64) float64 {
+	if sp.CtVal <= 0 && sp.Inverse {
+		return amount * px
+	}
+	return amount
+}
+
+func (ps *paperPos) unrealized(sp InstrumentSpec) float64 {
+	if nearlyZero(ps.qty) {
+		return 0
+	}
+	return toQuote(sp, ps.qty*pnlPerContract(sp, ps.avgPx, ps.mark), ps.mark)
+}
+
+// apply —— 平均成本法（dq 为带符号张数），口径同 pnl.go：返回本次实现盈亏（计价币）
+func (ps *paperPos) apply(sp InstrumentSpec, dq, px float64) float64 {
+	if nearlyZero(ps.qty) || sign(ps.qty) == sign(dq) {
+		ps.avgPx = blendAvg(sp, math.Abs(ps.qty), ps.avgPx, math.Abs(dq), px)
+		ps.qty += dq
+		return 0
+	}
+	closeQty := math.Min(math.Abs(dq), math.Abs(ps.qty))
+	realized := toQuote(sp, closeQty*sign(ps.qty)*pnlPerContract(sp, ps.avgPx, px), px)
+	nq := ps.qty + dq
+	switch {
+	case math.Abs(nq) <= lotEps:
+		ps.qty, ps.avgPx = 0, 0
+	case sign(nq) != sign(ps.qty):
+		ps.qty, ps.avgPx = nq, px // 反手：剩余部分按本次价格开仓
+	default:
+		ps.qty = nq
+	}
+	return realized
+}
+
+func (p *PaperExchange) markToMarket(inst string) {
+	ps := p.pos[inst]
+	if ps == nil {
+		return
+	}
+	if q := p.quotes[inst]; q.last > 0 {
+		ps.mark = q.last
+	}
+	ps.upl = ps.unrealized(p.specs[inst])
+}
+
+func (p *PaperExchange) update(po *paperOrder, status string) OrderUpdate {
+	avg := 0.0
+	if po.filled > 0 {
+		avg = po.cost / po.filled
+	}
+	return OrderUpdate{
+		InstID: po.req.InstID, ClientID: po.req.ClientID, OrderID: "paper-" + po.req.ClientID,
+		FilledQty: po.filled, Status: status, AvgPrice: avg, Ts: p.now(),
+	}
+}
+
+func (p *PaperExchange) position(inst string, ps *paperPos) Position {
+	return Position{
+		InstID: inst, PosSide: "net", Qty: ps.qty, AvgPx: ps.avgPx, UPL: ps.unrealized(p.specs[inst]),
+		MarkPx: ps.mark, Lever: 1, MgnMode: "cross", Ts: p.now(),
+	}
+}
+
+func (p *PaperExchange) nextSeq() int64 { p.seq++; return p.seq }
+
+// flush —— 解锁后再回调，避免回调里重入 SendOrders 死锁
+func (p *PaperExchange) flush(evs paperEvents) {
+	p.mu.Lock()
+	oh := append([]func(OrderUpdate){}, p.orderHandlers...)
+	fh := append([]func(Fill){}, p.fillHandlers...)
+	ph := append([]func([]Position){}, p.positionHandlers...)
+	p.mu.Unlock()
+	// 成交先于状态，与私有 WS 的下发顺序一致
+	for _, f := range evs.fills {
+		for _, h := range fh {
+			h(f)
+		}
+	}
+	for _, u := range evs.orders {
+		for _, h := range oh {
+			h(u)
+		}
+	}
+	if len(evs.positions) > 0 {
+		for _, h := range ph {
+			h(evs.positions)
+		}
+	}
+}
+
+// ===================== 策略单（AlgoTrader） =====================
+
+type paperAlgo struct {
+	req   AlgoOrderRequest
+	above bool    // trigger：触发价在下单时最新价之上
+	peak  float64 // move_order_stop：激活后的最优价
+}
+
+func (p *PaperExchange) SendAlgoOrders(orders []AlgoOrderRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, o := range orders {
+		if o.ClientID == "" {
+			o.ClientID = fmt.Sprintf("paperalgo%d", p.nextSeq())
+		}
+		if _, dup := p.algos[o.ClientID]; dup {
+			errs = append(errs, &OKXError{Op: "paper-order-algo", Code: "51016", Msg: "duplicated algoClOrdId", InstID: o.InstID, ClientID: o.ClientID})
+			continue
+		}
+		if o.Qty <= 0 {
+			errs = append(errs, &OKXError{Op: "paper-order-algo", Code: "51000", Msg: "qty must be positive", InstID: o.InstID, ClientID: o.ClientID})
+			continue
+		}
+		last := p.quotes[o.InstID].last
+		p.algos[o.ClientID] = &paperAlgo{req: o, above: o.TriggerPx > last}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *PaperExchange) CancelAlgoOrders(cancels []AlgoCancelRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, c := range cancels {
+		if _, ok := p.algos[c.ClientID]; !ok {
+			errs = append(errs, &OKXError{Op: "paper-cancel-algos", Code: "51603", Msg: "algo order not exist", InstID: c.InstID, ClientID: c.ClientID})
+			continue
+		}
+		delete(p.algos, c.ClientID)
+	}
+	return errors.Join(errs...)
+}
+
+// AlgoOrders —— 未触发的策略单
+func (p *PaperExchange) AlgoOrders() []AlgoOrderRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]AlgoOrderRequest, 0, len(p.algos))
+	for _, a := range p.algos {
+		out = append(out, a.req)
+	}
+	return out
+}
+
+// PendingAlgos —— AlgoOrderLister：模拟盘按 algoClOrdId 撤单，AlgoID 留空
+func (p *PaperExchange) PendingAlgos() ([]PendingAlgo, error) {
+	var out []PendingAlgo
+	for _, a := range p.AlgoOrders() {
+		out = append(out, PendingAlgo{AlgoOrderRequest: a})
+	}
+	return out, nil
+}
+
+// checkAlgos —— 最新价触发策略单：触发后按委托价（0=市价）下单
+func (p *PaperExchange) checkAlgos(inst string, last float64, evs *paperEvents) {
+	if last <= 0 {
+		return
+	}
+	for id, a := range p.algos {
+		o := a.req
+		if o.InstID != inst {
+			continue
+		}
+		px, hit := 0.0, false
+		switch o.Type {
+		case AlgoConditional, AlgoOCO:
+			// 卖出平多：价格涨到止盈 / 跌到止损；买入平空反之
+			tpHit := o.TPTriggerPx > 0 && ((o.Side == SideSell && last >= o.TPTriggerPx) || (o.Side == SideBuy && last <= o.TPTriggerPx))
+			slHit := o.SLTriggerPx > 0 && ((o.Side == SideSell && last <= o.SLTriggerPx) || (o.Side == SideBuy && last >= o.SLTriggerPx))
+			switch {
+			case slHit:
+				px, hit = o.SLOrdPx, true
+			case tpHit:
+				px, hit = o.TPOrdPx, true
+			}
+		case AlgoTrigger:
+			hit = (a.above && last >= o.TriggerPx) || (!a.above && last <= o.TriggerPx)
+			px = o.OrdPx
+		case AlgoTrailing:
+			if o.ActivePx > 0 && a.peak == 0 {
+				if (o.Side == SideSell && last < o.ActivePx) || (o.Side == SideBuy && last > o.ActivePx) {
+					continue // 未激活
+				}
+			}
+			if a.peak == 0 || (o.Side == SideSell && last > a.peak) || (o.Side == SideBuy && last < a.peak) {
+				a.peak = last
+			}
+			dist := o.CallbackSpread
+			if o.CallbackRatio > 0 {
+				dist = a.peak * o.CallbackRatio
+			}
+			hit = dist > 0 && ((o.Side == SideSell && last <= a.peak-dist) || (o.Side == SideBuy && last >= a.peak+dist))
+		}
+		if !hit {
+			continue
+		}
+		delete(p.algos, id)
+		ord := OrderRequest{InstID: inst, Side: o.Side, Type: OrdMarket, Qty: o.Qty, ReduceOnly: o.ReduceOnly, ClientID: fmt.Sprintf("%st%d", id, p.nextSeq())}
+		if px > 0 {
+			ord.Type, ord.Price, ord.TimeInForce = OrdLimit, px, GTC
+		}
+		_ = p.place(ord, evs) // reduce-only 无仓位等拒单已体现在回报里
+	}
+}
+
+// attachTPSL —— 随单止盈止损：按已成交数量挂出/扩充 reduce-only 条件单
+func (p *PaperExchange) attachTPSL(po *paperOrder, qty float64) {
+	o := po.req
+	id := o.ClientID + "a"
+	if a, ok := p.algos[id]; ok {
+		a.req.Qty += qty
+		return
+	}
+	typ := AlgoConditional
+	if o.Attach.TPTriggerPx > 0 && o.Attach.SLTriggerPx > 0 {
+		typ = AlgoOCO
+	}
+	side := SideSell
+	if o.Side == SideSell {
+		side = SideBuy
+	}
+	p.algos[id] = &paperAlgo{req: AlgoOrderRequest{
+		InstID: o.InstID, ClientID: id, Type: typ, Side: side, Qty: qty, ReduceOnly: true, TPSL: *o.Attach,
+	}}
+}
+
+// ===================== 场所装配 =====================
+
+// NewVenue —— DryRun=true 走本地 PaperExchange（挂到 md 行情，可为 HybridClient / Replayer / 模拟源），否则走 OKX REST（经 ResilientAdapter 重试/限频）+ 私有 WS
+func NewVenue(cfg Config, exCfg config.ExchangeConfig, md stream.MarketData) (ExchangeAdapter, EventSource, error) {
+	if cfg.DryRun {
+		paper := NewPaperExchange(PaperConfig{InitialCash: cfg.AccountEquity})
+		if md != nil {
+			paper.Attach(md)
+		}
+		return paper, paper, nil
+	}
+	ws := NewPrivateWSClient(exCfg)
+	if err := ws.Start(); err != nil {
+		return nil, nil, err
+	}
+	return NewResilientAdapter(NewOKXAdapter(exCfg), RetryPolicyFrom(cfg.Retry, cfg.MaxRetries)), ws, nil
+}
@@ -0,0 +1,122 @@
+package execution
+
+import (
+	"math"
+	"testing"
+
+	"Mod/src/stream"
+)
+
+func TestPaperExchangeMatchingSemantics(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1000, MakerFeeBps: 1, TakerFeeBps: 5})
+	ex := NewExecutor(Config{})
+	ex.Bind(p)
+	var fills []Fill
+	p.OnFill(func(f Fill) { fills = append(fills, f) })
+
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99", AskPx: "101", Last: "100"}})
+
+	// post-only 会吃单 -> 拒绝；reduce-only 无仓位 -> 拒绝
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 101, PostOnly: true, ClientID: "po"}}); err == nil {
+		t.Fatalf("expected post-only reject")
+	}
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideSell, Type: OrdLimit, Qty: 1, Price: 99, ReduceOnly: true, TimeInForce: IOC, ClientID: "ro"}}); err == nil {
+		t.Fatalf("expected reduce-only reject")
+	}
+
+	// IOC 可成交 -> taker 成交在卖一
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdLimit, Qty: 2, Price: 102, TimeInForce: IOC, ClientID: "ioc"}}); err != nil {
+		t.Fatalf("ioc failed: %v", err)
+	}
+	if len(fills) != 1 || fills[0].Price != 101 || fills[0].Liquidity != "T" {
+		t.Fatalf("unexpected ioc fill: %+v", fills)
+	}
+
+	// 被动挂单：成交价穿越后按挂单价 maker 成交
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideSell, Type: OrdLimit, Qty: 1, Price: 103, TimeInForce: GTC, PostOnly: true, ClientID: "rest"}}); err != nil {
+		t.Fatalf("rest failed: %v", err)
+	}
+	p.OnTrades([]stream.TradeData{{InstID: "X", Px: "104", Sz: "5"}})
+	if len(fills) != 2 || fills[1].Price != 103 || fills[1].Liquidity != "M" {
+		t.Fatalf("unexpected maker fill: %+v", fills)
+	}
+
+	// FOK 数量不足 -> 整单撤销
+	p.OnBooks(map[string][]stream.BookData{"X": {{Bids: [][]string{{"99", "0.5"}}, Asks: [][]string{{"101", "0.5"}}}}})
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 101, TimeInForce: FOK, ClientID: "fok"}}); err != nil {
+		t.Fatalf("fok failed: %v", err)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("fok must not fill partially")
+	}
+
+	ps := p.Positions()
+	if len(ps) != 1 || ps[0].Qty != 1 {
+		t.Fatalf("unexpected positions: %+v", ps)
+	}
+	// 保证金模式：现金 = 初始 + 已实现(1*(103-101)) - taker费 - maker费
+	want := 1000 + 2 - 202*5/10000.0 - 103*1/10000.0
+	if b := p.Balance(); math.Abs(b.Cash-want) > 1e-9 {
+		t.Fatalf("cash = %.6f, want %.6f", b.Cash, want)
+	}
+	if ex.ensure("X").position != 1 {
+		t.Fatalf("executor position not synced: %.2f", ex.ensure("X").position)
+	}
+}
+
+func TestPaperTradesFillInPriceTimePriority(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1e6})
+	var fills []Fill
+	p.OnFill(func(f Fill) { fills = append(fills, f) })
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99", AskPx: "103", Last: "101"}})
+	for _, o := range []OrderRequest{
+		{ClientID: "a", Price: 100, Qty: 2}, // 同价先到
+		{ClientID: "b", Price: 100, Qty: 2},
+		{ClientID: "c", Price: 101, Qty: 1}, // 价格更优
+	} {
+		o.InstID, o.Side, o.Type, o.TimeInForce = "X", SideBuy, OrdLimit, GTC
+		if err := p.SendOrders([]OrderRequest{o}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2 张的成交：c 先成 1 张，a 成 1 张，b 不成
+	p.OnTrades([]stream.TradeData{{InstID: "X", Px: "99", Sz: "2"}})
+	if len(fills) != 2 || fills[0].ClientID != "c" || fills[0].Qty != 1 || fills[1].ClientID != "a" || fills[1].Qty != 1 {
+		t.Fatalf("fills: %+v", fills)
+	}
+	// 用尽的成交量不能再分给后面的单
+	p.OnTrades([]stream.TradeData{{InstID: "X", Px: "99", Sz: "1"}})
+	if len(fills) != 3 || fills[2].ClientID != "a" || fills[2].Qty != 1 {
+		t.Fatalf("fills: %+v", fills)
+	}
+}
+
+func TestPaperRoundTripFlatEquity(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1000, TakerFeeBps: 5})
+	p.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, ContractValue: 10})
+	quote := func(bid, ask, last string) {
+		p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: bid, AskPx: ask, Last: last}})
+	}
+
+	quote("99", "100", "100")
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdMarket, Qty: 3, ClientID: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	quote("110", "111", "110")
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideSell, Type: OrdMarket, Qty: 3, ClientID: "s"}}); err != nil {
+		t.Fatal(err)
+	}
+	quote("119", "121", "120") // 平仓后的行情不应再产生浮盈
+
+	// 已实现 3×10/100×(110-100) = 3；taker 费 2×30×5bp
+	want := 1000 + 3 - 2*30*5/10000.0
+	ps := p.Positions()
+	b := p.Balance()
+	if len(ps) != 1 || ps[0].Qty != 0 || ps[0].UPL != 0 || b.UPL != 0 {
+		t.Fatalf("flat position: %+v balance %+v", ps, b)
+	}
+	if math.Abs(b.Cash-want) > 1e-9 || math.Abs(b.Equity-want) > 1e-9 {
+		t.Fatalf("balance %+v, want %.6f", b, want)
+	}
+}
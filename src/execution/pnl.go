@@ -0,0 +1,217 @@
+package execution
+
+// 持仓核算 —— 每笔 OnFill 推进均价 / 已实现 / 未实现盈亏、累计手续费与资金费
+// =============================================================================
+// 1) 金额均为结算币：线性合约/现货按计价币，其中 CtVal 口径按数量加权均价，仅 ContractValue 口径
+//    （每张计价币名义）按开仓时折合币数结算、均价取调和平均，与 PaperExchange 一致；
+//    显式标记 Inverse 的币本位合约按标的币结算，均价同样取调和平均；
+// 2) 加仓更新均价；减仓按均价结转已实现；反手时先平后开，剩余部分以成交价为新均价；
+// 3) mark 来自 Step/StepAlgos 的 markPrice、持仓推送的 MarkPx 或 OnMark；
+// 4) 账本与交易所持仓不一致（启动时已有仓位、漏推成交、强平）且无在途订单时，以交易所数量/均价重置账本，已实现不变。
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// book —— 单品种核算账本
+type book struct {
+	qty      float64 // 核算口径净持仓（张，带符号）
+	avg      float64 // 持仓均价
+	realized float64 // 已实现盈亏（不含手续费/资金费）
+	fees     float64 // 累计手续费（正=支出）
+	funding  float64 // 累计资金费（正=收入）
+	mark     float64
+	markTs   time.Time
+	updated  time.Time
+}
+
+// PositionInfo —— 持仓核算快照
+type PositionInfo struct {
+	InstID        string
+	Qty           float64 // 净持仓（张，带符号）
+	AvgPx         float64
+	MarkPx        float64
+	Notional      float64 // 按 mark 的名义（绝对值）
+	RealizedPnL   float64
+	UnrealizedPnL float64
+	Fees          float64
+	Funding       float64
+	NetPnL        float64 // 已实现 + 未实现 - 手续费 + 资金费
+	MarkTs        time.Time
+	Updated       time.Time
+}
+
+// pnlPerContract —— 每张从 entry 到 exit 的盈亏（多头口径，结算币）
+func pnlPerContract(sp InstrumentSpec, entry, exit float64) float64 {
+	if entry <= 0 || exit <= 0 {
+		return 0
+	}
+	switch {
+	case sp.CtVal > 0:
+		return sp.CtVal * (exit - entry)
+	case sp.Inverse:
+		return sp.ContractValue * (1/entry - 1/exit)
+	}
+	return baseQty(sp, 1, entry) * (exit - entry)
+}
+
+// applyFill —— 按成交推进账本（dir：+1 买 / -1 卖）
+func (b *book) applyFill(sp InstrumentSpec, dir, qty, px, fee float64, ts time.Time) {
+	b.fees += fee
+	b.updated = ts
+	if qty <= 0 || px <= 0 {
+		return
+	}
+	if nearlyZero(b.qty) || b.qty*dir > 0 {
+		b.avg = blendAvg(sp, math.Abs(b.qty), b.avg, qty, px)
+		b.qty += dir * qty
+		return
+	}
+	closeQty := math.Min(qty, math.Abs(b.qty))
+	b.realized += closeQty * sign(b.qty) * pnlPerContract(sp, b.avg, px)
+	b.qty += dir * closeQty
+	if rest := qty - closeQty; rest > lotEps {
+		b.qty = dir * rest
+		b.avg = px
+	} else if math.Abs(b.qty) <= lotEps {
+		b.qty, b.avg = 0, 0
+	}
+}
+
+// blendAvg —— 加仓后的均价：CtVal 按数量加权；仅 ContractValue（线性或币本位）按折合币数加权即调和平均
+func blendAvg(sp InstrumentSpec, q0, p0, q1, p1 float64) float64 {
+	if q0 <= 0 || p0 <= 0 {
+		return p1
+	}
+	if sp.CtVal <= 0 && sp.ContractValue > 0 {
+		return (q0 + q1) / (q0/p0 + q1/p1)
+	}
+	return (q0*p0 + q1*p1) / (q0 + q1)
+}
+
+// resync —— 以交易所数量/均价重置（已实现与费用保留）
+func (b *book) resync(qty, avg float64) {
+	b.qty = qty
+	if nearlyZero(qty) {
+		b.qty, b.avg = 0, 0
+	} else if avg > 0 {
+		b.avg = avg
+	} else if b.avg <= 0 {
+		b.avg = b.mark
+	}
+	b.updated = time.Now()
+}
+
+func (b *book) setMark(px float64, ts time.Time) {
+	if px > 0 {
+		b.mark, b.markTs = px, ts
+	}
+}
+
+func (b *book) info(inst string, sp InstrumentSpec) PositionInfo {
+	pi := PositionInfo{
+		InstID: inst, Qty: b.qty, AvgPx: b.avg, MarkPx: b.mark,
+		RealizedPnL: b.realized, Fees: b.fees, Funding: b.funding, MarkTs: b.markTs, Updated: b.updated,
+	}
+	if !nearlyZero(b.qty) && b.mark > 0 {
+		pi.UnrealizedPnL = b.qty * pnlPerContract(sp, b.avg, b.mark)
+		pi.Notional = math.Abs(b.qty) * notionalPerContract(sp, b.mark)
+	}
+	pi.NetPnL = pi.RealizedPnL + pi.UnrealizedPnL - pi.Fees + pi.Funding
+	return pi
+}
+
+// OnMark —— 更新 mark（行情推送驱动未实现盈亏）
+func (ex *Executor) OnMark(inst string, px float64) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.ensure(inst).book.setMark(px, time.Now())
+}
+
+// OnFunding —— 记入资金费（amount 正=收入，负=支出，结算币）
+func (ex *Executor) OnFunding(inst string, amount float64) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	b := &ex.ensure(inst).book
+	b.funding += amount
+	b.updated = time.Now()
+}
+
+// Positions —— 全部品种的核算快照（按 InstID 排序；从未有过成交/持仓的品种不返回）
+func (ex *Executor) Positions() []PositionInfo {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	out := make([]PositionInfo, 0, len(ex.ins))
+	for inst, st := range ex.ins {
+		if st.book.updated.IsZero() {
+			continue
+		}
+		out = append(out, st.book.info(inst, ex.specs[inst]))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstID < out[j].InstID })
+	return out
+}
+
+// PositionOf —— 单品种核算快照
+func (ex *Executor) PositionOf(inst string) (PositionInfo, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st, ok := ex.ins[inst]
+	if !ok || st.book.updated.IsZero() {
+		return PositionInfo{}, false
+	}
+	return st.book.info(inst, ex.specs[inst]), true
+}
+
+// Equity —— 账户权益（计价币），与仓位换算同源：收到账户推送后取推送权益（已含未实现盈亏），
+// 之前为 AccountEquity + 各线性品种 NetPnL
+func (ex *Executor) Equity() float64 {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.sizingEquity()
+}
+
+// bookPnL —— 各线性品种 NetPnL 之和（计价币）；币本位品种以标的币结算，不计入（调用方持有 ex.mu）
+func (ex *Executor) bookPnL() float64 {
+	sum := 0.0
+	for inst, st := range ex.ins {
+		if sp := ex.specs[inst]; sp.Inverse || st.book.updated.IsZero() {
+			continue
+		}
+		sum += st.book.info(inst, ex.specs[inst]).NetPnL
+	}
+	return sum
+}
+
+// syncBooks —— 交易所持仓（净额 + 数量加权均价）校正账本（调用方持有 ex.mu）
+// force=false 时仅在无在途订单时重置，避免与尚未到达的成交重复计数
+func (ex *Executor) syncBooks(ps []Position, force bool) {
+	type agg struct{ qty, w, mark float64 }
+	net := make(map[string]*agg, len(ps))
+	for _, p := range ps {
+		a, ok := net[p.InstID]
+		if !ok {
+			a = &agg{}
+			net[p.InstID] = a
+		}
+		a.qty += p.Qty
+		a.w += math.Abs(p.Qty) * p.AvgPx
+		if p.MarkPx > 0 {
+			a.mark = p.MarkPx
+		}
+	}
+	for inst, a := range net {
+		st := ex.ensure(inst)
+		st.book.setMark(a.mark, time.Now())
+		if math.Abs(st.book.qty-a.qty) <= lotEps || (!force && len(st.open) > 0) {
+			continue
+		}
+		avg := 0.0
+		if q := math.Abs(a.qty); q > 0 {
+			avg = a.w / q
+		}
+		st.book.resync(a.qty, avg)
+	}
+}
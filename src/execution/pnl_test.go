@@ -0,0 +1,113 @@
+package execution
+
+import (
+	"math"
+	"testing"
+
+	"Mod/src/stream"
+)
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestPositionAccountingLinear(t *testing.T) {
+	ex := NewExecutor(Config{AccountEquity: 1000})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 0.1})
+	st := ex.ensure("X")
+	fill := func(id string, side Side, qty, px, fee float64) {
+		st.addOpen(id, side, qty, px)
+		ex.OnFill(Fill{InstID: "X", ClientID: id, Side: side, Qty: qty, Price: px, Fee: fee, TradeID: id})
+	}
+
+	fill("b1", SideBuy, 2, 100, 0.02)
+	fill("b2", SideBuy, 2, 110, 0.02)
+	p, _ := ex.PositionOf("X")
+	if p.Qty != 4 || !approx(p.AvgPx, 105) || p.RealizedPnL != 0 {
+		t.Fatalf("after adds: %+v", p)
+	}
+
+	fill("s1", SideSell, 3, 120, 0.03) // 平 3 张：3×0.1×15
+	fill("s2", SideSell, 2, 100, 0.02) // 平 1 张（-0.5）后反手空 1 张 @100
+	ex.OnMark("X", 90)
+	ex.OnFunding("X", 0.25)
+	p, _ = ex.PositionOf("X")
+	if p.Qty != -1 || !approx(p.AvgPx, 100) || !approx(p.RealizedPnL, 4.0) {
+		t.Fatalf("after flip: %+v", p)
+	}
+	if !approx(p.UnrealizedPnL, 1) || !approx(p.Fees, 0.09) || !approx(p.NetPnL, 4+1-0.09+0.25) || !approx(p.Notional, 9) {
+		t.Fatalf("pnl: %+v", p)
+	}
+	if !approx(ex.Equity(), 1000+p.NetPnL) {
+		t.Fatalf("equity=%v", ex.Equity())
+	}
+
+	// 无在途时以交易所持仓重置账本，已实现保留
+	ex.OnPositions([]Position{{InstID: "X", PosSide: "net", Qty: 5, AvgPx: 95, MarkPx: 96}})
+	p, _ = ex.PositionOf("X")
+	if p.Qty != 5 || p.AvgPx != 95 || p.MarkPx != 96 || !approx(p.RealizedPnL, 4.0) {
+		t.Fatalf("after resync: %+v", p)
+	}
+}
+
+func TestPositionAccountingInverse(t *testing.T) {
+	ex := NewExecutor(Config{})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "BTC-USD-SWAP", TickSize: 0.1, LotSize: 1, ContractValue: 100, Inverse: true})
+	st := ex.ensure("BTC-USD-SWAP")
+	for i, f := range []Fill{
+		{Side: SideBuy, Qty: 100, Price: 100},
+		{Side: SideBuy, Qty: 100, Price: 200},
+		{Side: SideSell, Qty: 200, Price: 200},
+	} {
+		f.InstID, f.ClientID = "BTC-USD-SWAP", string(rune('a'+i))
+		st.addOpen(f.ClientID, f.Side, f.Qty, f.Price)
+		ex.OnFill(f)
+	}
+	ps := ex.Positions()
+	// 调和均价 133.33；平仓收益 200×100×(1/133.33-1/200) = 50（币）
+	if len(ps) != 1 || ps[0].Qty != 0 || !approx(ps[0].RealizedPnL, 50) {
+		t.Fatalf("inverse: %+v", ps)
+	}
+}
+
+func TestContractValueLinearMatchesPaper(t *testing.T) {
+	spec := InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, ContractValue: 10}
+	p := NewPaperExchange(PaperConfig{InitialCash: 1000})
+	p.RegisterInstrument(spec)
+	ex := NewExecutor(Config{AccountEquity: 1000})
+	ex.RegisterInstrument(spec)
+	ex.RegisterInstrument(InstrumentSpec{InstID: "INV", TickSize: 0.1, LotSize: 1, ContractValue: 100, Inverse: true})
+	ex.Bind(p)
+	trade := func(side Side, qty float64, px string) {
+		t.Helper()
+		p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: px, AskPx: px, Last: px}})
+		req := OrderRequest{InstID: "X", Side: side, Type: OrdMarket, Qty: qty, ClientID: ex.ids.Next("t")}
+		ex.ensure("X").addOrder(req)
+		if err := p.SendOrders([]OrderRequest{req}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trade(SideBuy, 2, "100")
+	trade(SideBuy, 2, "125")
+	pi, _ := ex.PositionOf("X")
+	// 折合币数 0.2 + 0.16，调和均价 111.11
+	if !approx(pi.AvgPx, 4/(2/100.0+2/125.0)) || !approx(pi.AvgPx, p.Positions()[0].AvgPx) {
+		t.Fatalf("avg %.6f paper %+v", pi.AvgPx, p.Positions())
+	}
+	trade(SideSell, 4, "120")
+	pi, _ = ex.PositionOf("X")
+	if pi.Qty != 0 || !approx(pi.RealizedPnL, 3.2) {
+		t.Fatalf("realized: %+v", pi)
+	}
+	if b := p.Balance(); math.Abs(b.Cash-1000-pi.NetPnL) > 1e-9 {
+		t.Fatalf("paper cash %.6f vs book net %.6f", b.Cash, pi.NetPnL)
+	}
+
+	// 币本位盈亏以标的币计，不混入计价币权益
+	st := ex.ensure("INV")
+	st.addOpen("i1", SideBuy, 1, 100)
+	ex.OnFill(Fill{InstID: "INV", ClientID: "i1", Side: SideBuy, Qty: 1, Price: 100})
+	ex.OnMark("INV", 200)
+	if eq := ex.Equity(); !approx(eq, 1000+pi.NetPnL) {
+		t.Fatalf("equity %.6f", eq)
+	}
+}
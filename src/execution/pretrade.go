@@ -0,0 +1,395 @@
+package execution
+
+// 下单前闸门 —— 在 Executor.Step 与 ExchangeAdapter.SendOrders 之间执行 config.RiskConfig 限额
+// =============================================================================
+// 1) 逐单检查：价格带（相对 mark 偏离 > PriceDeviationBps）、胖手指（单笔张数/名义上限）、
+//    每秒下单次数、全局挂单数，违反即拒；
+// 2) 单品种持仓（MaxPos 张）/名义（MaxNotional）按“持仓 + 同向在途”最坏敞口计算，超出部分裁剪到 lot，
+//    裁剪后不足最小张数则拒；ReduceOnly 单不受敞口限制；
+// 3) 被拒的单在执行器里按 rejected 结束，裁剪的单同步数量，被拒的改价按失败回写 ConfirmAmends；
+//    每次拒/裁都写 TradeLogger.Action；
+// 4) 策略单（Plan.Algos）按触发/委托价检查胖手指；非 ReduceOnly 的策略单计入敞口，超限整单拒（不裁剪）；
+//    被拒的常驻止损回写 ConfirmStop，保留旧止损；
+// 5) Send 是计划下发到适配器的统一入口：过滤后依次撤单、撤策略单、改单、新单、策略单；
+//    下单失败的新单在本地按 rejected 结束；
+// 6) 限额为 0 表示不检查。
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/storage"
+)
+
+// Rejection —— 被闸门拒绝/裁剪的订单
+type Rejection struct {
+	Order   OrderRequest
+	Reason  string  // price_band / fat_finger / rate_limit / max_open_orders / max_pos / max_notional / no_reference_price
+	Trimmed float64 // >0 表示未拒绝，只是裁剪到该数量
+	Algo    bool    // 策略单（Order 由 AlgoOrderRequest 折算，Price 为触发/委托价）
+	Detail  string
+}
+
+// ErrGateRejected —— 被下单闸门拒绝
+var ErrGateRejected = errors.New("下单闸门拒绝")
+
+// PreTradeGate —— 下单前风控闸门（并发安全）
+type PreTradeGate struct {
+	mu   sync.Mutex
+	cfg  config.RiskConfig
+	ex   *Executor
+	lg   *storage.TradeLogger
+	sent []time.Time // 最近 1 秒内放行的下单时刻
+	now  func() time.Time
+}
+
+// NewPreTradeGate —— lg 可为 nil（只打日志）
+func NewPreTradeGate(cfg config.RiskConfig, ex *Executor, lg *storage.TradeLogger) *PreTradeGate {
+	return &PreTradeGate{cfg: cfg, ex: ex, lg: lg, now: time.Now}
+}
+
+// Filter —— 过滤计划中的新单与策略单；撤单原样放行，改单只检查价格带
+func (g *PreTradeGate) Filter(plan Plan) (Plan, []Rejection) {
+	if len(plan.Orders) == 0 && len(plan.Amends) == 0 && len(plan.Algos) == 0 {
+		return plan, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ex := g.ex
+	ex.mu.Lock()
+	var rejs []Rejection
+
+	now := g.now()
+	cut := now.Add(-time.Second)
+	for len(g.sent) > 0 && !g.sent[0].After(cut) {
+		g.sent = g.sent[1:]
+	}
+
+	// 全局挂单数（不含本批）
+	open := 0
+	batch := make(map[string]bool, len(plan.Orders))
+	for _, o := range plan.Orders {
+		batch[o.ClientID] = true
+	}
+	for _, st := range ex.ins {
+		for id, o := range st.open {
+			if !o.State.Terminal() && !batch[id] {
+				open++
+			}
+		}
+	}
+
+	// 同向最坏敞口（张）：持仓 + 同向在途（不含本批，本批逐单累加）
+	type expo struct{ long, short float64 }
+	exp := make(map[string]*expo)
+	expOf := func(inst string) *expo {
+		if e, ok := exp[inst]; ok {
+			return e
+		}
+		e := &expo{}
+		if st, ok := ex.ins[inst]; ok {
+			e.long, e.short = st.position, st.position
+			for id, o := range st.open {
+				if batch[id] {
+					continue
+				}
+				if o.Side == SideBuy {
+					e.long += o.Remaining
+				} else {
+					e.short -= o.Remaining
+				}
+			}
+		}
+		exp[inst] = e
+		return e
+	}
+	// room —— 按 MaxPos / MaxNotional 的同向剩余额度（张）
+	room := func(e *expo, side Side, npc float64) (cur, left float64, reason string) {
+		cur = e.long
+		if side == SideSell {
+			cur = -e.short
+		}
+		left = math.Inf(1)
+		if g.cfg.MaxPos > 0 {
+			left, reason = g.cfg.MaxPos-cur, "max_pos"
+		}
+		if g.cfg.MaxNotional > 0 && npc > 0 {
+			if r := g.cfg.MaxNotional/npc - cur; r < left {
+				left, reason = r, "max_notional"
+			}
+		}
+		return cur, left, reason
+	}
+	add := func(e *expo, side Side, qty float64) {
+		if side == SideBuy {
+			e.long += qty
+		} else {
+			e.short -= qty
+		}
+	}
+
+	kept := plan.Orders[:0:0]
+	for _, o := range plan.Orders {
+		sp := ex.specs[o.InstID]
+		ref := g.refPrice(o.InstID, o.Price)
+		reject := func(reason, detail string) {
+			rejs = append(rejs, Rejection{Order: o, Reason: reason, Detail: detail})
+			ex.rejectLocal(o)
+		}
+
+		if ref <= 0 {
+			reject("no_reference_price", "无 mark 且无委托价")
+			continue
+		}
+		if dev := g.deviationBps(o.InstID, o.Price); dev > 0 {
+			reject("price_band", fmt.Sprintf("偏离 %.1fbps > %dbps", dev, g.cfg.PriceDeviationBps))
+			continue
+		}
+		npc := notionalPerContract(sp, ref)
+		if g.cfg.MaxOrderQty > 0 && o.Qty > g.cfg.MaxOrderQty+lotEps {
+			reject("fat_finger", fmt.Sprintf("数量 %.4f > %.4f", o.Qty, g.cfg.MaxOrderQty))
+			continue
+		}
+		if g.cfg.MaxOrderNotional > 0 && o.Qty*npc > g.cfg.MaxOrderNotional {
+			reject("fat_finger", fmt.Sprintf("名义 %.2f > %.2f", o.Qty*npc, g.cfg.MaxOrderNotional))
+			continue
+		}
+		if g.cfg.MaxOrderRatePerSec > 0 && float64(len(g.sent)) >= g.cfg.MaxOrderRatePerSec {
+			reject("rate_limit", fmt.Sprintf("1s 内已发 %d 单", len(g.sent)))
+			continue
+		}
+		if g.cfg.MaxOpenOrders > 0 && open >= g.cfg.MaxOpenOrders {
+			reject("max_open_orders", fmt.Sprintf("挂单 %d 已达上限", open))
+			continue
+		}
+
+		qty := o.Qty
+		e := expOf(o.InstID)
+		if !o.ReduceOnly {
+			cur, left, reason := room(e, o.Side, npc)
+			if qty > left+lotEps {
+				trimmed := roundDownToLot(math.Max(0, left), sp.LotSize)
+				if trimmed <= 0 || trimmed < sp.MinQty {
+					reject(reason, fmt.Sprintf("敞口 %.4f 无剩余额度", cur))
+					continue
+				}
+				rejs = append(rejs, Rejection{Order: o, Reason: reason, Trimmed: trimmed, Detail: fmt.Sprintf("%.4f -> %.4f", qty, trimmed)})
+				qty = trimmed
+				ex.trimLocal(o, qty)
+			}
+		}
+
+		o.Qty = qty
+		add(e, o.Side, qty)
+		open++
+		g.sent = append(g.sent, now)
+		kept = append(kept, o)
+	}
+
+	amends := plan.Amends[:0:0]
+	var dropped []AmendRequest
+	for _, a := range plan.Amends {
+		if a.NewPrice > 0 {
+			if dev := g.deviationBps(a.InstID, a.NewPrice); dev > 0 {
+				rejs = append(rejs, Rejection{Order: OrderRequest{InstID: a.InstID, ClientID: a.ClientID, Price: a.NewPrice, Qty: a.NewQty}, Reason: "price_band", Detail: fmt.Sprintf("改价偏离 %.1fbps", dev)})
+				dropped = append(dropped, a)
+				continue
+			}
+		}
+		amends = append(amends, a)
+	}
+
+	algos := plan.Algos[:0:0]
+	for _, a := range plan.Algos {
+		px := algoPx(a)
+		o := OrderRequest{InstID: a.InstID, ClientID: a.ClientID, Side: a.Side, Qty: a.Qty, Price: px, ReduceOnly: a.ReduceOnly}
+		reject := func(reason, detail string) {
+			rejs = append(rejs, Rejection{Order: o, Algo: true, Reason: reason, Detail: detail})
+		}
+		npc := notionalPerContract(ex.specs[a.InstID], g.refPrice(a.InstID, px))
+		if g.cfg.MaxOrderQty > 0 && a.Qty > g.cfg.MaxOrderQty+lotEps {
+			reject("fat_finger", fmt.Sprintf("策略单数量 %.4f > %.4f", a.Qty, g.cfg.MaxOrderQty))
+			continue
+		}
+		if g.cfg.MaxOrderNotional > 0 && a.Qty*npc > g.cfg.MaxOrderNotional {
+			reject("fat_finger", fmt.Sprintf("策略单名义 %.2f > %.2f", a.Qty*npc, g.cfg.MaxOrderNotional))
+			continue
+		}
+		if !a.ReduceOnly {
+			e := expOf(a.InstID)
+			if cur, left, reason := room(e, a.Side, npc); a.Qty > left+lotEps {
+				reject(reason, fmt.Sprintf("策略单敞口 %.4f 无剩余额度", cur))
+				continue
+			}
+			add(e, a.Side, a.Qty)
+		}
+		algos = append(algos, a)
+	}
+	ex.mu.Unlock()
+
+	plan.Orders, plan.Amends, plan.Algos = kept, amends, algos
+	if len(dropped) > 0 {
+		ex.ConfirmAmends(dropped, ErrGateRejected) // 清掉在途改价，挂单回到按原价管理 / 超时撤
+	}
+	for _, r := range rejs {
+		g.record(r)
+		if r.Algo {
+			g.algoSent(r.Order.InstID, r.Order.ClientID, fmt.Errorf("%w: %s %s", ErrGateRejected, r.Reason, r.Detail))
+		}
+	}
+	return plan, rejs
+}
+
+// Send —— 闸门过滤后把计划下发到适配器：撤单 → 撤策略单 → 改单 → 新单 → 策略单；
+// 改单与常驻止损的结果回写执行器（ConfirmAmends / ConfirmStop），止损确认后随即撤旧止损
+func (g *PreTradeGate) Send(venue ExchangeAdapter, plan Plan) ([]Rejection, error) {
+	plan, rejs := g.Filter(plan)
+	var errs []error
+	note := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	at, _ := venue.(AlgoTrader)
+	if len(plan.Cancels) > 0 {
+		note(venue.CancelOrders(plan.Cancels))
+	}
+	if len(plan.AlgoCancels) > 0 {
+		if at == nil {
+			note(ErrUnsupported)
+		} else {
+			note(at.CancelAlgoOrders(plan.AlgoCancels))
+		}
+	}
+	if len(plan.Amends) > 0 {
+		err := venue.AmendOrders(plan.Amends)
+		g.ex.ConfirmAmends(plan.Amends, err)
+		note(err)
+	}
+	if len(plan.Orders) > 0 {
+		err := venue.SendOrders(plan.Orders)
+		g.ex.rejectFailed(plan.Orders, err)
+		note(err)
+	}
+	if len(plan.Algos) > 0 {
+		err := ErrUnsupported
+		if at != nil {
+			err = at.SendAlgoOrders(plan.Algos)
+		}
+		note(err)
+		items := make(map[string]error)
+		whole := splitErrs(err, items)
+		var after []AlgoCancelRequest
+		for _, a := range plan.Algos {
+			after = append(after, g.algoSent(a.InstID, a.ClientID, errors.Join(whole, items[a.ClientID])).AlgoCancels...)
+		}
+		if len(after) > 0 {
+			note(at.CancelAlgoOrders(after))
+		}
+	}
+	return rejs, errors.Join(errs...)
+}
+
+// algoSent —— 常驻止损（来源 tag=sl）的下发结果回写执行器；其他策略单不跟踪
+func (g *PreTradeGate) algoSent(inst, clientID string, err error) Plan {
+	if o, ok := g.ex.Origin(clientID); !ok || o.Tag != "sl" {
+		return Plan{}
+	}
+	return g.ex.ConfirmStop(inst, clientID, err)
+}
+
+// algoPx —— 策略单的参考价：委托价 / 触发价 / 止损 / 止盈 / 激活价中第一个有效值
+func algoPx(a AlgoOrderRequest) float64 {
+	for _, px := range []float64{a.OrdPx, a.TriggerPx, a.SLOrdPx, a.SLTriggerPx, a.TPOrdPx, a.TPTriggerPx, a.ActivePx} {
+		if px > 0 {
+			return px
+		}
+	}
+	return 0
+}
+
+// refPrice —— 名义计算用参考价：优先 mark，否则委托价（调用方持有 ex.mu）
+func (g *PreTradeGate) refPrice(inst string, px float64) float64 {
+	if st, ok := g.ex.ins[inst]; ok && st.book.mark > 0 {
+		return st.book.mark
+	}
+	return px
+}
+
+// deviationBps —— 超出价格带时返回偏离 bps，否则 0（无 mark / 市价单不检查；调用方持有 ex.mu）
+func (g *PreTradeGate) deviationBps(inst string, px float64) float64 {
+	if g.cfg.PriceDeviationBps <= 0 || px <= 0 {
+		return 0
+	}
+	st, ok := g.ex.ins[inst]
+	if !ok || st.book.mark <= 0 {
+		return 0
+	}
+	dev := math.Abs(px-st.book.mark) / st.book.mark * 10000
+	if dev > float64(g.cfg.PriceDeviationBps) {
+		return dev
+	}
+	return 0
+}
+
+func (g *PreTradeGate) record(r Rejection) {
+	typ := "reject"
+	size := r.Order.Qty
+	if r.Trimmed > 0 {
+		typ, size = "trim", r.Trimmed
+	}
+	log.Printf("⚠️ 下单闸门 %s: %s %s %s %.4f@%.4f %s", typ, r.Reason, r.Order.InstID, r.Order.ClientID, r.Order.Qty, r.Order.Price, r.Detail)
+	if g.lg == nil {
+		return
+	}
+	_ = g.lg.Action(storage.LogAction{
+		InstID: r.Order.InstID, Type: typ, Reason: r.Reason, Size: size, Price: r.Order.Price,
+		Meta: map[string]any{"clientId": r.Order.ClientID, "side": string(r.Order.Side), "qty": r.Order.Qty, "detail": r.Detail},
+	})
+}
+
+// rejectLocal —— 本地登记的订单按 rejected 结束（调用方持有 ex.mu）
+func (ex *Executor) rejectLocal(o OrderRequest) {
+	st, ok := ex.ins[o.InstID]
+	if !ok {
+		return
+	}
+	if oo, ok := st.open[o.ClientID]; ok && oo.State.canMoveTo(OrdRejected) {
+		oo.State = OrdRejected
+		oo.Updated = time.Now()
+		st.settle(o.ClientID, oo)
+	}
+}
+
+// rejectFailed —— 下单失败（整批或逐单 sCode）的订单在本地按 rejected 结束：下单失败交易所不推送回报
+func (ex *Executor) rejectFailed(orders []OrderRequest, err error) {
+	if err == nil {
+		return
+	}
+	items := make(map[string]error)
+	whole := splitErrs(err, items)
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	for _, o := range orders {
+		if whole != nil || items[o.ClientID] != nil {
+			ex.rejectLocal(o)
+		}
+	}
+}
+
+// trimLocal —— 同步裁剪后的数量（调用方持有 ex.mu）
+func (ex *Executor) trimLocal(o OrderRequest, qty float64) {
+	st, ok := ex.ins[o.InstID]
+	if !ok {
+		return
+	}
+	if oo, ok := st.open[o.ClientID]; ok {
+		oo.Qty = qty
+		st.settle(o.ClientID, oo)
+	}
+}
@@ -0,0 +1,193 @@
+package execution
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/storage"
+	"Mod/src/stream"
+)
+
+func TestPreTradeGate(t *testing.T) {
+	ex := NewExecutor(Config{AccountEquity: 1e6, LeverageCap: 1, ChildMinQty: 1, ChildMaxQty: 50})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	ex.OnMark("X", 100)
+	st := ex.ensure("X")
+	st.position = 5
+
+	dir := t.TempDir()
+	lg := storage.NewTradeLogger(dir, "trade.jsonl", false, 0)
+	defer lg.Close()
+	g := NewPreTradeGate(config.RiskConfig{
+		PriceDeviationBps: 50, MaxOrderRatePerSec: 4, MaxOpenOrders: 10,
+		MaxPos: 12, MaxNotional: 1e6, MaxOrderQty: 20, MaxOrderNotional: 1e6,
+	}, ex, lg)
+	now := time.Unix(1_700_000_000, 0)
+	g.now = func() time.Time { return now }
+
+	mk := func(id string, side Side, qty, px float64, ro bool) OrderRequest {
+		o := OrderRequest{InstID: "X", ClientID: id, Side: side, Type: OrdLimit, Qty: qty, Price: px, ReduceOnly: ro}
+		st.addOrder(o)
+		return o
+	}
+	plan := Plan{Orders: []OrderRequest{
+		mk("band", SideBuy, 1, 101, false),    // 偏离 100bps
+		mk("fat", SideBuy, 25, 100, false),    // 超单笔张数
+		mk("trim", SideBuy, 10, 100.2, false), // 5 + 10 > 12 → 裁到 7
+		mk("full", SideBuy, 1, 100, false),    // 额度用尽
+		mk("ro", SideSell, 8, 99.8, true),     // 减仓单不受敞口限制
+	}, Cancels: []CancelRequest{{InstID: "X", ClientID: "old"}}}
+
+	out, rejs := g.Filter(plan)
+	if len(out.Orders) != 2 || out.Orders[0].ClientID != "trim" || out.Orders[0].Qty != 7 || out.Orders[1].ClientID != "ro" || len(out.Cancels) != 1 {
+		t.Fatalf("kept: %+v", out)
+	}
+	reasons := map[string]string{}
+	for _, r := range rejs {
+		reasons[r.Order.ClientID] = r.Reason
+	}
+	if reasons["band"] != "price_band" || reasons["fat"] != "fat_finger" || reasons["trim"] != "max_pos" || reasons["full"] != "max_pos" || len(rejs) != 4 {
+		t.Fatalf("rejections: %+v", rejs)
+	}
+	if _, ok := st.open["band"]; ok || st.done["band"] != OrdRejected || st.open["trim"].Qty != 7 {
+		t.Fatalf("local state not synced: open=%v done=%v", st.open, st.done)
+	}
+
+	// 频控：同一秒内已放行 2 单，再来 3 单只放 2 单；下一秒恢复
+	st.position = 0
+	plan = Plan{Orders: []OrderRequest{mk("r1", SideSell, 1, 100, true), mk("r2", SideSell, 1, 100, true), mk("r3", SideSell, 1, 100, true)}}
+	if out, rejs = g.Filter(plan); len(out.Orders) != 2 || len(rejs) != 1 || rejs[0].Reason != "rate_limit" {
+		t.Fatalf("rate limit: %+v %+v", out.Orders, rejs)
+	}
+	now = now.Add(1100 * time.Millisecond)
+	if out, _ = g.Filter(Plan{Orders: []OrderRequest{mk("r4", SideSell, 1, 100, true)}}); len(out.Orders) != 1 {
+		t.Fatalf("rate window not reset: %+v", out.Orders)
+	}
+
+	lines, err := lg.Tail(20)
+	if err != nil || len(lines) != 5 {
+		t.Fatalf("action log: %d %v", len(lines), err)
+	}
+}
+
+func TestPreTradeGateAlgosAndSend(t *testing.T) {
+	ex := NewExecutor(Config{StopMoveTicks: 1})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	ex.OnMark("X", 100)
+	ex.ensure("X").position = 5
+	g := NewPreTradeGate(config.RiskConfig{MaxPos: 6, MaxOrderQty: 20, MaxOrderNotional: 1500}, ex, nil)
+
+	out, rejs := g.Filter(Plan{Algos: []AlgoOrderRequest{
+		{InstID: "X", ClientID: "fat", Type: AlgoConditional, Side: SideSell, Qty: 20, ReduceOnly: true, TPSL: TPSL{SLTriggerPx: 95}},
+		{InstID: "X", ClientID: "entry", Type: AlgoTrigger, Side: SideBuy, Qty: 3, TriggerPx: 101},
+		{InstID: "X", ClientID: "tp", Type: AlgoConditional, Side: SideSell, Qty: 5, ReduceOnly: true, TPSL: TPSL{TPTriggerPx: 110}},
+	}})
+	if len(out.Algos) != 1 || out.Algos[0].ClientID != "tp" || len(rejs) != 2 ||
+		rejs[0].Reason != "fat_finger" || rejs[1].Reason != "max_pos" || !rejs[1].Algo {
+		t.Fatalf("algos kept %+v rejected %+v", out.Algos, rejs)
+	}
+
+	// Send：常驻止损经闸门下发并在确认后记录；被闸门拒的换挂保留旧止损
+	p := NewPaperExchange(PaperConfig{InitialCash: 1e5})
+	ex2 := NewExecutor(Config{StopMoveTicks: 1})
+	for _, v := range []interface{ RegisterInstrument(InstrumentSpec) }{p, ex2} {
+		v.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	}
+	ex2.Bind(p)
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99.9", AskPx: "100", Last: "100"}})
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdMarket, Qty: 3, ClientID: "e"}}); err != nil {
+		t.Fatal(err)
+	}
+	g2 := NewPreTradeGate(config.RiskConfig{MaxOrderQty: 5}, ex2, nil)
+	if _, err := g2.Send(p, ex2.SyncStops("X", 95)); err != nil {
+		t.Fatal(err)
+	}
+	first, ok := ex2.StopOrder("X")
+	if !ok || first.SLTriggerPx != 95 || len(p.AlgoOrders()) != 1 {
+		t.Fatalf("stop via gate: %+v algos=%+v", first, p.AlgoOrders())
+	}
+	if _, err := g2.Send(p, ex2.SyncStops("X", 97)); err != nil {
+		t.Fatal(err)
+	}
+	if so, _ := ex2.StopOrder("X"); so.SLTriggerPx != 97 || len(p.AlgoOrders()) != 1 || p.AlgoOrders()[0].SLTriggerPx != 97 {
+		t.Fatalf("moved stop: %+v algos=%+v", so, p.AlgoOrders())
+	}
+	g2.cfg.MaxOrderQty = 1
+	if rejs, _ := g2.Send(p, ex2.SyncStops("X", 98)); len(rejs) != 1 {
+		t.Fatalf("expected gate rejection: %+v", rejs)
+	}
+	if so, _ := ex2.StopOrder("X"); so.SLTriggerPx != 97 || len(p.AlgoOrders()) != 1 {
+		t.Fatalf("rejected move lost stop: %+v", so)
+	}
+	if pl := ex2.SyncStops("X", 98); len(pl.Algos) != 1 {
+		t.Fatalf("rejected stop not retried: %+v", pl)
+	}
+}
+
+func TestPreTradeGateRejectedAmendFallsBackToStaleCancel(t *testing.T) {
+	ex := NewExecutor(Config{
+		AccountEquity: 1000, LeverageCap: 1, PreferPassive: true, ChildMinQty: 1, ChildMaxQty: 1,
+		CancelStaleAfterMs: 1, AmendMinTicks: 2, AmendMaxTicks: 10, AmendMinIntervalMs: 1, MaxAmendsPerOrder: 1,
+	})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 1, LotSize: 1, ContractValue: 1})
+	g := NewPreTradeGate(config.RiskConfig{PriceDeviationBps: 50}, ex, nil)
+
+	p := ex.Step("X", 0.001, 100, 1000)
+	id := p.Orders[0].ClientID
+	ex.OnOrderUpdate(OrderUpdate{InstID: "X", ClientID: id, Status: "live"})
+	time.Sleep(2 * time.Millisecond)
+
+	// 改价到 103 后 mark 回落：改价偏离 >50bps 被闸门拦下
+	p = ex.Step("X", 0.001, 104, 1000)
+	if len(p.Amends) != 1 {
+		t.Fatalf("expected amend: %+v", p)
+	}
+	ex.OnMark("X", 100)
+	out, rejs := g.Filter(p)
+	if len(out.Amends) != 0 || len(rejs) != 1 || rejs[0].Reason != "price_band" {
+		t.Fatalf("amend not rejected: %+v %+v", out.Amends, rejs)
+	}
+	if o := ex.ensure("X").open[id]; o.AmendPx != 0 || o.Price != 99 {
+		t.Fatalf("rejected amend left in flight: %+v", o)
+	}
+
+	// 改价额度用尽：超时撤单
+	time.Sleep(2 * time.Millisecond)
+	p = ex.Step("X", 0.001, 104, 1000)
+	if len(p.Cancels) != 1 || p.Cancels[0].ClientID != id {
+		t.Fatalf("expected stale cancel: %+v", p)
+	}
+}
+
+func TestPreTradeGateSendRejectsFailedPlacements(t *testing.T) {
+	f := &fakeOKX{t: t, secret: "s", sCode: map[string]string{"bad": "51008"}}
+	okx, done := newTestAdapter(t, f)
+	defer done()
+	ex := NewExecutor(Config{})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	st := ex.ensure("X")
+	g := NewPreTradeGate(config.RiskConfig{}, ex, nil)
+	mk := func(id string) OrderRequest {
+		o := OrderRequest{InstID: "X", ClientID: id, Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 100}
+		st.addOrder(o)
+		return o
+	}
+
+	// 逐单 sCode：只结束失败的那一笔
+	if _, err := g.Send(okx, Plan{Orders: []OrderRequest{mk("good"), mk("bad")}}); err == nil {
+		t.Fatal("expected item error")
+	}
+	if st.done["bad"] != OrdRejected || st.open["good"] == nil {
+		t.Fatalf("item failure: open=%v done=%v", st.open, st.done)
+	}
+	// 整批失败：全部结束
+	f.status = http.StatusInternalServerError
+	if _, err := g.Send(okx, Plan{Orders: []OrderRequest{mk("t1"), mk("t2")}}); err == nil {
+		t.Fatal("expected transport error")
+	}
+	if st.done["t1"] != OrdRejected || st.done["t2"] != OrdRejected {
+		t.Fatalf("transport failure: open=%v done=%v", st.open, st.done)
+	}
+}
@@ -0,0 +1,443 @@
+package execution
+
+// 容错适配器 —— 包装任意 ExchangeAdapter：重试 / 幂等重发 / 客户端限频
+// =============================================================================
+// 1) 退避：第 n 次重试等待 min(MaxBackoff, Base×2^(n-1))，取其一半 + [0, 一半) 随机抖动；
+// 2) 分类：限频（429/50011/50061）、繁忙/超时（5xx、50001/50004/50013/50026）、网络错误可重试；
+//    鉴权、参数、余额、价格带等业务错误直接返回；批量接口按 clOrdId 逐笔判定，只重发失败的那几笔；
+// 3) 幂等：网络/超时/5xx 属“可能已受理”，重发前先按 clOrdId 查单（OrderStatusQuerier），已存在即不重发；
+//    重试轮上 clOrdId 重复视为前次已受理，撤单遇到“不存在/已完结”视为已撤；
+// 4) 限频：按 OKX 各接口限额（2 秒窗口）建令牌桶，批量接口按笔数计费，额度不足时本地等待而不是吃 429。
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"Mod/src/config"
+)
+
+// OrderStatusQuerier —— 可选能力：按 clOrdId 查单；查无此单返回 ErrOrderNotFound
+type OrderStatusQuerier interface {
+	OrderStatus(instID, clientID string) (RemoteOrder, error)
+}
+
+// ErrUnsupported —— 被包装的场所不支持该能力
+var ErrUnsupported = errors.New("execution: 场所不支持该操作")
+
+// RetryPolicy —— 重试参数
+type RetryPolicy struct {
+	MaxAttempts int           // 含首次；<=1 表示不重试
+	BaseBackoff time.Duration // 首次重试等待
+	MaxBackoff  time.Duration
+}
+
+// RetryPolicyFrom —— 由 config.RetryConfig 生成；未配置时回落到 execution.Config.MaxRetries
+func RetryPolicyFrom(rc config.RetryConfig, maxRetries int) RetryPolicy {
+	p := RetryPolicy{MaxAttempts: rc.MaxAttempts, BaseBackoff: time.Duration(rc.BackoffMs) * time.Millisecond}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = maxRetries + 1
+	}
+	return p.withDefaults()
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = 200 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.MaxBackoff < p.BaseBackoff {
+		p.MaxBackoff = p.BaseBackoff
+	}
+	return p
+}
+
+// Retryable —— 是否值得重试
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrUnsupported) {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServiceUnavailable) {
+		return true
+	}
+	var oe *OKXError
+	if errors.As(err, &oe) {
+		return oe.HTTPStatus >= http.StatusInternalServerError
+	}
+	return true // 网络/超时等非交易所错误
+}
+
+// ambiguous —— 请求可能已被交易所受理（重发前需查单）
+func ambiguous(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return false
+	}
+	var oe *OKXError
+	if errors.As(err, &oe) {
+		return oe.HTTPStatus >= http.StatusInternalServerError || errors.Is(oe, ErrServiceUnavailable)
+	}
+	return true
+}
+
+// splitErrs —— 拆出逐笔错误（按 clOrdId）与整批错误
+func splitErrs(err error, items map[string]error) (whole error) {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range j.Unwrap() {
+			whole = errors.Join(whole, splitErrs(e, items))
+		}
+		return whole
+	}
+	var oe *OKXError
+	if errors.As(err, &oe) && oe.ClientID != "" {
+		items[oe.ClientID] = err
+		return nil
+	}
+	return err
+}
+
+// ===================== 令牌桶 =====================
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(n, perSec float64) *tokenBucket {
+	return &tokenBucket{rate: n / perSec, burst: n, tokens: n}
+}
+
+// reserve —— 预扣 n 个令牌，返回需要等待的时长（可透支，等待期间由补充抵消）
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= math.Min(n, b.burst)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// okxLimits —— OKX 交易接口限额（笔数或请求数 / 2 秒）
+var okxLimits = map[string]float64{
+	"batch-orders":        300,
+	"cancel-batch-orders": 300,
+	"amend-batch-orders":  300,
+	"order-algo":          20,
+	"cancel-algos":        20,
+	"order":               60,
+	"set-leverage":        20,
+}
+
+// ===================== 适配器 =====================
+
+// ResilientAdapter —— 容错包装；同时转发策略单、对账快照、查单等可选能力
+type ResilientAdapter struct {
+	inner   ExchangeAdapter
+	pol     RetryPolicy
+	buckets map[string]*tokenBucket
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	sleep func(time.Duration)
+	now   func() time.Time
+}
+
+func NewResilientAdapter(inner ExchangeAdapter, pol RetryPolicy) *ResilientAdapter {
+	r := &ResilientAdapter{
+		inner:   inner,
+		pol:     pol.withDefaults(),
+		buckets: make(map[string]*tokenBucket, len(okxLimits)),
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:   time.Sleep,
+		now:     time.Now,
+	}
+	for op, n := range okxLimits {
+		r.buckets[op] = newBucket(n, 2)
+	}
+	return r
+}
+
+// Inner —— 被包装的场所
+func (r *ResilientAdapter) Inner() ExchangeAdapter { return r.inner }
+
+func (r *ResilientAdapter) throttle(op string, n int) {
+	b, ok := r.buckets[op]
+	if !ok || n <= 0 {
+		return
+	}
+	if d := b.reserve(float64(n), r.now()); d > 0 {
+		r.sleep(d)
+	}
+}
+
+func (r *ResilientAdapter) backoff(attempt int) {
+	d := r.pol.BaseBackoff << uint(attempt-1)
+	if d > r.pol.MaxBackoff || d <= 0 {
+		d = r.pol.MaxBackoff
+	}
+	r.mu.Lock()
+	j := time.Duration(r.rnd.Int63n(int64(d/2) + 1))
+	r.mu.Unlock()
+	r.sleep(d/2 + j)
+}
+
+// retryOp —— 一次带重试的批量操作（按下标标识每一笔）
+type retryOp struct {
+	op     string
+	ids    []string
+	cost   func(n int) int
+	send   func(idx []int) error
+	landed func(i int) bool                  // 可选：模糊失败后确认是否已受理
+	benign func(attempt int, err error) bool // 重试轮上可视为成功的错误
+}
+
+func (r *ResilientAdapter) run(o retryOp) error {
+	pending := make([]int, len(o.ids))
+	for i := range pending {
+		pending[i] = i
+	}
+	var fatal []error
+	seen := make(map[error]bool)
+	fail := func(e error) {
+		if !seen[e] {
+			seen[e] = true
+			fatal = append(fatal, e)
+		}
+	}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		r.throttle(o.op, o.cost(len(pending)))
+		err := o.send(pending)
+		if err == nil {
+			break
+		}
+		items := make(map[string]error)
+		whole := splitErrs(err, items)
+		var retry, check []int
+		for _, i := range pending {
+			e, ok := items[o.ids[i]]
+			if !ok {
+				e = whole
+			}
+			switch {
+			case e == nil:
+			case attempt > 1 && o.benign != nil && o.benign(attempt, e):
+			case !Retryable(e) || attempt >= r.pol.MaxAttempts:
+				fail(e)
+			case ambiguous(e) && o.landed != nil:
+				check = append(check, i)
+			default:
+				retry = append(retry, i)
+			}
+		}
+		if len(retry)+len(check) == 0 {
+			break
+		}
+		r.backoff(attempt)
+		for _, i := range check {
+			if !o.landed(i) {
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
+	}
+	return errors.Join(fatal...)
+}
+
+func isDup(_ int, err error) bool { return errors.Is(err, ErrDuplicateClientID) }
+
+// SendOrders —— 下单；模糊失败后先查单再决定是否重发
+func (r *ResilientAdapter) SendOrders(orders []OrderRequest) error {
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ClientID
+	}
+	q, _ := r.inner.(OrderStatusQuerier)
+	op := retryOp{
+		op: "batch-orders", ids: ids, cost: func(n int) int { return n }, benign: isDup,
+		send: func(idx []int) error {
+			sub := make([]OrderRequest, len(idx))
+			for k, i := range idx {
+				sub[k] = orders[i]
+			}
+			return r.inner.SendOrders(sub)
+		},
+	}
+	if q != nil {
+		op.landed = func(i int) bool {
+			o := orders[i]
+			if o.ClientID == "" {
+				return false
+			}
+			r.throttle("order", 1)
+			_, err := q.OrderStatus(o.InstID, o.ClientID)
+			return err == nil
+		}
+	}
+	return r.run(op)
+}
+
+// CancelOrders —— 撤单；重试轮上“不存在/已完结”视为已撤
+func (r *ResilientAdapter) CancelOrders(cancels []CancelRequest) error {
+	ids := make([]string, len(cancels))
+	for i, c := range cancels {
+		ids[i] = c.ClientID
+	}
+	return r.run(retryOp{
+		op: "cancel-batch-orders", ids: ids, cost: func(n int) int { return n },
+		benign: func(_ int, err error) bool {
+			return errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrOrderCompleted)
+		},
+		send: func(idx []int) error {
+			sub := make([]CancelRequest, len(idx))
+			for k, i := range idx {
+				sub[k] = cancels[i]
+			}
+			return r.inner.CancelOrders(sub)
+		},
+	})
+}
+
+// AmendOrders —— 改单（同参数重发天然幂等）
+func (r *ResilientAdapter) AmendOrders(amends []AmendRequest) error {
+	ids := make([]string, len(amends))
+	for i, m := range amends {
+		ids[i] = m.ClientID
+	}
+	return r.run(retryOp{
+		op: "amend-batch-orders", ids: ids, cost: func(n int) int { return n },
+		send: func(idx []int) error {
+			sub := make([]AmendRequest, len(idx))
+			for k, i := range idx {
+				sub[k] = amends[i]
+			}
+			return r.inner.AmendOrders(sub)
+		},
+	})
+}
+
+// SendAlgoOrders —— 策略单（OKX 逐笔下发，按笔计费）
+func (r *ResilientAdapter) SendAlgoOrders(orders []AlgoOrderRequest) error {
+	at, ok := r.inner.(AlgoTrader)
+	if !ok {
+		return ErrUnsupported
+	}
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ClientID
+	}
+	return r.run(retryOp{
+		op: "order-algo", ids: ids, cost: func(n int) int { return n }, benign: isDup,
+		send: func(idx []int) error {
+			sub := make([]AlgoOrderRequest, len(idx))
+			for k, i := range idx {
+				sub[k] = orders[i]
+			}
+			return at.SendAlgoOrders(sub)
+		},
+	})
+}
+
+// CancelAlgoOrders —— 撤策略单（单批 10 笔，按请求计费）
+func (r *ResilientAdapter) CancelAlgoOrders(cancels []AlgoCancelRequest) error {
+	at, ok := r.inner.(AlgoTrader)
+	if !ok {
+		return ErrUnsupported
+	}
+	ids := make([]string, len(cancels))
+	for i, c := range cancels {
+		ids[i] = c.ClientID
+	}
+	return r.run(retryOp{
+		op: "cancel-algos", ids: ids, cost: func(n int) int { return (n + okxMaxAlgoBatch - 1) / okxMaxAlgoBatch },
+		benign: func(_ int, err error) bool {
+			return errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrOrderCompleted)
+		},
+		send: func(idx []int) error {
+			sub := make([]AlgoCancelRequest, len(idx))
+			for k, i := range idx {
+				sub[k] = cancels[i]
+			}
+			return at.CancelAlgoOrders(sub)
+		},
+	})
+}
+
+// Snapshot —— 对账快照（整体重试）
+func (r *ResilientAdapter) Snapshot() (ExchangeSnapshot, error) {
+	sp, ok := r.inner.(SnapshotProvider)
+	if !ok {
+		return ExchangeSnapshot{}, ErrUnsupported
+	}
+	for attempt := 1; ; attempt++ {
+		snap, err := sp.Snapshot()
+		if err == nil || !Retryable(err) || attempt >= r.pol.MaxAttempts {
+			return snap, err
+		}
+		r.backoff(attempt)
+	}
+}
+
+// PendingAlgos —— 未触发策略单（整体重试）
+func (r *ResilientAdapter) PendingAlgos() ([]PendingAlgo, error) {
+	al, ok := r.inner.(AlgoOrderLister)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	for attempt := 1; ; attempt++ {
+		out, err := al.PendingAlgos()
+		if err == nil || !Retryable(err) || attempt >= r.pol.MaxAttempts {
+			return out, err
+		}
+		r.backoff(attempt)
+	}
+}
+
+// OrderStatus —— 查单（整体重试；查无此单不重试）
+func (r *ResilientAdapter) OrderStatus(instID, clientID string) (RemoteOrder, error) {
+	q, ok := r.inner.(OrderStatusQuerier)
+	if !ok {
+		return RemoteOrder{}, ErrUnsupported
+	}
+	for attempt := 1; ; attempt++ {
+		r.throttle("order", 1)
+		ro, err := q.OrderStatus(instID, clientID)
+		if err == nil || !Retryable(err) || attempt >= r.pol.MaxAttempts {
+			return ro, err
+		}
+		r.backoff(attempt)
+	}
+}
+
+// OrderStatus —— GET /api/v5/trade/order（按 clOrdId）
+func (a *OKXAdapter) OrderStatus(instID, clientID string) (RemoteOrder, error) {
+	var rows []okxOrderPush
+	q := url.Values{"instId": {instID}, "clOrdId": {clientID}}
+	if err := a.get("order", "/api/v5/trade/order", q, &rows); err != nil {
+		return RemoteOrder{}, err
+	}
+	if len(rows) == 0 {
+		return RemoteOrder{}, &OKXError{Op: "order", HTTPStatus: http.StatusOK, Code: "51603", Msg: "order not found", InstID: instID, ClientID: clientID}
+	}
+	o := rows[0]
+	return RemoteOrder{
+		InstID: o.InstID, ClientID: o.ClOrdID, OrderID: o.OrdID, Side: Side(o.Side),
+		Qty: pf(o.Sz), Price: pf(o.Px), FilledQty: pf(o.AccFillSz), Status: o.State,
+	}, nil
+}
@@ -0,0 +1,175 @@
+package execution
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Mod/src/config"
+)
+
+// 本地故障场所：按脚本注入 429 / 超时（超时时订单其实已受理）/ 业务错误
+type flakyVenue struct {
+	mu     sync.Mutex
+	script []string // 每次 SendOrders 的故障：429 / timeout / ""
+	calls  [][]string
+	live   map[string]bool
+	fatal  map[string]string // clOrdId -> sCode
+	cancel int
+}
+
+func (f *flakyVenue) SendOrders(os []OrderRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(os))
+	for _, o := range os {
+		ids = append(ids, o.ClientID)
+	}
+	f.calls = append(f.calls, ids)
+	fault := ""
+	if len(f.script) > 0 {
+		fault, f.script = f.script[0], f.script[1:]
+	}
+	if fault == "429" {
+		return &OKXError{Op: "batch-orders", HTTPStatus: http.StatusTooManyRequests, Code: "50011"}
+	}
+	var errs []error
+	for _, o := range os {
+		if c, ok := f.fatal[o.ClientID]; ok {
+			errs = append(errs, &OKXError{Op: "batch-orders", HTTPStatus: 200, Code: c, ClientID: o.ClientID})
+			continue
+		}
+		if f.live[o.ClientID] {
+			errs = append(errs, &OKXError{Op: "batch-orders", HTTPStatus: 200, Code: "51016", ClientID: o.ClientID})
+			continue
+		}
+		f.live[o.ClientID] = true
+	}
+	if fault == "timeout" {
+		return errors.New("okx batch-orders 请求失败: context deadline exceeded")
+	}
+	return errors.Join(errs...)
+}
+
+func (f *flakyVenue) CancelOrders(cs []CancelRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancel++
+	if f.cancel == 1 {
+		for _, c := range cs {
+			delete(f.live, c.ClientID)
+		}
+		return &OKXError{Op: "cancel-batch-orders", HTTPStatus: http.StatusBadGateway, Code: "502"}
+	}
+	var errs []error
+	for _, c := range cs {
+		if !f.live[c.ClientID] {
+			errs = append(errs, &OKXError{Op: "cancel-batch-orders", HTTPStatus: 200, Code: "51400", ClientID: c.ClientID})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *flakyVenue) AmendOrders([]AmendRequest) error { return nil }
+
+func (f *flakyVenue) OrderStatus(inst, id string) (RemoteOrder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.live[id] {
+		return RemoteOrder{InstID: inst, ClientID: id, Status: "live"}, nil
+	}
+	return RemoteOrder{}, &OKXError{Op: "order", HTTPStatus: 200, Code: "51603"}
+}
+
+func TestResilientAdapterRetriesIdempotently(t *testing.T) {
+	f := &flakyVenue{script: []string{"429", "timeout"}, live: map[string]bool{}, fatal: map[string]string{"poor": "51008"}}
+	r := NewResilientAdapter(f, RetryPolicyFrom(config.RetryConfig{MaxAttempts: 4, BackoffMs: 100}, 0))
+	var slept []time.Duration
+	r.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	err := r.SendOrders([]OrderRequest{{InstID: "X", ClientID: "a"}, {InstID: "X", ClientID: "b"}, {InstID: "X", ClientID: "poor"}})
+	if !errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected only the fatal error, got %v", err)
+	}
+	// 429 → 全部重发；超时 → 查单：已受理的 a/b 不再重发，查无此单的 poor 重发后拿到业务错误
+	if len(f.calls) != 3 || len(f.calls[1]) != 3 || len(f.calls[2]) != 1 || f.calls[2][0] != "poor" || !f.live["a"] || !f.live["b"] {
+		t.Fatalf("calls=%v live=%v", f.calls, f.live)
+	}
+	if len(slept) != 2 || slept[0] < 50*time.Millisecond || slept[0] > 100*time.Millisecond || slept[1] < 100*time.Millisecond || slept[1] > 200*time.Millisecond {
+		t.Fatalf("backoff with jitter: %v", slept)
+	}
+
+	// 撤单：首轮 502（其实已撤），重试轮上“已完结”视为成功
+	if err := r.CancelOrders([]CancelRequest{{InstID: "X", ClientID: "a"}}); err != nil || f.cancel != 2 {
+		t.Fatalf("cancel: %v calls=%d", err, f.cancel)
+	}
+
+	// 次数用尽：返回最后一次的错误
+	f2 := &flakyVenue{script: []string{"429", "429", "429"}, live: map[string]bool{}}
+	r2 := NewResilientAdapter(f2, RetryPolicy{MaxAttempts: 3})
+	r2.sleep = func(time.Duration) {}
+	if err := r2.SendOrders([]OrderRequest{{InstID: "X", ClientID: "c"}}); !errors.Is(err, ErrRateLimited) || len(f2.calls) != 3 {
+		t.Fatalf("exhausted: %v calls=%d", err, len(f2.calls))
+	}
+}
+
+func TestTokenBucketMatchesWindow(t *testing.T) {
+	b := newBucket(300, 2)
+	t0 := time.Unix(0, 0)
+	if d := b.reserve(300, t0); d != 0 {
+		t.Fatalf("burst should pass: %v", d)
+	}
+	if d := b.reserve(150, t0); d != time.Second {
+		t.Fatalf("expected 1s wait, got %v", d)
+	}
+	if d := b.reserve(150, t0.Add(3*time.Second)); d != 0 {
+		t.Fatalf("refilled bucket should pass: %v", d)
+	}
+}
+
+func TestResilientAdapterOverOKXTimeout(t *testing.T) {
+	var mu sync.Mutex
+	posts, gets := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/batch-orders"):
+			posts++
+			if posts == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"code":"50011","msg":"Too Many Requests"}`))
+				return
+			}
+			if posts == 2 {
+				mu.Unlock()
+				time.Sleep(80 * time.Millisecond) // 交易所已受理，但响应超时
+				mu.Lock()
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": []map[string]any{{"clOrdId": "a", "sCode": "0"}}})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v5/trade/order":
+			gets++
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": []map[string]any{{"instId": "X-USDT-SWAP", "clOrdId": r.URL.Query().Get("clOrdId"), "state": "live", "sz": "1"}}})
+		}
+	}))
+	defer srv.Close()
+
+	okx := NewOKXAdapter(config.ExchangeConfig{BaseURL: srv.URL})
+	okx.httpTimeout = 30 * time.Millisecond
+	r := NewResilientAdapter(okx, RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Millisecond})
+	if err := r.SendOrders([]OrderRequest{{InstID: "X-USDT-SWAP", ClientID: "a", Side: SideBuy, Type: OrdLimit, Qty: 1, Price: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 2 || gets != 1 {
+		t.Fatalf("posts=%d gets=%d", posts, gets)
+	}
+}
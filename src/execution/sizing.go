@@ -0,0 +1,224 @@
+package execution
+
+// 仓位换算 —— 相对目标 → 张数，按实时账户权益而不是静态 AccountEquity
+// =============================================================================
+// 1) 权益来自账户推送（account 频道 / balance_and_position）：MarginCcy 为空取总权益（美元折算），
+//    否则取该币种权益，再乘 MarginFX 换算到合约计价币（如币本位保证金 × 币价）；未收到推送前用 AccountEquity + 核算盈亏；
+//    Equity()（回撤/风控）与换算同源；
+// 2) 相对目标的夹断范围与风控层一致：SetPositionLimits 接 risk.Engine 后按其 MaxAbsPosition 逐品种取值，
+//    SetPositionLimit 单品种覆盖优先；两者都未设置时为 ±MaxAbsPosition；
+// 3) 单品种名义不超过“权益 × 该品种交易所杠杆”（Config.Leverage），杠杆由 PushLeverage 经 set-leverage 下发。
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"Mod/src/risk"
+)
+
+// AccountSource —— 账户推送源（可选能力，Bind 时类型断言接入）
+type AccountSource interface {
+	OnAccount(func(AccountSnapshot))
+	OnBalance(func([]Balance))
+}
+
+// PositionLimits —— 单品种相对仓位上限来源（*risk.Engine）
+type PositionLimits interface {
+	MaxAbsPosition(inst string) float64
+}
+
+var _ PositionLimits = (*risk.Engine)(nil)
+
+// LeverageSetter —— 支持设置杠杆倍数的场所（可选能力）
+type LeverageSetter interface {
+	SetLeverage(instID string, lever float64, mgnMode string) error
+}
+
+// liveEquity —— 最近一次账户推送的保证金权益（MarginCcy 计）
+type liveEquity struct {
+	eq float64
+	ts time.Time
+	fx float64 // 保证金币种 → 计价币
+}
+
+// OnAccount —— 账户总览推送
+func (ex *Executor) OnAccount(a AccountSnapshot) {
+	eq := a.TotalEq
+	if ex.cfg.MarginCcy != "" {
+		eq = 0
+		for _, b := range a.Details {
+			if b.Ccy == ex.cfg.MarginCcy {
+				eq = b.Equity
+			}
+		}
+	}
+	ex.setLiveEquity(eq, a.Ts)
+}
+
+// OnBalances —— 余额推送（只含变化的币种；MarginCcy 为空时忽略，等总览推送）
+func (ex *Executor) OnBalances(bs []Balance) {
+	if ex.cfg.MarginCcy == "" {
+		return
+	}
+	for _, b := range bs {
+		if b.Ccy == ex.cfg.MarginCcy {
+			ex.setLiveEquity(b.Equity, b.Ts)
+		}
+	}
+}
+
+func (ex *Executor) setLiveEquity(eq float64, ts time.Time) {
+	if eq <= 0 {
+		return
+	}
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ts.Before(ex.live.ts) {
+		return // 乱序推送
+	}
+	ex.live.eq, ex.live.ts = eq, ts
+}
+
+// SetMarginFX —— 更新保证金币种 → 计价币汇率（如 BTC 保证金 × BTC 价格）
+func (ex *Executor) SetMarginFX(rate float64) {
+	if rate <= 0 {
+		return
+	}
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.live.fx = rate
+}
+
+// SetPositionLimit —— 单品种相对目标上限（与风控层批准的范围一致）；<=0 表示回到 MaxAbsPosition
+func (ex *Executor) SetPositionLimit(inst string, maxAbs float64) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if maxAbs <= 0 {
+		delete(ex.maxAbs, inst)
+		return
+	}
+	if ex.maxAbs == nil {
+		ex.maxAbs = make(map[string]float64)
+	}
+	ex.maxAbs[inst] = maxAbs
+}
+
+// SetPositionLimits —— 以风控层的单品种上限作为夹断范围（nil 取消）
+func (ex *Executor) SetPositionLimits(src PositionLimits) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.limits = src
+}
+
+// SizingEquity —— 当前用于换算的权益（计价币）
+func (ex *Executor) SizingEquity() float64 {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.sizingEquity()
+}
+
+// sizingEquity —— 调用方持有 ex.mu
+func (ex *Executor) sizingEquity() float64 {
+	if ex.live.eq <= 0 {
+		return ex.cfg.AccountEquity + ex.bookPnL()
+	}
+	fx := ex.live.fx
+	if fx <= 0 {
+		fx = ex.cfg.MarginFX
+	}
+	return ex.live.eq * fx
+}
+
+// posLimit —— 相对目标夹断范围（调用方持有 ex.mu）
+func (ex *Executor) posLimit(inst string) float64 {
+	if v, ok := ex.maxAbs[inst]; ok {
+		return v
+	}
+	if ex.limits != nil {
+		if v := ex.limits.MaxAbsPosition(inst); v > 0 {
+			return v
+		}
+	}
+	return ex.cfg.MaxAbsPosition
+}
+
+// exchangeLever —— 单品种交易所杠杆（0=未配置，不额外限制名义；调用方持有 ex.mu）
+func (ex *Executor) exchangeLever(inst string) float64 {
+	return ex.cfg.Leverage[inst]
+}
+
+// PushLeverage —— 把各品种杠杆（Config.Leverage，未配置的用 LeverageCap）下发到交易所；mgnMode 为空由场所推断
+func (ex *Executor) PushLeverage(v LeverageSetter, mgnMode string) error {
+	ex.mu.Lock()
+	insts := make([]string, 0, len(ex.specs))
+	for inst := range ex.specs {
+		insts = append(insts, inst)
+	}
+	levers := make(map[string]float64, len(insts))
+	for _, inst := range insts {
+		levers[inst] = ex.cfg.LeverageCap
+		if l := ex.cfg.Leverage[inst]; l > 0 {
+			levers[inst] = l
+		}
+	}
+	ex.mu.Unlock()
+
+	sort.Strings(insts)
+	var errs []error
+	for _, inst := range insts {
+		if err := v.SetLeverage(inst, levers[inst], mgnMode); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", inst, err))
+			continue
+		}
+		log.Printf("✅ 杠杆已设置: %s %.2fx", inst, levers[inst])
+	}
+	return errors.Join(errs...)
+}
+
+// ===================== OKX：set-leverage =====================
+
+// SetLeverage —— POST /api/v5/account/set-leverage（net 持仓模式；现货 cash 模式无杠杆，直接跳过）
+func (a *OKXAdapter) SetLeverage(instID string, lever float64, mgnMode string) error {
+	if mgnMode == "" {
+		mgnMode = a.tradeModeFor(instID)
+	}
+	if mgnMode == "cash" {
+		return nil
+	}
+	if lever <= 0 {
+		return fmt.Errorf("okx set-leverage %s: lever must be positive", instID)
+	}
+	body := map[string]any{"instId": instID, "lever": strconv.FormatFloat(lever, 'f', -1, 64), "mgnMode": mgnMode}
+	env, err := a.do("set-leverage", http.MethodPost, "/api/v5/account/set-leverage", nil, body)
+	if err != nil {
+		return err
+	}
+	if env.Code != "0" {
+		return &OKXError{Op: "set-leverage", HTTPStatus: http.StatusOK, Code: env.Code, Msg: env.Msg, InstID: instID}
+	}
+	return nil
+}
+
+// SetLeverage —— 设置杠杆（整体重试）
+func (r *ResilientAdapter) SetLeverage(instID string, lever float64, mgnMode string) error {
+	ls, ok := r.inner.(LeverageSetter)
+	if !ok {
+		return ErrUnsupported
+	}
+	for attempt := 1; ; attempt++ {
+		r.throttle("set-leverage", 1)
+		err := ls.SetLeverage(instID, lever, mgnMode)
+		if err == nil || !Retryable(err) || attempt >= r.pol.MaxAttempts {
+			return err
+		}
+		r.backoff(attempt)
+	}
+}
@@ -0,0 +1,90 @@
+package execution
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"Mod/src/config"
+	"Mod/src/risk"
+)
+
+func TestTargetContractsFollowsLiveEquity(t *testing.T) {
+	ex := NewExecutor(Config{AccountEquity: 1000, LeverageCap: 2, MarginCcy: "USDT", Leverage: map[string]float64{"Y": 1}})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "Y", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	target := func(inst string, rel float64) float64 {
+		ex.mu.Lock()
+		defer ex.mu.Unlock()
+		return ex.targetContracts(ex.specs[inst], rel, 10)
+	}
+
+	// 未收到推送：静态权益；默认夹断 ±1
+	if q := target("X", 1.5); q != 200 {
+		t.Fatalf("static sizing: %v", q)
+	}
+	// 账户推送：取 USDT 权益；乱序的旧推送被忽略
+	now := time.Now()
+	ex.OnAccount(AccountSnapshot{TotalEq: 9999, Details: []Balance{{Ccy: "BTC", Equity: 1}, {Ccy: "USDT", Equity: 1500}}, Ts: now})
+	ex.OnBalances([]Balance{{Ccy: "USDT", Equity: 800, Ts: now.Add(-time.Second)}})
+	if eq := ex.SizingEquity(); eq != 1500 || ex.Equity() != eq {
+		t.Fatalf("live equity: %v equity %v", eq, ex.Equity())
+	}
+	if q := target("X", 0.5); q != 150 {
+		t.Fatalf("live sizing: %v", q)
+	}
+	// 与风控一致的夹断范围：接风控层按品种取上限，单品种覆盖优先
+	ex.SetPositionLimits(risk.NewEngine(risk.Config{PerInstrumentMax: map[string]float64{"X": 0.5}}))
+	if q := target("X", 2); q != 150 {
+		t.Fatalf("risk per-instrument clamp: %v", q)
+	}
+	ex.SetPositionLimit("X", 1.5)
+	if q := target("X", 2); q != 450 {
+		t.Fatalf("risk-consistent clamp: %v", q)
+	}
+	// 交易所杠杆 1x：名义不超过权益
+	if q := target("Y", -1); q != -150 {
+		t.Fatalf("exchange leverage cap: %v", q)
+	}
+	// 保证金币种换算
+	ex.SetMarginFX(2)
+	if eq := ex.SizingEquity(); math.Abs(eq-3000) > 1e-9 {
+		t.Fatalf("fx: %v", eq)
+	}
+}
+
+func TestPushLeverageOverOKX(t *testing.T) {
+	var mu sync.Mutex
+	var got []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		got = append(got, body)
+		mu.Unlock()
+		if r.URL.Path != "/api/v5/account/set-leverage" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": []map[string]string{{"instId": body["instId"], "lever": body["lever"]}}})
+	}))
+	defer srv.Close()
+
+	ex := NewExecutor(Config{LeverageCap: 3, Leverage: map[string]float64{"ETH-USDT-SWAP": 5}})
+	for _, inst := range []string{"BTC-USDT-SWAP", "ETH-USDT-SWAP", "BTC-USDT"} {
+		ex.RegisterInstrument(InstrumentSpec{InstID: inst, TickSize: 0.1, LotSize: 1, CtVal: 1})
+	}
+	okx := NewOKXAdapter(config.ExchangeConfig{BaseURL: srv.URL})
+	if err := ex.PushLeverage(NewResilientAdapter(okx, RetryPolicy{MaxAttempts: 2}), ""); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0]["instId"] != "BTC-USDT-SWAP" || got[0]["lever"] != "3" || got[1]["lever"] != "5" || got[1]["mgnMode"] != "cross" {
+		t.Fatalf("requests: %v", got)
+	}
+}
@@ -0,0 +1,220 @@
+package execution
+
+// 交易所常驻策略单 —— 条件单 / OCO / 计划委托 / 移动止损 + 随单止盈止损
+// =============================================================================
+// 1) 止损不再只依赖 K 线收盘检查：进程退出后，挂在交易所的 reduce-only 条件单仍然生效；
+// 2) SyncStops 以“当前净仓位 + 风控层给出的止损价”为目标，计算需要撤/挂的条件单：
+//    仓位归零即撤；方向/数量变化或触发价移动超过 StopMoveTicks 个 tick 时先挂新单；
+// 3) 新止损在 ConfirmStop 收到交易所确认后才记为常驻，并在此时撤旧止损（换单期间始终有一张在场）；
+//    下发失败则保留旧止损，下次 SyncStops 重挂；
+// 4) 实际收发由 AlgoTrader（OKXAdapter / PaperExchange）完成，执行器只出计划（Plan.Algos）；
+//    ApplyStops 按上述顺序同步下发，FollowRiskStops 直接跟随 risk.Engine 的止损价位。
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"Mod/src/risk"
+)
+
+type AlgoOrdType string
+
+const (
+	AlgoConditional AlgoOrdType = "conditional"     // 单向止盈/止损
+	AlgoOCO         AlgoOrdType = "oco"             // 双向止盈止损（触发一边撤另一边）
+	AlgoTrigger     AlgoOrdType = "trigger"         // 计划委托
+	AlgoTrailing    AlgoOrdType = "move_order_stop" // 移动止损
+)
+
+// TPSL —— 止盈/止损触发价与委托价；委托价为 0 表示触发后市价
+type TPSL struct {
+	TPTriggerPx   float64
+	TPOrdPx       float64
+	SLTriggerPx   float64
+	SLOrdPx       float64
+	TriggerPxType string // last/index/mark，默认 last
+}
+
+// AlgoOrderRequest —— 策略单
+type AlgoOrderRequest struct {
+	InstID     string
+	ClientID   string // algoClOrdId
+	Type       AlgoOrdType
+	Side       Side
+	Qty        float64
+	ReduceOnly bool
+
+	TPSL // conditional / oco
+
+	TriggerPx float64 // trigger：触发价
+	OrdPx     float64 // trigger：触发后委托价（0=市价）
+
+	CallbackRatio  float64 // move_order_stop：回调比例（0.01=1%），与 CallbackSpread 二选一
+	CallbackSpread float64 // move_order_stop：回调价距
+	ActivePx       float64 // move_order_stop：激活价（0=立即激活）
+}
+
+// AlgoCancelRequest —— 撤策略单（AlgoID 为空时由适配器按 ClientID 查找）
+type AlgoCancelRequest struct {
+	InstID   string
+	ClientID string
+	AlgoID   string
+}
+
+// AlgoTrader —— 支持策略单的场所（可选能力，类型断言获取）
+type AlgoTrader interface {
+	SendAlgoOrders([]AlgoOrderRequest) error
+	CancelAlgoOrders([]AlgoCancelRequest) error
+}
+
+// liveStop —— 执行器记录的当前常驻止损
+type liveStop struct {
+	ClientID string
+	Side     Side
+	Qty      float64
+	Trigger  float64
+}
+
+// StopOrder —— 当前常驻止损（无则 ok=false）
+func (ex *Executor) StopOrder(inst string) (AlgoOrderRequest, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st, ok := ex.ins[inst]
+	if !ok || st.stop == nil {
+		return AlgoOrderRequest{}, false
+	}
+	s := st.stop
+	return AlgoOrderRequest{
+		InstID: inst, ClientID: s.ClientID, Type: AlgoConditional, Side: s.Side, Qty: s.Qty,
+		ReduceOnly: true, TPSL: TPSL{SLTriggerPx: s.Trigger},
+	}, true
+}
+
+// SyncStops —— 让交易所常驻止损跟随风控止损价（stopPx<=0 表示不需要止损）；
+// 换挂时计划里只有新止损，旧止损由 ConfirmStop 在确认后给出撤单
+func (ex *Executor) SyncStops(inst string, stopPx float64) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st := ex.ensure(inst)
+	sp := ex.specs[inst]
+	cur := st.stop
+	if ex.halt != nil {
+		return Plan{} // 停机期间由 FlattenPlan 统一撤销
+	}
+
+	if nearlyZero(st.position) || stopPx <= 0 {
+		var plan Plan
+		if cur != nil {
+			plan.AlgoCancels = append(plan.AlgoCancels, AlgoCancelRequest{InstID: inst, ClientID: cur.ClientID})
+		}
+		st.stop, st.stopPending = nil, nil // 未确认的新止损若随后确认，由 ConfirmStop 撤掉
+		return plan
+	}
+
+	side := sideOpposite(st.position)
+	qty := math.Abs(st.position)
+	trig := roundToTick(stopPx, sp.TickSize)
+	if trig <= 0 {
+		return Plan{}
+	}
+
+	tol := float64(ex.cfg.StopMoveTicks) * sp.TickSize
+	matches := func(s *liveStop) bool {
+		if s == nil || s.Side != side || math.Abs(s.Qty-qty) > lotEps {
+			return false
+		}
+		return math.Abs(s.Trigger-trig) < tol || (tol <= 0 && s.Trigger == trig)
+	}
+	if matches(cur) {
+		st.stopPending = nil
+		return Plan{}
+	}
+	if matches(st.stopPending) {
+		return Plan{} // 等待确认
+	}
+
+	req := AlgoOrderRequest{
+		InstID: inst, ClientID: ex.ids.Next("sl"), Type: AlgoConditional, Side: side, Qty: qty,
+		ReduceOnly: true, TPSL: TPSL{SLTriggerPx: trig},
+	}
+	ex.remember(Origin{ClientID: req.ClientID, InstID: inst, Tag: "sl", Signal: st.decision.Signal, Mark: trig, Ts: time.Now()})
+	st.stopPending = &liveStop{ClientID: req.ClientID, Side: side, Qty: qty, Trigger: trig}
+	return Plan{Algos: []AlgoOrderRequest{req}}
+}
+
+// ConfirmStop —— SyncStops 所挂止损的下发结果（err 为 SendAlgoOrders 的返回）：
+// 成功则记为常驻并返回撤旧止损的计划；失败则保留旧止损；已被取代的止损确认后直接撤掉
+func (ex *Executor) ConfirmStop(inst, clientID string, err error) Plan {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	st := ex.ensure(inst)
+	p := st.stopPending
+	if p == nil || p.ClientID != clientID {
+		if err == nil && (st.stop == nil || st.stop.ClientID != clientID) {
+			return Plan{AlgoCancels: []AlgoCancelRequest{{InstID: inst, ClientID: clientID}}}
+		}
+		return Plan{}
+	}
+	st.stopPending = nil
+	if err != nil {
+		log.Printf("⚠️ 常驻止损下发失败 %s %s: %v（保留旧止损，下次重挂）", inst, clientID, err)
+		return Plan{}
+	}
+	old := st.stop
+	st.stop = p
+	if old == nil {
+		return Plan{}
+	}
+	return Plan{AlgoCancels: []AlgoCancelRequest{{InstID: inst, ClientID: old.ClientID}}}
+}
+
+// ApplyStops —— SyncStops 的同步下发：先挂新止损，确认后再撤旧止损
+func (ex *Executor) ApplyStops(at AlgoTrader, inst string, stopPx float64) error {
+	plan := ex.SyncStops(inst, stopPx)
+	var errs []error
+	for _, a := range plan.Algos {
+		err := at.SendAlgoOrders([]AlgoOrderRequest{a})
+		if err != nil {
+			errs = append(errs, err)
+		}
+		plan.AlgoCancels = append(plan.AlgoCancels, ex.ConfirmStop(inst, a.ClientID, err).AlgoCancels...)
+	}
+	if len(plan.AlgoCancels) > 0 {
+		if err := at.CancelAlgoOrders(plan.AlgoCancels); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RiskStops —— 风控层的止损来源（*risk.Engine）
+type RiskStops interface {
+	SetEntry(inst string, position, entryPrice float64)
+	StopLevels(inst string) (risk.StopLevels, bool)
+}
+
+var _ RiskStops = (*risk.Engine)(nil)
+
+// FollowRiskStops —— 把持仓方向与均价同步给风控层，再按其 Effective 止损价同步交易所常驻止损；
+// 调仓途中（批准方向与实际持仓相反）保留风控层原入场价；风控层暂无价位（ATR 未就绪）时不动现有止损
+func (ex *Executor) FollowRiskStops(at AlgoTrader, r RiskStops, inst string) error {
+	ex.mu.Lock()
+	st := ex.ensure(inst)
+	pos, avg, rel := st.position, st.book.avg, st.approved
+	ex.mu.Unlock()
+
+	switch {
+	case nearlyZero(pos):
+		r.SetEntry(inst, 0, 0)
+		return ex.ApplyStops(at, inst, 0)
+	case rel*pos > 0 && avg > 0:
+		r.SetEntry(inst, rel, avg)
+	}
+	lv, ok := r.StopLevels(inst)
+	if !ok {
+		return nil
+	}
+	return ex.ApplyStops(at, inst, lv.Effective)
+}
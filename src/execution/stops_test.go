@@ -0,0 +1,195 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"Mod/src/risk"
+	"Mod/src/stream"
+)
+
+func TestSyncStopsFollowsPositionAndLevel(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1000})
+	ex := NewExecutor(Config{StopMoveTicks: 2})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, ContractValue: 1})
+	p.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, ContractValue: 1})
+	ex.Bind(p)
+
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99.9", AskPx: "100", Last: "100"}})
+	if err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdMarket, Qty: 3, ClientID: "e"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ex.ApplyStops(p, "X", 95); err != nil {
+		t.Fatal(err)
+	}
+	if so, ok := ex.StopOrder("X"); !ok || so.Side != SideSell || so.Qty != 3 || so.SLTriggerPx != 95 {
+		t.Fatalf("unexpected stop: %+v", so)
+	}
+	// 不足 2 tick 的移动不重挂
+	if pl := ex.SyncStops("X", 95.1); len(pl.Algos)+len(pl.AlgoCancels) != 0 {
+		t.Fatalf("stop churned on small move: %+v", pl)
+	}
+	// 跟踪止损上移：计划只挂新单，确认后才撤旧单
+	pl := ex.SyncStops("X", 97)
+	if len(pl.AlgoCancels) != 0 || len(pl.Algos) != 1 || pl.Algos[0].SLTriggerPx != 97 {
+		t.Fatalf("unexpected move plan: %+v", pl)
+	}
+	if so, _ := ex.StopOrder("X"); so.SLTriggerPx != 95 {
+		t.Fatalf("stop recorded before ack: %+v", so)
+	}
+	if err := p.SendAlgoOrders(pl.Algos); err != nil {
+		t.Fatal(err)
+	}
+	next := ex.ConfirmStop("X", pl.Algos[0].ClientID, nil)
+	if len(next.AlgoCancels) != 1 {
+		t.Fatalf("old stop not cancelled after ack: %+v", next)
+	}
+	if err := p.CancelAlgoOrders(next.AlgoCancels); err != nil {
+		t.Fatal(err)
+	}
+	if algos := p.AlgoOrders(); len(algos) != 1 || algos[0].SLTriggerPx != 97 {
+		t.Fatalf("exchange stop out of sync: %+v", algos)
+	}
+
+	// 价格跌破止损：交易所侧触发平仓，执行器仓位同步为 0，再同步即撤销记录
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "96.9", AskPx: "97", Last: "96.9"}})
+	if ex.ensure("X").position != 0 || len(p.AlgoOrders()) != 0 {
+		t.Fatalf("stop did not trigger: pos=%.2f algos=%d", ex.ensure("X").position, len(p.AlgoOrders()))
+	}
+	if pl := ex.SyncStops("X", 97); len(pl.AlgoCancels) != 1 {
+		t.Fatalf("flat position must drop stop: %+v", pl)
+	}
+}
+
+// algoRecorder —— 记录条件单收发顺序，可令下单失败
+type algoRecorder struct {
+	ops  []string
+	fail error
+}
+
+func (r *algoRecorder) SendAlgoOrders(orders []AlgoOrderRequest) error {
+	for _, o := range orders {
+		r.ops = append(r.ops, fmt.Sprintf("send %.1f", o.SLTriggerPx))
+	}
+	return r.fail
+}
+
+func (r *algoRecorder) CancelAlgoOrders(cancels []AlgoCancelRequest) error {
+	for _, c := range cancels {
+		r.ops = append(r.ops, "cancel "+c.ClientID)
+	}
+	return nil
+}
+
+func TestApplyStopsPlacesFirstAndRollsBack(t *testing.T) {
+	ex := NewExecutor(Config{StopMoveTicks: 1})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	ex.OnPositions([]Position{{InstID: "X", Qty: 2, AvgPx: 100}})
+	r := &algoRecorder{fail: errors.New("rejected")}
+
+	// 首次下发失败：不记录止损，下次同步重挂
+	if err := ex.ApplyStops(r, "X", 95); err == nil {
+		t.Fatal("expected send error")
+	}
+	if _, ok := ex.StopOrder("X"); ok {
+		t.Fatal("stop recorded without ack")
+	}
+	r.fail = nil
+	if err := ex.ApplyStops(r, "X", 95); err != nil {
+		t.Fatal(err)
+	}
+	first, ok := ex.StopOrder("X")
+	if !ok || first.SLTriggerPx != 95 {
+		t.Fatalf("stop after retry: %+v", first)
+	}
+
+	// 上移失败：保留旧止损，不撤
+	r.ops, r.fail = nil, errors.New("rejected")
+	if err := ex.ApplyStops(r, "X", 97); err == nil {
+		t.Fatal("expected send error")
+	}
+	if so, _ := ex.StopOrder("X"); so.ClientID != first.ClientID || len(r.ops) != 1 {
+		t.Fatalf("old stop lost on failed move: %+v ops=%v", so, r.ops)
+	}
+
+	// 上移成功：先挂新单，再撤旧单
+	r.ops, r.fail = nil, nil
+	if err := ex.ApplyStops(r, "X", 97); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"send 97.0", "cancel " + first.ClientID}; fmt.Sprint(r.ops) != fmt.Sprint(want) {
+		t.Fatalf("ops=%v want %v", r.ops, want)
+	}
+	if so, _ := ex.StopOrder("X"); so.SLTriggerPx != 97 {
+		t.Fatalf("stop not moved: %+v", so)
+	}
+
+	// 确认前已平仓：迟到的确认直接撤掉
+	pl := ex.SyncStops("X", 99)
+	ex.OnPositions([]Position{{InstID: "X", Qty: 0}})
+	ex.SyncStops("X", 99)
+	if late := ex.ConfirmStop("X", pl.Algos[0].ClientID, nil); len(late.AlgoCancels) != 1 {
+		t.Fatalf("superseded stop not cancelled: %+v", late)
+	}
+}
+
+func TestFollowRiskStops(t *testing.T) {
+	ex := NewExecutor(Config{StopMoveTicks: 1})
+	ex.RegisterInstrument(InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 1})
+	eng := risk.NewEngine(risk.Config{StopATR: 2, TrailATR: 3, BreakevenAfterR: 10})
+	for i := 0; i < 5; i++ {
+		eng.OnCandle(risk.Candle{InstID: "X", O: 100, H: 101, L: 99, C: 100})
+	}
+	r := &algoRecorder{}
+
+	// 无仓位：不挂
+	if err := ex.FollowRiskStops(r, eng, "X"); err != nil || len(r.ops) != 0 {
+		t.Fatalf("err=%v ops=%v", err, r.ops)
+	}
+	ex.mu.Lock()
+	ex.ensure("X").approved = 0.5
+	ex.mu.Unlock()
+	ex.OnPositions([]Position{{InstID: "X", Qty: 2, AvgPx: 100}})
+	if err := ex.FollowRiskStops(r, eng, "X"); err != nil {
+		t.Fatal(err)
+	}
+	lv, ok := eng.StopLevels("X")
+	if !ok || lv.Entry != 100 || !lv.Long {
+		t.Fatalf("risk entry not synced: %+v", lv)
+	}
+	so, ok := ex.StopOrder("X")
+	if !ok || so.Side != SideSell || so.Qty != 2 || !approx(so.SLTriggerPx, roundToTick(lv.Effective, 0.1)) {
+		t.Fatalf("stop %+v levels %+v", so, lv)
+	}
+
+	// 平仓：风控层清空入场价，常驻止损撤销
+	ex.OnPositions([]Position{{InstID: "X", Qty: 0}})
+	if err := ex.FollowRiskStops(r, eng, "X"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := eng.StopLevels("X"); ok {
+		t.Fatal("risk entry not cleared")
+	}
+	if _, ok := ex.StopOrder("X"); ok || r.ops[len(r.ops)-1] != "cancel "+so.ClientID {
+		t.Fatalf("stop not cancelled: ops=%v", r.ops)
+	}
+}
+
+func TestPaperAttachedTPSL(t *testing.T) {
+	p := NewPaperExchange(PaperConfig{InitialCash: 1000})
+	p.OnTickers([]stream.TickerData{{InstID: "X", BidPx: "99.9", AskPx: "100", Last: "100"}})
+	err := p.SendOrders([]OrderRequest{{InstID: "X", Side: SideBuy, Type: OrdMarket, Qty: 2, ClientID: "e",
+		Attach: &TPSL{TPTriggerPx: 105, SLTriggerPx: 95}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algos := p.AlgoOrders(); len(algos) != 1 || algos[0].Type != AlgoOCO || algos[0].Qty != 2 || !algos[0].ReduceOnly {
+		t.Fatalf("unexpected attached algo: %+v", algos)
+	}
+	p.OnTrades([]stream.TradeData{{InstID: "X", Px: "105.5", Sz: "1"}})
+	if ps := p.Positions(); len(ps) != 1 || ps[0].Qty != 0 {
+		t.Fatalf("take-profit not executed: %+v", ps)
+	}
+}
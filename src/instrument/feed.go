@@ -0,0 +1,84 @@
+package instrument
+
+// 规格下发 —— 执行层 InstrumentSpec / 策略 EliteParams / 回测价格取整共用同一份 Spec
+
+import (
+	"math"
+
+	"Mod/src/execution"
+	"Mod/src/strategy"
+)
+
+// ExecSpec —— 转为执行层规格
+// 现货/线性合约：CtVal = 每张标的数量（现货按 1 单位基础币）；币本位：ContractValue = 每张面值（计价币）
+func ExecSpec(s Spec) execution.InstrumentSpec {
+	es := execution.InstrumentSpec{
+		InstID:   s.InstID,
+		TickSize: s.TickSize,
+		LotSize:  s.LotSize,
+		MinQty:   s.MinSize,
+	}
+	switch {
+	case s.InstType == "SPOT":
+		es.CtVal = 1
+	case s.Inverse():
+		es.ContractValue, es.Inverse = s.CtVal*mult(s), true
+	default:
+		es.CtVal = s.CtVal * mult(s)
+	}
+	return es
+}
+
+// Registrar —— 接收执行层规格（*execution.Executor、*execution.PaperExchange）
+type Registrar interface {
+	RegisterInstrument(execution.InstrumentSpec)
+}
+
+// FeedExecutor —— 注册指定品种（为空则全部），之后每次加载/刷新的新增与变化自动重新注册；
+// 在 Start 之前或之后调用都可以
+func (c *Catalog) FeedExecutor(ex Registrar, instIDs ...string) {
+	want := make(map[string]bool, len(instIDs))
+	for _, id := range instIDs {
+		want[id] = true
+	}
+	c.Feed(func(s Spec) {
+		if len(want) == 0 || want[s.InstID] {
+			ex.RegisterInstrument(ExecSpec(s))
+		}
+	})
+}
+
+// ApplyElite —— 写入该品种的交易所约束（EliteParams.Instruments[InstID]：LotSize / TickSize / ContractMultiplier）；
+// 多品种各写各的，不改全局值
+func ApplyElite(s Spec, p *strategy.EliteParams) {
+	c := strategy.InstrumentConstraints{LotSize: s.LotSize, TickSize: s.TickSize}
+	switch {
+	case s.InstType == "SPOT":
+		c.ContractMultiplier = 1
+	case !s.Inverse() && s.CtVal > 0:
+		c.ContractMultiplier = s.CtVal * mult(s)
+	}
+	if p.Instruments == nil {
+		p.Instruments = make(map[string]strategy.InstrumentConstraints)
+	}
+	p.Instruments[s.InstID] = c
+}
+
+// RoundPx —— 按 tick 保守取整：买向上、卖向下（回测成交价用）
+func (s Spec) RoundPx(px float64, side string) float64 {
+	if s.TickSize <= 0 || px <= 0 {
+		return px
+	}
+	steps := px / s.TickSize
+	if side == "buy" {
+		return math.Ceil(steps-1e-9) * s.TickSize
+	}
+	return math.Floor(steps+1e-9) * s.TickSize
+}
+
+func mult(s Spec) float64 {
+	if s.CtMult > 0 {
+		return s.CtMult
+	}
+	return 1
+}
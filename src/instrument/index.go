@@ -0,0 +1,427 @@
+package instrument
+
+// Instrument —— 合约规格目录（OKX /api/v5/public/instruments）
+// =============================================================================
+// 1) 拉取 SWAP / FUTURES / SPOT 全量规格（tickSz / lotSz / minSz / ctVal / ctMult ...）；
+// 2) 以 JSON 落盘到 DataDir，冷启动/离线回测直接读文件，不依赖网络；
+// 3) 定时刷新，与上一版逐字段比对，规格变化（含上/下架）走日志告警 + OnChange 回调；
+// 4) 同一份规格同时喂给执行层（InstrumentSpec）与策略/回测取整（见 feed.go）；
+//    Feed 订阅者先收到当前全部规格，之后收到每次加载/刷新的新增与变化（含首次加载）。
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Spec —— 单个品种规格（字段含义与 OKX 一致，数值已解析）
+type Spec struct {
+	InstID    string  `json:"instId"`
+	InstType  string  `json:"instType"` // SPOT/SWAP/FUTURES
+	BaseCcy   string  `json:"baseCcy,omitempty"`
+	QuoteCcy  string  `json:"quoteCcy,omitempty"`
+	SettleCcy string  `json:"settleCcy,omitempty"`
+	CtType    string  `json:"ctType,omitempty"` // linear/inverse（仅衍生品）
+	CtVal     float64 `json:"ctVal,omitempty"`
+	CtMult    float64 `json:"ctMult,omitempty"`
+	CtValCcy  string  `json:"ctValCcy,omitempty"`
+	TickSize  float64 `json:"tickSz"`
+	LotSize   float64 `json:"lotSz"`
+	MinSize   float64 `json:"minSz"`
+	MaxLmtSz  float64 `json:"maxLmtSz,omitempty"`
+	MaxMktSz  float64 `json:"maxMktSz,omitempty"`
+	State     string  `json:"state"`
+	ListTime  int64   `json:"listTime,omitempty"`
+	ExpTime   int64   `json:"expTime,omitempty"`
+}
+
+// Inverse —— 币本位合约（每张面值以计价币计）
+func (s Spec) Inverse() bool { return s.CtType == "inverse" }
+
+// Change —— 规格变化
+type Change struct {
+	InstID string
+	Kind   string   // added / removed / changed
+	Fields []string // changed 时变化的字段
+	Old    *Spec
+	New    *Spec
+}
+
+func (c Change) String() string {
+	if c.Kind == "changed" {
+		return fmt.Sprintf("%s %s %v", c.Kind, c.InstID, c.Fields)
+	}
+	return c.Kind + " " + c.InstID
+}
+
+// Fetcher —— 公共 REST 读取（*stream.HybridClient 满足该接口）
+type Fetcher interface {
+	GetPublic(path string, query url.Values) (json.RawMessage, error)
+}
+
+type Config struct {
+	DataDir      string        // 落盘目录
+	FileName     string        // 默认 instruments.json
+	InstTypes    []string      // 默认 SWAP/FUTURES/SPOT
+	RefreshEvery time.Duration // 默认 1h；<0 关闭定时刷新
+}
+
+func (c *Config) withDefaults() Config {
+	q := *c
+	if q.DataDir == "" {
+		q.DataDir = "./data"
+	}
+	if q.FileName == "" {
+		q.FileName = "instruments.json"
+	}
+	if len(q.InstTypes) == 0 {
+		q.InstTypes = []string{"SWAP", "FUTURES", "SPOT"}
+	}
+	if q.RefreshEvery == 0 {
+		q.RefreshEvery = time.Hour
+	}
+	return q
+}
+
+// ===================== 目录主体 =====================
+
+type Catalog struct {
+	cfg Config
+	src Fetcher
+
+	mu       sync.RWMutex
+	specs    map[string]Spec
+	updated  time.Time
+	handlers []func([]Change)
+
+	feedMu sync.Mutex // 串行化规格下发（回放与刷新不交错）
+	feeds  []func(Spec)
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewCatalog —— src 可为 nil（仅读本地文件，用于离线回测）
+func NewCatalog(cfg Config, src Fetcher) *Catalog {
+	return &Catalog{
+		cfg:   cfg.withDefaults(),
+		src:   src,
+		specs: make(map[string]Spec),
+		stop:  make(chan struct{}),
+	}
+}
+
+func (c *Catalog) Path() string { return filepath.Join(c.cfg.DataDir, c.cfg.FileName) }
+
+func (c *Catalog) Get(instID string) (Spec, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.specs[instID]
+	return s, ok
+}
+
+// All —— 全部规格（按 InstID 排序）
+func (c *Catalog) All() []Spec {
+	c.mu.RLock()
+	out := make([]Spec, 0, len(c.specs))
+	for _, s := range c.specs {
+		out = append(out, s)
+	}
+	c.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].InstID < out[j].InstID })
+	return out
+}
+
+func (c *Catalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.specs)
+}
+
+func (c *Catalog) UpdatedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updated
+}
+
+// OnChange —— 规格变化回调（刷新线程内同步调用）
+func (c *Catalog) OnChange(h func([]Change)) {
+	c.mu.Lock()
+	c.handlers = append(c.handlers, h)
+	c.mu.Unlock()
+}
+
+// Feed —— 规格下发：注册时回放当前全部规格，之后每次 Load / Refresh 新增或变化的规格
+// （含首次加载）逐个回调；与调用 Start 的先后无关
+func (c *Catalog) Feed(h func(Spec)) {
+	c.feedMu.Lock()
+	defer c.feedMu.Unlock()
+	c.mu.Lock()
+	c.feeds = append(c.feeds, h)
+	c.mu.Unlock()
+	for _, s := range c.All() {
+		h(s)
+	}
+}
+
+// deliver —— 把新增/变化的规格交给 Feed 订阅者
+func (c *Catalog) deliver(changes []Change) {
+	c.feedMu.Lock()
+	defer c.feedMu.Unlock()
+	c.mu.RLock()
+	fs := append([]func(Spec){}, c.feeds...)
+	c.mu.RUnlock()
+	for _, ch := range changes {
+		if ch.New == nil {
+			continue
+		}
+		for _, f := range fs {
+			f(*ch.New)
+		}
+	}
+}
+
+// ===================== 持久化 =====================
+
+type fileFormat struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+	Specs     []Spec    `json:"specs"`
+}
+
+// Load —— 读取落盘规格（文件不存在返回 os.ErrNotExist）
+func (c *Catalog) Load() error {
+	b, err := os.ReadFile(c.Path())
+	if err != nil {
+		return err
+	}
+	var ff fileFormat
+	if err := json.Unmarshal(b, &ff); err != nil {
+		return fmt.Errorf("instrument: 解析 %s 失败: %v", c.Path(), err)
+	}
+	m := make(map[string]Spec, len(ff.Specs))
+	for _, s := range ff.Specs {
+		m[s.InstID] = s
+	}
+	c.mu.Lock()
+	changes := Diff(c.specs, m)
+	c.specs, c.updated = m, ff.UpdatedAt
+	c.mu.Unlock()
+	c.deliver(changes)
+	return nil
+}
+
+// save —— 先写临时文件再 rename，避免半截文件
+func (c *Catalog) save(specs []Spec, ts time.Time) error {
+	if err := os.MkdirAll(c.cfg.DataDir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(fileFormat{UpdatedAt: ts, Specs: specs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.Path() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path())
+}
+
+// ===================== 拉取与刷新 =====================
+
+type okxInstrument struct {
+	InstType  string `json:"instType"`
+	InstID    string `json:"instId"`
+	BaseCcy   string `json:"baseCcy"`
+	QuoteCcy  string `json:"quoteCcy"`
+	SettleCcy string `json:"settleCcy"`
+	CtType    string `json:"ctType"`
+	CtVal     string `json:"ctVal"`
+	CtMult    string `json:"ctMult"`
+	CtValCcy  string `json:"ctValCcy"`
+	TickSz    string `json:"tickSz"`
+	LotSz     string `json:"lotSz"`
+	MinSz     string `json:"minSz"`
+	MaxLmtSz  string `json:"maxLmtSz"`
+	MaxMktSz  string `json:"maxMktSz"`
+	State     string `json:"state"`
+	ListTime  string `json:"listTime"`
+	ExpTime   string `json:"expTime"`
+}
+
+func (o okxInstrument) spec() Spec {
+	return Spec{
+		InstID: o.InstID, InstType: o.InstType,
+		BaseCcy: o.BaseCcy, QuoteCcy: o.QuoteCcy, SettleCcy: o.SettleCcy,
+		CtType: o.CtType, CtVal: atof(o.CtVal), CtMult: atof(o.CtMult), CtValCcy: o.CtValCcy,
+		TickSize: atof(o.TickSz), LotSize: atof(o.LotSz), MinSize: atof(o.MinSz),
+		MaxLmtSz: atof(o.MaxLmtSz), MaxMktSz: atof(o.MaxMktSz),
+		State: o.State, ListTime: atoi(o.ListTime), ExpTime: atoi(o.ExpTime),
+	}
+}
+
+// Fetch —— 拉取配置中全部品种类型（任一类型失败即整体失败，避免误判下架）
+func (c *Catalog) Fetch() ([]Spec, error) {
+	if c.src == nil {
+		return nil, fmt.Errorf("instrument: 未配置数据源")
+	}
+	var out []Spec
+	for _, t := range c.cfg.InstTypes {
+		raw, err := c.src.GetPublic("/api/v5/public/instruments", url.Values{"instType": {t}})
+		if err != nil {
+			return nil, fmt.Errorf("instrument: 拉取 %s 失败: %v", t, err)
+		}
+		var rows []okxInstrument
+		if err := json.Unmarshal(raw, &rows); err != nil {
+			return nil, fmt.Errorf("instrument: 解析 %s 失败: %v", t, err)
+		}
+		for _, r := range rows {
+			if r.InstID != "" {
+				out = append(out, r.spec())
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstID < out[j].InstID })
+	return out, nil
+}
+
+// Refresh —— 拉取 → 比对 → 替换 → 落盘 → 下发（Feed）→ 告警；首次加载（本地为空）只下发不告警
+func (c *Catalog) Refresh() ([]Change, error) {
+	specs, err := c.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("instrument: 返回为空")
+	}
+	next := make(map[string]Spec, len(specs))
+	for _, s := range specs {
+		next[s.InstID] = s
+	}
+	now := time.Now().UTC()
+
+	c.mu.Lock()
+	first := len(c.specs) == 0
+	changes := Diff(c.specs, next)
+	c.specs, c.updated = next, now
+	hs := append([]func([]Change){}, c.handlers...)
+	c.mu.Unlock()
+
+	if err := c.save(specs, now); err != nil {
+		log.Printf("⚠️ 合约规格落盘失败: %v", err)
+	}
+	c.deliver(changes)
+	if first || len(changes) == 0 {
+		return nil, nil
+	}
+	for _, ch := range changes {
+		log.Printf("⚠️ 合约规格变更: %s", ch)
+	}
+	for _, h := range hs {
+		h(changes)
+	}
+	return changes, nil
+}
+
+// Start —— 先读本地，再立即刷新一次，之后按 RefreshEvery 定时刷新
+func (c *Catalog) Start() error {
+	if err := c.Load(); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️ 读取本地合约规格失败: %v", err)
+	}
+	if c.src == nil {
+		if c.Len() == 0 {
+			return fmt.Errorf("instrument: 本地无规格且未配置数据源")
+		}
+		return nil
+	}
+	if _, err := c.Refresh(); err != nil {
+		if c.Len() == 0 {
+			return err
+		}
+		log.Printf("⚠️ 合约规格刷新失败，沿用本地副本: %v", err)
+	}
+	if c.cfg.RefreshEvery > 0 {
+		go c.loop()
+	}
+	return nil
+}
+
+func (c *Catalog) loop() {
+	t := time.NewTicker(c.cfg.RefreshEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			if _, err := c.Refresh(); err != nil {
+				log.Printf("⚠️ 合约规格刷新失败: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Catalog) Close() { c.once.Do(func() { close(c.stop) }) }
+
+// ===================== 比对 =====================
+
+// Diff —— old → next 的规格变化（按 InstID 排序）
+func Diff(old, next map[string]Spec) []Change {
+	var out []Change
+	for id, n := range next {
+		n := n
+		o, ok := old[id]
+		if !ok {
+			out = append(out, Change{InstID: id, Kind: "added", New: &n})
+			continue
+		}
+		if f := changedFields(o, n); len(f) > 0 {
+			o := o
+			out = append(out, Change{InstID: id, Kind: "changed", Fields: f, Old: &o, New: &n})
+		}
+	}
+	for id, o := range old {
+		if _, ok := next[id]; !ok {
+			o := o
+			out = append(out, Change{InstID: id, Kind: "removed", Old: &o})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstID < out[j].InstID })
+	return out
+}
+
+func changedFields(a, b Spec) []string {
+	var f []string
+	add := func(name string, diff bool) {
+		if diff {
+			f = append(f, name)
+		}
+	}
+	add("tickSz", a.TickSize != b.TickSize)
+	add("lotSz", a.LotSize != b.LotSize)
+	add("minSz", a.MinSize != b.MinSize)
+	add("ctVal", a.CtVal != b.CtVal)
+	add("ctMult", a.CtMult != b.CtMult)
+	add("ctType", a.CtType != b.CtType)
+	add("maxLmtSz", a.MaxLmtSz != b.MaxLmtSz)
+	add("maxMktSz", a.MaxMktSz != b.MaxMktSz)
+	add("state", a.State != b.State)
+	add("expTime", a.ExpTime != b.ExpTime)
+	return f
+}
+
+func atof(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func atoi(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
@@ -0,0 +1,119 @@
+package instrument
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"Mod/src/execution"
+	"Mod/src/strategy"
+)
+
+type fakeFetcher struct{ rows map[string]string }
+
+func (f *fakeFetcher) GetPublic(path string, q url.Values) (json.RawMessage, error) {
+	if path != "/api/v5/public/instruments" {
+		return nil, fmt.Errorf("unexpected path %s", path)
+	}
+	return json.RawMessage(f.rows[q.Get("instType")]), nil
+}
+
+func TestCatalogRefreshPersistsAndAlertsOnChange(t *testing.T) {
+	f := &fakeFetcher{rows: map[string]string{
+		"SWAP":    `[{"instType":"SWAP","instId":"BTC-USDT-SWAP","ctType":"linear","ctVal":"0.01","ctMult":"1","tickSz":"0.1","lotSz":"0.01","minSz":"0.01","state":"live"}]`,
+		"FUTURES": `[]`,
+		"SPOT":    `[{"instType":"SPOT","instId":"BTC-USDT","tickSz":"0.1","lotSz":"0.00000001","minSz":"0.00001","state":"live"}]`,
+	}}
+	dir := t.TempDir()
+	cat := NewCatalog(Config{DataDir: dir, RefreshEvery: -1}, f)
+	var alerts []Change
+	cat.OnChange(func(chs []Change) { alerts = append(alerts, chs...) })
+
+	if _, err := cat.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 || cat.Len() != 2 {
+		t.Fatalf("first load: alerts=%v len=%d", alerts, cat.Len())
+	}
+
+	f.rows["SWAP"] = `[{"instType":"SWAP","instId":"BTC-USDT-SWAP","ctType":"linear","ctVal":"0.01","ctMult":"1","tickSz":"0.5","lotSz":"0.01","minSz":"0.01","state":"live"}]`
+	f.rows["SPOT"] = `[]`
+	if _, err := cat.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].Kind != "removed" || alerts[1].Kind != "changed" || alerts[1].Fields[0] != "tickSz" {
+		t.Fatalf("unexpected alerts: %v", alerts)
+	}
+
+	// 离线读取落盘副本
+	off := NewCatalog(Config{DataDir: dir}, nil)
+	if err := off.Load(); err != nil {
+		t.Fatal(err)
+	}
+	s, ok := off.Get("BTC-USDT-SWAP")
+	if !ok || s.TickSize != 0.5 {
+		t.Fatalf("persisted spec mismatch: %+v", s)
+	}
+	es := ExecSpec(s)
+	if es.CtVal != 0.01 || es.LotSize != 0.01 || es.MinQty != 0.01 || es.ContractValue != 0 {
+		t.Fatalf("unexpected exec spec: %+v", es)
+	}
+	if got := s.RoundPx(100.2, "buy"); got != 100.5 {
+		t.Fatalf("RoundPx buy = %v", got)
+	}
+}
+
+func TestApplyEliteKeepsSpecsPerInstrument(t *testing.T) {
+	var p strategy.EliteParams
+	p.LotSize, p.TickSize = 0.001, 0.1
+	ApplyElite(Spec{InstID: "BTC-USDT-SWAP", InstType: "SWAP", CtType: "linear", CtVal: 0.01, CtMult: 1, LotSize: 0.01, TickSize: 0.1}, &p)
+	ApplyElite(Spec{InstID: "DOGE-USDT-SWAP", InstType: "SWAP", CtType: "linear", CtVal: 1000, CtMult: 1, LotSize: 1, TickSize: 0.00001}, &p)
+
+	btc, doge := p.Instruments["BTC-USDT-SWAP"], p.Instruments["DOGE-USDT-SWAP"]
+	if btc.LotSize != 0.01 || btc.ContractMultiplier != 0.01 || doge.LotSize != 1 || doge.TickSize != 0.00001 || doge.ContractMultiplier != 1000 {
+		t.Fatalf("constraints: btc=%+v doge=%+v", btc, doge)
+	}
+	if p.LotSize != 0.001 || p.TickSize != 0.1 {
+		t.Fatalf("global defaults overwritten: lot=%v tick=%v", p.LotSize, p.TickSize)
+	}
+}
+
+type registrar struct {
+	got map[string]execution.InstrumentSpec
+}
+
+func (r *registrar) RegisterInstrument(s execution.InstrumentSpec) { r.got[s.InstID] = s }
+
+func TestFeedExecutorBeforeFirstLoad(t *testing.T) {
+	f := &fakeFetcher{rows: map[string]string{
+		"SWAP": `[{"instType":"SWAP","instId":"BTC-USDT-SWAP","ctType":"linear","ctVal":"0.01","ctMult":"1","tickSz":"0.1","lotSz":"0.01","minSz":"0.01","state":"live"},
+			{"instType":"SWAP","instId":"ETH-USDT-SWAP","ctType":"linear","ctVal":"0.1","ctMult":"1","tickSz":"0.01","lotSz":"0.01","minSz":"0.01","state":"live"}]`,
+	}}
+	cat := NewCatalog(Config{DataDir: t.TempDir(), InstTypes: []string{"SWAP"}, RefreshEvery: -1}, f)
+	r := &registrar{got: make(map[string]execution.InstrumentSpec)}
+	cat.FeedExecutor(r, "BTC-USDT-SWAP") // 尚未加载：注册即空回放
+	if err := cat.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.got) != 1 || r.got["BTC-USDT-SWAP"].TickSize != 0.1 {
+		t.Fatalf("first load not fed: %+v", r.got)
+	}
+
+	// 之后的变化重新注册
+	f.rows["SWAP"] = strings.Replace(f.rows["SWAP"], `"tickSz":"0.1"`, `"tickSz":"0.5"`, 1)
+	if _, err := cat.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if r.got["BTC-USDT-SWAP"].TickSize != 0.5 {
+		t.Fatalf("change not fed: %+v", r.got)
+	}
+
+	// 加载后再接入：回放当前规格
+	late := &registrar{got: make(map[string]execution.InstrumentSpec)}
+	cat.FeedExecutor(late)
+	if len(late.got) != 2 {
+		t.Fatalf("late feed: %+v", late.got)
+	}
+}
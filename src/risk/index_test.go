@@ -0,0 +1,57 @@
+package risk
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStopLevelsTrailAndBreakeven(t *testing.T) {
+	e := NewEngine(Config{StopATR: 2, TrailATR: 3, BreakevenAfterR: 1})
+	for i := 0; i < 3; i++ {
+		e.OnCandle(Candle{InstID: "X", O: 100, H: 101, L: 99, C: 100})
+	}
+	if _, ok := e.StopLevels("X"); ok {
+		t.Fatal("levels without entry")
+	}
+	e.SetEntry("X", 0.5, 100)
+	lv, ok := e.StopLevels("X")
+	if !ok || !lv.Long || lv.ATR <= 0 {
+		t.Fatalf("levels: %+v", lv)
+	}
+	if math.Abs(lv.Stop-(100-2*lv.ATR)) > 1e-9 || lv.Effective != lv.Stop {
+		t.Fatalf("initial stop: %+v", lv)
+	}
+
+	// 最优价走出 1 倍 ATR：止损抬到保本，跟踪止损更紧时取跟踪
+	e.OnCandle(Candle{InstID: "X", O: 100, H: 110, L: 100, C: 109})
+	lv, _ = e.StopLevels("X")
+	if lv.Stop != 100 || math.Abs(lv.Trail-(110-3*lv.ATR)) > 1e-9 {
+		t.Fatalf("after run-up: %+v", lv)
+	}
+	if want := math.Max(lv.Stop, lv.Trail); lv.Effective != want {
+		t.Fatalf("effective %.4f want %.4f", lv.Effective, want)
+	}
+
+	// 空头：方向相反
+	e.SetEntry("X", -0.5, 109)
+	lv, _ = e.StopLevels("X")
+	if lv.Long || lv.Stop <= 109 {
+		t.Fatalf("short levels: %+v", lv)
+	}
+	e.SetEntry("X", 0, 0)
+	if _, ok := e.StopLevels("X"); ok {
+		t.Fatal("levels after flat")
+	}
+}
+
+func TestOnCandleDoesNotArmTimeStop(t *testing.T) {
+	e := NewEngine(Config{StopATR: 5, TrailATR: 5, BreakevenAfterR: 100, TimeStopBars: 3, MinPositionStep: 1e-9})
+	e.SetEntry("X", 0.5, 100)
+	for i := 0; i < 10; i++ {
+		e.OnCandle(Candle{InstID: "X", O: 100, H: 100.5, L: 99.5, C: 100})
+	}
+	// 价格停在入场价、持仓已超过 TimeStopBars 根：OnCandle 不推进时间止损
+	if _, acts := e.Approve("X", 0.5, 0.5, 100, 0); len(acts) != 0 {
+		t.Fatalf("unexpected actions: %+v", acts)
+	}
+}
@@ -0,0 +1,451 @@
+package stream
+
+// 成交 → K线 —— 自定义 bar（时间 / 成交量 / 成交额 / 笔数 / 不平衡）
+// =============================================================================
+// 1) 输入 TradeData（实时 OnTrade 或离线录制的成交），输出 []Candle，回调类型与 OnCandle 相同；
+// 2) 时间 bar 以 epoch 对齐（支持秒级及以下，最小 1ms 且须为整毫秒——成交时间戳精度为毫秒），水位 = 该品种最大成交 ts（或 Advance 的时钟），
+//    bar 在 水位 ≥ 结束时间 + Lateness 时闭合；迟到超过 Lateness 的成交丢弃并计数；无成交的区间不出 bar；
+// 3) 成交量 / 成交额 / 笔数 bar：累计达到 Threshold 即闭合（按到达顺序；触发成交归入当前 bar）；
+// 4) 不平衡 bar（López de Prado）：θ = Σ b·w（b 为主动方向 ±1，w 为 1 / 张数 / 成交额），
+//    |θ| ≥ E[T]·max(|E[b·w]|, MinImbalance·E[w]) 时闭合，E[·] 为按 bar 更新的 EWMA；
+// 5) 按 TradeID 去重（重连后重复推送）。
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BarType —— bar 类型
+type BarType string
+
+const (
+	BarTime      BarType = "time"
+	BarVolume    BarType = "volume"
+	BarDollar    BarType = "dollar"
+	BarTick      BarType = "tick"
+	BarImbalance BarType = "imbalance"
+)
+
+const barSeenCap = 4096 // 每品种去重窗口
+
+// BarSpec —— bar 参数
+type BarSpec struct {
+	Type      BarType
+	Interval  time.Duration // time：周期（如 time.Second、15*time.Second、time.Minute；整毫秒，≥1ms）
+	Threshold float64       // volume：张；dollar：计价币；tick：笔
+	CtVal     float64       // dollar / 不平衡按成交额：每张面值（默认 1）
+	Lateness  time.Duration // 允许乱序 / 迟到（默认 2s）
+
+	// 不平衡 bar
+	ImbalanceOf   BarType // BarTick / BarVolume / BarDollar（默认 BarTick）
+	ExpectedTicks float64 // 初始 E[T]（默认 100）
+	Alpha         float64 // EWMA 系数（默认 0.1）
+	MinImbalance  float64 // |E[b]| 下限，防止阈值塌缩（默认 0.1）
+}
+
+func (s BarSpec) withDefaults() BarSpec {
+	if s.CtVal <= 0 {
+		s.CtVal = 1
+	}
+	if s.Lateness <= 0 {
+		s.Lateness = 2 * time.Second
+	}
+	if s.ImbalanceOf == "" {
+		s.ImbalanceOf = BarTick
+	}
+	if s.ExpectedTicks <= 0 {
+		s.ExpectedTicks = 100
+	}
+	if s.Alpha <= 0 || s.Alpha > 1 {
+		s.Alpha = 0.1
+	}
+	if s.MinImbalance <= 0 {
+		s.MinImbalance = 0.1
+	}
+	return s
+}
+
+func (s BarSpec) validate() error {
+	switch s.Type {
+	case BarTime:
+		if s.Interval < time.Millisecond || s.Interval%time.Millisecond != 0 {
+			return fmt.Errorf("时间 bar 的 Interval 须为 ≥1ms 的整毫秒（成交时间戳精度为毫秒）: %v", s.Interval)
+		}
+	case BarVolume, BarDollar, BarTick:
+		if s.Threshold <= 0 {
+			return fmt.Errorf("%s bar 需要 Threshold > 0", s.Type)
+		}
+	case BarImbalance:
+		switch s.ImbalanceOf {
+		case BarTick, BarVolume, BarDollar:
+		default:
+			return fmt.Errorf("不支持的不平衡权重: %s", s.ImbalanceOf)
+		}
+	default:
+		return fmt.Errorf("不支持的 bar 类型: %s", s.Type)
+	}
+	return nil
+}
+
+// Label —— 写入 Candle.TF 的周期名（如 1s / 15m / vol:100 / dollar:1e+06 / tick:500 / imb:tick）
+func (s BarSpec) Label() string {
+	num := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	switch s.Type {
+	case BarTime:
+		return durationLabel(s.Interval)
+	case BarVolume:
+		return "vol:" + num(s.Threshold)
+	case BarDollar:
+		return "dollar:" + num(s.Threshold)
+	case BarTick:
+		return "tick:" + num(s.Threshold)
+	case BarImbalance:
+		return "imb:" + string(s.ImbalanceOf)
+	}
+	return string(s.Type)
+}
+
+func durationLabel(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+// BarStats —— 计数
+type BarStats struct {
+	Trades uint64 `json:"trades"`
+	Bars   uint64 `json:"bars"`
+	Late   uint64 `json:"late"` // 超过 Lateness 被丢弃
+	Dups   uint64 `json:"dups"` // TradeID 重复
+	Bad    uint64 `json:"bad"`  // 价格/数量/时间无法解析
+}
+
+// BarBuilder —— 成交聚合器（并发安全）
+type BarBuilder struct {
+	spec  BarSpec
+	label string
+	step  int64 // 时间 bar 周期（ms）
+	late  int64 // Lateness（ms）
+
+	mu       sync.Mutex
+	states   map[string]*barState
+	stats    BarStats
+	handlers handlerSet[func([]Candle)]
+}
+
+type barState struct {
+	watermark int64 // 最大成交 ts / Advance 时钟（ms）
+
+	// 时间 bar
+	pending  map[int64]*timeBar // bar 起点 -> bar
+	closedTo int64              // 该时刻之前的 bar 已下发
+
+	// 事件 bar
+	cur   *Candle
+	acc   float64 // 累计量 / 额 / 笔
+	n     float64 // 当前 bar 笔数
+	theta float64 // Σ b·w
+	sumW  float64 // Σ w
+	eT    float64 // E[T]
+	eBW   float64 // E[b·w]
+	eW    float64 // E[w]
+
+	seen  map[string]struct{}
+	seenQ []string
+}
+
+// timeBar —— 时间 bar 及其首末成交 ts（乱序成交按 ts 决定开/收）
+type timeBar struct {
+	k           Candle
+	first, last int64
+}
+
+// NewBarBuilder —— spec 不合法时返回错误
+func NewBarBuilder(spec BarSpec) (*BarBuilder, error) {
+	spec = spec.withDefaults()
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &BarBuilder{
+		spec:   spec,
+		label:  spec.Label(),
+		step:   spec.Interval.Milliseconds(),
+		late:   spec.Lateness.Milliseconds(),
+		states: make(map[string]*barState),
+	}, nil
+}
+
+// OnCandle —— 闭合 bar 回调（与 HybridClient.OnCandle 同型）
+func (b *BarBuilder) OnCandle(handler func([]Candle)) Subscription {
+	return b.handlers.add(handler)
+}
+
+// Stats —— 计数快照
+func (b *BarBuilder) Stats() BarStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// AddTrades —— 喂入一批成交（可直接作为 OnTrade 回调）
+func (b *BarBuilder) AddTrades(arr []TradeData) {
+	b.mu.Lock()
+	var out []Candle
+	for _, t := range arr {
+		out = append(out, b.addLocked(t)...)
+	}
+	b.mu.Unlock()
+	emitBars(b.handlers.snapshot(), out)
+}
+
+// Advance —— 用时钟推进所有品种的水位（行情清淡时让时间 bar 按时闭合）
+func (b *BarBuilder) Advance(now time.Time) {
+	if b.spec.Type != BarTime {
+		return
+	}
+	ms := now.UnixMilli()
+	b.mu.Lock()
+	var out []Candle
+	for _, st := range b.states {
+		if ms > st.watermark {
+			st.watermark = ms
+		}
+		out = append(out, b.closeTimeBars(st, false)...)
+	}
+	b.mu.Unlock()
+	emitBars(b.handlers.snapshot(), out)
+}
+
+// Flush —— 下发所有未闭合的 bar（离线数据结束时调用）
+func (b *BarBuilder) Flush() {
+	b.mu.Lock()
+	var out []Candle
+	for _, st := range b.states {
+		if b.spec.Type == BarTime {
+			out = append(out, b.closeTimeBars(st, true)...)
+		} else if st.cur != nil {
+			out = append(out, b.closeEventBar(st))
+		}
+	}
+	b.mu.Unlock()
+	emitBars(b.handlers.snapshot(), out)
+}
+
+// BuildBars —— 离线：对一段成交（如录制回放）生成 bar，末尾未满的 bar 也输出
+func BuildBars(spec BarSpec, trades []TradeData) ([]Candle, error) {
+	b, err := NewBarBuilder(spec)
+	if err != nil {
+		return nil, err
+	}
+	var out []Candle
+	b.OnCandle(func(cs []Candle) { out = append(out, cs...) })
+	b.AddTrades(trades)
+	b.Flush()
+	return out, nil
+}
+
+// TradeBars —— 接到实时成交：订阅方自行 SubscribeTrades；时间 bar 另起时钟推进，随 Close 停止
+func (c *HybridClient) TradeBars(spec BarSpec) (*BarBuilder, error) {
+	b, err := NewBarBuilder(spec)
+	if err != nil {
+		return nil, err
+	}
+	c.OnTrade(b.AddTrades)
+	if b.spec.Type == BarTime {
+		every := b.spec.Interval / 4
+		if every < 100*time.Millisecond {
+			every = 100 * time.Millisecond
+		}
+		go func() {
+			tk := time.NewTicker(every)
+			defer tk.Stop()
+			for {
+				select {
+				case now := <-tk.C:
+					b.Advance(now)
+				case <-c.done:
+					return
+				}
+			}
+		}()
+	}
+	return b, nil
+}
+
+// ===================== 内部 =====================
+
+// （调用方持有 b.mu）
+func (b *BarBuilder) addLocked(t TradeData) []Candle {
+	px, err1 := strconv.ParseFloat(t.Px, 64)
+	sz, err2 := strconv.ParseFloat(t.Sz, 64)
+	ts, err3 := strconv.ParseInt(t.Ts, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || px <= 0 || sz < 0 {
+		b.stats.Bad++
+		return nil
+	}
+	st := b.states[t.InstID]
+	if st == nil {
+		st = &barState{pending: make(map[int64]*timeBar), seen: make(map[string]struct{}), eT: b.spec.ExpectedTicks}
+		b.states[t.InstID] = st
+	}
+	if t.TradeID != "" {
+		if _, dup := st.seen[t.TradeID]; dup {
+			b.stats.Dups++
+			return nil
+		}
+		st.seen[t.TradeID] = struct{}{}
+		st.seenQ = append(st.seenQ, t.TradeID)
+		if len(st.seenQ) > barSeenCap {
+			delete(st.seen, st.seenQ[0])
+			st.seenQ = st.seenQ[1:]
+		}
+	}
+	b.stats.Trades++
+
+	if b.spec.Type == BarTime {
+		start := ts - ts%b.step
+		if start < st.closedTo {
+			b.stats.Late++
+			return nil
+		}
+		tb := st.pending[start]
+		if tb == nil {
+			tb = &timeBar{k: Candle{Timestamp: start, Open: px, High: px, Low: px, InstID: t.InstID, TF: b.label}, first: ts, last: ts}
+			st.pending[start] = tb
+		}
+		prevClose := tb.k.Close
+		mergeTrade(&tb.k, px, sz)
+		if ts < tb.first {
+			tb.first, tb.k.Open = ts, px
+		}
+		if ts >= tb.last {
+			tb.last = ts
+		} else {
+			tb.k.Close = prevClose // 乱序的早到成交不改收盘
+		}
+		if ts > st.watermark {
+			st.watermark = ts
+		}
+		return b.closeTimeBars(st, false)
+	}
+
+	if ts < st.watermark-b.late {
+		b.stats.Late++
+		return nil
+	}
+	if ts > st.watermark {
+		st.watermark = ts
+	}
+	if st.cur == nil {
+		st.cur = &Candle{Timestamp: ts, Open: px, High: px, Low: px, InstID: t.InstID, TF: b.label}
+	}
+	if ts < st.cur.Timestamp {
+		st.cur.Timestamp = ts
+	}
+	mergeTrade(st.cur, px, sz)
+	st.n++
+
+	switch b.spec.Type {
+	case BarVolume:
+		st.acc += sz
+	case BarDollar:
+		st.acc += px * sz * b.spec.CtVal
+	case BarTick:
+		st.acc++
+	case BarImbalance:
+		w := 1.0
+		switch b.spec.ImbalanceOf {
+		case BarVolume:
+			w = sz
+		case BarDollar:
+			w = px * sz * b.spec.CtVal
+		}
+		sign := 1.0
+		if strings.EqualFold(t.Side, "sell") {
+			sign = -1
+		}
+		st.theta += sign * w
+		st.sumW += w
+		if st.eW == 0 {
+			st.eW = w
+		}
+		if math.Abs(st.theta) >= st.eT*math.Max(math.Abs(st.eBW), b.spec.MinImbalance*st.eW) {
+			return []Candle{b.closeEventBar(st)}
+		}
+		return nil
+	}
+	if st.acc >= b.spec.Threshold {
+		return []Candle{b.closeEventBar(st)}
+	}
+	return nil
+}
+
+// closeTimeBars —— 水位越过 结束 + Lateness 的 bar 按时间顺序下发；all=true 时全部下发（调用方持有 b.mu）
+func (b *BarBuilder) closeTimeBars(st *barState, all bool) []Candle {
+	var starts []int64
+	for s := range st.pending {
+		if all || s+b.step+b.late <= st.watermark {
+			starts = append(starts, s)
+		}
+	}
+	if len(starts) == 0 {
+		return nil
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	out := make([]Candle, 0, len(starts))
+	for _, s := range starts {
+		out = append(out, st.pending[s].k)
+		delete(st.pending, s)
+		st.closedTo = s + b.step
+	}
+	b.stats.Bars += uint64(len(out))
+	return out
+}
+
+// closeEventBar —— 闭合当前事件 bar 并更新不平衡 EWMA（调用方持有 b.mu）
+func (b *BarBuilder) closeEventBar(st *barState) Candle {
+	k := *st.cur
+	if b.spec.Type == BarImbalance && st.n > 0 {
+		a := b.spec.Alpha
+		st.eT = a*st.n + (1-a)*st.eT
+		st.eBW = a*(st.theta/st.n) + (1-a)*st.eBW
+		st.eW = a*(st.sumW/st.n) + (1-a)*st.eW
+	}
+	st.cur, st.acc, st.n, st.theta, st.sumW = nil, 0, 0, 0, 0
+	b.stats.Bars++
+	return k
+}
+
+func mergeTrade(k *Candle, px, sz float64) {
+	if px > k.High {
+		k.High = px
+	}
+	if px < k.Low {
+		k.Low = px
+	}
+	k.Close = px
+	k.Volume += sz
+}
+
+func emitBars(hs []func([]Candle), out []Candle) {
+	if len(out) == 0 {
+		return
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	for _, h := range hs {
+		h(out)
+	}
+}
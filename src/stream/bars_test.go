@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func tr(id string, ts int64, px, sz float64, side string) TradeData {
+	return TradeData{InstID: "X", TradeID: id, Ts: strconv.FormatInt(ts, 10),
+		Px: strconv.FormatFloat(px, 'f', -1, 64), Sz: strconv.FormatFloat(sz, 'f', -1, 64), Side: side}
+}
+
+func TestTimeBarsLateAndOutOfOrder(t *testing.T) {
+	b, err := NewBarBuilder(BarSpec{Type: BarTime, Interval: time.Second, Lateness: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Candle
+	b.OnCandle(func(cs []Candle) { got = append(got, cs...) })
+
+	b.AddTrades([]TradeData{
+		tr("1", 1000, 10, 1, "buy"),
+		tr("3", 1900, 12, 1, "buy"),
+		tr("2", 1500, 9, 2, "sell"), // 乱序：不改收盘
+		tr("2", 1500, 9, 2, "sell"), // 重复
+		tr("4", 2100, 11, 1, "buy"), // 水位 2100 < 2000+500：1s bar 未闭合
+		tr("5", 1200, 13, 1, "buy"), // 宽限内迟到：归入第一根
+	})
+	if len(got) != 0 {
+		t.Fatalf("closed too early: %+v", got)
+	}
+	b.AddTrades([]TradeData{tr("6", 2600, 11.5, 1, "sell")})
+	if len(got) != 1 {
+		t.Fatalf("bars: %+v", got)
+	}
+	k := got[0]
+	if k.Timestamp != 1000 || k.Open != 10 || k.High != 13 || k.Low != 9 || k.Close != 12 || k.Volume != 5 || k.TF != "1s" {
+		t.Fatalf("bar: %+v", k)
+	}
+	b.AddTrades([]TradeData{tr("7", 1999, 1, 1, "buy")}) // 已下发的 bar：丢弃
+	// 无成交时由时钟推进闭合
+	b.Advance(time.UnixMilli(3500))
+	if len(got) != 2 || got[1].Timestamp != 2000 || got[1].Open != 11 || got[1].Close != 11.5 {
+		t.Fatalf("advance: %+v", got)
+	}
+	if st := b.Stats(); st.Late != 1 || st.Dups != 1 || st.Trades != 7 || st.Bars != 2 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestEventBarsOffline(t *testing.T) {
+	var trades []TradeData
+	for i := 0; i < 10; i++ {
+		trades = append(trades, tr(strconv.Itoa(i), int64(1000+i), 100+float64(i), 1.5, "buy"))
+	}
+
+	vol, _ := BuildBars(BarSpec{Type: BarVolume, Threshold: 3}, trades)
+	if len(vol) != 5 || vol[0].Volume != 3 || vol[0].Open != 100 || vol[0].Close != 101 || vol[1].Timestamp != 1002 || vol[0].TF != "vol:3" {
+		t.Fatalf("volume bars: %+v", vol)
+	}
+	tick, _ := BuildBars(BarSpec{Type: BarTick, Threshold: 4}, trades)
+	if len(tick) != 3 || tick[2].Volume != 3 { // 末尾未满的 bar 由 Flush 输出
+		t.Fatalf("tick bars: %+v", tick)
+	}
+	dollar, _ := BuildBars(BarSpec{Type: BarDollar, Threshold: 1000, CtVal: 2}, trades)
+	if len(dollar) != 3 || dollar[0].Volume != 6 || dollar[2].Volume != 3 { // 每笔约 300~327
+		t.Fatalf("dollar bars: %+v", dollar)
+	}
+
+	// 不平衡：单边买入按 E[T]·max(|E[b]|, 0.1) 闭合；E[T]=10 时首根需 1 笔
+	imb, _ := BuildBars(BarSpec{Type: BarImbalance, ExpectedTicks: 10}, trades)
+	if len(imb) == 0 || imb[0].Volume != 1.5 {
+		t.Fatalf("imbalance bars: %+v", imb)
+	}
+	for _, iv := range []time.Duration{0, 500 * time.Microsecond, 1500 * time.Microsecond} {
+		if _, err := NewBarBuilder(BarSpec{Type: BarTime, Interval: iv}); err == nil {
+			t.Fatalf("interval %v accepted", iv)
+		}
+	}
+	if _, err := NewBarBuilder(BarSpec{Type: BarVolume}); err == nil {
+		t.Fatal("volume bar without threshold should fail")
+	}
+}
@@ -0,0 +1,405 @@
+package stream
+
+// 本地 L2 订单簿 —— books / books5 / books50-l2-tbt / books-l2-tbt / bbo-tbt
+// =============================================================================
+// 1) 全量（snapshot）重建、增量（update）按价位覆盖，数量为 0 即删除该价位；
+//    books5 / bbo-tbt 每次推送都是全量；
+// 2) 增量频道校验 prevSeqId 连续性与 OKX CRC32 checksum（买卖各取前 25 档交替拼接 "px:sz"）；
+//    不一致即作废本地簿并重订阅该品种，等待新的全量；
+// 3) 同一品种只维护一本簿（以最后订阅的深度频道为准）；查询接口返回副本，可并发调用；
+// 4) 同一品种的盘口推送固定在所在连接的同一条 lane 上顺序处理（shard.go），避免乱序导致的假缺口。
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 深度频道
+const (
+	ChannelBooks       = "books"          // 400 档，100ms 增量
+	ChannelBooks5      = "books5"         // 5 档全量，100ms
+	ChannelBooks50L2   = "books50-l2-tbt" // 50 档逐笔增量（需 VIP）
+	ChannelBooksL2     = "books-l2-tbt"   // 400 档逐笔增量（需 VIP）
+	ChannelBBO         = "bbo-tbt"        // 1 档全量，逐笔
+	checksumDepthLevel = 25
+)
+
+var (
+	ErrBookChecksum = errors.New("order book checksum mismatch")
+	ErrBookSeqGap   = errors.New("order book sequence gap")
+	ErrBookNotReady = errors.New("order book awaiting snapshot")
+)
+
+// bookChannels —— 频道是否为增量（带 seqId / checksum）
+var bookChannels = map[string]bool{
+	ChannelBooks:     true,
+	ChannelBooks5:    false,
+	ChannelBooks50L2: true,
+	ChannelBooksL2:   true,
+	ChannelBBO:       false,
+}
+
+// IsBookChannel —— 是否为深度频道
+func IsBookChannel(ch string) bool {
+	_, ok := bookChannels[ch]
+	return ok
+}
+
+// Level —— 单个价位；PxStr/SzStr 保留原始字符串（checksum 需要）
+type Level struct {
+	Px     float64
+	Sz     float64
+	Orders int
+	PxStr  string
+	SzStr  string
+}
+
+// ===================== OrderBook =====================
+
+// OrderBook —— 单品种本地订单簿（并发安全；可离线复用于录制数据）
+type OrderBook struct {
+	mu      sync.RWMutex
+	instID  string
+	channel string
+	bids    []Level // 价格降序
+	asks    []Level // 价格升序
+	seqID   int64
+	ts      int64
+	ready   bool
+}
+
+func NewOrderBook(instID, channel string) *OrderBook {
+	return &OrderBook{instID: instID, channel: channel}
+}
+
+// Apply —— 应用一条推送；action 为 "snapshot" / "update"（空值视为全量）。
+// 返回 ErrBookSeqGap / ErrBookChecksum 时本地簿已作废，需重订阅
+func (b *OrderBook) Apply(action string, d BookData) error {
+	if d.InstID == "" {
+		d.InstID = b.instID
+	}
+	bk, err := DecodeBook(d, action)
+	if err != nil {
+		b.mu.Lock()
+		b.ready = false
+		b.mu.Unlock()
+		return err
+	}
+	return b.ApplyBook(bk)
+}
+
+// ApplyBook —— 同 Apply，输入为已解码的推送（WS worker 直接调用，不再重复解析）
+func (b *OrderBook) ApplyBook(d Book) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	incremental := bookChannels[b.channel]
+
+	if d.Action != "update" || !incremental {
+		bids, asks := nonZero(d.Bids), nonZero(d.Asks)
+		sort.Slice(bids, func(i, j int) bool { return bids[i].Px > bids[j].Px })
+		sort.Slice(asks, func(i, j int) bool { return asks[i].Px < asks[j].Px })
+		b.bids, b.asks = bids, asks
+	} else {
+		if !b.ready {
+			return ErrBookNotReady
+		}
+		// prevSeqId == seqId 表示无变化的心跳；seqId 回退（交易所维护重置）按缺口处理
+		if d.PrevSeqID != b.seqID {
+			b.ready = false
+			return fmt.Errorf("%w: %s prev=%d local=%d", ErrBookSeqGap, b.instID, d.PrevSeqID, b.seqID)
+		}
+		b.merge(&b.bids, d.Bids, true)
+		b.merge(&b.asks, d.Asks, false)
+	}
+	b.seqID = d.SeqID
+	b.ts = 0
+	if !d.Ts.IsZero() {
+		b.ts = d.Ts.UnixMilli()
+	}
+	b.ready = true
+
+	if incremental {
+		if got := b.checksum(); got != int32(d.Checksum) {
+			b.ready = false
+			return fmt.Errorf("%w: %s local=%d remote=%d", ErrBookChecksum, b.instID, got, d.Checksum)
+		}
+	}
+	return nil
+}
+
+// Reset —— 作废本地簿（重订阅前调用）
+func (b *OrderBook) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bids, b.asks, b.seqID, b.ready = nil, nil, 0, false
+}
+
+// Ready —— 是否已有有效全量
+func (b *OrderBook) Ready() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ready
+}
+
+// Best —— 最优买卖价位
+func (b *OrderBook) Best() (bid, ask Level, ok bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.ready || len(b.bids) == 0 || len(b.asks) == 0 {
+		return Level{}, Level{}, false
+	}
+	return b.bids[0], b.asks[0], true
+}
+
+// Depth —— 前 n 档（n<=0 返回全部）副本
+func (b *OrderBook) Depth(n int) (bids, asks []Level, ok bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.ready {
+		return nil, nil, false
+	}
+	return topN(b.bids, n), topN(b.asks, n), true
+}
+
+// Imbalance —— 前 n 档挂单量失衡 (Σbid - Σask) / (Σbid + Σask)，范围 [-1, 1]
+func (b *OrderBook) Imbalance(n int) (float64, bool) {
+	bids, asks, ok := b.Depth(n)
+	if !ok {
+		return 0, false
+	}
+	var sb, sa float64
+	for _, l := range bids {
+		sb += l.Sz
+	}
+	for _, l := range asks {
+		sa += l.Sz
+	}
+	if sb+sa <= 0 {
+		return 0, false
+	}
+	return (sb - sa) / (sb + sa), true
+}
+
+// Checksum —— 按 OKX 规则计算的本地 checksum
+func (b *OrderBook) Checksum() int32 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.checksum()
+}
+
+func (b *OrderBook) checksum() int32 {
+	var sb strings.Builder
+	for i := 0; i < checksumDepthLevel; i++ {
+		if i < len(b.bids) {
+			if sb.Len() > 0 {
+				sb.WriteByte(':')
+			}
+			sb.WriteString(b.bids[i].PxStr + ":" + b.bids[i].SzStr)
+		}
+		if i < len(b.asks) {
+			if sb.Len() > 0 {
+				sb.WriteByte(':')
+			}
+			sb.WriteString(b.asks[i].PxStr + ":" + b.asks[i].SzStr)
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(sb.String())))
+}
+
+// merge —— 增量覆盖：数量为 0 删除，否则替换/插入（保持排序）
+func (b *OrderBook) merge(side *[]Level, levels []Level, desc bool) {
+	for _, l := range levels {
+		s := *side
+		i := sort.Search(len(s), func(i int) bool {
+			if desc {
+				return s[i].Px <= l.Px
+			}
+			return s[i].Px >= l.Px
+		})
+		found := i < len(s) && s[i].Px == l.Px
+		switch {
+		case l.Sz == 0 && found:
+			s = append(s[:i], s[i+1:]...)
+		case l.Sz == 0:
+		case found:
+			s[i] = l
+		default:
+			s = append(s, Level{})
+			copy(s[i+1:], s[i:])
+			s[i] = l
+		}
+		*side = s
+	}
+}
+
+// nonZero —— 全量里数量为 0 的价位不入簿（返回新切片，不改动入参）
+func nonZero(levels []Level) []Level {
+	out := make([]Level, 0, len(levels))
+	for _, l := range levels {
+		if l.Sz > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// parseLevel —— OKX 价位：[px, sz, 已弃用字段, 订单数]
+func parseLevel(r []string) (Level, error) {
+	if len(r) < 2 {
+		return Level{}, fmt.Errorf("bad book level %v", r)
+	}
+	px, err := strconv.ParseFloat(r[0], 64)
+	if err != nil {
+		return Level{}, fmt.Errorf("bad book px %q: %v", r[0], err)
+	}
+	sz, err := strconv.ParseFloat(r[1], 64)
+	if err != nil {
+		return Level{}, fmt.Errorf("bad book sz %q: %v", r[1], err)
+	}
+	l := Level{Px: px, Sz: sz, PxStr: r[0], SzStr: r[1]}
+	if len(r) >= 4 {
+		l.Orders, _ = strconv.Atoi(r[3])
+	}
+	return l, nil
+}
+
+func topN(s []Level, n int) []Level {
+	if n <= 0 || n > len(s) {
+		n = len(s)
+	}
+	return append([]Level(nil), s[:n]...)
+}
+
+// ===================== HybridClient 接入 =====================
+
+// BookStats —— 盘口维护统计
+type BookStats struct {
+	Snapshots    int64
+	Updates      int64
+	ChecksumErrs int64
+	SeqGaps      int64
+	Resubscribes int64
+}
+
+type bookCounters struct {
+	snapshots, updates, checksumErrs, seqGaps, resubs atomic.Int64
+}
+
+// SubscribeBooks —— 订阅深度频道并维护本地簿（channel 为空默认 books）
+func (c *HybridClient) SubscribeBooks(instIDs []string, channel string) error {
+	if channel == "" {
+		channel = ChannelBooks
+	}
+	if !IsBookChannel(channel) {
+		return fmt.Errorf("不支持的深度频道: %s", channel)
+	}
+	for _, id := range instIDs {
+		c.books.Store(id, NewOrderBook(id, channel))
+	}
+	return c.subscribeWS(channel, instIDs)
+}
+
+// UnsubscribeBooks —— 退订并丢弃本地簿
+func (c *HybridClient) UnsubscribeBooks(instIDs []string, channel string) error {
+	if channel == "" {
+		channel = ChannelBooks
+	}
+	for _, id := range instIDs {
+		c.books.Delete(id)
+	}
+	return c.unsubscribeWS(channel, instIDs)
+}
+
+// OrderBook —— 本地簿（未订阅返回 nil）
+func (c *HybridClient) OrderBook(instID string) *OrderBook {
+	if v, ok := c.books.Load(instID); ok {
+		return v.(*OrderBook)
+	}
+	return nil
+}
+
+// BestBidAsk —— 最优买卖价位
+func (c *HybridClient) BestBidAsk(instID string) (bid, ask Level, ok bool) {
+	if b := c.OrderBook(instID); b != nil {
+		return b.Best()
+	}
+	return Level{}, Level{}, false
+}
+
+// Depth —— 前 n 档
+func (c *HybridClient) Depth(instID string, n int) (bids, asks []Level, ok bool) {
+	if b := c.OrderBook(instID); b != nil {
+		return b.Depth(n)
+	}
+	return nil, nil, false
+}
+
+// Imbalance —— 前 n 档挂单量失衡
+func (c *HybridClient) Imbalance(instID string, n int) (float64, bool) {
+	if b := c.OrderBook(instID); b != nil {
+		return b.Imbalance(n)
+	}
+	return 0, false
+}
+
+// BookStats —— 盘口维护统计
+func (c *HybridClient) BookStats() BookStats {
+	return BookStats{
+		Snapshots:    c.bookCnt.snapshots.Load(),
+		Updates:      c.bookCnt.updates.Load(),
+		ChecksumErrs: c.bookCnt.checksumErrs.Load(),
+		SeqGaps:      c.bookCnt.seqGaps.Load(),
+		Resubscribes: c.bookCnt.resubs.Load(),
+	}
+}
+
+// onBookWS —— 应用已解码的推送到本地簿，再按 instID 分发原始数据与类型化数据
+func (c *HybridClient) onBookWS(channel, instID string, arr []BookData, typed []Book) {
+	b := c.OrderBook(instID)
+	if b != nil && b.channel == channel {
+	apply:
+		for _, d := range typed {
+			err := b.ApplyBook(d)
+			if d.Action == "update" {
+				c.bookCnt.updates.Add(1)
+			} else {
+				c.bookCnt.snapshots.Add(1)
+			}
+			switch {
+			case err == nil, errors.Is(err, ErrBookNotReady):
+				continue
+			case errors.Is(err, ErrBookChecksum):
+				c.bookCnt.checksumErrs.Add(1)
+			case errors.Is(err, ErrBookSeqGap):
+				c.bookCnt.seqGaps.Add(1)
+			}
+			log.Printf("⚠️ 盘口失效，重订阅 %s %s: %v", channel, instID, err)
+			c.resubscribeBook(channel, instID)
+			break apply // 本批剩余增量作废：等重订阅后的全量
+		}
+	}
+	c.dispatchBook(map[string][]BookData{instID: arr})
+	c.dispatchTypedBook(typed)
+}
+
+// resubscribeBook —— 作废本地簿并重订阅（交易所会先推全量）
+func (c *HybridClient) resubscribeBook(channel, instID string) {
+	if b := c.OrderBook(instID); b != nil {
+		b.Reset()
+	}
+	c.bookCnt.resubs.Add(1)
+	if err := c.unsubscribeWS(channel, []string{instID}); err != nil {
+		log.Printf("⚠️ 盘口退订失败 %s %s: %v", channel, instID, err)
+		return
+	}
+	if err := c.subscribeWS(channel, []string{instID}); err != nil {
+		log.Printf("⚠️ 盘口重订阅失败 %s %s: %v", channel, instID, err)
+	}
+}
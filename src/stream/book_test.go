@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func okxChecksum(s string) int64 { return int64(int32(crc32.ChecksumIEEE([]byte(s)))) }
+
+func TestOrderBookSnapshotUpdateChecksum(t *testing.T) {
+	b := NewOrderBook("BTC-USDT-SWAP", ChannelBooks)
+	snap := BookData{
+		Bids:  [][]string{{"99.5", "3", "0", "1"}, {"100.0", "2", "0", "2"}, {"99", "1", "0", "1"}},
+		Asks:  [][]string{{"100.5", "4", "0", "3"}, {"101", "1", "0", "1"}},
+		SeqID: 10, PrevSeqID: -1, Ts: "1700000000000",
+		Checksum: okxChecksum("100.0:2:100.5:4:99.5:3:101:1:99:1"),
+	}
+	if err := b.Apply("snapshot", snap); err != nil {
+		t.Fatal(err)
+	}
+	if bid, ask, ok := b.Best(); !ok || bid.Px != 100 || ask.Px != 100.5 || bid.Orders != 2 {
+		t.Fatalf("best: %+v %+v", bid, ask)
+	}
+
+	// 删 100.0、改 99.5、插 100.2 买；卖侧插 100.4
+	upd := BookData{
+		Bids:  [][]string{{"100.0", "0", "0", "0"}, {"99.5", "5", "0", "2"}, {"100.2", "1", "0", "1"}},
+		Asks:  [][]string{{"100.4", "2", "0", "1"}},
+		SeqID: 11, PrevSeqID: 10,
+		Checksum: okxChecksum("100.2:1:100.4:2:99.5:5:100.5:4:99:1:101:1"),
+	}
+	if err := b.Apply("update", upd); err != nil {
+		t.Fatal(err)
+	}
+	bids, asks, _ := b.Depth(2)
+	if len(bids) != 2 || bids[0].Px != 100.2 || bids[1].Px != 99.5 || bids[1].Sz != 5 || asks[0].Px != 100.4 {
+		t.Fatalf("depth: %+v %+v", bids, asks)
+	}
+	if imb, ok := b.Imbalance(1); !ok || imb != (1.0-2.0)/3.0 {
+		t.Fatalf("imbalance: %v", imb)
+	}
+
+	// 心跳（prev == seq）通过；缺口作废
+	if err := b.Apply("update", BookData{SeqID: 11, PrevSeqID: 11, Checksum: upd.Checksum}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if err := b.Apply("update", BookData{SeqID: 13, PrevSeqID: 12}); !errors.Is(err, ErrBookSeqGap) || b.Ready() {
+		t.Fatalf("gap: %v ready=%v", err, b.Ready())
+	}
+	if err := b.Apply("update", upd); !errors.Is(err, ErrBookNotReady) {
+		t.Fatalf("update before snapshot: %v", err)
+	}
+}
+
+func TestHybridClientBookRouting(t *testing.T) {
+	c := NewHybridClient()
+	_ = c.SubscribeBooks([]string{"X"}, ChannelBooks) // 未连接：只建本地簿
+	var keys []string
+	c.OnBook(func(m map[string][]BookData) {
+		for k := range m {
+			keys = append(keys, k)
+		}
+	})
+
+	snap, _ := json.Marshal([]BookData{{Bids: [][]string{{"1", "1"}}, Asks: [][]string{{"2", "3"}}, SeqID: 1, PrevSeqID: -1, Checksum: okxChecksum("1:1:2:3")}})
+	c.handleWSData(ChannelBooks, "X", "snapshot", snap)
+	if bid, ask, ok := c.BestBidAsk("X"); !ok || bid.Sz != 1 || ask.Sz != 3 {
+		t.Fatalf("best: %+v %+v %v", bid, ask, ok)
+	}
+	bad, _ := json.Marshal([]BookData{{Bids: [][]string{{"1", "2"}}, SeqID: 2, PrevSeqID: 1, Checksum: 42}})
+	c.handleWSData(ChannelBooks, "X", "update", bad)
+	if _, _, ok := c.BestBidAsk("X"); ok {
+		t.Fatal("book should be invalid after checksum mismatch")
+	}
+	st := c.BookStats()
+	if st.Snapshots != 1 || st.Updates != 1 || st.ChecksumErrs != 1 || st.Resubscribes != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+	// 同批内失效后不再应用剩余增量（只重订阅一次）
+	c.handleWSData(ChannelBooks, "X", "snapshot", snap)
+	batch, _ := json.Marshal([]BookData{
+		{Bids: [][]string{{"1", "2"}}, SeqID: 2, PrevSeqID: 1, Checksum: 42},
+		{Bids: [][]string{{"1", "3"}}, SeqID: 3, PrevSeqID: 2, Checksum: 43},
+	})
+	c.handleWSData(ChannelBooks, "X", "update", batch)
+	if st := c.BookStats(); st.Updates != 2 || st.ChecksumErrs != 2 || st.Resubscribes != 2 {
+		t.Fatalf("batch stats: %+v", st)
+	}
+	// books5：每次都是全量，不校验 checksum
+	five, _ := json.Marshal([]BookData{{InstID: "Y", Bids: [][]string{{"9", "1"}}, Asks: [][]string{{"10", "1"}}}})
+	_ = c.SubscribeBooks([]string{"Y"}, ChannelBooks5)
+	c.handleWSData(ChannelBooks5, "Y", "", five)
+	if imb, ok := c.Imbalance("Y", 5); !ok || imb != 0 {
+		t.Fatalf("books5: %v %v", imb, ok)
+	}
+	if len(keys) != 5 || keys[0] != "X" || keys[4] != "Y" {
+		t.Fatalf("dispatch keys: %v", keys)
+	}
+}
@@ -0,0 +1,500 @@
+package stream
+
+// 区间历史K线下载 —— 任意 [start, end]，增量追加到 CSV，可断点续传
+// =============================================================================
+// 1) 统一走 history-candles，按 100 根一个窗口（before/after 双边界）从旧到新翻页，
+//    每页写完即 flush，进程中断后按文件尾部时间戳续传；尾部残行（写到一半）自动截掉；
+// 2) 请求起点早于文件首根时，先下载缺失的头部再整体重写（临时文件 + rename）；
+// 3) 自适应限速：多个下载任务共享一个间隔，遇 429 / 50011 加倍，成功后缓慢回落到交易所上限；
+// 4) 完成后按周期步长校验缺口（交易所维护等真实缺口只报告，不视为失败）；
+// 5) CSV 格式与回测加载器一致：timestamp,open,high,low,close,volume；
+// 6) 默认文件 Dir/<instId>.csv 不含周期，已有文件的K线间距与本次周期不符时拒绝续传/追加。
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited —— 公共 REST 被限频（HTTP 429 / code 50011）
+var ErrRateLimited = errors.New("okx public rate limited")
+
+const historyPageSize = 100
+
+// TimeframeStep —— 周期步长（1m/5m/15m/30m/1h/4h/1d）；未知周期返回 0
+func TimeframeStep(tf string) time.Duration {
+	switch tf {
+	case "1m":
+		return time.Minute
+	case "5m":
+		return 5 * time.Minute
+	case "15m":
+		return 15 * time.Minute
+	case "30m":
+		return 30 * time.Minute
+	case "1h":
+		return time.Hour
+	case "4h":
+		return 4 * time.Hour
+	case "1d":
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// DownloadJob —— 单个品种/周期的下载区间
+type DownloadJob struct {
+	InstID    string
+	Timeframe string
+	Start     time.Time
+	End       time.Time // 零值表示到当前
+}
+
+// Gap —— 两根相邻K线之间缺失的区间（From/To 为缺失的首尾 ts，ms）
+type Gap struct {
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+	Missing int   `json:"missing"`
+}
+
+// DownloadResult —— 下载结果
+type DownloadResult struct {
+	InstID    string `json:"instId"`
+	Timeframe string `json:"timeframe"`
+	Path      string `json:"path"`
+	Added     int    `json:"added"`
+	Requests  int    `json:"requests"`
+	Bars      int    `json:"bars"`  // 区间内的K线数
+	First     int64  `json:"first"` // 区间内首根 ts
+	Last      int64  `json:"last"`  // 区间内末根 ts
+	Gaps      []Gap  `json:"gaps,omitempty"`
+	Err       string `json:"err,omitempty"`
+}
+
+// RangeDownloader —— 区间下载器（并发安全）
+type RangeDownloader struct {
+	c        *HybridClient
+	Dir      string
+	Parallel int
+	// PathFor —— CSV 路径；为空时为 Dir/<instId>.csv（与回测 DataPath 约定一致）
+	PathFor func(instID, tf string) string
+
+	lim  *adaptiveLimiter
+	now  func() time.Time
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// NewRangeDownloader —— dir 为 CSV 目录
+func NewRangeDownloader(c *HybridClient, dir string) *RangeDownloader {
+	return &RangeDownloader{
+		c: c, Dir: dir, Parallel: 4,
+		lim:  newAdaptiveLimiter(100*time.Millisecond, 5*time.Second), // history-candles：20 次 / 2s
+		now:  time.Now,
+		wait: sleepCtx,
+	}
+}
+
+// DownloadAll —— 并行下载多个任务（共享限速），结果与 jobs 同序
+func (d *RangeDownloader) DownloadAll(ctx context.Context, jobs []DownloadJob) []DownloadResult {
+	par := d.Parallel
+	if par <= 0 {
+		par = 1
+	}
+	out := make([]DownloadResult, len(jobs))
+	sem := make(chan struct{}, par)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j DownloadJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res, err := d.Download(ctx, j)
+			if err != nil {
+				res.Err = err.Error()
+				log.Printf("⚠️ 下载失败 %s %s: %v", j.InstID, j.Timeframe, err)
+			}
+			out[i] = res
+		}(i, j)
+	}
+	wg.Wait()
+	return out
+}
+
+// Download —— 下载单个任务：续传尾部、补齐头部、校验缺口
+func (d *RangeDownloader) Download(ctx context.Context, j DownloadJob) (DownloadResult, error) {
+	res := DownloadResult{InstID: j.InstID, Timeframe: j.Timeframe, Path: d.path(j.InstID, j.Timeframe)}
+	step := TimeframeStep(j.Timeframe).Milliseconds()
+	if step <= 0 {
+		return res, fmt.Errorf("不支持的周期: %s", j.Timeframe)
+	}
+	start := j.Start.UnixMilli()
+	end := d.now().UnixMilli()
+	if !j.End.IsZero() && j.End.UnixMilli() < end {
+		end = j.End.UnixMilli()
+	}
+	if start > end {
+		return res, fmt.Errorf("起点晚于终点: %s > %s", j.Start.Format(time.RFC3339), time.UnixMilli(end).Format(time.RFC3339))
+	}
+
+	have, err := loadCandleCSV(res.Path)
+	if err != nil {
+		return res, err
+	}
+	// 默认文件名不含周期：已有文件的K线间距与本次周期不符（同目录混放了其他周期）则拒绝，不往里追加
+	if sp := barSpacing(have); sp > 0 && sp != step {
+		return res, fmt.Errorf("%s 已有K线间距 %s，与周期 %s 不符（换目录或用 PathFor 按周期区分文件）",
+			res.Path, time.Duration(sp)*time.Millisecond, j.Timeframe)
+	}
+
+	// 头部：请求起点早于文件首根
+	if len(have) > 0 && start < have[0].Timestamp {
+		head, err := d.fetchRange(ctx, j, start, have[0].Timestamp-1, &res, nil)
+		if err != nil {
+			return res, err
+		}
+		if len(head) > 0 {
+			if err := rewriteCandleCSV(res.Path, append(head, have...)); err != nil {
+				return res, err
+			}
+			res.Added += len(head)
+			have = append(head, have...)
+		}
+	}
+
+	// 尾部：从文件末根之后续传
+	from := start
+	if len(have) > 0 && have[len(have)-1].Timestamp+step > from {
+		from = have[len(have)-1].Timestamp + step
+	}
+	if from <= end {
+		w, err := openCandleCSVAppend(res.Path)
+		if err != nil {
+			return res, err
+		}
+		tail, err := d.fetchRange(ctx, j, from, end, &res, w)
+		cerr := w.Close()
+		have = append(have, tail...)
+		res.Added += len(tail)
+		if err != nil {
+			return res, err
+		}
+		if cerr != nil {
+			return res, cerr
+		}
+	}
+
+	// 校验
+	var in []Candle
+	for _, k := range have {
+		if k.Timestamp >= start && k.Timestamp <= end {
+			in = append(in, k)
+		}
+	}
+	res.Bars = len(in)
+	if len(in) > 0 {
+		res.First, res.Last = in[0].Timestamp, in[len(in)-1].Timestamp
+	}
+	res.Gaps = FindGaps(in, step)
+	if len(res.Gaps) > 0 {
+		log.Printf("⚠️ %s %s 存在 %d 处缺口（首个 %s）", j.InstID, j.Timeframe, len(res.Gaps), time.UnixMilli(res.Gaps[0].From).UTC().Format(time.RFC3339))
+	}
+	log.Printf("✅ %s %s: +%d 根，区间内 %d 根，请求 %d 次", j.InstID, j.Timeframe, res.Added, res.Bars, res.Requests)
+	return res, nil
+}
+
+// fetchRange —— 按 100 根窗口从旧到新翻页；w 非空时每页即写（仅写已闭合K线）
+func (d *RangeDownloader) fetchRange(ctx context.Context, j DownloadJob, from, to int64, res *DownloadResult, w *candleCSVWriter) ([]Candle, error) {
+	step := TimeframeStep(j.Timeframe).Milliseconds()
+	bar := d.c.tfToBarParam(j.Timeframe)
+	var out []Candle
+	for cur := from; cur <= to; {
+		hi := cur + historyPageSize*step - 1
+		if hi > to {
+			hi = to
+		}
+		api := fmt.Sprintf("%s/api/v5/market/history-candles?instId=%s&bar=%s&limit=%d&after=%d&before=%d",
+			d.c.httpBaseURL, j.InstID, bar, historyPageSize, hi+1, cur-1)
+		rows, err := d.request(ctx, api, res)
+		if err != nil {
+			return out, err
+		}
+		page := make([]Candle, 0, len(rows))
+		open := false
+		for _, k := range parseOKXRowsToCandlesAsc(rows, j.InstID, j.Timeframe) {
+			if k.Timestamp < cur || k.Timestamp > hi {
+				continue
+			}
+			page = append(page, k)
+		}
+		// 未闭合的最后一根不落盘，下次续传
+		for _, r := range rows {
+			if len(r) >= 9 && r[8] == "0" {
+				ts, _ := strconv.ParseInt(r[0], 10, 64)
+				for i, k := range page {
+					if k.Timestamp == ts {
+						page, open = page[:i], true
+						break
+					}
+				}
+			}
+		}
+		if w != nil && len(page) > 0 {
+			if err := w.Write(page); err != nil {
+				return out, err
+			}
+		}
+		out = append(out, page...)
+		if open {
+			break
+		}
+		cur = hi + 1
+	}
+	return out, nil
+}
+
+// request —— 限速 + 重试（限频时加大全局间隔后重试，其余错误线性退避）
+func (d *RangeDownloader) request(ctx context.Context, api string, res *DownloadResult) ([][]string, error) {
+	const maxAttempts = 8
+	var last error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := d.wait(ctx, d.lim.reserve(d.now())); err != nil {
+			return nil, err
+		}
+		res.Requests++
+		rows, err := d.c.doOKXCandlesRequest(api)
+		if err == nil {
+			d.lim.ok()
+			return rows, nil
+		}
+		last = err
+		if errors.Is(err, ErrRateLimited) {
+			d.lim.throttled()
+			continue
+		}
+		if err := d.wait(ctx, time.Duration(attempt)*500*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+	return nil, last
+}
+
+func (d *RangeDownloader) path(inst, tf string) string {
+	if d.PathFor != nil {
+		return d.PathFor(inst, tf)
+	}
+	return filepath.Join(d.Dir, inst+".csv")
+}
+
+// barSpacing —— 相邻K线的最小间距（毫秒，输入升序）；不足两根返回 0
+func barSpacing(cs []Candle) int64 {
+	var sp int64
+	for i := 1; i < len(cs); i++ {
+		if d := cs[i].Timestamp - cs[i-1].Timestamp; d > 0 && (sp == 0 || d < sp) {
+			sp = d
+		}
+	}
+	return sp
+}
+
+// FindGaps —— 相邻K线间距大于步长的区间（输入升序）
+func FindGaps(cs []Candle, step int64) []Gap {
+	var gaps []Gap
+	for i := 1; i < len(cs); i++ {
+		if d := cs[i].Timestamp - cs[i-1].Timestamp; d > step {
+			gaps = append(gaps, Gap{From: cs[i-1].Timestamp + step, To: cs[i].Timestamp - step, Missing: int(d/step) - 1})
+		}
+	}
+	return gaps
+}
+
+// ===================== 自适应限速 =====================
+
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	min, max time.Duration
+	next     time.Time
+}
+
+func newAdaptiveLimiter(min, max time.Duration) *adaptiveLimiter {
+	return &adaptiveLimiter{interval: min, min: min, max: max}
+}
+
+// reserve —— 占一个请求时隙，返回需要等待的时长
+func (l *adaptiveLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	return slot.Sub(now)
+}
+
+func (l *adaptiveLimiter) ok() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.interval = l.interval * 19 / 20; l.interval < l.min {
+		l.interval = l.min
+	}
+}
+
+func (l *adaptiveLimiter) throttled() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.interval *= 2; l.interval > l.max {
+		l.interval = l.max
+	}
+	l.next = l.next.Add(l.interval)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// ===================== CSV =====================
+
+var candleCSVHeader = "timestamp,open,high,low,close,volume"
+
+// loadCandleCSV —— 读取已有 CSV（升序去重）；尾部不完整的行会被截掉，便于续传
+func loadCandleCSV(path string) ([]Candle, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		out  []Candle
+		good int64 // 最后一个完整行之后的偏移
+		off  int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		off += int64(len(line))
+		complete := strings.HasSuffix(line, "\n")
+		text := strings.TrimSpace(line)
+		if text != "" && complete {
+			if k, ok := parseCandleCSVLine(text); ok {
+				out = append(out, k)
+				good = off
+			} else if text == candleCSVHeader || strings.HasPrefix(text, "timestamp") {
+				good = off
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if good < off {
+		log.Printf("⚠️ %s 尾部有 %d 字节不完整数据，已截断", path, off-good)
+		if err := f.Truncate(good); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	return dedupAsc(out), nil
+}
+
+func parseCandleCSVLine(s string) (Candle, bool) {
+	p := strings.Split(s, ",")
+	if len(p) < 6 {
+		return Candle{}, false
+	}
+	var v [6]float64
+	ts, err := strconv.ParseInt(p[0], 10, 64)
+	if err != nil {
+		return Candle{}, false
+	}
+	for i := 1; i < 6; i++ {
+		if v[i], err = strconv.ParseFloat(p[i], 64); err != nil {
+			return Candle{}, false
+		}
+	}
+	return Candle{Timestamp: ts, Open: v[1], High: v[2], Low: v[3], Close: v[4], Volume: v[5]}, true
+}
+
+func formatCandleCSVLine(k Candle) string {
+	return fmt.Sprintf("%d,%.8f,%.8f,%.8f,%.8f,%.8f\n", k.Timestamp, k.Open, k.High, k.Low, k.Close, k.Volume)
+}
+
+// candleCSVWriter —— 追加写；每批写完 fsync，保证中断后文件只会缺整行或残一行
+type candleCSVWriter struct{ f *os.File }
+
+func openCandleCSVAppend(path string) (*candleCSVWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		if _, err := f.WriteString(candleCSVHeader + "\n"); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &candleCSVWriter{f: f}, nil
+}
+
+func (w *candleCSVWriter) Write(cs []Candle) error {
+	var sb strings.Builder
+	for _, k := range cs {
+		sb.WriteString(formatCandleCSVLine(k))
+	}
+	if _, err := w.f.WriteString(sb.String()); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *candleCSVWriter) Close() error { return w.f.Close() }
+
+// rewriteCandleCSV —— 整体重写（临时文件 + rename）
+func rewriteCandleCSV(path string, cs []Candle) error {
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	w, err := openCandleCSVAppend(tmp)
+	if err != nil {
+		return err
+	}
+	if err := w.Write(cs); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 模拟 history-candles：1m K线，缺一根，首个请求限频，最新一根未闭合
+func newHistoryServer(t *testing.T, base int64, n int, missing int64) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		q := r.URL.Query()
+		after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+		before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+		var rows [][]string
+		for i := n - 1; i >= 0; i-- { // 新到旧
+			ts := base + int64(i)*60_000
+			if ts == missing || ts >= after || ts <= before {
+				continue
+			}
+			confirm := "1"
+			if i == n-1 {
+				confirm = "0"
+			}
+			px := strconv.Itoa(100 + i)
+			rows = append(rows, []string{strconv.FormatInt(ts, 10), px, px, px, px, "1", "1", "1", confirm})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": rows})
+	}))
+	return srv, &calls
+}
+
+func TestRangeDownloaderResumeAndGaps(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	missing := base + 150*60_000
+	srv, _ := newHistoryServer(t, base, 300, missing)
+	defer srv.Close()
+
+	c := NewHybridClient()
+	c.httpBaseURL = srv.URL
+	dir := t.TempDir()
+	d := NewRangeDownloader(c, dir)
+	d.wait = func(context.Context, time.Duration) error { return nil }
+	d.now = func() time.Time { return time.UnixMilli(base + 400*60_000) }
+
+	job := DownloadJob{InstID: "X", Timeframe: "1m", Start: time.UnixMilli(base + 100*60_000)}
+	res, err := d.Download(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// [100, 298]，去掉缺失的 150；299 未闭合不落盘
+	if res.Added != 198 || res.Bars != 198 || res.First != base+100*60_000 || res.Last != base+298*60_000 {
+		t.Fatalf("first run: %+v", res)
+	}
+	if len(res.Gaps) != 1 || res.Gaps[0].From != missing || res.Gaps[0].Missing != 1 {
+		t.Fatalf("gaps: %+v", res.Gaps)
+	}
+
+	// 续传：无新K线
+	if res, err = d.Download(context.Background(), job); err != nil || res.Added != 0 || res.Bars != 198 {
+		t.Fatalf("resume: %+v %v", res, err)
+	}
+
+	// 截断的尾行被丢弃后重新下载
+	path := filepath.Join(dir, "X.csv")
+	b, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, b[:len(b)-10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = d.Download(context.Background(), job); err != nil || res.Added != 1 || res.Bars != 198 {
+		t.Fatalf("partial line: %+v %v", res, err)
+	}
+
+	// 头部扩展
+	job.Start = time.UnixMilli(base + 50*60_000)
+	if res, err = d.Download(context.Background(), job); err != nil || res.Added != 50 || res.First != base+50*60_000 {
+		t.Fatalf("head: %+v %v", res, err)
+	}
+	cs, err := loadCandleCSV(path)
+	if err != nil || len(cs) != 248 {
+		t.Fatalf("csv: %d %v", len(cs), err)
+	}
+	for i := 1; i < len(cs); i++ {
+		if cs[i].Timestamp <= cs[i-1].Timestamp {
+			t.Fatalf("csv not ascending at %d", i)
+		}
+	}
+	if b, _ = os.ReadFile(path); !strings.HasSuffix(string(b), "\n") {
+		t.Fatal("csv must end with newline")
+	}
+
+	// 同一文件换周期：拒绝，不追加
+	job.Timeframe = "5m"
+	if _, err = d.Download(context.Background(), job); err == nil || !strings.Contains(err.Error(), "5m") {
+		t.Fatalf("mixed timeframe accepted: %v", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(b) {
+		t.Fatal("csv modified on timeframe mismatch")
+	}
+}
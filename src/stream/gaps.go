@@ -0,0 +1,217 @@
+package stream
+
+// K线缺口 —— 实时流按周期步长检测缺口，REST 回补后再按序下发
+// =============================================================================
+// 1) WS 闭合K、HTTP 轮询、首次历史回补统一经 commitCandles 下发：同一序列（instID+tf）串行处理，
+//    只下发 ts > lastClosedTs 的闭合K，保证每根恰好一次、严格升序；
+// 2) 新K与上一根（或批内相邻两根）间距大于步长即为缺口：先按 [缺口首, 缺口尾] 拉 REST 闭合K补齐，
+//    补到的与新K一起按序下发；仍缺的（交易所本身无数据或请求失败）计入 Unrecovered 后照常继续；
+// 3) 重连成功后主动追平（catchUpCandles），不必等下一根闭合；
+// 4) 计数见 CandleGapStats；回放客户端关闭 REST 回补，只计数。
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 与 GetCandles 一致：market/candles 只保留最近 1440 根，更早的走 history-candles
+const recentCandleBars = 1440
+
+// CandleGapStats —— 缺口/回补计数
+type CandleGapStats struct {
+	Gaps           int64 `json:"gaps"`           // 检测到的缺口段数
+	Missing        int64 `json:"missing"`        // 缺失的K线根数
+	Backfilled     int64 `json:"backfilled"`     // REST 补回的根数
+	Unrecovered    int64 `json:"unrecovered"`    // 回补后仍缺的根数
+	BackfillErrors int64 `json:"backfillErrors"` // 回补请求失败次数
+	Duplicates     int64 `json:"duplicates"`     // 重复 / 过期（ts ≤ 已下发）而丢弃的根数
+	Dispatched     int64 `json:"dispatched"`     // 下发的根数
+}
+
+type candleCounters struct {
+	gaps, missing, backfilled, unrecovered, backfillErrs, dups, dispatched atomic.Int64
+}
+
+// CandleGapStats —— 计数快照
+func (c *HybridClient) CandleGapStats() CandleGapStats {
+	return CandleGapStats{
+		Gaps:           c.candleCnt.gaps.Load(),
+		Missing:        c.candleCnt.missing.Load(),
+		Backfilled:     c.candleCnt.backfilled.Load(),
+		Unrecovered:    c.candleCnt.unrecovered.Load(),
+		BackfillErrors: c.candleCnt.backfillErrs.Load(),
+		Duplicates:     c.candleCnt.dups.Load(),
+		Dispatched:     c.candleCnt.dispatched.Load(),
+	}
+}
+
+// seriesLock —— 每个序列一把锁（合并、回补、下发期间持有）
+func (c *HybridClient) seriesLock(key string) *sync.Mutex {
+	v, _ := c.seriesMu.LoadOrStore(key, &sync.Mutex{})
+	return v.(*sync.Mutex)
+}
+
+// commitCandles —— K线下发的唯一入口（fresh 须为闭合K，顺序任意）
+func (c *HybridClient) commitCandles(instID, timeframe string, fresh []Candle) {
+	if len(fresh) == 0 {
+		return
+	}
+	cacheKey := instID + "_" + timeframe
+	mu := c.seriesLock(cacheKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastTs := int64(0)
+	if v, ok := c.lastClosedTs.Load(cacheKey); ok {
+		lastTs, _ = v.(int64)
+	}
+	n := len(fresh)
+	incr := tailAfterTs(dedupAsc(fresh), lastTs)
+	c.candleCnt.dups.Add(int64(n - len(incr)))
+	if len(incr) == 0 {
+		return
+	}
+
+	// 缺口：以已下发的最后一根为锚（首次订阅无锚，不检测）
+	if step := TimeframeStep(timeframe).Milliseconds(); step > 0 && lastTs > 0 {
+		incr = c.fillGaps(instID, timeframe, step, lastTs, incr)
+	}
+
+	var base []Candle
+	if v, ok := c.candleCache.Load(cacheKey); ok {
+		base = v.([]Candle)
+	}
+	c.candleCache.Store(cacheKey, mergeCandlesAsc(base, incr))
+	c.lastClosedTs.Store(cacheKey, incr[len(incr)-1].Timestamp)
+	c.candleCnt.dispatched.Add(int64(len(incr)))
+	c.dispatchCandle(incr)
+}
+
+// fillGaps —— 找出 (prev, incr...) 之间缺失的区间并回补，返回合并后的升序K线（调用方持有序列锁）
+func (c *HybridClient) fillGaps(instID, timeframe string, step, prev int64, incr []Candle) []Candle {
+	var filled []Candle
+	for _, k := range incr {
+		if k.Timestamp-prev > step {
+			from, to := prev+step, k.Timestamp-step
+			missing := (to-from)/step + 1
+			c.candleCnt.gaps.Add(1)
+			c.candleCnt.missing.Add(missing)
+
+			got := 0
+			if c.candleBackfill {
+				rows, err := c.fetchClosedCandles(instID, timeframe, from, to)
+				if err != nil {
+					c.candleCnt.backfillErrs.Add(1)
+					log.Printf("⚠️ K线回补失败 %s %s [%d,%d]: %v", instID, timeframe, from, to, err)
+				}
+				for _, r := range rows {
+					if r.Timestamp >= from && r.Timestamp <= to && (r.Timestamp-from)%step == 0 {
+						filled = append(filled, r)
+						got++
+					}
+				}
+			}
+			c.candleCnt.backfilled.Add(int64(got))
+			c.candleCnt.unrecovered.Add(missing - int64(got))
+			log.Printf("🔄 K线缺口 %s %s: 缺 %d 根（%s 起），补回 %d 根",
+				instID, timeframe, missing, time.UnixMilli(from).UTC().Format(time.RFC3339), got)
+		}
+		prev = k.Timestamp
+	}
+	if len(filled) == 0 {
+		return incr
+	}
+	return mergeCandlesAsc(filled, incr)
+}
+
+// fetchClosedCandles —— REST 拉 [from, to] 的闭合K（升序）；近 1440 根走 market/candles，更早走 history-candles
+func (c *HybridClient) fetchClosedCandles(instID, timeframe string, from, to int64) ([]Candle, error) {
+	step := TimeframeStep(timeframe).Milliseconds()
+	if step <= 0 {
+		return nil, fmt.Errorf("不支持的周期: %s", timeframe)
+	}
+	bar := c.tfToBarParam(timeframe)
+	recentFrom := c.clock.Now().UnixMilli() - recentCandleBars*step
+	var out []Candle
+	for cur := from; cur <= to; {
+		hi := cur + historyPageSize*step - 1
+		if hi > to {
+			hi = to
+		}
+		endpoint := "history-candles"
+		if cur >= recentFrom {
+			endpoint = "candles"
+		}
+		api := fmt.Sprintf("%s/api/v5/market/%s?instId=%s&bar=%s&limit=%d&after=%d&before=%d",
+			c.httpBaseURL, endpoint, instID, bar, historyPageSize, hi+1, cur-1)
+		rows, err := c.doOKXCandlesRequest(api)
+		if err != nil {
+			return out, err
+		}
+		closed := rows[:0:0]
+		for _, r := range rows {
+			if len(r) >= 9 && r[8] == "0" {
+				continue // 未闭合
+			}
+			closed = append(closed, r)
+		}
+		out = append(out, parseOKXRowsToCandlesAsc(closed, instID, timeframe)...)
+		cur = hi + 1
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	return out, nil
+}
+
+// catchUpCandles —— 重连后按每个序列的最后闭合K追平到当前
+func (c *HybridClient) catchUpCandles() {
+	if !c.candleBackfill {
+		return
+	}
+	type series struct {
+		inst, tf string
+		last     int64
+	}
+	var all []series
+	c.lastClosedTs.Range(func(k, v any) bool {
+		key, _ := k.(string)
+		i := strings.LastIndex(key, "_")
+		last, _ := v.(int64)
+		if i > 0 && last > 0 {
+			all = append(all, series{key[:i], key[i+1:], last})
+		}
+		return true
+	})
+	now := c.clock.Now().UnixMilli()
+	for _, s := range all {
+		step := TimeframeStep(s.tf).Milliseconds()
+		if step <= 0 || s.last+2*step > now {
+			continue // 没有已闭合的新K
+		}
+		rows, err := c.fetchClosedCandles(s.inst, s.tf, s.last+step, now-step)
+		if err != nil {
+			c.candleCnt.backfillErrs.Add(1)
+			log.Printf("⚠️ 重连追平失败 %s %s: %v", s.inst, s.tf, err)
+		}
+		c.commitCandles(s.inst, s.tf, rows)
+	}
+}
+
+// closedOnly —— 去掉尚未收盘的K（REST 返回的最新一根可能未闭合）
+func closedOnly(cs []Candle, timeframe string, now time.Time) []Candle {
+	step := TimeframeStep(timeframe).Milliseconds()
+	if step <= 0 {
+		return cs
+	}
+	out := cs[:0:0]
+	for _, k := range cs {
+		if k.Timestamp+step <= now.UnixMilli() {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func wsCandle(ts int64, close float64) json.RawMessage {
+	row := []string{strconv.FormatInt(ts, 10), "1", "2", "0.5", strconv.FormatFloat(close, 'f', -1, 64), "10", "0", "0", "1"}
+	b, _ := json.Marshal([][]string{row})
+	return b
+}
+
+func TestCandleGapBackfillInOrder(t *testing.T) {
+	const step = int64(60_000)
+	base := time.Now().Add(-2*time.Hour).UnixMilli() / step * step
+	missing := base + 3*step // 交易所也没有这根
+	var paths []string
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		paths = append(paths, r.URL.Path)
+		q := r.URL.Query()
+		after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+		before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+		var rows [][]string
+		for ts := base + (after-1-base)/step*step; ts > before; ts -= step { // 新->旧
+			if ts == missing {
+				continue
+			}
+			rows = append(rows, []string{strconv.FormatInt(ts, 10), "1", "2", "0.5", "1", "1", "0", "0", "1"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": rows})
+	}))
+	defer srv.Close()
+
+	c := NewHybridClient()
+	c.httpBaseURL = srv.URL
+	var got []int64
+	c.OnCandle(func(cs []Candle) {
+		for _, k := range cs {
+			got = append(got, (k.Timestamp-base)/step)
+		}
+	})
+
+	c.handleWSData("candle1m", "X", "", wsCandle(base, 1))
+	c.handleWSData("candle1m", "X", "", wsCandle(base+6*step, 1)) // 断线：缺 1..5
+	c.handleWSData("candle1m", "X", "", wsCandle(base+6*step, 1)) // 重复
+	c.handleWSData("candle1m", "X", "", wsCandle(base+7*step, 1))
+	c.mergeAndDispatch("X", "1m", []Candle{{Timestamp: base + 7*step}, {Timestamp: base + 8*step}, {Timestamp: time.Now().UnixMilli()}})
+
+	want := []int64{0, 1, 2, 4, 5, 6, 7, 8}
+	if len(got) != len(want) {
+		t.Fatalf("dispatched: %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("dispatched: %v", got)
+		}
+	}
+	st := c.CandleGapStats()
+	if st.Gaps != 1 || st.Missing != 5 || st.Backfilled != 4 || st.Unrecovered != 1 || st.Duplicates != 2 || st.Dispatched != 8 {
+		t.Fatalf("stats: %+v", st)
+	}
+	if calls != 1 || paths[0] != "/api/v5/market/candles" {
+		t.Fatalf("requests: %d %v", calls, paths)
+	}
+
+	// 重连追平：最后一根 8 之后到当前的闭合K
+	c.catchUpCandles()
+	if n := len(got); got[8] != 9 || got[n-1] < 110 || got[n-1]-got[8] != int64(n-9) {
+		t.Fatalf("catch up: %v", got[8:])
+	}
+}
@@ -0,0 +1,526 @@
+package stream
+
+// 连接健康 —— 无限指数退避重连、按频道的数据静默看门狗、订阅回执跟踪、连接状态事件
+// =============================================================================
+// 1) 断线后按 Backoff 无限重试（指数增长 + 抖动，封顶 Max），连上后恢复订阅并 REST 追平K线；
+// 2) 每个订阅（channel:instId）记录最后一条数据的时间，超过 StaleAfter[频道] 未收到即判为静默：
+//    发 ConnStale 并主动断开重连；同一静默期只踢一次（冷门品种不会反复重连），收到数据后发 ConnFresh；
+// 3) 订阅请求带 id：subscribe 回执标记确认；event:error 按 id（缺省时按报文内容）定位失败的订阅，
+//    发 ConnSubscribeFailed 并移出重连恢复列表；AckTimeout 内无回执则重发；两种失败都把该订阅标为不可信
+//    （事件 Stale=true），收到数据后发 ConnFresh，被拒的订阅退订时发 ConnFresh；
+// 4) OnConnState 推送状态变化；ConnEvent 的 Key/InstID/Stale 可直接交给 risk.Engine.SetFeedStale，
+//    行情不可信期间只允许减仓；
+// 5) 以上均按连接分片各自进行（shard.go），Health 汇总全部连接。
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// ConnState —— 连接 / 订阅状态
+type ConnState int
+
+const (
+	ConnConnected       ConnState = iota + 1 // 已连接（含重连成功）
+	ConnDisconnected                         // 连接断开
+	ConnReconnecting                         // 等待第 Attempt 次重连
+	ConnStale                                // 订阅静默超时
+	ConnFresh                                // 静默的订阅恢复数据
+	ConnSubscribeFailed                      // 订阅被拒（event:error）或回执超时
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnected:
+		return "connected"
+	case ConnDisconnected:
+		return "disconnected"
+	case ConnReconnecting:
+		return "reconnecting"
+	case ConnStale:
+		return "stale"
+	case ConnFresh:
+		return "fresh"
+	case ConnSubscribeFailed:
+		return "subscribe_failed"
+	}
+	return "unknown"
+}
+
+// ConnEvent —— 状态事件
+type ConnEvent struct {
+	State   ConnState
+	Key     string // 订阅事件为 channel:instId；连接事件为 shard:<id>（作用于该连接上的全部订阅）
+	Shard   int    // 所在连接
+	Channel string
+	InstID  string
+	Stale   bool          // Key 对应的数据当前是否不可信（断线 / 重连中 / 静默）
+	Attempt int           // 重连次数（ConnReconnecting）
+	Delay   time.Duration // 本次重连前的等待
+	Err     string
+	At      time.Time
+}
+
+// ConnStateSource —— 能推送连接状态的行情源（可选能力）
+type ConnStateSource interface {
+	OnConnState(func(ConnEvent)) Subscription
+}
+
+var _ ConnStateSource = (*HybridClient)(nil)
+
+// Backoff —— 重连退避：Initial·Factor^(n-1)，封顶 Max，再乘以 1±Jitter 的随机因子
+type Backoff struct {
+	Initial time.Duration // 默认 1s
+	Max     time.Duration // 默认 60s
+	Factor  float64       // 默认 2
+	Jitter  float64       // 0~1，默认 0.2
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = time.Second
+	}
+	if b.Max <= 0 {
+		b.Max = 60 * time.Second
+	}
+	if b.Max < b.Initial {
+		b.Max = b.Initial
+	}
+	if b.Factor < 1 {
+		b.Factor = 2
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		b.Jitter = 0.2
+	}
+	return b
+}
+
+// Delay —— 第 attempt 次（从 1 起）重连前的等待；u 为 [0,1) 的随机数
+func (b Backoff) Delay(attempt int, u float64) time.Duration {
+	b = b.withDefaults()
+	d := float64(b.Max)
+	if attempt < 64 { // 之后必然封顶，避免 Pow 溢出
+		d = math.Min(float64(b.Initial)*math.Pow(b.Factor, float64(attempt-1)), float64(b.Max))
+	}
+	return time.Duration(d * (1 + b.Jitter*(2*u-1)))
+}
+
+// 默认静默阈值：ticker 与深度在活跃品种上持续推送；成交/K线等频道可能天然安静，默认不看
+var defaultStaleAfter = map[string]time.Duration{
+	"tickers":        60 * time.Second,
+	ChannelBooks:     60 * time.Second,
+	ChannelBooks5:    60 * time.Second,
+	ChannelBooks50L2: 60 * time.Second,
+	ChannelBooksL2:   60 * time.Second,
+	ChannelBBO:       60 * time.Second,
+}
+
+const defaultAckTimeout = 10 * time.Second
+
+// defaultDialTimeout —— 单次拨号超时（重连按退避再试）
+const defaultDialTimeout = 10 * time.Second
+
+// FeedHealth —— 健康快照
+type FeedHealth struct {
+	Connected   bool          `json:"connected"`   // 全部连接在线
+	Since       time.Time     `json:"since"`       // 最近一次有连接连上的时间
+	Reconnects  int64         `json:"reconnects"`  // 重连尝试次数（全部连接）
+	StaleEvents int64         `json:"staleEvents"` // 静默判定次数
+	AckErrors   int64         `json:"ackErrors"`   // 被拒的订阅数
+	AckTimeouts int64         `json:"ackTimeouts"` // 回执超时（已重发）次数
+	Shards      []ShardHealth `json:"shards"`
+	Subs        []SubHealth   `json:"subs"`
+}
+
+// SubHealth —— 单个订阅
+type SubHealth struct {
+	Key     string    `json:"key"`
+	Shard   int       `json:"shard"`
+	Acked   bool      `json:"acked"`
+	Stale   bool      `json:"stale"`
+	LastMsg time.Time `json:"lastMsg"`
+	Err     string    `json:"err,omitempty"`
+}
+
+type healthCounters struct {
+	reconnects, stale, ackErrs, ackTimeouts atomic.Int64
+}
+
+type subState struct {
+	channel, inst string
+	shard         int
+	sentAt        time.Time
+	lastMsg       time.Time
+	acked, stale  bool
+	err           string
+}
+
+// healthState —— 订阅跟踪（自带锁，读循环 / worker / 看门狗并发访问）
+type healthState struct {
+	mu       sync.Mutex
+	subs     map[string]*subState // key: channel:instId
+	reqs     map[string][]string  // 订阅请求 id -> 尚未回执的 keys
+	nextID   uint64
+	since    time.Time
+	handlers handlerSet[func(ConnEvent)]
+	cnt      healthCounters
+}
+
+// OnConnState —— 连接状态回调（在读循环 / worker / 看门狗 goroutine 中同步调用，须非阻塞）
+func (c *HybridClient) OnConnState(handler func(ConnEvent)) Subscription {
+	return c.health.handlers.add(handler)
+}
+
+func (c *HybridClient) emitConn(evs ...ConnEvent) {
+	if len(evs) == 0 {
+		return
+	}
+	hs := c.health.handlers.snapshot()
+	now := c.clock.Now()
+	for _, ev := range evs {
+		if ev.At.IsZero() {
+			ev.At = now
+		}
+		for _, h := range hs {
+			h(ev)
+		}
+	}
+}
+
+// Health —— 健康快照（连接按 id、订阅按 key 排序）
+func (c *HybridClient) Health() FeedHealth {
+	shards, connected := c.shardHealth()
+	h := &c.health
+	h.mu.Lock()
+	out := FeedHealth{
+		Connected:   connected,
+		Since:       h.since,
+		Reconnects:  h.cnt.reconnects.Load(),
+		StaleEvents: h.cnt.stale.Load(),
+		AckErrors:   h.cnt.ackErrs.Load(),
+		AckTimeouts: h.cnt.ackTimeouts.Load(),
+		Shards:      shards,
+	}
+	for k, s := range h.subs {
+		out.Subs = append(out.Subs, SubHealth{Key: k, Shard: s.shard, Acked: s.acked, Stale: s.stale, LastMsg: s.lastMsg, Err: s.err})
+	}
+	h.mu.Unlock()
+	sort.Slice(out.Subs, func(i, j int) bool { return out.Subs[i].Key < out.Subs[j].Key })
+	return out
+}
+
+// staleLimit —— 频道的静默阈值（≤0 不检测）
+func (c *HybridClient) staleLimit(channel string) time.Duration {
+	if d, ok := c.staleAfter[channel]; ok {
+		return d
+	}
+	return 0
+}
+
+// watchEvery —— 看门狗检查间隔：最小阈值的 1/4，夹在 [10ms, 1s]
+func (c *HybridClient) watchEvery() time.Duration {
+	every := time.Second
+	for _, d := range c.staleAfter {
+		if d > 0 && d/4 < every {
+			every = d / 4
+		}
+	}
+	if c.ackTimeout/4 < every {
+		every = c.ackTimeout / 4
+	}
+	if every < 10*time.Millisecond {
+		every = 10 * time.Millisecond
+	}
+	return every
+}
+
+// ===================== 订阅跟踪 =====================
+
+// trackSubscribe —— 记录发往 shard 的待确认订阅，返回请求 id（静默标记跨重订阅保留）
+func (c *HybridClient) trackSubscribe(channel string, instIDs []string, shard int) string {
+	h := &c.health
+	now := c.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[string]*subState)
+		h.reqs = make(map[string][]string)
+	}
+	h.nextID++
+	id := strconv.FormatUint(h.nextID, 10)
+	keys := make([]string, 0, len(instIDs))
+	for _, inst := range instIDs {
+		key := channel + ":" + inst
+		s := h.subs[key]
+		if s == nil {
+			s = &subState{channel: channel, inst: inst}
+			h.subs[key] = s
+		}
+		s.shard, s.sentAt, s.acked, s.err = shard, now, false, ""
+		keys = append(keys, key)
+	}
+	h.reqs[id] = keys
+	return id
+}
+
+// untrackSubscribe —— 退订：停止跟踪；仍处于静默的订阅补发 ConnFresh（不再阻塞下游）
+func (c *HybridClient) untrackSubscribe(keys []string) []ConnEvent {
+	h := &c.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var evs []ConnEvent
+	for _, key := range keys {
+		if s := h.subs[key]; s != nil {
+			if s.stale {
+				evs = append(evs, ConnEvent{State: ConnFresh, Key: key, Shard: s.shard, Channel: s.channel, InstID: s.inst, Err: "unsubscribed"})
+			}
+			delete(h.subs, key)
+		}
+	}
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Key < evs[j].Key })
+	return evs
+}
+
+// resetSubsOnConnect —— shard 新连接：其上的订阅回到待确认（断线期间不计静默，回执后重新计时），
+// 旧连接上未回执的请求作废
+func (c *HybridClient) resetSubsOnConnect(shard int) {
+	h := &c.health
+	now := c.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.since = now
+	for id, keys := range h.reqs {
+		for _, k := range keys {
+			if s := h.subs[k]; s == nil || s.shard == shard {
+				h.dropReqKey(id, k)
+			}
+		}
+	}
+	for _, s := range h.subs {
+		if s.shard == shard && s.err == "" {
+			s.acked, s.sentAt = false, now
+		}
+	}
+}
+
+func argKey(a *WSArg) (key, channel, inst string) {
+	inst = a.InstID
+	if inst == "" {
+		inst = a.InstType
+	}
+	return a.Channel + ":" + inst, a.Channel, inst
+}
+
+// onSubscribeAck —— subscribe 回执：确认并从此刻开始计静默
+func (c *HybridClient) onSubscribeAck(m *WSMessage) {
+	h := &c.health
+	now := c.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m.Arg == nil {
+		return
+	}
+	key, _, _ := argKey(m.Arg)
+	if m.ID != "" {
+		h.dropReqKey(m.ID, key)
+	}
+	if s := h.subs[key]; s != nil && !s.acked {
+		s.acked, s.lastMsg, s.err = true, now, ""
+	}
+}
+
+// touchSub —— 收到数据：刷新最后消息时间，静默中的订阅恢复
+func (c *HybridClient) touchSub(arg *WSArg) {
+	key, channel, inst := argKey(arg)
+	h := &c.health
+	now := c.clock.Now()
+	h.mu.Lock()
+	s := h.subs[key]
+	if s == nil {
+		h.mu.Unlock()
+		return
+	}
+	s.lastMsg, s.acked = now, true
+	recovered, shard := s.stale, s.shard
+	s.stale = false
+	h.mu.Unlock()
+	if recovered {
+		log.Printf("✅ 行情恢复 %s", key)
+		c.emitConn(ConnEvent{State: ConnFresh, Key: key, Shard: shard, Channel: channel, InstID: inst})
+	}
+}
+
+func (h *healthState) dropReqKey(id, key string) {
+	keys := h.reqs[id]
+	for i, k := range keys {
+		if k == key {
+			keys = append(keys[:i:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(h.reqs, id)
+	} else {
+		h.reqs[id] = keys
+	}
+}
+
+// errInstID —— 取 OKX 错误报文里 "instId:XXX" 的完整品种（到空白/逗号等分隔符为止）；没有则返回 ""
+func errInstID(msg string) string {
+	i := strings.Index(msg, "instId:")
+	if i < 0 {
+		return ""
+	}
+	rest := msg[i+len("instId:"):]
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	if end >= 0 {
+		rest = rest[:end]
+	}
+	return rest
+}
+
+// onWSError —— event:error：按请求 id 取尚未回执的订阅；一个请求含多个参数时，
+// 再按报文里 instId:XXX 的完整品种收窄（OKX 形如 "channel:tickers,instId:XXX doesn't exist"）；
+// 无 id 时只认精确的 instId，BTC-USDT-SWAP 的报错不会落到 BTC-USDT 上
+func (c *HybridClient) onWSError(m *WSMessage) {
+	h := &c.health
+	h.mu.Lock()
+	var cands []string
+	if m.ID != "" {
+		cands = h.reqs[m.ID]
+	} else {
+		for k, s := range h.subs {
+			if !s.acked && s.err == "" {
+				cands = append(cands, k)
+			}
+		}
+	}
+	var keys []string
+	if inst := errInstID(m.Msg); inst != "" {
+		for _, k := range cands {
+			if s := h.subs[k]; s != nil && s.inst == inst {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 && m.ID != "" {
+		keys = cands
+	}
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	for _, k := range keys {
+		if m.ID != "" {
+			h.dropReqKey(m.ID, k)
+		}
+	}
+	reason := fmt.Sprintf("%s %s", m.Code, m.Msg)
+	var evs []ConnEvent
+	for _, k := range keys {
+		s := h.subs[k]
+		if s == nil {
+			continue
+		}
+		s.err, s.stale = reason, true // 该品种没有行情，直到退订或收到数据
+		evs = append(evs, ConnEvent{State: ConnSubscribeFailed, Key: k, Shard: s.shard, Channel: s.channel, InstID: s.inst, Stale: true, Err: reason})
+	}
+	h.mu.Unlock()
+
+	if len(evs) == 0 {
+		log.Printf("⚠️ WS错误: %s", reason)
+		return
+	}
+	// 被拒的订阅不再随重连恢复（参数有误，重试无益）
+	c.mu.Lock()
+	for _, ev := range evs {
+		if sh := c.assign[ev.Key]; sh != nil {
+			delete(sh.subs, ev.Key)
+		}
+	}
+	c.mu.Unlock()
+	h.cnt.ackErrs.Add(int64(len(evs)))
+	for _, ev := range evs {
+		log.Printf("❌ WS订阅被拒 %s: %s", ev.Key, reason)
+	}
+	c.emitConn(evs...)
+}
+
+// ===================== 看门狗 =====================
+
+// watchdog —— 每条连接一个，只看本连接的订阅，随 closeCh 退出
+func (c *HybridClient) watchdog(sh *wsShard, closeCh <-chan struct{}) {
+	every := c.watchEvery()
+	for {
+		select {
+		case <-c.clock.After(every):
+			c.checkSubs(sh, c.clock.Now())
+		case <-closeCh:
+			return
+		}
+	}
+}
+
+// checkSubs —— 静默判定与回执超时重发（sh 上的订阅）
+func (c *HybridClient) checkSubs(sh *wsShard, now time.Time) {
+	h := &c.health
+	var evs []ConnEvent
+	resend := make(map[string][]string) // channel -> instIDs
+	h.mu.Lock()
+	for k, s := range h.subs {
+		switch {
+		case s.shard != sh.id || s.err != "":
+		case !s.acked:
+			if now.Sub(s.sentAt) > c.ackTimeout {
+				resend[s.channel] = append(resend[s.channel], s.inst)
+				h.cnt.ackTimeouts.Add(1)
+				s.stale = true // 收到数据（touchSub）后发 ConnFresh
+				evs = append(evs, ConnEvent{State: ConnSubscribeFailed, Key: k, Shard: s.shard, Channel: s.channel, InstID: s.inst, Stale: true, Err: "ack timeout"})
+			}
+		case !s.stale:
+			if lim := c.staleLimit(s.channel); lim > 0 && now.Sub(s.lastMsg) > lim {
+				s.stale = true
+				h.cnt.stale.Add(1)
+				evs = append(evs, ConnEvent{State: ConnStale, Key: k, Shard: s.shard, Channel: s.channel, InstID: s.inst, Stale: true,
+					Err: fmt.Sprintf("%s 无数据", now.Sub(s.lastMsg).Truncate(time.Millisecond))})
+			}
+		}
+	}
+	h.mu.Unlock()
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Key < evs[j].Key })
+
+	kick := false
+	for _, ev := range evs {
+		if ev.State == ConnStale {
+			kick = true
+			log.Printf("⚠️ 行情静默 %s: %s", ev.Key, ev.Err)
+		}
+	}
+	c.emitConn(evs...)
+	for ch, ids := range resend {
+		sort.Strings(ids)
+		log.Printf("⚠️ WS订阅回执超时，重发 %s %v", ch, ids)
+		_ = c.subscribeWS(ch, ids)
+	}
+	if kick {
+		c.recycleShard(sh)
+	}
+}
+
+// recycleShard —— 主动断开该连接，由读循环走正常的重连流程
+func (c *HybridClient) recycleShard(sh *wsShard) {
+	c.mu.RLock()
+	conn := sh.conn
+	c.mu.RUnlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,254 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Factor: 2, Jitter: 0.2}
+	for _, c := range []struct {
+		n    int
+		u    float64
+		want time.Duration
+	}{
+		{1, 0.5, time.Second},
+		{3, 0.5, 4 * time.Second},
+		{5, 0.5, 10 * time.Second},
+		{1000, 0.5, 10 * time.Second},
+		{1, 0, 800 * time.Millisecond},
+		{4, 1, 9600 * time.Millisecond},
+	} {
+		if got := b.Delay(c.n, c.u); got != c.want {
+			t.Fatalf("Delay(%d,%v)=%v want %v", c.n, c.u, got, c.want)
+		}
+	}
+}
+
+// okxStub —— 回订阅回执（BAD 品种回 event:error，SLOW 品种不回），可向当前连接推消息或断开
+type okxStub struct {
+	mu    sync.Mutex
+	conn  *websocket.Conn
+	conns int
+	subs  []string
+	ready chan struct{} // 每次收到订阅请求
+}
+
+func (s *okxStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.conns++
+	s.mu.Unlock()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID   string  `json:"id"`
+			Op   string  `json:"op"`
+			Args []WSArg `json:"args"`
+		}
+		if json.Unmarshal(msg, &req) != nil || req.Op != "subscribe" {
+			continue
+		}
+		s.mu.Lock()
+		for _, a := range req.Args {
+			s.subs = append(s.subs, a.InstID)
+			switch a.InstID {
+			case "SLOW":
+			case "BAD":
+				conn.WriteJSON(map[string]string{"event": "error", "code": "60018", "msg": "Wrong URL or channel:tickers,instId:BAD doesn't exist.", "id": req.ID})
+			default:
+				conn.WriteJSON(map[string]any{"event": "subscribe", "arg": a, "id": req.ID})
+			}
+		}
+		s.mu.Unlock()
+		s.ready <- struct{}{}
+	}
+}
+
+func (s *okxStub) push(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func (s *okxStub) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Close()
+}
+
+func TestReconnectStalenessAndAcks(t *testing.T) {
+	stub := &okxStub{ready: make(chan struct{}, 16)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	c := NewMarketClient(Options{
+		WSURL:      "ws" + strings.TrimPrefix(srv.URL, "http"),
+		HTTPURL:    srv.URL,
+		Reconnect:  Backoff{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond},
+		StaleAfter: map[string]time.Duration{"tickers": 200 * time.Millisecond},
+	})
+	evs := make(chan ConnEvent, 64)
+	c.OnConnState(func(ev ConnEvent) { evs <- ev })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(want ConnState) ConnEvent {
+		t.Helper()
+		for {
+			select {
+			case ev := <-evs:
+				if ev.State == want {
+					return ev
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("no %v event", want)
+			}
+		}
+	}
+	waitSub := func() {
+		t.Helper()
+		select {
+		case <-stub.ready:
+		case <-time.After(3 * time.Second):
+			t.Fatal("no subscribe request")
+		}
+	}
+	tick := `{"arg":{"channel":"tickers","instId":"X"},"data":[{"instId":"X","last":"1"}]}`
+
+	next(ConnConnected)
+	if err := c.SubscribeTickers([]string{"X", "BAD"}); err != nil {
+		t.Fatal(err)
+	}
+	waitSub()
+	if ev := next(ConnSubscribeFailed); ev.Key != "tickers:BAD" || ev.InstID != "BAD" || !ev.Stale || !strings.Contains(ev.Err, "60018") {
+		t.Fatalf("subscribe error: %+v", ev)
+	}
+
+	// 持续推送不判静默；停推后判静默并踢线重连，只恢复未被拒的订阅
+	for i := 0; i < 5; i++ {
+		stub.push(tick)
+		time.Sleep(50 * time.Millisecond)
+	}
+	if ev := next(ConnStale); ev.Key != "tickers:X" || !ev.Stale {
+		t.Fatalf("stale: %+v", ev)
+	}
+	next(ConnDisconnected)
+	if ev := next(ConnReconnecting); ev.Attempt != 1 || ev.Delay <= 0 {
+		t.Fatalf("reconnecting: %+v", ev)
+	}
+	next(ConnConnected)
+	waitSub()
+	stub.push(tick)
+	if ev := next(ConnFresh); ev.Key != "tickers:X" || ev.Stale {
+		t.Fatalf("fresh: %+v", ev)
+	}
+
+	// 服务端断开：照常重连
+	stub.drop()
+	next(ConnDisconnected)
+	next(ConnConnected)
+	waitSub()
+
+	stub.mu.Lock()
+	conns, subs := stub.conns, strings.Join(stub.subs, ",")
+	stub.mu.Unlock()
+	if conns != 3 || subs != "X,BAD,X,X" {
+		t.Fatalf("conns=%d subs=%s", conns, subs)
+	}
+	h := c.Health()
+	if !h.Connected || h.Reconnects != 2 || h.StaleEvents != 1 || h.AckErrors != 1 || len(h.Subs) != 2 ||
+		h.Subs[0].Key != "tickers:BAD" || h.Subs[0].Err == "" || h.Subs[1].Stale {
+		t.Fatalf("health: %+v", h)
+	}
+}
+
+func TestAckTimeoutMarksFeedStale(t *testing.T) {
+	stub := &okxStub{ready: make(chan struct{}, 16)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	c := NewMarketClient(Options{
+		WSURL:      "ws" + strings.TrimPrefix(srv.URL, "http"),
+		HTTPURL:    srv.URL,
+		AckTimeout: 100 * time.Millisecond,
+		StaleAfter: map[string]time.Duration{"tickers": 0},
+	})
+	evs := make(chan ConnEvent, 64)
+	c.OnConnState(func(ev ConnEvent) { evs <- ev })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	next := func(want ConnState) ConnEvent {
+		t.Helper()
+		for {
+			select {
+			case ev := <-evs:
+				if ev.State == want {
+					return ev
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("no %v event", want)
+			}
+		}
+	}
+
+	if err := c.SubscribeTickers([]string{"SLOW"}); err != nil {
+		t.Fatal(err)
+	}
+	// 回执超时：该品种标为不可信，交给风控只减不加
+	if ev := next(ConnSubscribeFailed); ev.Key != "tickers:SLOW" || ev.InstID != "SLOW" || !ev.Stale || ev.Err != "ack timeout" {
+		t.Fatalf("ack timeout: %+v", ev)
+	}
+	stub.push(`{"arg":{"channel":"tickers","instId":"SLOW"},"data":[{"instId":"SLOW","last":"1"}]}`)
+	if ev := next(ConnFresh); ev.Key != "tickers:SLOW" || ev.Stale {
+		t.Fatalf("fresh: %+v", ev)
+	}
+}
+
+func TestWSErrorMatchesExactInstID(t *testing.T) {
+	c := NewMarketClient(Options{})
+	var evs []ConnEvent
+	c.OnConnState(func(ev ConnEvent) { evs = append(evs, ev) })
+
+	// BTC-USDT-SWAP 被拒不能连带 BTC-USDT（子串相同）
+	id := c.trackSubscribe("tickers", []string{"BTC-USDT", "BTC-USDT-SWAP"}, 0)
+	c.onWSError(&WSMessage{Event: "error", ID: id, Code: "60018", Msg: "Wrong URL or channel:tickers,instId:BTC-USDT-SWAP doesn't exist."})
+	if len(evs) != 1 || evs[0].Key != "tickers:BTC-USDT-SWAP" {
+		t.Fatalf("events with id: %+v", evs)
+	}
+	if keys := c.health.reqs[id]; len(keys) != 1 || keys[0] != "tickers:BTC-USDT" {
+		t.Fatalf("pending: %v", keys)
+	}
+
+	// 无 id：同样只认完整的 instId
+	evs = nil
+	c.trackSubscribe("tickers", []string{"ETH-USDT", "ETH-USDT-SWAP"}, 0)
+	c.onWSError(&WSMessage{Event: "error", Code: "60018", Msg: "Wrong URL or channel:tickers,instId:ETH-USDT doesn't exist."})
+	if len(evs) != 1 || evs[0].Key != "tickers:ETH-USDT" {
+		t.Fatalf("events without id: %+v", evs)
+	}
+	if s := c.health.subs["tickers:BTC-USDT"]; s.stale || s.err != "" {
+		t.Fatalf("BTC-USDT marked failed: %+v", s)
+	}
+}
@@ -0,0 +1,179 @@
+package stream
+
+// 行情源抽象 —— MarketData 接口、生命周期、可退订回调、可注入的地址/拨号器/时钟
+// =============================================================================
+// 1) MarketData：Start(ctx) 启动、ctx 结束即关闭；On* 返回 Subscription，可随时退订；
+//    HybridClient（实时）与 Replayer（回放）都满足，策略/风控/运行器只依赖接口即可切换数据源；
+// 2) Chan：把任一 On* 回调转成带缓冲的 channel，ctx 结束自动退订并关闭；
+// 3) Options：WS/HTTP 地址（可取 config.MarketConfig）、Dialer、HTTP 客户端、Clock，
+//    测试可连本地 WS 服务、用假时钟驱动重连与心跳。
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"Mod/src/config"
+)
+
+// Subscription —— 回调句柄（别名：其他包可直接声明同型的 interface{ Unsubscribe() }）
+type Subscription = interface{ Unsubscribe() }
+
+// MarketData —— 行情源
+type MarketData interface {
+	Start(ctx context.Context) error
+	OnTicker(func([]TickerData)) Subscription
+	OnTrade(func([]TradeData)) Subscription
+	OnBook(func(map[string][]BookData)) Subscription
+	OnCandle(func([]Candle)) Subscription
+	Close()
+}
+
+// BookTop —— 维护本地簿的行情源（可选能力）
+type BookTop interface {
+	BestBidAsk(instID string) (bid, ask Level, ok bool)
+}
+
+var (
+	_ MarketData = (*HybridClient)(nil)
+	_ MarketData = (*Replayer)(nil)
+	_ BookTop    = (*HybridClient)(nil)
+	_ BookTop    = (*Replayer)(nil)
+)
+
+// Dialer —— WS 拨号（*websocket.Dialer 满足）
+type Dialer interface {
+	DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*websocket.Conn, *http.Response, error)
+}
+
+// Clock —— 时间源（重连退避、心跳、读超时、K线闭合判断）
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Options —— 客户端选项（零值取 OKX 公共地址与系统默认）
+type Options struct {
+	WSURL      string
+	HTTPURL    string
+	Dialer     Dialer
+	HTTPClient *http.Client
+	Clock      Clock
+
+	// 连接健康（health.go）
+	Reconnect   Backoff                  // 重连退避（无限次）
+	StaleAfter  map[string]time.Duration // 频道 -> 静默阈值，覆盖默认；≤0 关闭该频道检测
+	AckTimeout  time.Duration            // 订阅回执超时（默认 10s，超时重发）
+	DialTimeout time.Duration            // 单次拨号超时（默认 10s）
+
+	// 连接分片（shard.go）
+	Shards ShardConfig
+}
+
+// OptionsFromConfig —— 取行情配置中的地址
+func OptionsFromConfig(mc config.MarketConfig) Options {
+	return Options{WSURL: mc.WSURL, HTTPURL: mc.HTTPURL}
+}
+
+// Start —— 连接 WS；ctx 结束时关闭客户端（On* 回调可在 Start 前注册，Subscribe* 须在连接之后）
+func (c *HybridClient) Start(ctx context.Context) error {
+	if err := c.ConnectWebSocketContext(ctx); err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-c.done:
+		}
+	}()
+	return nil
+}
+
+// Chan —— 把 On* 回调转成 channel（阻塞投递，消费慢会反压分发方）；ctx 结束后退订并关闭
+//
+//	ticks := stream.Chan(ctx, md.OnTicker, 256)
+func Chan[T any](ctx context.Context, register func(func(T)) Subscription, buf int) <-chan T {
+	ch := make(chan T, buf)
+	var mu sync.Mutex
+	closed := false
+	sub := register(func(v T) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		mu.Lock()
+		closed = true
+		close(ch)
+		mu.Unlock()
+	}()
+	return ch
+}
+
+// ===================== 回调集合 =====================
+
+// handlerSet —— 可退订的回调列表（并发安全；分发时取快照，回调内可再注册/退订）
+type handlerSet[T any] struct {
+	mu   sync.RWMutex
+	next uint64
+	hs   []handlerEntry[T]
+}
+
+type handlerEntry[T any] struct {
+	id uint64
+	h  T
+}
+
+type subscription struct {
+	once sync.Once
+	fn   func()
+}
+
+func (s *subscription) Unsubscribe() { s.once.Do(s.fn) }
+
+func (s *handlerSet[T]) add(h T) Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	id := s.next
+	s.hs = append(s.hs, handlerEntry[T]{id: id, h: h})
+	return &subscription{fn: func() { s.remove(id) }}
+}
+
+func (s *handlerSet[T]) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.hs {
+		if e.id == id {
+			s.hs = append(s.hs[:i:i], s.hs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *handlerSet[T]) snapshot() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]T, len(s.hs))
+	for i, e := range s.hs {
+		out[i] = e.h
+	}
+	return out
+}
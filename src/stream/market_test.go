@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 本地 WS：收到订阅即回 ack，之后把 push 里的消息原样推给客户端
+func localWS(t *testing.T, push <-chan string) *httptest.Server {
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case m := <-push:
+				if conn.WriteMessage(websocket.TextMessage, []byte(m)) != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMarketDataLocalServer(t *testing.T) {
+	push := make(chan string, 8)
+	srv := localWS(t, push)
+
+	var md MarketData = NewMarketClient(Options{WSURL: "ws" + strings.TrimPrefix(srv.URL, "http"), HTTPURL: srv.URL})
+	got := make(chan string, 8)
+	sub := md.OnTicker(func(arr []TickerData) { got <- "cb:" + arr[0].Last })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := Chan(ctx, md.OnTicker, 8)
+	if err := md.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.(*HybridClient).SubscribeTickers([]string{"X"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tick := func(last string) string {
+		return `{"arg":{"channel":"tickers","instId":"X"},"data":[{"instId":"X","last":"` + last + `"}]}`
+	}
+	recv := func(ch <-chan string) string {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(2 * time.Second):
+			return "timeout"
+		}
+	}
+
+	push <- tick("100")
+	if v := recv(got); v != "cb:100" {
+		t.Fatalf("handler: %s", v)
+	}
+	select {
+	case arr := <-ticks:
+		if arr[0].Last != "100" {
+			t.Fatalf("chan: %+v", arr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("chan: timeout")
+	}
+
+	// 退订后不再回调；Chan 仍在
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	push <- tick("101")
+	if arr := <-ticks; arr[0].Last != "101" {
+		t.Fatalf("chan after unsubscribe: %+v", arr)
+	}
+	select {
+	case v := <-got:
+		t.Fatalf("unsubscribed handler called: %s", v)
+	default:
+	}
+
+	// ctx 结束：Chan 关闭、客户端关闭
+	cancel()
+	for range ticks {
+	}
+	c := md.(*HybridClient)
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client not closed on ctx cancel")
+	}
+	if err := c.ConnectWebSocket(); err == nil {
+		t.Fatal("connect after close should fail")
+	}
+}
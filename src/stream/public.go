@@ -0,0 +1,465 @@
+package stream
+
+// 其他公共频道 —— 资金费率 / 标记价格 / 指数 / 持仓量 / 强平 / 标记价格K线
+// =============================================================================
+// 1) 每个频道一组 Subscribe*/Unsubscribe*/On*，数据结构保持 OKX 原样字符串（与 TickerData 一致）；
+// 2) liquidation-orders 按 instType 订阅（订阅键 channel:instType），其余按 instId；
+// 3) 派生量：OnFunding 给出年化资金费率（按结算间隔折算），OnBasis 给出 标记价/指数 - 1，
+//    策略经 strategy.QuantMasterElite.BindCarry 自动接入；
+// 4) REST：GetFundingRateHistory 按时间区间分页拉取历史资金费率（升序）。
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ChannelFundingRate     = "funding-rate"
+	ChannelMarkPrice       = "mark-price"
+	ChannelIndexTickers    = "index-tickers"
+	ChannelOpenInterest    = "open-interest"
+	ChannelLiquidation     = "liquidation-orders"
+	ChannelMarkPriceCandle = "mark-price-candle" // + 1m/5m/15m/30m/1H/4H/1D
+
+	defaultFundingInterval = 8 * time.Hour
+	fundingHistoryPageSize = 100
+)
+
+// FundingRateData —— funding-rate 推送
+type FundingRateData struct {
+	InstID          string `json:"instId"`
+	InstType        string `json:"instType"`
+	Method          string `json:"method"` // current_period / next_period
+	FundingRate     string `json:"fundingRate"`
+	NextFundingRate string `json:"nextFundingRate"`
+	FundingTime     string `json:"fundingTime"`     // 本期结算时间（ms）
+	NextFundingTime string `json:"nextFundingTime"` // 下期结算时间（ms）
+	MinFundingRate  string `json:"minFundingRate"`
+	MaxFundingRate  string `json:"maxFundingRate"`
+	Premium         string `json:"premium"`
+	Ts              string `json:"ts"`
+}
+
+// Annualized —— 年化资金费率（结算间隔取 nextFundingTime - fundingTime，缺省 8h）
+func (f FundingRateData) Annualized() float64 {
+	rate, _ := strconv.ParseFloat(f.FundingRate, 64)
+	ft, _ := strconv.ParseInt(f.FundingTime, 10, 64)
+	nft, _ := strconv.ParseInt(f.NextFundingTime, 10, 64)
+	interval := defaultFundingInterval
+	if nft > ft && ft > 0 {
+		interval = time.Duration(nft-ft) * time.Millisecond
+	}
+	return AnnualizeFunding(rate, interval)
+}
+
+// AnnualizeFunding —— 单期费率 × 每年结算次数
+func AnnualizeFunding(rate float64, interval time.Duration) float64 {
+	if interval <= 0 {
+		interval = defaultFundingInterval
+	}
+	return rate * float64(365*24*time.Hour) / float64(interval)
+}
+
+// MarkPriceData —— mark-price 推送
+type MarkPriceData struct {
+	InstID   string `json:"instId"`
+	InstType string `json:"instType"`
+	MarkPx   string `json:"markPx"`
+	Ts       string `json:"ts"`
+}
+
+// IndexTickerData —— index-tickers 推送（instId 为指数，如 BTC-USDT）
+type IndexTickerData struct {
+	InstID  string `json:"instId"`
+	IdxPx   string `json:"idxPx"`
+	Open24h string `json:"open24h"`
+	High24h string `json:"high24h"`
+	Low24h  string `json:"low24h"`
+	SodUtc0 string `json:"sodUtc0"`
+	SodUtc8 string `json:"sodUtc8"`
+	Ts      string `json:"ts"`
+}
+
+// OpenInterestData —— open-interest 推送
+type OpenInterestData struct {
+	InstID   string `json:"instId"`
+	InstType string `json:"instType"`
+	Oi       string `json:"oi"`    // 张
+	OiCcy    string `json:"oiCcy"` // 币
+	OiUsd    string `json:"oiUsd"`
+	Ts       string `json:"ts"`
+}
+
+// LiquidationData —— liquidation-orders 推送（同一合约的一批强平单）
+type LiquidationData struct {
+	InstID     string              `json:"instId"`
+	InstType   string              `json:"instType"`
+	InstFamily string              `json:"instFamily"`
+	Uly        string              `json:"uly"`
+	Details    []LiquidationDetail `json:"details"`
+}
+
+// LiquidationDetail —— 单笔强平
+type LiquidationDetail struct {
+	Side    string `json:"side"` // 强平单方向：buy = 空头被强平
+	PosSide string `json:"posSide"`
+	BkPx    string `json:"bkPx"`
+	Sz      string `json:"sz"`
+	BkLoss  string `json:"bkLoss"`
+	Ccy     string `json:"ccy"`
+	Ts      string `json:"ts"`
+}
+
+// FundingRate —— 历史资金费率（REST）
+type FundingRate struct {
+	InstID       string  `json:"instId"`
+	FundingTime  int64   `json:"fundingTime"`
+	FundingRate  float64 `json:"fundingRate"`
+	RealizedRate float64 `json:"realizedRate"`
+	Method       string  `json:"method"`
+}
+
+// publicState —— 本文件频道的回调与派生量缓存
+type publicState struct {
+	fundingHandlers    handlerSet[func([]FundingRateData)]
+	markHandlers       handlerSet[func([]MarkPriceData)]
+	indexHandlers      handlerSet[func([]IndexTickerData)]
+	oiHandlers         handlerSet[func([]OpenInterestData)]
+	liqHandlers        handlerSet[func([]LiquidationData)]
+	markCandleHandlers handlerSet[func([]Candle)]
+	annualHandlers     handlerSet[func(inst string, annualRate float64, ts time.Time)]
+	basisHandlers      handlerSet[func(inst string, basis float64, ts time.Time)]
+
+	mu    sync.Mutex
+	marks map[string]float64 // instId -> 标记价
+	index map[string]float64 // 指数 -> 指数价
+}
+
+type basisPt struct {
+	inst  string
+	basis float64
+	ts    time.Time
+}
+
+// IndexID —— 合约对应的指数（BTC-USDT-SWAP / BTC-USD-240329 -> BTC-USDT / BTC-USD）
+func IndexID(instID string) string {
+	parts := strings.Split(instID, "-")
+	if len(parts) < 2 {
+		return instID
+	}
+	return parts[0] + "-" + parts[1]
+}
+
+// wsArg —— 订阅参数：liquidation-orders 的 id 为 instType
+func wsArg(channel, id string) WSArg {
+	if channel == ChannelLiquidation {
+		return WSArg{Channel: channel, InstType: id}
+	}
+	return WSArg{Channel: channel, InstID: id}
+}
+
+// ===================== 订阅 =====================
+
+func (c *HybridClient) SubscribeFundingRate(instIDs []string) error {
+	return c.subscribeWS(ChannelFundingRate, instIDs)
+}
+func (c *HybridClient) SubscribeMarkPrice(instIDs []string) error {
+	return c.subscribeWS(ChannelMarkPrice, instIDs)
+}
+
+// SubscribeIndexTickers —— indexIDs 为指数（见 IndexID）
+func (c *HybridClient) SubscribeIndexTickers(indexIDs []string) error {
+	return c.subscribeWS(ChannelIndexTickers, indexIDs)
+}
+func (c *HybridClient) SubscribeOpenInterest(instIDs []string) error {
+	return c.subscribeWS(ChannelOpenInterest, instIDs)
+}
+
+// SubscribeLiquidations —— 按产品类型订阅（SWAP / FUTURES / OPTION / MARGIN）
+func (c *HybridClient) SubscribeLiquidations(instTypes []string) error {
+	return c.subscribeWS(ChannelLiquidation, instTypes)
+}
+func (c *HybridClient) SubscribeMarkPriceCandles(instIDs []string, timeframe string) error {
+	return c.subscribeWS(ChannelMarkPriceCandle+strings.TrimPrefix(c.tfToCandleChannel(timeframe), "candle"), instIDs)
+}
+
+func (c *HybridClient) UnsubscribeFundingRate(instIDs []string) error {
+	return c.unsubscribeWS(ChannelFundingRate, instIDs)
+}
+func (c *HybridClient) UnsubscribeMarkPrice(instIDs []string) error {
+	return c.unsubscribeWS(ChannelMarkPrice, instIDs)
+}
+func (c *HybridClient) UnsubscribeIndexTickers(indexIDs []string) error {
+	return c.unsubscribeWS(ChannelIndexTickers, indexIDs)
+}
+func (c *HybridClient) UnsubscribeOpenInterest(instIDs []string) error {
+	return c.unsubscribeWS(ChannelOpenInterest, instIDs)
+}
+func (c *HybridClient) UnsubscribeLiquidations(instTypes []string) error {
+	return c.unsubscribeWS(ChannelLiquidation, instTypes)
+}
+func (c *HybridClient) UnsubscribeMarkPriceCandles(instIDs []string, timeframe string) error {
+	return c.unsubscribeWS(ChannelMarkPriceCandle+strings.TrimPrefix(c.tfToCandleChannel(timeframe), "candle"), instIDs)
+}
+
+// SubscribeCarry —— 资金费率（仅永续）+ 标记价格 + 对应指数，供 OnFunding / OnBasis 使用
+func (c *HybridClient) SubscribeCarry(instIDs []string) error {
+	var swaps []string
+	seen := make(map[string]bool)
+	var idx []string
+	for _, id := range instIDs {
+		if strings.HasSuffix(id, "-SWAP") {
+			swaps = append(swaps, id)
+		}
+		if ix := IndexID(id); !seen[ix] {
+			seen[ix] = true
+			idx = append(idx, ix)
+		}
+	}
+	if len(swaps) > 0 {
+		if err := c.SubscribeFundingRate(swaps); err != nil {
+			return err
+		}
+	}
+	if err := c.SubscribeMarkPrice(instIDs); err != nil {
+		return err
+	}
+	return c.SubscribeIndexTickers(idx)
+}
+
+// ===================== 回调 =====================
+
+func (c *HybridClient) OnFundingRate(handler func([]FundingRateData)) Subscription {
+	return c.pub.fundingHandlers.add(handler)
+}
+func (c *HybridClient) OnMarkPrice(handler func([]MarkPriceData)) Subscription {
+	return c.pub.markHandlers.add(handler)
+}
+func (c *HybridClient) OnIndexTicker(handler func([]IndexTickerData)) Subscription {
+	return c.pub.indexHandlers.add(handler)
+}
+func (c *HybridClient) OnOpenInterest(handler func([]OpenInterestData)) Subscription {
+	return c.pub.oiHandlers.add(handler)
+}
+func (c *HybridClient) OnLiquidation(handler func([]LiquidationData)) Subscription {
+	return c.pub.liqHandlers.add(handler)
+}
+
+// OnMarkPriceCandle —— 标记价格K线（仅闭合K，Volume 为 0）
+func (c *HybridClient) OnMarkPriceCandle(handler func([]Candle)) Subscription {
+	return c.pub.markCandleHandlers.add(handler)
+}
+
+// OnFunding —— 年化资金费率（每条 funding-rate 推送一次）
+func (c *HybridClient) OnFunding(handler func(inst string, annualRate float64, ts time.Time)) Subscription {
+	return c.pub.annualHandlers.add(handler)
+}
+
+// OnBasis —— 基差 = 标记价 / 指数价 - 1（标记价或指数更新时重算）
+func (c *HybridClient) OnBasis(handler func(inst string, basis float64, ts time.Time)) Subscription {
+	return c.pub.basisHandlers.add(handler)
+}
+
+// ===================== 路由 =====================
+
+// handlePublicWS —— 由 handleWSData 转入（未知频道忽略）
+func (c *HybridClient) handlePublicWS(channel, instID string, data json.RawMessage) {
+	switch {
+	case channel == ChannelFundingRate:
+		if arr, ok := decodeData[FundingRateData](c, channel, data); ok {
+			c.onFundingWS(arr)
+		}
+	case channel == ChannelMarkPrice:
+		if arr, ok := decodeData[MarkPriceData](c, channel, data); ok {
+			c.onMarkWS(arr)
+		}
+	case channel == ChannelIndexTickers:
+		if arr, ok := decodeData[IndexTickerData](c, channel, data); ok {
+			c.onIndexWS(arr)
+		}
+	case channel == ChannelOpenInterest:
+		if arr, ok := decodeData[OpenInterestData](c, channel, data); ok {
+			for _, h := range c.pub.oiHandlers.snapshot() {
+				h(arr)
+			}
+		}
+	case channel == ChannelLiquidation:
+		if arr, ok := decodeData[LiquidationData](c, channel, data); ok {
+			for _, h := range c.pub.liqHandlers.snapshot() {
+				h(arr)
+			}
+		}
+	case strings.HasPrefix(channel, ChannelMarkPriceCandle):
+		// data: [[ts, o, h, l, c, confirm], ...]
+		var rows [][]string
+		tf := c.channelToTF("candle" + strings.TrimPrefix(channel, ChannelMarkPriceCandle))
+		if tf == "" {
+			return
+		}
+		if err := json.Unmarshal(data, &rows); err != nil {
+			c.malformed(channel, err, data)
+			return
+		}
+		var closed []Candle
+		for _, r := range rows {
+			if len(r) < 6 || r[5] != "1" {
+				continue
+			}
+			ts, _ := strconv.ParseInt(r[0], 10, 64)
+			o, _ := strconv.ParseFloat(r[1], 64)
+			h, _ := strconv.ParseFloat(r[2], 64)
+			l, _ := strconv.ParseFloat(r[3], 64)
+			cx, _ := strconv.ParseFloat(r[4], 64)
+			closed = append(closed, Candle{Timestamp: ts, Open: o, High: h, Low: l, Close: cx, InstID: instID, TF: tf})
+		}
+		if len(closed) == 0 {
+			return
+		}
+		for _, h := range c.pub.markCandleHandlers.snapshot() {
+			h(closed)
+		}
+	}
+}
+
+func (c *HybridClient) onFundingWS(arr []FundingRateData) {
+	hs := c.pub.fundingHandlers.snapshot()
+	as := c.pub.annualHandlers.snapshot()
+	for _, h := range hs {
+		h(arr)
+	}
+	for _, f := range arr {
+		if f.FundingRate == "" {
+			continue
+		}
+		ann, ts := f.Annualized(), msToTime(f.Ts)
+		for _, h := range as {
+			h(f.InstID, ann, ts)
+		}
+	}
+}
+
+func (c *HybridClient) onMarkWS(arr []MarkPriceData) {
+	for _, h := range c.pub.markHandlers.snapshot() {
+		h(arr)
+	}
+	var pts []basisPt
+	c.pub.mu.Lock()
+	if c.pub.marks == nil {
+		c.pub.marks = make(map[string]float64)
+	}
+	for _, m := range arr {
+		px, err := strconv.ParseFloat(m.MarkPx, 64)
+		if err != nil || px <= 0 {
+			continue
+		}
+		c.pub.marks[m.InstID] = px
+		if ix := c.pub.index[IndexID(m.InstID)]; ix > 0 {
+			pts = append(pts, basisPt{m.InstID, px/ix - 1, msToTime(m.Ts)})
+		}
+	}
+	c.pub.mu.Unlock()
+	for _, p := range pts {
+		c.dispatchBasis(p.inst, p.basis, p.ts)
+	}
+}
+
+func (c *HybridClient) onIndexWS(arr []IndexTickerData) {
+	for _, h := range c.pub.indexHandlers.snapshot() {
+		h(arr)
+	}
+	var pts []basisPt
+	c.pub.mu.Lock()
+	if c.pub.index == nil {
+		c.pub.index = make(map[string]float64)
+	}
+	for _, t := range arr {
+		px, err := strconv.ParseFloat(t.IdxPx, 64)
+		if err != nil || px <= 0 {
+			continue
+		}
+		c.pub.index[t.InstID] = px
+		for inst, mark := range c.pub.marks {
+			if IndexID(inst) == t.InstID {
+				pts = append(pts, basisPt{inst, mark/px - 1, msToTime(t.Ts)})
+			}
+		}
+	}
+	c.pub.mu.Unlock()
+	sort.Slice(pts, func(i, j int) bool { return pts[i].inst < pts[j].inst })
+	for _, p := range pts {
+		c.dispatchBasis(p.inst, p.basis, p.ts)
+	}
+}
+
+func (c *HybridClient) dispatchBasis(inst string, basis float64, ts time.Time) {
+	for _, h := range c.pub.basisHandlers.snapshot() {
+		h(inst, basis, ts)
+	}
+}
+
+func msToTime(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(ms)
+}
+
+// ===================== REST =====================
+
+// GetFundingRateHistory —— [start, end] 内的历史资金费率（升序；end 零值表示到当前）
+// OKX 按 fundingTime 新->旧分页，after 取上一页最旧一条
+func (c *HybridClient) GetFundingRateHistory(instID string, start, end time.Time) ([]FundingRate, error) {
+	type row struct {
+		InstID       string `json:"instId"`
+		FundingTime  string `json:"fundingTime"`
+		FundingRate  string `json:"fundingRate"`
+		RealizedRate string `json:"realizedRate"`
+		Method       string `json:"method"`
+	}
+	var out []FundingRate
+	after := ""
+	if !end.IsZero() {
+		after = strconv.FormatInt(end.UnixMilli()+1, 10)
+	}
+	for {
+		q := url.Values{"instId": {instID}, "limit": {strconv.Itoa(fundingHistoryPageSize)}}
+		if after != "" {
+			q.Set("after", after)
+		}
+		raw, err := c.GetPublic("/api/v5/public/funding-rate-history", q)
+		if err != nil {
+			return nil, err
+		}
+		var rows []row
+		if err := json.Unmarshal(raw, &rows); err != nil {
+			return nil, fmt.Errorf("解析资金费率失败: %v", err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		oldest := int64(0)
+		for _, r := range rows {
+			ft, _ := strconv.ParseInt(r.FundingTime, 10, 64)
+			oldest = ft
+			if ft < start.UnixMilli() {
+				continue
+			}
+			rate, _ := strconv.ParseFloat(r.FundingRate, 64)
+			realized, _ := strconv.ParseFloat(r.RealizedRate, 64)
+			out = append(out, FundingRate{InstID: r.InstID, FundingTime: ft, FundingRate: rate, RealizedRate: realized, Method: r.Method})
+		}
+		if oldest < start.UnixMilli() || len(rows) < fundingHistoryPageSize {
+			break
+		}
+		after = strconv.FormatInt(oldest, 10)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FundingTime < out[j].FundingTime })
+	return out, nil
+}
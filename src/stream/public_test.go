@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPublicChannelsFundingAndBasis(t *testing.T) {
+	c := NewHybridClient()
+	var ann []float64
+	var basis []string
+	c.OnFunding(func(inst string, r float64, _ time.Time) { ann = append(ann, r) })
+	c.OnBasis(func(inst string, b float64, _ time.Time) {
+		basis = append(basis, inst+":"+strconv.FormatFloat(b, 'f', 4, 64))
+	})
+	var marks []Candle
+	c.OnMarkPriceCandle(func(cs []Candle) { marks = append(marks, cs...) })
+	var liq []LiquidationData
+	c.OnLiquidation(func(arr []LiquidationData) { liq = append(liq, arr...) })
+
+	// 4h 结算：0.0001 × 6 × 365
+	c.handleWSData(ChannelFundingRate, "BTC-USDT-SWAP", "", json.RawMessage(
+		`[{"instId":"BTC-USDT-SWAP","fundingRate":"0.0001","fundingTime":"1700000000000","nextFundingTime":"1700014400000","ts":"1699999000000"}]`))
+	if len(ann) != 1 || math.Abs(ann[0]-0.219) > 1e-12 {
+		t.Fatalf("annualized funding: %v", ann)
+	}
+
+	// 标记价先到、指数后到：指数到达时补算；之后标记价更新再算
+	c.handleWSData(ChannelMarkPrice, "BTC-USDT-SWAP", "", json.RawMessage(`[{"instId":"BTC-USDT-SWAP","markPx":"101","ts":"1"}]`))
+	c.handleWSData(ChannelIndexTickers, "BTC-USDT", "", json.RawMessage(`[{"instId":"BTC-USDT","idxPx":"100","ts":"2"}]`))
+	c.handleWSData(ChannelMarkPrice, "BTC-USDT-SWAP", "", json.RawMessage(`[{"instId":"BTC-USDT-SWAP","markPx":"99.5","ts":"3"}]`))
+	if len(basis) != 2 || basis[0] != "BTC-USDT-SWAP:0.0100" || basis[1] != "BTC-USDT-SWAP:-0.0050" {
+		t.Fatalf("basis: %v", basis)
+	}
+
+	// 标记价格K线：仅闭合
+	c.handleWSData("mark-price-candle15m", "BTC-USDT-SWAP", "", json.RawMessage(
+		`[["1700000900000","1","2","0.5","1.5","0"],["1700000000000","1","2","0.5","1.2","1"]]`))
+	if len(marks) != 1 || marks[0].Close != 1.2 || marks[0].TF != "15m" {
+		t.Fatalf("mark candles: %+v", marks)
+	}
+
+	c.handleWSData(ChannelLiquidation, "", "", json.RawMessage(
+		`[{"instId":"ETH-USDT-SWAP","instType":"SWAP","details":[{"side":"buy","bkPx":"2000","sz":"3","ts":"1"}]}]`))
+	if len(liq) != 1 || len(liq[0].Details) != 1 || liq[0].Details[0].Sz != "3" {
+		t.Fatalf("liquidations: %+v", liq)
+	}
+	if b, _ := json.Marshal(wsArg(ChannelLiquidation, "SWAP")); string(b) != `{"channel":"liquidation-orders","instType":"SWAP"}` {
+		t.Fatalf("liquidation arg: %s", b)
+	}
+
+	// 无法反序列化的公共频道消息计入 malformed
+	c.handleWSData(ChannelFundingRate, "BTC-USDT-SWAP", "", json.RawMessage(`{"instId":"BTC-USDT-SWAP"}`))
+	c.handleWSData("mark-price-candle15m", "BTC-USDT-SWAP", "", json.RawMessage(`[{"ts":"1"}]`))
+	if st := c.DecodeStats(); st.Malformed != 2 || len(ann) != 1 {
+		t.Fatalf("malformed public messages: %+v", st)
+	}
+}
+
+func TestGetFundingRateHistoryPages(t *testing.T) {
+	const step = int64(8 * time.Hour / time.Millisecond)
+	base := int64(1_700_000_000_000)
+	var afters []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		afters = append(afters, r.URL.Query().Get("after"))
+		var rows []map[string]string
+		for i := int64(249); i >= 0 && len(rows) < fundingHistoryPageSize; i-- { // 新->旧
+			ft := base + i*step
+			if after > 0 && ft >= after {
+				continue
+			}
+			rows = append(rows, map[string]string{"instId": "X", "fundingTime": strconv.FormatInt(ft, 10), "fundingRate": "0.0001", "realizedRate": "0.0001"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": "0", "data": rows})
+	}))
+	defer srv.Close()
+
+	c := NewHybridClient()
+	c.httpBaseURL = srv.URL
+	got, err := c.GetFundingRateHistory("X", time.UnixMilli(base+10*step), time.UnixMilli(base+220*step))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 211 || got[0].FundingTime != base+10*step || got[210].FundingTime != base+220*step {
+		t.Fatalf("history: n=%d first=%v last=%v", len(got), got[0].FundingTime, got[len(got)-1].FundingTime)
+	}
+	if len(afters) != 3 {
+		t.Fatalf("pages: %v", afters)
+	}
+}
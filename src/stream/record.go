@@ -0,0 +1,258 @@
+package stream
+
+// 行情录制 —— 原始 WS 消息 + 接收时间，按时间/大小轮转的 gzip 文件
+// =============================================================================
+// 1) HybridClient.OnRaw 在读循环里拿到每条原始消息（入 worker 队列之前），Recorder 异步落盘，
+//    写入跟不上时丢弃并计数，不阻塞读循环；
+// 2) 文件：<Dir>/<YYYYMMDD>/md-<YYYYMMDDTHHMMSS.mmm>.jsonl.gz（UTC，文件名即时间序），
+//    每行 "<接收时间 UnixNano> <紧凑 JSON>"；
+// 3) 每 FlushEvery 刷一次 gzip，进程崩溃最多丢失这段时间；截断的尾部由回放端容忍（replay.go）。
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const recordExt = ".jsonl.gz"
+
+// OnRaw —— 原始消息回调（在各连接的读循环内同步调用，可能并发，须非阻塞）
+func (c *HybridClient) OnRaw(handler func(msg []byte, recvAt time.Time)) Subscription {
+	return c.rawHandlers.add(handler)
+}
+
+func (c *HybridClient) dispatchRaw(msg []byte, recvAt time.Time) {
+	for _, h := range c.rawHandlers.snapshot() {
+		h(msg, recvAt)
+	}
+}
+
+// RecorderConfig —— 录制参数
+type RecorderConfig struct {
+	DataDir     string        // 根目录（config.App.DataDir）；文件写在 DataDir/marketdata 下
+	Dir         string        // 直接指定录制目录（优先于 DataDir）
+	RotateEvery time.Duration // 按时间轮转（默认 1h）
+	MaxBytes    int64         // 按未压缩大小轮转（默认 512MB）
+	FlushEvery  time.Duration // 刷盘间隔（默认 1s）
+	Buffer      int           // 待写队列（默认 65536 条）
+}
+
+func (c *RecorderConfig) withDefaults() RecorderConfig {
+	q := *c
+	if q.Dir == "" {
+		root := q.DataDir
+		if root == "" {
+			root = "./data"
+		}
+		q.Dir = filepath.Join(root, "marketdata")
+	}
+	if q.RotateEvery <= 0 {
+		q.RotateEvery = time.Hour
+	}
+	if q.MaxBytes <= 0 {
+		q.MaxBytes = 512 << 20
+	}
+	if q.FlushEvery <= 0 {
+		q.FlushEvery = time.Second
+	}
+	if q.Buffer <= 0 {
+		q.Buffer = 65536
+	}
+	return q
+}
+
+// RecorderStats —— 计数
+type RecorderStats struct {
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"` // 队列满 / 已关闭
+	Invalid int64 `json:"invalid"` // 非 JSON（不录）
+	Files   int64 `json:"files"`
+	Bytes   int64 `json:"bytes"` // 未压缩
+	Errors  int64 `json:"errors"`
+}
+
+type recorderCounters struct {
+	written, dropped, invalid, files, bytes, errs atomic.Int64
+}
+
+type rawRecord struct {
+	at  time.Time
+	msg []byte
+}
+
+// Recorder —— 行情录制器
+type Recorder struct {
+	cfg RecorderConfig
+	ch  chan rawRecord
+
+	// 写 goroutine 独占
+	f      *os.File
+	gz     *gzip.Writer
+	bw     *bufio.Writer
+	opened time.Time // 当前文件首条消息的接收时间
+	size   int64     // 当前文件未压缩字节数
+
+	cnt recorderCounters
+
+	closeOnce sync.Once
+	done      chan struct{} // Close 发出
+	stopped   chan struct{} // loop 退出
+}
+
+// NewRecorder —— 创建目录并启动写 goroutine
+func NewRecorder(cfg RecorderConfig) (*Recorder, error) {
+	cfg = cfg.withDefaults()
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	r := &Recorder{cfg: cfg, ch: make(chan rawRecord, cfg.Buffer), done: make(chan struct{}), stopped: make(chan struct{})}
+	go r.loop()
+	return r, nil
+}
+
+// Attach —— 录制该客户端收到的全部原始消息（退订即停止录制该客户端）
+func (r *Recorder) Attach(c *HybridClient) Subscription {
+	return c.OnRaw(r.Record)
+}
+
+// Record —— 入队一条消息（非阻塞；Close 之后调用会被丢弃）
+func (r *Recorder) Record(msg []byte, recvAt time.Time) {
+	if !json.Valid(msg) {
+		r.cnt.invalid.Add(1)
+		return
+	}
+	var buf bytes.Buffer
+	_ = json.Compact(&buf, msg)
+	select {
+	case <-r.done:
+		r.cnt.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case r.ch <- rawRecord{at: recvAt, msg: buf.Bytes()}:
+	default:
+		r.cnt.dropped.Add(1)
+	}
+}
+
+// Stats —— 计数快照
+func (r *Recorder) Stats() RecorderStats {
+	return RecorderStats{
+		Written: r.cnt.written.Load(), Dropped: r.cnt.dropped.Load(), Invalid: r.cnt.invalid.Load(),
+		Files: r.cnt.files.Load(), Bytes: r.cnt.bytes.Load(), Errors: r.cnt.errs.Load(),
+	}
+}
+
+// Dir —— 录制目录（回放时传给 NewReplayer）
+func (r *Recorder) Dir() string { return r.cfg.Dir }
+
+// Close —— 写完队列中的消息并关闭当前文件
+func (r *Recorder) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	<-r.stopped
+	return nil
+}
+
+func (r *Recorder) loop() {
+	tk := time.NewTicker(r.cfg.FlushEvery)
+	defer tk.Stop()
+	for {
+		select {
+		case rec := <-r.ch:
+			r.write(rec)
+		case <-tk.C:
+			r.flush()
+		case <-r.done:
+			for n := len(r.ch); n > 0; n-- {
+				r.write(<-r.ch)
+			}
+			r.closeFile()
+			close(r.stopped)
+			return
+		}
+	}
+}
+
+func (r *Recorder) write(rec rawRecord) {
+	if r.f != nil && (rec.at.Sub(r.opened) >= r.cfg.RotateEvery || r.size >= r.cfg.MaxBytes) {
+		r.closeFile()
+	}
+	if r.f == nil {
+		if err := r.openFile(rec.at); err != nil {
+			r.cnt.errs.Add(1)
+			log.Printf("⚠️ 行情录制打开文件失败: %v", err)
+			return
+		}
+	}
+	line := make([]byte, 0, len(rec.msg)+24)
+	line = strconv.AppendInt(line, rec.at.UnixNano(), 10)
+	line = append(line, ' ')
+	line = append(line, rec.msg...)
+	line = append(line, '\n')
+	if _, err := r.bw.Write(line); err != nil {
+		r.cnt.errs.Add(1)
+		return
+	}
+	r.size += int64(len(line))
+	r.cnt.written.Add(1)
+	r.cnt.bytes.Add(int64(len(line)))
+}
+
+func (r *Recorder) openFile(at time.Time) error {
+	u := at.UTC()
+	dir := filepath.Join(r.cfg.Dir, u.Format("20060102"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, "md-"+u.Format("20060102T150405.000")+recordExt)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("创建 %s: %w", name, err)
+	}
+	r.f, r.gz = f, gzip.NewWriter(f)
+	r.bw = bufio.NewWriterSize(r.gz, 64<<10)
+	r.opened, r.size = at, 0
+	r.cnt.files.Add(1)
+	return nil
+}
+
+func (r *Recorder) flush() {
+	if r.f == nil {
+		return
+	}
+	err := r.bw.Flush()
+	if err == nil {
+		err = r.gz.Flush()
+	}
+	if err != nil {
+		r.cnt.errs.Add(1)
+	}
+}
+
+func (r *Recorder) closeFile() {
+	if r.f == nil {
+		return
+	}
+	err := r.bw.Flush()
+	if e := r.gz.Close(); err == nil {
+		err = e
+	}
+	if e := r.f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		r.cnt.errs.Add(1)
+		log.Printf("⚠️ 行情录制关闭文件失败: %v", err)
+	}
+	r.f, r.gz, r.bw = nil, nil, nil
+}
@@ -0,0 +1,241 @@
+package stream
+
+// 行情回放 —— 按录制顺序把原始消息重新喂给 HybridClient 的路由
+// =============================================================================
+// 1) 回放走与实时相同的 handleWSMessage（本地簿校验、K线合并、派生量全部一致），
+//    OnTicker/OnTrade/OnBook/OnCandle 等回调签名不变；Replayer 本身满足 MarketData，可直接交给 PaperExchange.Attach；
+// 2) 单 goroutine 顺序分发：同一份录制每次回放的回调顺序完全相同，与速度无关；
+// 3) Speed：1 原速，N 为 N 倍速，≤0 尽快；Now() 返回当前消息的接收时间（回放时钟）；
+// 4) 文件按名字（即时间）排序；崩溃留下的截断 gzip 读到哪算哪。
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const replayMaxLine = 64 << 20
+
+// ReplayConfig —— 回放参数
+type ReplayConfig struct {
+	Dir   string    // 录制目录（递归查找 *.jsonl.gz）
+	Files []string  // 直接指定文件（优先于 Dir，按给定顺序）
+	Speed float64   // 1 原速；N 倍速；≤0 尽快
+	From  time.Time // 按接收时间过滤（零值不限）
+	To    time.Time
+}
+
+// ReplayStats —— 计数
+type ReplayStats struct {
+	Files     int64 `json:"files"`
+	Messages  int64 `json:"messages"`
+	Bad       int64 `json:"bad"`       // 无法解析的行
+	Truncated int64 `json:"truncated"` // 尾部截断的文件
+}
+
+// Replayer —— 回放源
+type Replayer struct {
+	cfg   ReplayConfig
+	files []string
+	c     *HybridClient
+
+	now   atomic.Int64 // 当前回放时间（UnixNano）
+	stats ReplayStats  // 仅 Run 所在 goroutine 写；Stats 在 Run 结束后读
+
+	wall  func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	// Start / Close
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	err      error
+	finished chan struct{}
+}
+
+// NewReplayer —— 找不到录制文件时返回错误
+func NewReplayer(cfg ReplayConfig) (*Replayer, error) {
+	files := cfg.Files
+	if len(files) == 0 {
+		var err error
+		if files, err = ListRecordings(cfg.Dir); err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有录制文件: %s", cfg.Dir)
+	}
+	c := NewHybridClient()
+	c.candleBackfill = false // 录制中的缺口如实重现，不请求 REST
+	return &Replayer{cfg: cfg, files: files, c: c, wall: time.Now, sleep: sleepCtx, finished: make(chan struct{})}, nil
+}
+
+// ListRecordings —— 目录下全部录制文件（按路径排序 = 按时间排序）
+func ListRecordings(dir string) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), recordExt) {
+			out = append(out, p)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out, err
+}
+
+func (r *Replayer) OnTicker(h func([]TickerData)) Subscription        { return r.c.OnTicker(h) }
+func (r *Replayer) OnTrade(h func([]TradeData)) Subscription          { return r.c.OnTrade(h) }
+func (r *Replayer) OnBook(h func(map[string][]BookData)) Subscription { return r.c.OnBook(h) }
+func (r *Replayer) OnCandle(h func([]Candle)) Subscription            { return r.c.OnCandle(h) }
+func (r *Replayer) OnRaw(h func(msg []byte, at time.Time)) Subscription {
+	return r.c.OnRaw(h)
+}
+
+// BestBidAsk —— 回放本地簿的最优价
+func (r *Replayer) BestBidAsk(instID string) (bid, ask Level, ok bool) {
+	return r.c.BestBidAsk(instID)
+}
+
+// Start —— 后台回放（MarketData）；ctx 取消或 Close 即停止，结束后 Done 关闭、Err 给出结果
+func (r *Replayer) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return errors.New("回放已启动")
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	go func() {
+		err := r.Run(ctx)
+		r.mu.Lock()
+		r.err = err
+		r.mu.Unlock()
+		close(r.finished)
+	}()
+	return nil
+}
+
+// Close —— 停止后台回放并等待退出（未 Start 时无操作）
+func (r *Replayer) Close() {
+	r.mu.Lock()
+	cancel := r.cancel
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-r.finished
+}
+
+// Done —— Start 的回放结束时关闭
+func (r *Replayer) Done() <-chan struct{} { return r.finished }
+
+// Err —— 回放结果（正常放完为 nil，被取消为 context.Canceled）
+func (r *Replayer) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Client —— 回放驱动的客户端（本地簿、缓存、其他频道回调都可用；不要对它 Connect）
+func (r *Replayer) Client() *HybridClient { return r.c }
+
+// Now —— 回放时钟：最近一条已分发消息的接收时间
+func (r *Replayer) Now() time.Time { return time.Unix(0, r.now.Load()) }
+
+// Stats —— 计数（Run 返回后读取）
+func (r *Replayer) Stats() ReplayStats { return r.stats }
+
+// Run —— 顺序回放全部文件；ctx 取消时返回 ctx.Err()
+func (r *Replayer) Run(ctx context.Context) error {
+	var first int64
+	var start time.Time
+	for _, path := range r.files {
+		err := r.replayFile(ctx, path, func(at int64) error {
+			if r.cfg.Speed <= 0 {
+				return ctx.Err()
+			}
+			if first == 0 {
+				first, start = at, r.wall()
+				return nil
+			}
+			due := start.Add(time.Duration(float64(at-first) / r.cfg.Speed))
+			if d := due.Sub(r.wall()); d > 0 {
+				return r.sleep(ctx, d)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replayer) replayFile(ctx context.Context, path string, pace func(at int64) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		r.stats.Truncated++
+		log.Printf("⚠️ 回放跳过损坏文件 %s: %v", path, err)
+		return nil
+	}
+	defer zr.Close()
+	r.stats.Files++
+
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 0, 1<<20), replayMaxLine)
+	from, to := r.cfg.From.UnixNano(), r.cfg.To.UnixNano()
+	for sc.Scan() {
+		line := sc.Bytes()
+		sp := bytes.IndexByte(line, ' ')
+		if sp <= 0 {
+			r.stats.Bad++
+			continue
+		}
+		at, err := strconv.ParseInt(string(line[:sp]), 10, 64)
+		if err != nil {
+			r.stats.Bad++
+			continue
+		}
+		if (!r.cfg.From.IsZero() && at < from) || (!r.cfg.To.IsZero() && at > to) {
+			continue
+		}
+		if err := pace(at); err != nil {
+			return err
+		}
+		msg := append([]byte(nil), line[sp+1:]...)
+		r.now.Store(at)
+		r.c.dispatchRaw(msg, time.Unix(0, at))
+		r.c.handleWSMessage(msg)
+		r.stats.Messages++
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			r.stats.Truncated++
+			log.Printf("⚠️ 回放文件尾部截断 %s（已回放到此为止）", path)
+			return nil
+		}
+		return fmt.Errorf("读取 %s: %w", path, err)
+	}
+	return nil
+}
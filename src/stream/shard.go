@@ -0,0 +1,264 @@
+package stream
+
+// 连接分片 —— 订阅按频道类别与数量自动分到多条 WS 连接，每条连接独立读循环 / worker / 重连
+// =============================================================================
+// 1) 频道分三类：books（深度，消息量最大）、trades、public（tickers / K线 / 资金费率等），
+//    不同类别不共用连接，慢的深度流不会拖住 ticker；同类订阅装满 MaxPerShard 再开下一条连接，
+//    同一品种尽量落在同一条连接上；
+// 2) 分配是粘性的：退订后再订阅、回执超时重发、重连恢复都回到原连接；连接数达到 MaxShards 后
+//    挑同类（没有则任意类）订阅最少的连接；新连接在首次订阅时拨号；
+// 3) 每条连接 Workers 条 lane，按 instId 哈希：同一品种的消息（含订阅回执）严格按到达顺序处理，
+//    不同品种并行；lane 满时丢弃并计数（盘口丢消息会在 seqId 校验时暴露为缺口并重订阅）；
+// 4) 每条连接独立退避重连、静默看门狗，只恢复本连接的订阅；连接事件的 Key 为 "shard:<id>"；
+//    拨号不持有客户端锁（每条连接自己的 dialMu 串行化，带超时），一条连接连不上不拖住其他连接与 Health；
+// 5) Health 汇总全部连接（FeedHealth.Shards），Connected 表示所有连接都在线。
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 频道类别
+const (
+	ShardBooks  = "books"
+	ShardTrades = "trades"
+	ShardPublic = "public"
+)
+
+// ShardConfig —— 分片参数（零值取默认）
+type ShardConfig struct {
+	MaxPerShard map[string]int // 类别 -> 每条连接的订阅上限，覆盖默认（books 20 / trades 50 / public 100）
+	MaxShards   int            // 连接总数上限（默认 0 不限）
+	Workers     int            // 每条连接的 lane 数（默认 4）
+	QueueSize   int            // 每条 lane 的队列长度（默认 1024）
+}
+
+var defaultMaxPerShard = map[string]int{
+	ShardBooks:  20,
+	ShardTrades: 50,
+	ShardPublic: 100,
+}
+
+func (s ShardConfig) withDefaults() ShardConfig {
+	per := make(map[string]int, len(defaultMaxPerShard))
+	for k, v := range defaultMaxPerShard {
+		per[k] = v
+	}
+	for k, v := range s.MaxPerShard {
+		if v > 0 {
+			per[k] = v
+		}
+	}
+	s.MaxPerShard = per
+	if s.MaxShards < 0 {
+		s.MaxShards = 0
+	}
+	if s.Workers <= 0 {
+		s.Workers = 4
+	}
+	if s.QueueSize <= 0 {
+		s.QueueSize = 1024
+	}
+	return s
+}
+
+// ShardClass —— 频道所属类别
+func ShardClass(channel string) string {
+	switch {
+	case IsBookChannel(channel):
+		return ShardBooks
+	case channel == "trades":
+		return ShardTrades
+	}
+	return ShardPublic
+}
+
+// ShardHealth —— 单条连接
+type ShardHealth struct {
+	ID         int       `json:"id"`
+	Class      string    `json:"class"`
+	Connected  bool      `json:"connected"`
+	Since      time.Time `json:"since"` // 最近一次连上的时间
+	Subs       int       `json:"subs"`
+	Reconnects int64     `json:"reconnects"`
+	Messages   int64     `json:"messages"`
+	Dropped    int64     `json:"dropped"` // lane 满丢弃
+	Queued     int       `json:"queued"`  // 当前排队
+}
+
+// wsShard —— 一条 WS 连接及其订阅（conn/closeCh/subs/reconnecting/since 由 HybridClient.mu 保护）
+type wsShard struct {
+	id    int
+	class string
+
+	conn         *websocket.Conn
+	closeCh      chan struct{}
+	subs         map[string]bool // key: channel+":"+instID
+	reconnecting bool
+	since        time.Time
+
+	dialMu  sync.Mutex // 串行化本连接的拨号（不持有 c.mu）
+	writeMu sync.Mutex
+	lanes   []chan []byte
+
+	reconnects, msgs, dropped atomic.Int64
+}
+
+func (s *wsShard) key() string { return fmt.Sprintf("shard:%d", s.id) }
+
+// hasInst —— 本连接是否已有该品种的订阅
+func (s *wsShard) hasInst(inst string) bool {
+	for k := range s.subs {
+		if strings.HasSuffix(k, ":"+inst) {
+			return true
+		}
+	}
+	return false
+}
+
+// ===================== 分配 =====================
+
+// shardForLocked —— 取 key 的连接，没有则分配（调用方持有 c.mu）
+func (c *HybridClient) shardForLocked(channel, inst string) *wsShard {
+	key := channel + ":" + inst
+	if sh := c.assign[key]; sh != nil {
+		return sh
+	}
+	class := ShardClass(channel)
+	limit := c.shardCfg.MaxPerShard[class]
+	var pick, open *wsShard
+	for _, sh := range c.shards {
+		if sh.class != class || len(sh.subs) >= limit {
+			continue
+		}
+		if sh.hasInst(inst) {
+			pick = sh
+			break
+		}
+		if open == nil {
+			open = sh
+		}
+	}
+	if pick == nil {
+		pick = open
+	}
+	if pick == nil {
+		if c.shardCfg.MaxShards == 0 || len(c.shards) < c.shardCfg.MaxShards {
+			pick = c.newShardLocked(class)
+		} else {
+			pick = c.leastLoadedLocked(class)
+		}
+	}
+	c.assign[key] = pick
+	return pick
+}
+
+// firstShardLocked —— 该类别的第一条连接（没有则新建）
+func (c *HybridClient) firstShardLocked(class string) *wsShard {
+	for _, sh := range c.shards {
+		if sh.class == class {
+			return sh
+		}
+	}
+	return c.newShardLocked(class)
+}
+
+func (c *HybridClient) newShardLocked(class string) *wsShard {
+	sh := &wsShard{id: len(c.shards), class: class, subs: make(map[string]bool)}
+	c.shards = append(c.shards, sh)
+	return sh
+}
+
+// leastLoadedLocked —— 连接数到顶：同类中订阅最少的，没有同类则全部中最少的
+func (c *HybridClient) leastLoadedLocked(class string) *wsShard {
+	var best *wsShard
+	for pass := 0; pass < 2 && best == nil; pass++ {
+		for _, sh := range c.shards {
+			if pass == 0 && sh.class != class {
+				continue
+			}
+			if best == nil || len(sh.subs) < len(best.subs) {
+				best = sh
+			}
+		}
+	}
+	return best
+}
+
+// ===================== lane =====================
+
+// startLanesLocked —— 首次连上时启动本连接的 worker
+func (c *HybridClient) startLanesLocked(sh *wsShard) {
+	if sh.lanes != nil {
+		return
+	}
+	sh.lanes = make([]chan []byte, c.shardCfg.Workers)
+	for i := range sh.lanes {
+		sh.lanes[i] = make(chan []byte, c.shardCfg.QueueSize)
+		c.workersWg.Add(1)
+		go c.wsWorker(sh.lanes[i])
+	}
+}
+
+// enqueue —— 按 instId 入 lane；满则丢弃
+func (c *HybridClient) enqueue(sh *wsShard, msg []byte) {
+	sh.msgs.Add(1)
+	select {
+	case sh.lanes[laneOf(msg, len(sh.lanes))] <- msg:
+	default:
+		if n := sh.dropped.Add(1); logEvery(n) {
+			log.Printf("⚠️ WS消息队列已满 %s，丢弃（第%d条）", sh.key(), n)
+		}
+	}
+}
+
+var instIDField = []byte(`"instId":"`)
+
+// laneOf —— 取报文中第一个 instId 哈希到 lane（没有 instId 的进 0 号）
+func laneOf(msg []byte, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	i := bytes.Index(msg, instIDField)
+	if i < 0 {
+		return 0
+	}
+	rest := msg[i+len(instIDField):]
+	j := bytes.IndexByte(rest, '"')
+	if j < 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(rest[:j])
+	return int(h.Sum32() % uint32(n))
+}
+
+// ===================== 健康 =====================
+
+// shardHealth —— 各连接快照（按 id），以及是否全部在线
+func (c *HybridClient) shardHealth() (out []ShardHealth, allUp bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	allUp = c.wsRunning && len(c.shards) > 0
+	for _, sh := range c.shards {
+		queued := 0
+		for _, l := range sh.lanes {
+			queued += len(l)
+		}
+		up := c.wsRunning && sh.conn != nil
+		allUp = allUp && up
+		out = append(out, ShardHealth{
+			ID: sh.id, Class: sh.class, Connected: up, Since: sh.since, Subs: len(sh.subs),
+			Reconnects: sh.reconnects.Load(), Messages: sh.msgs.Load(), Dropped: sh.dropped.Load(), Queued: queued,
+		})
+	}
+	return out, allUp
+}
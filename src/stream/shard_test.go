@@ -0,0 +1,284 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shardStub —— 记录每条连接收到的订阅并回执，可按品种找到连接推送或断开
+type shardStub struct {
+	mu    sync.Mutex
+	conns []*websocket.Conn
+	subs  [][]string // 与 conns 同序
+	ready chan struct{}
+}
+
+func (s *shardStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	idx := len(s.conns)
+	s.conns = append(s.conns, conn)
+	s.subs = append(s.subs, nil)
+	s.mu.Unlock()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID   string  `json:"id"`
+			Op   string  `json:"op"`
+			Args []WSArg `json:"args"`
+		}
+		if json.Unmarshal(msg, &req) != nil || req.Op != "subscribe" {
+			continue
+		}
+		s.mu.Lock()
+		for _, a := range req.Args {
+			s.subs[idx] = append(s.subs[idx], a.Channel+":"+a.InstID)
+			conn.WriteJSON(map[string]any{"event": "subscribe", "arg": a, "id": req.ID})
+		}
+		s.mu.Unlock()
+		s.ready <- struct{}{}
+	}
+}
+
+// connOf —— 最后一条订阅了 key 的连接
+func (s *shardStub) connOf(key string) (int, *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.subs) - 1; i >= 0; i-- {
+		for _, k := range s.subs[i] {
+			if k == key {
+				return i, s.conns[i]
+			}
+		}
+	}
+	return -1, nil
+}
+
+func (s *shardStub) push(key, msg string) {
+	_, conn := s.connOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func TestShardedSubscriptions(t *testing.T) {
+	stub := &shardStub{ready: make(chan struct{}, 16)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	c := NewMarketClient(Options{
+		WSURL:     "ws" + strings.TrimPrefix(srv.URL, "http"),
+		HTTPURL:   srv.URL,
+		Reconnect: Backoff{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond},
+		Shards:    ShardConfig{MaxPerShard: map[string]int{ShardPublic: 2}, Workers: 3},
+	})
+	evs := make(chan ConnEvent, 64)
+	c.OnConnState(func(ev ConnEvent) { evs <- ev })
+	var mu sync.Mutex
+	seen := make(map[string][]int) // instId -> 收到的 last 序列
+	c.OnTicker(func(arr []TickerData) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, d := range arr {
+			n, _ := strconv.Atoi(d.Last)
+			seen[d.InstID] = append(seen[d.InstID], n)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitSub := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-stub.ready:
+			case <-time.After(3 * time.Second):
+				t.Fatal("no subscribe request")
+			}
+		}
+	}
+
+	// public 每条连接 2 个：A、B 在首条连接，C 开新连接；trades 另开一条
+	if err := c.SubscribeTickers([]string{"A", "B", "C"}); err != nil {
+		t.Fatal(err)
+	}
+	waitSub(2)
+	if err := c.SubscribeTrades([]string{"A"}); err != nil {
+		t.Fatal(err)
+	}
+	waitSub(1)
+	for key, want := range map[string]int{"tickers:A": 0, "tickers:B": 0, "tickers:C": 1, "trades:A": 2} {
+		if i, _ := stub.connOf(key); i != want {
+			t.Fatalf("%s on conn %d, want %d", key, i, want)
+		}
+	}
+
+	// 同一品种按到达顺序处理
+	const n = 200
+	for i := 1; i <= n; i++ {
+		for _, inst := range []string{"A", "C"} {
+			stub.push("tickers:"+inst, fmt.Sprintf(`{"arg":{"channel":"tickers","instId":"%s"},"data":[{"instId":"%s","last":"%d"}]}`, inst, inst, i))
+		}
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		done := len(seen["A"]) == n && len(seen["C"]) == n
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received A=%d C=%d", len(seen["A"]), len(seen["C"]))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for inst, got := range seen {
+		for i, v := range got {
+			if v != i+1 {
+				t.Fatalf("%s out of order at %d: %v", inst, i, got[:i+1])
+			}
+		}
+	}
+
+	// 只断开 C 所在连接：只有它重连、只恢复 C
+	_, conn := stub.connOf("tickers:C")
+	conn.Close()
+	for {
+		select {
+		case ev := <-evs:
+			if ev.State == ConnDisconnected && (ev.Shard != 1 || ev.Key != "shard:1") {
+				t.Fatalf("disconnect: %+v", ev)
+			}
+			if ev.State != ConnConnected {
+				continue
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("no reconnect")
+		}
+		break
+	}
+	waitSub(1)
+	stub.mu.Lock()
+	conns, last := len(stub.conns), strings.Join(stub.subs[len(stub.subs)-1], ",")
+	stub.mu.Unlock()
+	if conns != 4 || last != "tickers:C" {
+		t.Fatalf("conns=%d resubscribed=%s", conns, last)
+	}
+
+	h := c.Health()
+	if !h.Connected || h.Reconnects != 1 || len(h.Shards) != 3 || len(h.Subs) != 4 {
+		t.Fatalf("health: %+v", h)
+	}
+	for i, want := range []struct {
+		class      string
+		subs       int
+		reconnects int64
+	}{{ShardPublic, 2, 0}, {ShardPublic, 1, 1}, {ShardTrades, 1, 0}} {
+		s := h.Shards[i]
+		if s.ID != i || s.Class != want.class || !s.Connected || s.Subs != want.subs || s.Reconnects != want.reconnects {
+			t.Fatalf("shard %d: %+v", i, s)
+		}
+	}
+	if h.Shards[0].Messages < n || h.Subs[2].Key != "tickers:C" || h.Subs[2].Shard != 1 {
+		t.Fatalf("health: %+v", h)
+	}
+	if !c.IsConnected() {
+		t.Fatal("not connected")
+	}
+}
+
+// hangDialer —— 第 hang 次拨号挂起直到 ctx 结束，其余照常
+type hangDialer struct {
+	mu   sync.Mutex
+	n    int
+	hang int
+}
+
+func (d *hangDialer) DialContext(ctx context.Context, u string, h http.Header) (*websocket.Conn, *http.Response, error) {
+	d.mu.Lock()
+	d.n++
+	n := d.n
+	d.mu.Unlock()
+	if n == d.hang {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	return websocket.DefaultDialer.DialContext(ctx, u, h)
+}
+
+func TestSlowDialDoesNotBlockOtherShards(t *testing.T) {
+	stub := &shardStub{ready: make(chan struct{}, 16)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	c := NewMarketClient(Options{
+		WSURL:       "ws" + strings.TrimPrefix(srv.URL, "http"),
+		HTTPURL:     srv.URL,
+		Dialer:      &hangDialer{hang: 2},
+		DialTimeout: 300 * time.Millisecond,
+		Reconnect:   Backoff{Initial: time.Hour, Max: time.Hour},
+		Shards:      ShardConfig{MaxPerShard: map[string]int{ShardPublic: 1}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// B 落到新连接，拨号挂起；其间首条连接的订阅与 Health 不受影响
+	slow := make(chan error, 1)
+	go func() { slow <- c.SubscribeTickers([]string{"A", "B"}) }()
+	select {
+	case <-stub.ready:
+	case <-time.After(150 * time.Millisecond):
+		t.Fatal("shard 0 subscribe blocked by shard 1 dial")
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Health()
+		if err := c.SubscribeTrades([]string{"A"}); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Health/Subscribe blocked by a pending dial")
+	}
+	select {
+	case err := <-slow:
+		t.Fatalf("dial returned before timeout: %v", err)
+	default:
+	}
+
+	// 拨号超时返回错误
+	select {
+	case err := <-slow:
+		if err == nil || !strings.Contains(err.Error(), "shard:1") {
+			t.Fatalf("slow subscribe: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dial did not time out")
+	}
+}
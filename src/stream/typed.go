@@ -0,0 +1,354 @@
+package stream
+
+// 类型化行情 —— tickers / trades / books 在 WS worker 里解码一次，下游直接拿数值
+// =============================================================================
+// 1) Ticker / Trade / Book：价格数量为 float64，时间为 time.Time，成交方向为 Side；
+// 2) 解码即校验：缺 instId、数值无法解析或为负 / NaN、成交价量非正、方向未知的条目判为无效，
+//    不再下发（字符串回调也只收到有效条目）；可选字段缺省为零值（ts 缺省为零时间）；
+// 3) 整条 data 无法反序列化计为 Malformed，条目校验失败计为 Invalid，均限频打日志，见 DecodeStats；
+// 4) OnTypedTicker / OnTypedTrade / OnTypedBook 与 OnTicker 等并存，同一条消息先字符串后类型化。
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Side —— 成交方向（主动方）
+type Side int8
+
+const (
+	SideUnknown Side = iota
+	SideBuy
+	SideSell
+)
+
+func (s Side) String() string {
+	switch s {
+	case SideBuy:
+		return "buy"
+	case SideSell:
+		return "sell"
+	}
+	return "unknown"
+}
+
+// Sign —— 买 +1 / 卖 -1
+func (s Side) Sign() float64 {
+	switch s {
+	case SideBuy:
+		return 1
+	case SideSell:
+		return -1
+	}
+	return 0
+}
+
+// ParseSide —— "buy" / "sell"（大小写不敏感）
+func ParseSide(s string) (Side, error) {
+	switch strings.ToLower(s) {
+	case "buy":
+		return SideBuy, nil
+	case "sell":
+		return SideSell, nil
+	}
+	return SideUnknown, fmt.Errorf("bad side %q", s)
+}
+
+// Ticker —— 类型化 ticker（无报价的字段为 0）
+type Ticker struct {
+	InstID    string
+	Last      float64
+	LastSz    float64
+	Bid       float64
+	BidSz     float64
+	Ask       float64
+	AskSz     float64
+	Open24h   float64
+	High24h   float64
+	Low24h    float64
+	Vol24h    float64
+	VolCcy24h float64
+	Ts        time.Time
+}
+
+// Mid —— 中间价（缺一侧报价时退回 Last）
+func (t Ticker) Mid() float64 {
+	if t.Bid > 0 && t.Ask > 0 {
+		return (t.Bid + t.Ask) / 2
+	}
+	return t.Last
+}
+
+// Trade —— 类型化成交
+type Trade struct {
+	InstID  string
+	TradeID string
+	Px      float64
+	Sz      float64
+	Side    Side
+	Ts      time.Time
+}
+
+// Book —— 类型化深度推送（本条推送本身，不是本地簿；update 中 Sz=0 表示删除该价位）
+type Book struct {
+	InstID    string
+	Action    string // snapshot / update（books5、bbo-tbt 为空，均为全量）
+	Bids      []Level
+	Asks      []Level
+	Ts        time.Time
+	SeqID     int64
+	PrevSeqID int64
+	Checksum  int64
+}
+
+// ===================== 解码 =====================
+
+// DecodeTicker —— 校验并转换一条 ticker
+func DecodeTicker(d TickerData) (Ticker, error) {
+	if d.InstID == "" {
+		return Ticker{}, fmt.Errorf("ticker: missing instId")
+	}
+	t := Ticker{InstID: d.InstID}
+	var err error
+	for _, f := range []struct {
+		name string
+		s    string
+		dst  *float64
+	}{
+		{"last", d.Last, &t.Last}, {"lastSz", d.LastSz, &t.LastSz},
+		{"bidPx", d.BidPx, &t.Bid}, {"bidSz", d.BidSz, &t.BidSz},
+		{"askPx", d.AskPx, &t.Ask}, {"askSz", d.AskSz, &t.AskSz},
+		{"open24h", d.Open24h, &t.Open24h}, {"high24h", d.High24h, &t.High24h}, {"low24h", d.Low24h, &t.Low24h},
+		{"vol24h", d.Vol24h, &t.Vol24h}, {"volCcy24h", d.VolCcy24h, &t.VolCcy24h},
+	} {
+		if *f.dst, err = optNum(f.s); err != nil {
+			return Ticker{}, fmt.Errorf("ticker %s %s: %v", d.InstID, f.name, err)
+		}
+	}
+	if t.Ts, err = optMillis(d.Ts); err != nil {
+		return Ticker{}, fmt.Errorf("ticker %s ts: %v", d.InstID, err)
+	}
+	return t, nil
+}
+
+// DecodeTrade —— 校验并转换一条成交（价、量必须为正，方向必须已知，ts 必填）
+func DecodeTrade(d TradeData) (Trade, error) {
+	if d.InstID == "" {
+		return Trade{}, fmt.Errorf("trade: missing instId")
+	}
+	t := Trade{InstID: d.InstID, TradeID: d.TradeID}
+	var err error
+	if t.Px, err = optNum(d.Px); err != nil || t.Px <= 0 {
+		return Trade{}, fmt.Errorf("trade %s: bad px %q", d.InstID, d.Px)
+	}
+	if t.Sz, err = optNum(d.Sz); err != nil || t.Sz <= 0 {
+		return Trade{}, fmt.Errorf("trade %s: bad sz %q", d.InstID, d.Sz)
+	}
+	if t.Side, err = ParseSide(d.Side); err != nil {
+		return Trade{}, fmt.Errorf("trade %s: %v", d.InstID, err)
+	}
+	if d.Ts == "" {
+		return Trade{}, fmt.Errorf("trade %s: missing ts", d.InstID)
+	}
+	if t.Ts, err = optMillis(d.Ts); err != nil {
+		return Trade{}, fmt.Errorf("trade %s ts: %v", d.InstID, err)
+	}
+	return t, nil
+}
+
+// DecodeBook —— 校验并转换一条深度推送（保留 Sz=0 的删除价位）
+func DecodeBook(d BookData, action string) (Book, error) {
+	if d.InstID == "" {
+		return Book{}, fmt.Errorf("book: missing instId")
+	}
+	b := Book{InstID: d.InstID, Action: action, SeqID: d.SeqID, PrevSeqID: d.PrevSeqID, Checksum: d.Checksum}
+	var err error
+	if b.Bids, err = decodeLevels(d.Bids); err != nil {
+		return Book{}, fmt.Errorf("book %s bids: %v", d.InstID, err)
+	}
+	if b.Asks, err = decodeLevels(d.Asks); err != nil {
+		return Book{}, fmt.Errorf("book %s asks: %v", d.InstID, err)
+	}
+	if b.Ts, err = optMillis(d.Ts); err != nil {
+		return Book{}, fmt.Errorf("book %s ts: %v", d.InstID, err)
+	}
+	return b, nil
+}
+
+func decodeLevels(rows [][]string) ([]Level, error) {
+	out := make([]Level, 0, len(rows))
+	for _, r := range rows {
+		l, err := parseLevel(r)
+		if err != nil {
+			return nil, err
+		}
+		if !(l.Px > 0) || !(l.Sz >= 0) || math.IsInf(l.Px, 0) || math.IsInf(l.Sz, 0) {
+			return nil, fmt.Errorf("bad book level %v", r)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// optNum —— 空串为 0；否则须为有限非负数
+func optNum(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("out of range %q", s)
+	}
+	return v, nil
+}
+
+// optMillis —— 空串为零时间；否则须为正的 Unix 毫秒
+func optMillis(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, fmt.Errorf("bad ts %q", s)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// ===================== 计数 =====================
+
+// DecodeStats —— 解码计数
+type DecodeStats struct {
+	Decoded   int64 `json:"decoded"`   // 通过校验的条目
+	Invalid   int64 `json:"invalid"`   // 校验失败而丢弃的条目
+	Malformed int64 `json:"malformed"` // 无法反序列化的消息
+}
+
+type decodeCounters struct {
+	decoded, invalid, malformed atomic.Int64
+}
+
+// DecodeStats —— 计数快照
+func (c *HybridClient) DecodeStats() DecodeStats {
+	return DecodeStats{
+		Decoded:   c.decodeCnt.decoded.Load(),
+		Invalid:   c.decodeCnt.invalid.Load(),
+		Malformed: c.decodeCnt.malformed.Load(),
+	}
+}
+
+// 前 10 次逐条打印，之后每 1000 次打印一次
+func logEvery(n int64) bool { return n <= 10 || n%1000 == 0 }
+
+// malformed —— 整条消息无法解析
+func (c *HybridClient) malformed(channel string, err error, raw []byte) {
+	n := c.decodeCnt.malformed.Add(1)
+	if logEvery(n) {
+		if len(raw) > 200 {
+			raw = raw[:200]
+		}
+		log.Printf("⚠️ WS消息无法解析（第%d条）%s: %v: %s", n, channel, err, raw)
+	}
+}
+
+// invalid —— 单个条目校验失败
+func (c *HybridClient) invalid(err error) {
+	n := c.decodeCnt.invalid.Add(1)
+	if logEvery(n) {
+		log.Printf("⚠️ 行情条目无效（第%d条）: %v", n, err)
+	}
+}
+
+// decodeTickers —— 返回有效的原始条目与对应的类型化结果
+func (c *HybridClient) decodeTickers(raw []TickerData) ([]TickerData, []Ticker) {
+	ok := raw[:0:0]
+	out := make([]Ticker, 0, len(raw))
+	for _, d := range raw {
+		t, err := DecodeTicker(d)
+		if err != nil {
+			c.invalid(err)
+			continue
+		}
+		ok, out = append(ok, d), append(out, t)
+	}
+	c.decodeCnt.decoded.Add(int64(len(out)))
+	return ok, out
+}
+
+func (c *HybridClient) decodeTrades(raw []TradeData) ([]TradeData, []Trade) {
+	ok := raw[:0:0]
+	out := make([]Trade, 0, len(raw))
+	for _, d := range raw {
+		t, err := DecodeTrade(d)
+		if err != nil {
+			c.invalid(err)
+			continue
+		}
+		ok, out = append(ok, d), append(out, t)
+	}
+	c.decodeCnt.decoded.Add(int64(len(out)))
+	return ok, out
+}
+
+func (c *HybridClient) decodeBooks(raw []BookData, action string) ([]BookData, []Book) {
+	ok := raw[:0:0]
+	out := make([]Book, 0, len(raw))
+	for _, d := range raw {
+		b, err := DecodeBook(d, action)
+		if err != nil {
+			c.invalid(err)
+			continue
+		}
+		ok, out = append(ok, d), append(out, b)
+	}
+	c.decodeCnt.decoded.Add(int64(len(out)))
+	return ok, out
+}
+
+// ===================== 回调 =====================
+
+func (c *HybridClient) OnTypedTicker(handler func([]Ticker)) Subscription {
+	return c.typedTickerHandlers.add(handler)
+}
+func (c *HybridClient) OnTypedTrade(handler func([]Trade)) Subscription {
+	return c.typedTradeHandlers.add(handler)
+}
+
+// OnTypedBook —— 每条深度推送（先应用到本地簿，再回调）
+func (c *HybridClient) OnTypedBook(handler func([]Book)) Subscription {
+	return c.typedBookHandlers.add(handler)
+}
+
+func (c *HybridClient) dispatchTypedTicker(arr []Ticker) {
+	for _, h := range c.typedTickerHandlers.snapshot() {
+		h(arr)
+	}
+}
+func (c *HybridClient) dispatchTypedTrade(arr []Trade) {
+	for _, h := range c.typedTradeHandlers.snapshot() {
+		h(arr)
+	}
+}
+func (c *HybridClient) dispatchTypedBook(arr []Book) {
+	for _, h := range c.typedBookHandlers.snapshot() {
+		h(arr)
+	}
+}
+
+// decodeData —— 反序列化 data 数组；失败计为 malformed
+func decodeData[T any](c *HybridClient, channel string, data json.RawMessage) ([]T, bool) {
+	var arr []T
+	if err := json.Unmarshal(data, &arr); err != nil {
+		c.malformed(channel, err, data)
+		return nil, false
+	}
+	return arr, true
+}
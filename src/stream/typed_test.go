@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTypedDecodeAndValidation(t *testing.T) {
+	c := NewHybridClient()
+	var raw []TickerData
+	var ticks []Ticker
+	var trades []Trade
+	var books []Book
+	c.OnTicker(func(arr []TickerData) { raw = append(raw, arr...) })
+	c.OnTypedTicker(func(arr []Ticker) { ticks = append(ticks, arr...) })
+	c.OnTypedTrade(func(arr []Trade) { trades = append(trades, arr...) })
+	c.OnTypedBook(func(arr []Book) { books = append(books, arr...) })
+
+	c.handleWSData("tickers", "X", "", json.RawMessage(`[
+		{"instId":"X","last":"100.5","bidPx":"100","bidSz":"2","askPx":"101","askSz":"3","ts":"1700000000000"},
+		{"instId":"X","last":"abc"},
+		{"instId":"","last":"1"},
+		{"instId":"Y","last":"5","bidPx":"","askPx":""}]`))
+	c.handleWSData("tickers", "X", "", json.RawMessage(`{"instId":"X"}`)) // 不是数组
+	c.handleWSData("trades", "X", "", json.RawMessage(`[
+		{"instId":"X","tradeId":"1","px":"100","sz":"0.5","side":"SELL","ts":"1700000000001"},
+		{"instId":"X","tradeId":"2","px":"100","sz":"0.5","side":"hold","ts":"1700000000002"},
+		{"instId":"X","tradeId":"3","px":"-1","sz":"0.5","side":"buy","ts":"1700000000003"},
+		{"instId":"X","tradeId":"4","px":"100","sz":"1","side":"buy"}]`))
+	c.handleWSData(ChannelBooks5, "X", "", json.RawMessage(`[{"asks":[["101","1","0","2"]],"bids":[["100","0","0","0"]],"ts":"1700000000004"}]`))
+	c.handleWSData(ChannelBooks5, "X", "", json.RawMessage(`[{"asks":[["x","1"]],"bids":[],"ts":"1"}]`))
+	c.handleWSData("candle1m", "X", "", json.RawMessage(`[["1700000000000","1","2","0.5","nan?","1","0","0","1"]]`))
+	c.handleWSMessage([]byte(`{"arg":`))
+	c.handleWSMessage([]byte(`pong`))
+
+	if len(raw) != 2 || raw[0].InstID != "X" || raw[1].InstID != "Y" || len(ticks) != 2 {
+		t.Fatalf("tickers raw=%+v typed=%+v", raw, ticks)
+	}
+	tk := ticks[0]
+	if tk.Last != 100.5 || tk.Bid != 100 || tk.AskSz != 3 || tk.Mid() != 100.5 || !tk.Ts.Equal(time.UnixMilli(1700000000000)) {
+		t.Fatalf("ticker: %+v", tk)
+	}
+	if ticks[1].Bid != 0 || ticks[1].Mid() != 5 || !ticks[1].Ts.IsZero() {
+		t.Fatalf("ticker without quotes: %+v", ticks[1])
+	}
+	if len(trades) != 1 || trades[0].Side != SideSell || trades[0].Side.Sign() != -1 || trades[0].Sz != 0.5 {
+		t.Fatalf("trades: %+v", trades)
+	}
+	if len(books) != 1 || books[0].InstID != "X" || len(books[0].Bids) != 1 || books[0].Bids[0].Sz != 0 || books[0].Asks[0].Orders != 2 {
+		t.Fatalf("books: %+v", books)
+	}
+	// 无效：2 ticker + 3 trade + 1 book + 1 candle；无法解析：非数组 data + 截断的消息（pong 不计）
+	if st := c.DecodeStats(); st.Decoded != 4 || st.Invalid != 7 || st.Malformed != 2 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
@@ -0,0 +1,126 @@
+package tca
+
+// 成本回灌 —— 把实测费率/滑点转成回测成本模型参数
+// =============================================================================
+// 1) 全局：TakerFeeBps 取 maker/taker 混合后的实测费率，SlippageBps 取到达价滑点（均按名义加权）；
+// 2) 品种：样本数达标的品种单独记一份，Hook 以“品种成本 - 全局成本”作为额外 bps 叠加到成交；
+// 3) backtest.Config 把 0 视为未设置（会回落到默认值），实测 ≤0 时全局参数取 floorBps，差额同样由 Hook 补回。
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"Mod/src/backtest"
+)
+
+const floorBps = 0.01
+
+// Cost —— 一组实测成本（bps）
+type Cost struct {
+	Orders      int     `json:"orders"`
+	FeeBps      float64 `json:"feeBps"` // maker/taker 混合
+	MakerFeeBps float64 `json:"makerFeeBps"`
+	TakerFeeBps float64 `json:"takerFeeBps"`
+	SlippageBps float64 `json:"slippageBps"`
+	MakerRatio  float64 `json:"makerRatio"`
+}
+
+func costOf(s Stats) Cost {
+	return Cost{
+		Orders: s.Orders, FeeBps: s.FeeBps, MakerFeeBps: s.MakerFeeBps, TakerFeeBps: s.TakerFeeBps,
+		SlippageBps: s.ArrivalBps, MakerRatio: s.MakerRatio,
+	}
+}
+
+func (c Cost) total() float64 { return c.FeeBps + c.SlippageBps }
+
+// Calibration —— 回测成本模型输入
+type Calibration struct {
+	Generated time.Time       `json:"generated"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	All       Cost            `json:"all"`
+	PerInst   map[string]Cost `json:"perInst,omitempty"`
+}
+
+// Calibrate —— 由报告生成校准参数；成交子单少于 minOrders 的品种不单独校准
+func Calibrate(r *Report, minOrders int) Calibration {
+	c := Calibration{Generated: time.Now().UTC(), From: r.From, To: r.To, All: costOf(r.All)}
+	for _, s := range r.ByInst {
+		if s.FilledOrders < minOrders || s.Notional <= 0 {
+			continue
+		}
+		if c.PerInst == nil {
+			c.PerInst = make(map[string]Cost)
+		}
+		c.PerInst[s.Key] = costOf(s)
+	}
+	return c
+}
+
+func (c Calibration) fee() float64  { return maxFloat(c.All.FeeBps, floorBps) }
+func (c Calibration) slip() float64 { return maxFloat(c.All.SlippageBps, floorBps) }
+
+// Apply —— 覆盖回测全局费率与滑点（按 taker 口径计费，maker 占比已折入混合费率）
+func (c Calibration) Apply(cfg *backtest.Config) {
+	cfg.UseMaker = false
+	cfg.TakerFeeBps = c.fee()
+	cfg.MakerFeeBps = c.All.MakerFeeBps
+	cfg.SlippageBps = c.slip()
+}
+
+// ExtraBps —— 某品种相对 Apply 后全局参数的额外成本
+func (c Calibration) ExtraBps(inst string) float64 {
+	target := c.All.total()
+	if pc, ok := c.PerInst[inst]; ok {
+		target = pc.total()
+	}
+	return target - (c.fee() + c.slip())
+}
+
+// Hook —— 包装 BeforeFill：保留 next 的成交价，叠加品种额外成本
+func (c Calibration) Hook(next backtest.FillHook) backtest.FillHook {
+	return func(inst, side string, delta float64, ref float64) (float64, float64) {
+		px, extra := 0.0, 0.0
+		if next != nil {
+			px, extra = next(inst, side, delta, ref)
+		}
+		return px, extra + c.ExtraBps(inst)
+	}
+}
+
+// Save —— 写 JSON（原子替换）
+func (c Calibration) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCalibration —— 读取 Save 写出的校准文件
+func LoadCalibration(path string) (Calibration, error) {
+	var c Calibration
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,511 @@
+package tca
+
+// TCA —— 交易成本分析：按 ClientID 关联“决策 mark → 子单 → 成交”，统计执行质量
+// =============================================================================
+// 1) 数据源：storage.TradeLogger 的 JSON Lines（execution.SetJournal 写入的 order/fill 记录）；
+//    order.Meta 带 mark（决策价）/arrival（到达价）/algo/parent，fill.Meta 带 liq/notional/fillTs；
+// 2) 单笔指标：到达价滑点、有效价差（2×偏离决策 mark）、成交率、首笔成交耗时、maker 占比、费率 bps；
+// 3) 汇总维度：整体 / 品种 / 算法（无算法记为 direct）/ UTC 小时（00..23）；bps 类指标按名义加权；
+// 4) 输出：CSV / JSON 报告；Calibrate（calibrate.go）把实测成本回灌为回测成本模型参数。
+//    约定：滑点/价差为正表示成本，费率为正表示支出（负数为返佣）。
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===================== 单笔执行 =====================
+
+// Execution —— 一张子单及其全部成交
+type Execution struct {
+	InstID   string    `json:"instId"`
+	ClientID string    `json:"clientId"`
+	Side     string    `json:"side"`
+	Algo     string    `json:"algo"`
+	Parent   string    `json:"parent,omitempty"`
+	PostOnly bool      `json:"postOnly"`
+	OrderTs  time.Time `json:"orderTs"`
+	Qty      float64   `json:"qty"`     // 下单张数
+	Price    float64   `json:"price"`   // 委托价
+	Mark     float64   `json:"mark"`    // 决策时 mark
+	Arrival  float64   `json:"arrival"` // 到达价（母单基准；直连单同 mark）
+
+	Fills     int       `json:"fills"`
+	FilledQty float64   `json:"filledQty"`
+	PxQty     float64   `json:"-"` // Σ price×qty（求均价）
+	Notional  float64   `json:"notional"`
+	Fee       float64   `json:"fee"`
+	MakerQty  float64   `json:"makerQty"`
+	MakerNtl  float64   `json:"-"`
+	MakerFee  float64   `json:"-"`
+	FirstFill time.Time `json:"firstFill,omitempty"`
+}
+
+// AvgPx —— 成交均价（未成交为 0）
+func (e *Execution) AvgPx() float64 {
+	if e.FilledQty <= 0 {
+		return 0
+	}
+	return e.PxQty / e.FilledQty
+}
+
+func (e *Execution) dir() float64 {
+	if e.Side == "sell" {
+		return -1
+	}
+	return 1
+}
+
+// ArrivalBps —— 相对到达价的滑点（正=成本）
+func (e *Execution) ArrivalBps() float64 {
+	avg := e.AvgPx()
+	if avg <= 0 || e.Arrival <= 0 {
+		return 0
+	}
+	return e.dir() * (avg - e.Arrival) / e.Arrival * 10000
+}
+
+// EffSpreadBps —— 有效价差：2×(成交价-决策 mark)（带方向，正=成本）
+func (e *Execution) EffSpreadBps() float64 {
+	avg := e.AvgPx()
+	if avg <= 0 || e.Mark <= 0 {
+		return 0
+	}
+	return 2 * e.dir() * (avg - e.Mark) / e.Mark * 10000
+}
+
+// TimeToFill —— 下单到首笔成交（未成交 ok=false）
+func (e *Execution) TimeToFill() (time.Duration, bool) {
+	if e.FirstFill.IsZero() || e.OrderTs.IsZero() {
+		return 0, false
+	}
+	d := e.FirstFill.Sub(e.OrderTs)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// ===================== 日志关联 =====================
+
+// line —— order/fill 记录的并集（TradeLogger 无 json tag 的字段按字段名大小写不敏感匹配）
+type line struct {
+	TS       string         `json:"ts"`
+	Cat      string         `json:"cat"`
+	InstID   string         `json:"instId"`
+	ClientID string         `json:"clientId"`
+	Side     string         `json:"side"`
+	Qty      float64        `json:"qty"`
+	Price    float64        `json:"price"`
+	Fee      float64        `json:"fee"`
+	PostOnly bool           `json:"postOnly"`
+	Meta     map[string]any `json:"meta"`
+}
+
+// Joiner —— 逐行吃日志，按 ClientID 关联下单与成交；成交可先于下单出现（多文件乱序）
+type Joiner struct {
+	execs   map[string]*Execution
+	pending map[string][]line // 尚未见到下单记录的成交
+	Lines   int
+	Skipped int // 非 order/fill 或解析失败的行
+}
+
+func NewJoiner() *Joiner {
+	return &Joiner{execs: make(map[string]*Execution), pending: make(map[string][]line)}
+}
+
+// Add —— 处理一行 JSON
+func (j *Joiner) Add(b []byte) {
+	j.Lines++
+	var ln line
+	if err := json.Unmarshal(b, &ln); err != nil || ln.ClientID == "" {
+		j.Skipped++
+		return
+	}
+	switch ln.Cat {
+	case "order":
+		e := &Execution{
+			InstID: ln.InstID, ClientID: ln.ClientID, Side: ln.Side, PostOnly: ln.PostOnly,
+			OrderTs: parseTS(ln.TS), Qty: ln.Qty, Price: ln.Price,
+			Mark: metaFloat(ln.Meta, "mark"), Arrival: metaFloat(ln.Meta, "arrival"),
+			Algo: metaString(ln.Meta, "algo"), Parent: metaString(ln.Meta, "parent"),
+		}
+		if e.Mark <= 0 {
+			e.Mark = e.Price
+		}
+		if e.Arrival <= 0 {
+			e.Arrival = e.Mark
+		}
+		if e.Algo == "" {
+			e.Algo = "direct"
+		}
+		if _, dup := j.execs[ln.ClientID]; dup {
+			return // 重复下单记录（重放/重复写）只认第一条
+		}
+		j.execs[ln.ClientID] = e
+		for _, f := range j.pending[ln.ClientID] {
+			j.applyFill(e, f)
+		}
+		delete(j.pending, ln.ClientID)
+	case "fill":
+		if e, ok := j.execs[ln.ClientID]; ok {
+			j.applyFill(e, ln)
+		} else {
+			j.pending[ln.ClientID] = append(j.pending[ln.ClientID], ln)
+		}
+	default:
+		j.Skipped++
+	}
+}
+
+func (j *Joiner) applyFill(e *Execution, f line) {
+	if f.Qty <= 0 {
+		return
+	}
+	ntl := metaFloat(f.Meta, "notional")
+	if ntl <= 0 {
+		ntl = f.Qty * f.Price
+	}
+	ts := parseTS(f.TS)
+	if ms := metaFloat(f.Meta, "fillTs"); ms > 0 {
+		ts = time.UnixMilli(int64(ms)).UTC()
+	}
+	e.Fills++
+	e.FilledQty += f.Qty
+	e.PxQty += f.Qty * f.Price
+	e.Notional += ntl
+	e.Fee += f.Fee
+	if e.FirstFill.IsZero() || ts.Before(e.FirstFill) {
+		e.FirstFill = ts
+	}
+	// 流动性标记缺失时按 post-only 推断
+	liq := strings.ToUpper(metaString(f.Meta, "liq"))
+	if liq == "M" || (liq == "" && e.PostOnly) {
+		e.MakerQty += f.Qty
+		e.MakerNtl += ntl
+		e.MakerFee += f.Fee
+	}
+}
+
+// ReadFrom —— 读取一段 JSON Lines
+func (j *Joiner) ReadFrom(r io.Reader) (int64, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var n int64
+	for sc.Scan() {
+		b := sc.Bytes()
+		n += int64(len(b)) + 1
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		j.Add(b)
+	}
+	return n, sc.Err()
+}
+
+// Executions —— 已关联的子单（按下单时间排序）
+func (j *Joiner) Executions() []Execution {
+	out := make([]Execution, 0, len(j.execs))
+	for _, e := range j.execs {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if !out[a].OrderTs.Equal(out[b].OrderTs) {
+			return out[a].OrderTs.Before(out[b].OrderTs)
+		}
+		return out[a].ClientID < out[b].ClientID
+	})
+	return out
+}
+
+// Orphans —— 找不到下单记录的成交笔数
+func (j *Joiner) Orphans() int {
+	n := 0
+	for _, fs := range j.pending {
+		n += len(fs)
+	}
+	return n
+}
+
+// LoadFiles —— 读取多个日志文件
+func LoadFiles(paths ...string) (*Joiner, error) {
+	j := NewJoiner()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		_, err = j.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("tca: read %s: %w", p, err)
+		}
+	}
+	return j, nil
+}
+
+// LoadDir —— 读取目录下全部 TradeLogger 文件（含按日/按大小滚动的 *.jsonl、*.jsonl.N）
+func LoadDir(dir string) (*Joiner, error) {
+	var paths []string
+	for _, pat := range []string{"*.jsonl", "*.jsonl.*"} {
+		m, err := filepath.Glob(filepath.Join(dir, pat))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, m...)
+	}
+	sort.Strings(paths)
+	return LoadFiles(paths...)
+}
+
+// ===================== 汇总 =====================
+
+// Stats —— 一组子单的执行质量
+type Stats struct {
+	Group        string  `json:"group"` // all / inst / algo / hour
+	Key          string  `json:"key"`
+	Orders       int     `json:"orders"`
+	FilledOrders int     `json:"filledOrders"`
+	Qty          float64 `json:"qty"`
+	FilledQty    float64 `json:"filledQty"`
+	FillRate     float64 `json:"fillRate"` // 成交张数 / 下单张数
+	Notional     float64 `json:"notional"`
+	ArrivalBps   float64 `json:"arrivalBps"`
+	EffSpreadBps float64 `json:"effSpreadBps"`
+	FeeBps       float64 `json:"feeBps"`
+	MakerFeeBps  float64 `json:"makerFeeBps"`
+	TakerFeeBps  float64 `json:"takerFeeBps"`
+	MakerRatio   float64 `json:"makerRatio"` // maker 成交张数占比
+	AvgTTFMs     float64 `json:"avgTtfMs"`
+	P50TTFMs     float64 `json:"p50TtfMs"`
+}
+
+type acc struct {
+	orders, filled           int
+	qty, filledQty, makerQty float64
+	ntl, arrW, sprW, fee     float64
+	makerNtl, makerFee       float64
+	ttf                      []float64
+}
+
+func (a *acc) add(e *Execution) {
+	a.orders++
+	a.qty += e.Qty
+	if e.FilledQty <= 0 {
+		return
+	}
+	a.filled++
+	a.filledQty += e.FilledQty
+	a.makerQty += e.MakerQty
+	a.ntl += e.Notional
+	a.arrW += e.ArrivalBps() * e.Notional
+	a.sprW += e.EffSpreadBps() * e.Notional
+	a.fee += e.Fee
+	a.makerNtl += e.MakerNtl
+	a.makerFee += e.MakerFee
+	if d, ok := e.TimeToFill(); ok {
+		a.ttf = append(a.ttf, float64(d.Milliseconds()))
+	}
+}
+
+func (a *acc) stats(group, key string) Stats {
+	s := Stats{Group: group, Key: key, Orders: a.orders, FilledOrders: a.filled, Qty: a.qty, FilledQty: a.filledQty, Notional: a.ntl}
+	if a.qty > 0 {
+		s.FillRate = a.filledQty / a.qty
+	}
+	if a.filledQty > 0 {
+		s.MakerRatio = a.makerQty / a.filledQty
+	}
+	if a.ntl > 0 {
+		s.ArrivalBps = a.arrW / a.ntl
+		s.EffSpreadBps = a.sprW / a.ntl
+		s.FeeBps = a.fee / a.ntl * 10000
+	}
+	if a.makerNtl > 0 {
+		s.MakerFeeBps = a.makerFee / a.makerNtl * 10000
+	}
+	if tn := a.ntl - a.makerNtl; tn > 0 {
+		s.TakerFeeBps = (a.fee - a.makerFee) / tn * 10000
+	}
+	if n := len(a.ttf); n > 0 {
+		sum := 0.0
+		for _, v := range a.ttf {
+			sum += v
+		}
+		s.AvgTTFMs = sum / float64(n)
+		sort.Float64s(a.ttf)
+		s.P50TTFMs = a.ttf[n/2]
+		if n%2 == 0 {
+			s.P50TTFMs = (a.ttf[n/2-1] + a.ttf[n/2]) / 2
+		}
+	}
+	return s
+}
+
+// Report —— TCA 报告
+type Report struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Fills   int       `json:"fills"`
+	Orphans int       `json:"orphanFills"`
+	All     Stats     `json:"all"`
+	ByInst  []Stats   `json:"byInst"`
+	ByAlgo  []Stats   `json:"byAlgo"`
+	ByHour  []Stats   `json:"byHour"`
+}
+
+// Analyze —— 汇总子单
+func Analyze(execs []Execution) *Report {
+	r := &Report{}
+	var all acc
+	inst := map[string]*acc{}
+	algo := map[string]*acc{}
+	hour := map[string]*acc{}
+	get := func(m map[string]*acc, k string) *acc {
+		a, ok := m[k]
+		if !ok {
+			a = &acc{}
+			m[k] = a
+		}
+		return a
+	}
+	for i := range execs {
+		e := &execs[i]
+		if !e.OrderTs.IsZero() {
+			if r.From.IsZero() || e.OrderTs.Before(r.From) {
+				r.From = e.OrderTs
+			}
+			if e.OrderTs.After(r.To) {
+				r.To = e.OrderTs
+			}
+		}
+		r.Fills += e.Fills
+		all.add(e)
+		get(inst, e.InstID).add(e)
+		get(algo, e.Algo).add(e)
+		get(hour, fmt.Sprintf("%02d", e.OrderTs.UTC().Hour())).add(e)
+	}
+	r.All = all.stats("all", "*")
+	r.ByInst = flatten("inst", inst)
+	r.ByAlgo = flatten("algo", algo)
+	r.ByHour = flatten("hour", hour)
+	return r
+}
+
+func flatten(group string, m map[string]*acc) []Stats {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]Stats, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, m[k].stats(group, k))
+	}
+	return out
+}
+
+// Rows —— 全部分组（all → inst → algo → hour）
+func (r *Report) Rows() []Stats {
+	out := []Stats{r.All}
+	out = append(out, r.ByInst...)
+	out = append(out, r.ByAlgo...)
+	return append(out, r.ByHour...)
+}
+
+// ===================== 输出 =====================
+
+var csvHeader = []string{
+	"group", "key", "orders", "filled_orders", "qty", "filled_qty", "fill_rate", "notional",
+	"arrival_bps", "eff_spread_bps", "fee_bps", "maker_fee_bps", "taker_fee_bps", "maker_ratio",
+	"avg_ttf_ms", "p50_ttf_ms",
+}
+
+// WriteCSV —— 一行一个分组
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(round(v), 'f', -1, 64) }
+	for _, s := range r.Rows() {
+		rec := []string{
+			s.Group, s.Key, strconv.Itoa(s.Orders), strconv.Itoa(s.FilledOrders), f(s.Qty), f(s.FilledQty),
+			f(s.FillRate), f(s.Notional), f(s.ArrivalBps), f(s.EffSpreadBps), f(s.FeeBps),
+			f(s.MakerFeeBps), f(s.TakerFeeBps), f(s.MakerRatio), f(s.AvgTTFMs), f(s.P50TTFMs),
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON —— 完整报告（缩进）
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Save —— 写入 dir/tca.csv 与 dir/tca.json
+func (r *Report) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, write := range map[string]func(io.Writer) error{"tca.csv": r.WriteCSV, "tca.json": r.WriteJSON} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===================== 工具 =====================
+
+func parseTS(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func metaFloat(m map[string]any, k string) float64 {
+	switch v := m[k].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func metaString(m map[string]any, k string) string {
+	if v, ok := m[k].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func round(v float64) float64 { return math.Round(v*1e6) / 1e6 }
@@ -0,0 +1,125 @@
+package tca
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"Mod/src/backtest"
+	"Mod/src/execution"
+	"Mod/src/storage"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func TestJoinAndAnalyze(t *testing.T) {
+	logs := strings.Join([]string{
+		// 成交先于下单出现（跨文件乱序）
+		`{"ts":"2026-01-02T03:00:00.500Z","cat":"fill","InstID":"X","ClientID":"a","Side":"buy","Qty":1,"Price":101,"Fee":0.0101,"Meta":{"liq":"T","notional":101}}`,
+		`{"ts":"2026-01-02T03:00:00Z","cat":"order","InstID":"X","ClientID":"a","Side":"buy","Qty":2,"Price":102,"Meta":{"mark":100,"arrival":100,"algo":"twap","parent":"p1"}}`,
+		`{"ts":"2026-01-02T03:00:01Z","cat":"fill","InstID":"X","ClientID":"a","Side":"buy","Qty":1,"Price":101,"Fee":0.0101,"Meta":{"liq":"T","notional":101,"fillTs":1767322801000}}`,
+		`{"ts":"2026-01-02T04:00:00Z","cat":"order","InstID":"Y","ClientID":"b","Side":"sell","Qty":1,"Price":200,"PostOnly":true,"Meta":{"mark":200,"arrival":200}}`,
+		`{"ts":"2026-01-02T04:00:02Z","cat":"fill","InstID":"Y","ClientID":"b","Side":"sell","Qty":1,"Price":200,"Fee":-0.01}`,
+		`{"ts":"2026-01-02T04:00:03Z","cat":"fill","InstID":"Y","ClientID":"zz","Side":"sell","Qty":1,"Price":200}`,
+		`{"ts":"2026-01-02T04:00:03Z","cat":"signal","instId":"Y","tag":"x"}`,
+	}, "\n")
+	j := NewJoiner()
+	if _, err := j.ReadFrom(strings.NewReader(logs)); err != nil {
+		t.Fatal(err)
+	}
+	if j.Orphans() != 1 {
+		t.Fatalf("orphans=%d", j.Orphans())
+	}
+	ex := j.Executions()
+	if len(ex) != 2 || ex[0].ClientID != "a" || ex[0].Algo != "twap" || ex[1].Algo != "direct" {
+		t.Fatalf("executions: %+v", ex)
+	}
+	if d, ok := ex[0].TimeToFill(); !ok || d.Milliseconds() != 500 {
+		t.Fatalf("ttf=%v", d)
+	}
+	if !near(ex[0].ArrivalBps(), 100) || !near(ex[0].EffSpreadBps(), 200) {
+		t.Fatalf("buy bps: arr=%v spr=%v", ex[0].ArrivalBps(), ex[0].EffSpreadBps())
+	}
+
+	r := Analyze(ex)
+	if r.Fills != 3 || len(r.ByInst) != 2 || len(r.ByAlgo) != 2 || len(r.ByHour) != 2 || r.ByHour[0].Key != "03" {
+		t.Fatalf("report: %+v", r)
+	}
+	x := r.ByInst[0]
+	if x.Key != "X" || !near(x.FillRate, 1) || !near(x.FeeBps, 1) || !near(x.TakerFeeBps, 1) || x.MakerRatio != 0 {
+		t.Fatalf("inst X: %+v", x)
+	}
+	y := r.ByInst[1]
+	if !near(y.MakerRatio, 1) || !near(y.MakerFeeBps, -0.5) || y.AvgTTFMs != 2000 {
+		t.Fatalf("inst Y: %+v", y)
+	}
+	// 名义加权：X 名义 202、滑点 100bps；Y 名义 200、滑点 0
+	if !near(r.All.ArrivalBps, 100*202.0/402) {
+		t.Fatalf("all arrival=%v", r.All.ArrivalBps)
+	}
+
+	var csvOut, jsonOut strings.Builder
+	if err := r.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteJSON(&jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(csvOut.String(), "\n"); n != 1+len(r.Rows()) {
+		t.Fatalf("csv rows=%d\n%s", n, csvOut.String())
+	}
+	if !strings.Contains(jsonOut.String(), `"byAlgo"`) {
+		t.Fatalf("json: %s", jsonOut.String())
+	}
+
+	cal := Calibrate(r, 1)
+	var cfg backtest.Config
+	cal.Apply(&cfg)
+	if cfg.UseMaker || !near(cfg.TakerFeeBps, cal.All.FeeBps) || !near(cfg.SlippageBps, cal.All.SlippageBps) {
+		t.Fatalf("apply: %+v cal=%+v", cfg, cal.All)
+	}
+	// Y 的实测成本低于全局：Hook 给出负的额外 bps，并保留内层 Hook 的成交价
+	px, extra := cal.Hook(func(string, string, float64, float64) (float64, float64) { return 99, 1 })("Y", "sell", 1, 100)
+	if px != 99 || !near(extra, 1+cal.PerInst["Y"].FeeBps+cal.PerInst["Y"].SlippageBps-cfg.TakerFeeBps-cfg.SlippageBps) {
+		t.Fatalf("hook: px=%v extra=%v", px, extra)
+	}
+
+	path := t.TempDir() + "/cal.json"
+	if err := cal.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadCalibration(path)
+	if err != nil || len(got.PerInst) != 2 || !near(got.All.FeeBps, cal.All.FeeBps) {
+		t.Fatalf("reload: %+v %v", got, err)
+	}
+}
+
+func TestExecutorJournalFeedsTCA(t *testing.T) {
+	dir := t.TempDir()
+	lg := storage.NewTradeLogger(dir, "trade.jsonl", false, 0)
+	ex := execution.NewExecutor(execution.Config{AccountEquity: 1000, LeverageCap: 1, ChildMinQty: 1, ChildMaxQty: 5})
+	ex.RegisterInstrument(execution.InstrumentSpec{InstID: "X", TickSize: 0.1, LotSize: 1, CtVal: 0.1})
+	ex.SetJournal(lg)
+
+	p := ex.Step("X", 0.5, 100, 1000)
+	if len(p.Orders) != 1 {
+		t.Fatalf("orders: %+v", p.Orders)
+	}
+	o := p.Orders[0]
+	ex.OnFill(execution.Fill{InstID: "X", ClientID: o.ClientID, Side: o.Side, Qty: o.Qty, Price: 100.2, Fee: 0.01 * o.Qty, TradeID: "t1", Liquidity: "T"})
+	if err := lg.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := j.Executions()
+	if len(es) != 1 || es[0].Mark != 100 || es[0].FilledQty != o.Qty || !near(es[0].Notional, o.Qty*10.02) {
+		t.Fatalf("executions: %+v", es)
+	}
+	if !near(es[0].ArrivalBps(), 20) || !near(Analyze(es).All.FeeBps, 0.01/10.02*10000) {
+		t.Fatalf("bps: arr=%v", es[0].ArrivalBps())
+	}
+}